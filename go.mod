@@ -1,8 +1,10 @@
 module github.com/FBemf/corkboard
 
-go 1.16
+go 1.18
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mattn/go-sqlite3 v1.14.4
+	golang.org/x/sys v0.7.0
 )
@@ -1,5 +1,6 @@
 -- NOTE: This document is for reference only & is not canonical.
 -- The actual schema is the concatenation of all the migrations, in order.
+-- Times are RFC 3339 strings in UTC, like 2026-10-17T09:30:00Z, written by corkboard.
 
 create table _migration (
 	date    date,
@@ -11,5 +12,196 @@ create table "note" (
     name        text not null primary key,
     body        blob not null,
     create_time  datetime default current_timestamp,
-    last_viewed  datetime default current_timestamp
+    last_viewed  datetime default current_timestamp,
+    hash         text,
+    blob_hash    text references "blob" (hash),
+    unlisted     boolean not null default 0,
+    views        integer not null default 0,
+    meta         text not null default '{}',
+    title        text not null default '',
+    content_type text not null default '',
+    -- set by a trigger when the note's contents change; null until then
+    modify_time  datetime,
+    -- the note is only shown from publish_at until hide_after; null means no bound
+    publish_at   datetime,
+    hide_after   datetime,
+    -- the length of the body, or of its blob; set by triggers
+    size         integer not null default 0,
+    -- set by triggers when anything shown on the note's page changes; null until then
+    page_time    datetime,
+    -- seconds to keep the note for, from retention hooks; 0 is forever, and null leaves it to -retention
+    retention_override integer
 );
+
+-- cover every column listings read, so they never read notes' bodies
+create index note_listing_time on "note" (create_time, name, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override);
+create index note_listing_name on "note" (name, create_time, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override);
+create index note_page_time on "note" (name, page_time, create_time);
+create index note_last_viewed on "note" (last_viewed);
+create index note_hash on "note" (hash);
+
+-- with -dedupe, bodies live here and notes reference them by blob_hash
+-- triggers keep refcount up to date and delete unreferenced blobs
+create table "blob" (
+    hash      text not null primary key,
+    body      blob not null,
+    refcount  integer not null default 0
+);
+
+create table "replication" (
+    id            integer primary key autoincrement,
+    name          text not null,
+    action        text not null,
+    attempts      integer not null default 0,
+    next_attempt  datetime default current_timestamp,
+    create_time   datetime default current_timestamp
+);
+
+create table "attachment" (
+    note          text not null references "note" (name) on delete cascade on update cascade,
+    name          text not null,
+    content_type  text not null,
+    body          blob not null,
+    create_time   datetime default current_timestamp,
+    primary key (note, name)
+);
+
+create table "note_lock" (
+    note      text not null primary key references "note" (name) on delete cascade on update cascade,
+    holder    text not null,
+    acquired  datetime default current_timestamp,
+    expires   datetime not null
+);
+
+create table "comment" (
+    id           integer primary key autoincrement,
+    note         text not null references "note" (name) on delete cascade on update cascade,
+    author       text not null,
+    body         text not null,
+    create_time  datetime default current_timestamp
+);
+
+create index comment_note on "comment" (note, id);
+
+-- appended to by triggers on "note" whenever a note is created, changed or deleted
+create table "change_log" (
+    id           integer primary key autoincrement,
+    name         text not null,
+    action       text not null,
+    hash         text,
+    change_time  datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+create index change_log_time on "change_log" (change_time);
+create index change_log_name on "change_log" (name, id);
+
+-- settings which can be changed while corkboard is running
+create table "setting" (
+    name   text primary key,
+    value  text not null
+);
+
+-- views of notes, with the client's address truncated to its network
+-- older views are pruned, but "note".views keeps counting them
+create table "view_event" (
+    id         integer primary key autoincrement,
+    note       text not null references "note" (name) on delete cascade on update cascade,
+    client     text not null,
+    view_time  datetime not null
+);
+
+create index view_event_note on "view_event" (note, view_time);
+create index view_event_time on "view_event" (view_time);
+
+-- notes created by POSTs with an X-Idempotency-Key, so retrying them gets the same response
+create table "idempotency_key" (
+    note          text not null references "note" (name) on delete cascade on update cascade,
+    key           text not null,
+    hash          text not null,
+    duplicate_of  text not null default '',
+    create_time   datetime not null,
+    primary key (note, key)
+);
+
+create index idempotency_key_time on "idempotency_key" (create_time);
+
+-- frozen copies of notes' contents, served at /snap/:hash
+-- they outlive their notes, until deleted by an admin
+create table "snapshot" (
+    hash          text not null primary key,
+    body          blob not null,
+    content_type  text not null default '',
+    create_time   datetime not null
+);
+
+-- which notes were snapshotted with which contents; no foreign key to "note", since snapshots outlive it
+create table "note_snapshot" (
+    note         text not null,
+    hash         text not null references "snapshot" (hash) on delete cascade,
+    create_time  datetime not null,
+    primary key (note, hash)
+);
+
+create index note_snapshot_hash on "note_snapshot" (hash);
+
+-- notes announced by -expiry-warning, with their last_viewed when they were, so each is only announced once
+create table "expiry_warning" (
+    note         text not null primary key references "note" (name) on delete cascade on update cascade,
+    last_viewed  datetime not null,
+    warn_time    datetime not null
+);
+
+-- the one-time tokens of submitted web forms, and the note each created or commented on,
+-- so submitting a form again links to that note rather than acting twice
+create table "form_submission" (
+    token        text not null primary key,
+    note         text not null,
+    submit_time  datetime not null
+);
+
+create index form_submission_time on "form_submission" (submit_time);
+
+-- callbacks told when a single note is updated or deleted
+-- triggers on "note" queue deliveries to them in "watch_delivery", which outlive the watch
+create table "note_watch" (
+    id           integer primary key autoincrement,
+    note         text not null references "note" (name) on delete cascade on update cascade,
+    url          text not null,
+    secret       text not null default '',
+    created_by   text not null default '',
+    create_time  datetime not null,
+    -- set once deliveries to url have failed too many times
+    disabled     boolean not null default 0
+);
+
+create index note_watch_note on "note_watch" (note);
+
+create table "watch_delivery" (
+    id            integer primary key autoincrement,
+    watch         integer not null,
+    note          text not null,
+    url           text not null,
+    secret        text not null,
+    action        text not null,
+    hash          text,
+    event_time    datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    attempts      integer not null default 0,
+    next_attempt  datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+create index watch_delivery_watch on "watch_delivery" (watch, id);
+
+-- the last check of each link in each note, by -linkcheck-interval
+create table "link_check" (
+    note        text not null references "note" (name) on delete cascade on update cascade,
+    url         text not null,
+    -- the status of the response, or 0 if there wasn't one
+    status      integer not null,
+    error       text not null default '',
+    check_time  datetime not null,
+    primary key (note, url)
+);
+
+create index link_check_time on "link_check" (check_time);
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// kinds of access to a note an ACL grants
+const (
+	ACCESS_READ  = "read"
+	ACCESS_WRITE = "write"
+)
+
+// in an ACL rule's list of users, everyone who can sign in
+const aclEveryone = "*"
+
+// in an ACL rule's list of users, the users given with -admins
+const aclAdmins = "@admins"
+
+// returned when the acl doesn't let a user write the note they'd create
+var errNotPermitted = errors.New("the acl doesn't allow writing this note")
+
+// ACL decides who may read and write notes, by the beginnings of their names
+// the first rule whose prefix a note's name begins with decides; if none does, the default does
+type ACL struct {
+	rules        []ACLRule
+	defaultAllow bool
+	// the users given with -admins, for @admins; if there are none, @admins is no one
+	admins map[string]bool
+}
+
+// ACLRule grants the users in read and write access to the notes whose names begin with prefix
+type ACLRule struct {
+	prefix string
+	read   map[string]bool
+	write  map[string]bool
+	// where the rule was given, for errors
+	line int
+}
+
+// parses an ACL file
+// each line is a prefix followed by who may read and write the notes beginning with it, e.g.
+//
+//	infra-    read=*          write=alice,bob
+//	secrets-  read=@admins    write=@admins
+//
+// users are separated by commas, * means everyone who can sign in, and @admins the users given with -admins,
+// which must be given if it's used;
+// leaving out read or write grants it to no one
+// blank lines and lines beginning with '#' are ignored
+func parseACL(r io.Reader, source string) ([]ACLRule, error) {
+	rules := []ACLRule{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rule := ACLRule{prefix: fields[0], read: make(map[string]bool), write: make(map[string]bool), line: lineNumber}
+		seen := make(map[string]bool)
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 || (parts[0] != ACCESS_READ && parts[0] != ACCESS_WRITE) {
+				return nil, fmt.Errorf("%s line %d: unknown option %q; expected read=users or write=users", source, lineNumber, field)
+			}
+			if seen[parts[0]] {
+				return nil, fmt.Errorf("%s line %d: %s is given twice", source, lineNumber, parts[0])
+			}
+			seen[parts[0]] = true
+			users := rule.read
+			if parts[0] == ACCESS_WRITE {
+				users = rule.write
+			}
+			for _, user := range strings.Split(parts[1], ",") {
+				if user == "" {
+					return nil, fmt.Errorf("%s line %d: %s has an empty user", source, lineNumber, parts[0])
+				}
+				users[user] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", source, err)
+	}
+	return rules, nil
+}
+
+// the first user a rule names who has no credentials, if any
+func (rule ACLRule) unknownUser(credentials map[string]bool) (string, bool) {
+	for _, users := range []map[string]bool{rule.read, rule.write} {
+		for user := range users {
+			if user != aclEveryone && user != aclAdmins && !hasUser(credentials, user) {
+				return user, true
+			}
+		}
+	}
+	return "", false
+}
+
+// whether a rule names @admins
+func (rule ACLRule) namesAdmins() bool {
+	return rule.read[aclAdmins] || rule.write[aclAdmins]
+}
+
+// whether a rule grants user access
+// unlike isAdmin, no users at all being admins doesn't make everyone one, so a rule for @admins can't open notes up
+func (acl *ACL) grants(rule ACLRule, user string, access string) bool {
+	users := rule.read
+	if access == ACCESS_WRITE {
+		users = rule.write
+	}
+	return users[aclEveryone] || users[user] || (users[aclAdmins] && acl.admins[user])
+}
+
+// whether user may read or write the note name
+// a nil ACL allows everything
+func (acl *ACL) allows(user string, name string, access string) bool {
+	if acl == nil {
+		return true
+	}
+	for _, rule := range acl.rules {
+		if strings.HasPrefix(name, rule.prefix) {
+			return acl.grants(rule, user, access)
+		}
+	}
+	return acl.defaultAllow
+}
+
+// the where clause and arguments matching the notes user may read or write, by the names they're stored under,
+// so listings can leave the rest out in the query; the rules are tried in order, like allows
+func (acl *ACL) clause(user string, access string) (string, []interface{}) {
+	if len(acl.rules) == 0 {
+		return sqlBool(acl.defaultAllow), nil
+	}
+	clause := `(case`
+	args := []interface{}{}
+	for _, rule := range acl.rules {
+		prefix, prefixArgs := prefixClause(rule.prefix)
+		clause += ` when ` + prefix + ` then ` + sqlBool(acl.grants(rule, user, access))
+		args = append(args, prefixArgs...)
+	}
+	return clause + ` else ` + sqlBool(acl.defaultAllow) + ` end)`, args
+}
+
+func sqlBool(b bool) string {
+	if b {
+		return `1`
+	}
+	return `0`
+}
+
+// middleware for a route about a note, which responds 403 unless the ACL lets the user have access to it
+// a user's own private notes aren't subject to the ACL; see Datastore.permits
+func Permitted(h httprouter.Handle, datastore Datastore, access string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		if !datastore.permits(params.ByName("note"), access) {
+			discardBody(resp, req)
+			errorResponse(resp, req, http.StatusForbidden)
+			return
+		}
+		h(resp, req, params)
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// overlapping prefixes, where the first rule matching a name wins
+const testACL = `
+# secrets first, so the broader infra- rule doesn't let everyone read them
+infra-secrets-  read=@admins  write=@admins
+infra-          read=*        write=alice
+shadowed-       read=*        write=*
+# never reached, since shadowed- comes first
+shadowed-x-     read=alice
+public-         read=*        write=*
+`
+
+func TestACLAllows(t *testing.T) {
+	rules, err := parseACL(strings.NewReader(testACL), "test acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admins := map[string]bool{"alice": true}
+	cases := []struct {
+		user, name, access string
+		wantAllow          bool
+		wantDeny           bool
+	}{
+		{"alice", "infra-secrets-key", ACCESS_READ, true, true},
+		{"alice", "infra-secrets-key", ACCESS_WRITE, true, true},
+		{"bob", "infra-secrets-key", ACCESS_READ, false, false},
+		{"bob", "infra-secrets-key", ACCESS_WRITE, false, false},
+		{"bob", "infra-runbook", ACCESS_READ, true, true},
+		{"bob", "infra-runbook", ACCESS_WRITE, false, false},
+		{"alice", "infra-runbook", ACCESS_WRITE, true, true},
+		{"bob", "shadowed-x-note", ACCESS_READ, true, true},
+		{"bob", "shadowed-x-note", ACCESS_WRITE, true, true},
+		// a prefix only matches the beginning of a name
+		{"bob", "not-infra-secrets-key", ACCESS_READ, true, false},
+		{"bob", "other", ACCESS_READ, true, false},
+		{"bob", "other", ACCESS_WRITE, true, false},
+		{"", "public-note", ACCESS_READ, true, true},
+		{"", "infra-runbook", ACCESS_READ, true, true},
+	}
+	for _, defaultAllow := range []bool{true, false} {
+		acl := &ACL{rules: rules, defaultAllow: defaultAllow, admins: admins}
+		for _, c := range cases {
+			want := c.wantDeny
+			if defaultAllow {
+				want = c.wantAllow
+			}
+			if got := acl.allows(c.user, c.name, c.access); got != want {
+				t.Errorf("default allow %v: %s %s %s: got %v, want %v", defaultAllow, c.user, c.access, c.name, got, want)
+			}
+		}
+	}
+	var acl *ACL
+	if !acl.allows("bob", "infra-secrets-key", ACCESS_WRITE) {
+		t.Error("no acl denied a write")
+	}
+}
+
+// with no -admins, @admins is no one, rather than everyone as it is for the admin endpoints
+func TestACLWithoutAdmins(t *testing.T) {
+	rules, err := parseACL(strings.NewReader(testACL), "test acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, defaultAllow := range []bool{true, false} {
+		acl := &ACL{rules: rules, defaultAllow: defaultAllow}
+		for _, user := range []string{"alice", "bob", ""} {
+			for _, access := range []string{ACCESS_READ, ACCESS_WRITE} {
+				if acl.allows(user, "infra-secrets-key", access) {
+					t.Errorf("default allow %v: %q may %s infra-secrets-key", defaultAllow, user, access)
+				}
+			}
+		}
+	}
+
+	// and an acl file can't use it without -admins
+	path := filepath.Join(t.TempDir(), "acl")
+	if err := os.WriteFile(path, []byte(testACL), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args := []string{"-creds", "alice:secret", "-acl-file", path}
+	_, err = ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError), args)
+	if want := "line 3: @admins requires -admins"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("without -admins: got %v, want an error ending %q", err, want)
+	}
+	config, err := ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError), append(args, "-admins", "alice"))
+	if err != nil {
+		t.Fatalf("with -admins: %v", err)
+	}
+	if !config.ACL.allows("alice", "infra-secrets-key", ACCESS_READ) || config.ACL.allows("bob", "infra-secrets-key", ACCESS_READ) {
+		t.Error("with -admins alice, @admins isn't only alice")
+	}
+}
+
+func TestParseACLErrors(t *testing.T) {
+	cases := []struct {
+		acl  string
+		want string
+	}{
+		{"infra- read=*\ninfra- reed=*", "test acl line 2: unknown option"},
+		{"infra- read=*\n\n# a comment\ninfra- write", "test acl line 4: unknown option"},
+		{"infra- read=a read=b", "test acl line 1: read is given twice"},
+		{"infra- write=a,,b", "test acl line 1: write has an empty user"},
+	}
+	for _, c := range cases {
+		if _, err := parseACL(strings.NewReader(c.acl), "test acl"); err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("%q: got %v, want %s", c.acl, err, c.want)
+		}
+	}
+}
+
+// the handlers refuse what the acl doesn't allow with 403, and listings leave out the notes a user can't read
+func TestACLHandlers(t *testing.T) {
+	rules, err := parseACL(strings.NewReader(testACL), "test acl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in order, as they're listed
+	notes := []string{"infra-runbook", "infra-secrets-key", "other", "public-note", "shadowed-x-note"}
+	cases := []struct {
+		defaultAllow bool
+		user         string
+		wantRead     []string
+		wantWrite    []string
+	}{
+		{false, "alice", []string{"infra-runbook", "infra-secrets-key", "public-note", "shadowed-x-note"}, []string{"infra-runbook", "infra-secrets-key", "public-note", "shadowed-x-note"}},
+		{false, "bob", []string{"infra-runbook", "public-note", "shadowed-x-note"}, []string{"public-note", "shadowed-x-note"}},
+		{true, "alice", notes, notes},
+		{true, "bob", []string{"infra-runbook", "other", "public-note", "shadowed-x-note"}, []string{"other", "public-note", "shadowed-x-note"}},
+	}
+	for _, c := range cases {
+		config := testConfig(t)
+		config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+		config.CreateDB = true
+		config.Credentials = map[string]bool{"alice:secret": true, "bob:secret": true}
+		config.Admins = map[string]bool{"alice": true}
+		config.ACL = &ACL{rules: rules, defaultAllow: c.defaultAllow, admins: config.Admins}
+		app, err := NewApp(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range notes {
+			if _, err := app.datastore.setNote(name, []byte("original"), false); err != nil {
+				t.Fatal(err)
+			}
+		}
+		handler := app.Router()
+		do := func(method string, path string, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.SetBasicAuth(c.user, "secret")
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			return resp
+		}
+		name := fmt.Sprintf("default allow %v, %s", c.defaultAllow, c.user)
+
+		var readable, writable []string
+		for _, note := range notes {
+			switch resp := do(http.MethodGet, "/api/note/"+note, ""); resp.Code {
+			case http.StatusOK:
+				readable = append(readable, note)
+			case http.StatusForbidden:
+			default:
+				t.Errorf("%s: reading %s: got %d", name, note, resp.Code)
+			}
+			switch resp := do(http.MethodPut, "/api/note/"+note, "changed"); resp.Code {
+			case http.StatusOK:
+				writable = append(writable, note)
+			case http.StatusForbidden:
+				if body, _, err := app.datastore.getNote(note); err != nil || string(body) != "original" {
+					t.Errorf("%s: %s says %q, %v after a refused write", name, note, body, err)
+				}
+			default:
+				t.Errorf("%s: writing %s: got %d", name, note, resp.Code)
+			}
+		}
+		if !equalStrings(readable, c.wantRead) {
+			t.Errorf("%s: could read %v, want %v", name, readable, c.wantRead)
+		}
+		if !equalStrings(writable, c.wantWrite) {
+			t.Errorf("%s: could write %v, want %v", name, writable, c.wantWrite)
+		}
+
+		resp := do(http.MethodGet, "/api/notes", "")
+		var listed []NoteInfo
+		if err := json.Unmarshal(resp.Body.Bytes(), &listed); err != nil {
+			t.Fatalf("%s: listing notes: got %d %q", name, resp.Code, resp.Body)
+		}
+		var names []string
+		for _, note := range listed {
+			names = append(names, note.Name)
+		}
+		sort.Strings(names)
+		if !equalStrings(names, c.wantRead) {
+			t.Errorf("%s: listed %v, want %v", name, names, c.wantRead)
+		}
+		app.Close()
+	}
+}
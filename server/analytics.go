@@ -0,0 +1,163 @@
+package server
+
+import (
+	"encoding/json"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most views waiting to be recorded; any more are dropped, so reads never wait for the database
+const analyticsQueueSize = 4096
+
+// most views recorded in one transaction
+const analyticsBatchSize = 256
+
+// viewers' addresses are truncated to networks of these sizes, so they can't be told apart
+const (
+	viewerIPv4Bits = 24
+	viewerIPv6Bits = 48
+)
+
+// days of views given by GET /api/note/:note/stats if ?days isn't, and the most which can be
+const (
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+// distinct viewers given by GET /api/note/:note/stats
+const statsRecentViewers = 10
+
+// counts views which were dropped because the queue was full
+var droppedViews = expvar.NewInt("dropped_views")
+
+// Analytics records views of notes in the background
+// a nil *Analytics records nothing, for -analytics=false
+type Analytics struct {
+	datastore      Datastore
+	trustedProxies []*net.IPNet
+	queue          chan viewEvent
+	// stop the recording loop, and are closed once it has recorded every queued view
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewAnalytics(datastore Datastore, trustedProxies []*net.IPNet) *Analytics {
+	return &Analytics{
+		datastore:      datastore,
+		trustedProxies: trustedProxies,
+		queue:          make(chan viewEvent, analyticsQueueSize),
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// queues a view of the note stored under key, without waiting
+func (a *Analytics) recordView(req *http.Request, key string) {
+	if a == nil {
+		return
+	}
+	view := viewEvent{note: key, client: viewerNetwork(clientIP(req, a.trustedProxies)), time: a.datastore.now()}
+	select {
+	case a.queue <- view:
+	default:
+		droppedViews.Add(1)
+	}
+}
+
+// the network an address is in, like 203.0.113.0/24
+func viewerNetwork(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "unknown"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return (&net.IPNet{IP: ip4.Mask(net.CIDRMask(viewerIPv4Bits, 32)), Mask: net.CIDRMask(viewerIPv4Bits, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(viewerIPv6Bits, 128)), Mask: net.CIDRMask(viewerIPv6Bits, 128)}).String()
+}
+
+// records queued views until stopped, in batches
+func (a *Analytics) run() {
+	defer close(a.done)
+	for {
+		select {
+		case view := <-a.queue:
+			a.record(append(a.drain(analyticsBatchSize-1), view))
+		case <-a.stop:
+			for len(a.queue) > 0 {
+				a.record(a.drain(analyticsBatchSize))
+			}
+			return
+		}
+	}
+}
+
+// takes up to n queued views, without waiting for more
+func (a *Analytics) drain(n int) []viewEvent {
+	views := make([]viewEvent, 0, n+1)
+	for len(views) < n {
+		select {
+		case view := <-a.queue:
+			views = append(views, view)
+		default:
+			return views
+		}
+	}
+	return views
+}
+
+func (a *Analytics) record(views []viewEvent) {
+	err := a.datastore.addViews(views)
+	if err != nil {
+		log.Printf("recording %d views: %v", len(views), err)
+	}
+}
+
+// the views waiting to be recorded, for diagnostics
+func (a *Analytics) diagnostics() interface{} {
+	return map[string]int64{"queued": int64(len(a.queue)), "dropped": droppedViews.Value()}
+}
+
+// stops recording views, waiting for the queued views to be recorded
+func (a *Analytics) shutdown() {
+	close(a.stop)
+	<-a.done
+}
+
+// responds with how often a note has been viewed as json
+// the days query parameter sets how many days of views are given
+func GetNoteStats(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		days := defaultStatsDays
+		if query := req.URL.Query().Get("days"); query != "" {
+			var err error
+			days, err = strconv.Atoi(query)
+			if err != nil || days < 1 || days > maxStatsDays {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "days must be between 1 and "+strconv.Itoa(maxStatsDays))
+				return
+			}
+		}
+		stats, ok, err := datastore.getNoteStats(noteName, days, statsRecentViewers)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error getting stats of note %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(stats)
+		if err != nil {
+			log.Printf("responding with stats of note %s: %v", noteName, err)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package server_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// each case starts with a note "existing" which says "hello",
+// and after the request checks what a GET of the note it names returns
+func TestNoteAPI(t *testing.T) {
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+		// the note to GET after the request, if any, and what it should say
+		note           string
+		wantNoteStatus int
+		wantNote       string
+	}{
+		{"create", http.MethodPut, "/api/note/fresh", "hi", http.StatusCreated, "", "fresh", http.StatusOK, "hi"},
+		{"create with post", http.MethodPost, "/api/note/fresh", "hi", http.StatusCreated, "", "fresh", http.StatusOK, "hi"},
+		{"read", http.MethodGet, "/api/note/existing", "", http.StatusOK, "hello", "", 0, ""},
+		{"read missing", http.MethodGet, "/api/note/missing", "", http.StatusNotFound, "", "", 0, ""},
+		{"update", http.MethodPut, "/api/note/existing", "bye", http.StatusOK, "", "existing", http.StatusOK, "bye"},
+		{"delete", http.MethodDelete, "/api/note/existing", "", http.StatusOK, "", "existing", http.StatusGone, ""},
+		{"delete missing", http.MethodDelete, "/api/note/missing", "", http.StatusOK, "", "existing", http.StatusOK, "hello"},
+		{"conflict", http.MethodPost, "/api/note/existing", "bye", http.StatusConflict, "", "existing", http.StatusOK, "hello"},
+		{"reserved name", http.MethodPut, "/api/note/_fresh", "hi", http.StatusBadRequest, "", "", 0, ""},
+		{"index", http.MethodGet, "/", "", http.StatusOK, `href="/note/existing"`, "", 0, ""},
+		{"create in a folder", http.MethodPut, "/api/note/ci/build-1", "ok", http.StatusCreated, "", "ci/build-1", http.StatusOK, "ok"},
+		{"create with an escaped slash", http.MethodPut, "/api/note/ci%2Fbuild-1", "ok", http.StatusCreated, "", "ci/build-1", http.StatusOK, "ok"},
+		{"create named like an action", http.MethodPut, "/api/note/print", "hi", http.StatusCreated, "", "print", http.StatusOK, "hi"},
+		{"action word in a folder", http.MethodPut, "/api/note/ci/print", "hi", http.StatusBadRequest, "invalid_name", "", 0, ""},
+		{"empty folder", http.MethodPut, "/api/note/ci%2F%2Fbuild", "hi", http.StatusBadRequest, "", "", 0, ""},
+		{"dot dot", http.MethodPut, "/api/note/ci%2F..%2Fbuild", "hi", http.StatusBadRequest, "", "", 0, ""},
+		{"trailing slash", http.MethodGet, "/api/note/existing/", "", http.StatusNotFound, "", "", 0, ""},
+		{"wrong method for an action", http.MethodPut, "/api/note/existing/lines", "", http.StatusMethodNotAllowed, "", "", 0, ""},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			s := servertest.New(t, servertest.Config(t))
+			if status, body := s.Do(t, http.MethodPut, "/api/note/existing", "hello"); status != http.StatusCreated {
+				t.Fatalf("creating existing: got %d %q", status, body)
+			}
+
+			status, body := s.Do(t, c.method, c.path, c.body)
+			if status != c.wantStatus || !strings.Contains(body, c.wantBody) {
+				t.Fatalf("%s %s: got %d %q, want %d containing %q", c.method, c.path, status, body, c.wantStatus, c.wantBody)
+			}
+			if c.note == "" {
+				return
+			}
+			status, body = s.Do(t, http.MethodGet, "/api/note/"+c.note, "")
+			if status != c.wantNoteStatus || (status == http.StatusOK && body != c.wantNote) {
+				t.Fatalf("then GET %s: got %d %q, want %d %q", c.note, status, body, c.wantNoteStatus, c.wantNote)
+			}
+		})
+	}
+}
+
+func TestAuth(t *testing.T) {
+	cases := []struct {
+		name       string
+		authOn     bool
+		creds      string
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{"off, raw", false, "", http.MethodGet, "/api/note/existing", http.StatusOK},
+		{"off, index", false, "", http.MethodGet, "/", http.StatusOK},
+		{"off, write", false, "", http.MethodPut, "/api/note/existing", http.StatusOK},
+		{"off, ignores credentials", false, "alice:wrong", http.MethodGet, "/api/note/existing", http.StatusOK},
+		{"on, raw", true, "alice:secret", http.MethodGet, "/api/note/existing", http.StatusOK},
+		{"on, index", true, "alice:secret", http.MethodGet, "/", http.StatusOK},
+		{"on, write", true, "alice:secret", http.MethodPut, "/api/note/existing", http.StatusOK},
+		{"on, raw without credentials", true, "", http.MethodGet, "/api/note/existing", http.StatusUnauthorized},
+		{"on, index without credentials", true, "", http.MethodGet, "/", http.StatusUnauthorized},
+		{"on, write without credentials", true, "", http.MethodPut, "/api/note/existing", http.StatusUnauthorized},
+		{"on, wrong password", true, "alice:wrong", http.MethodGet, "/api/note/existing", http.StatusUnauthorized},
+		{"on, unknown user", true, "mallory:secret", http.MethodGet, "/api/note/existing", http.StatusUnauthorized},
+		{"on, health", true, "", http.MethodGet, "/health", http.StatusOK},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			config := servertest.Config(t)
+			if c.authOn {
+				config.Credentials = map[string]bool{"alice:secret": true}
+			}
+			s := servertest.New(t, config)
+			if status, body := s.DoAs(t, "alice:secret", http.MethodPut, "/api/note/existing", "hello"); status != http.StatusCreated {
+				t.Fatalf("creating existing: got %d %q", status, body)
+			}
+
+			status, body := s.DoAs(t, c.creds, c.method, c.path, "hi")
+			if status != c.wantStatus {
+				t.Fatalf("%s %s as %q: got %d %q, want %d", c.method, c.path, c.creds, status, body, c.wantStatus)
+			}
+		})
+	}
+}
+
+// /debug/vars is for admins, and never shows the command line, which may hold credentials
+func TestDebugVars(t *testing.T) {
+	config := servertest.Config(t)
+	config.Credentials = map[string]bool{"alice:secret": true, "bob:hunter2": true}
+	config.Admins = map[string]bool{"alice": true}
+	s := servertest.New(t, config)
+
+	if status, body := s.DoAs(t, "bob:hunter2", http.MethodGet, "/debug/vars", ""); status != http.StatusForbidden {
+		t.Fatalf("as a user who isn't an admin: got %d %q, want 403", status, body)
+	}
+	status, body := s.DoAs(t, "alice:secret", http.MethodGet, "/debug/vars", "")
+	if status != http.StatusOK {
+		t.Fatalf("as an admin: got %d %q", status, body)
+	}
+	var vars map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &vars); err != nil {
+		t.Fatalf("%q isn't json: %v", body, err)
+	}
+	if _, ok := vars["writes_in_flight"]; !ok {
+		t.Errorf("writes_in_flight missing from %q", body)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Errorf("the command line leaked into %q", body)
+	}
+}
+
+// notes in folders get the same pages as any other note
+func TestFolderNotes(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/ci/build 1", "all green"); status != http.StatusCreated {
+		t.Fatalf("creating ci/build 1: got %d %q", status, body)
+	}
+	cases := []struct {
+		path     string
+		wantBody string
+	}{
+		{"/note/ci/build%201", "all green"},
+		{"/note/ci/build%201/print", "all green"},
+		{"/api/note/ci/build%201/metadata", `"name":"ci/build 1"`},
+		{"/api/notes?prefix=ci/", `"name":"ci/build 1"`},
+		{"/", `href="/note/ci/build%201"`},
+	}
+	for _, c := range cases {
+		status, body := s.Do(t, http.MethodGet, c.path, "")
+		if status != http.StatusOK || !strings.Contains(body, c.wantBody) {
+			t.Errorf("GET %s: got %d %q, want 200 containing %q", c.path, status, body, c.wantBody)
+		}
+	}
+}
+
+// with -private-notes, a slash in a shared note's name would read as an owner's
+func TestFolderNotesPrivate(t *testing.T) {
+	config := servertest.Config(t)
+	config.Credentials = map[string]bool{"alice:secret": true}
+	config.PrivateNotes = true
+	s := servertest.New(t, config)
+	if status, body := s.DoAs(t, "alice:secret", http.MethodPut, "/api/note/ci/build", "mine"); status != http.StatusCreated {
+		t.Fatalf("creating a private note in a folder: got %d %q", status, body)
+	}
+	if status, body := s.DoAs(t, "alice:secret", http.MethodGet, "/api/note/ci/build", ""); status != http.StatusOK || body != "mine" {
+		t.Fatalf("reading it back: got %d %q", status, body)
+	}
+	if status, body := s.DoAs(t, "alice:secret", http.MethodPut, "/shared/api/note/ci/build", "ours"); status != http.StatusBadRequest {
+		t.Fatalf("creating a shared note in a folder: got %d %q, want 400", status, body)
+	}
+}
+
+func TestFolderView(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	for _, name := range []string{"todo", "work/plan", "work/q4/budget"} {
+		if status, body := s.Do(t, http.MethodPut, "/api/note/"+name, "body"); status != http.StatusCreated {
+			t.Fatalf("creating %s: got %d %q", name, status, body)
+		}
+	}
+	cases := []struct {
+		path     string
+		want     []string
+		dontWant []string
+	}{
+		{"/", []string{`href="/notes?view=tree"`}, nil},
+		{"/notes?view=tree", []string{`folder=work">work/</a> <span class="badge" title="Notes">2</span>`, `(no folder)</a> <span class="badge" title="Notes">1</span>`}, []string{`href="/note/work/plan"`}},
+		{"/notes?view=tree&folder=work", []string{`href="/note/work/plan"`, `href="/note/work/q4/budget"`}, []string{`href="/note/todo"`}},
+		{"/notes?view=tree&folder=", []string{`href="/note/todo"`}, []string{`href="/note/work/plan"`}},
+	}
+	for _, c := range cases {
+		status, body := s.Do(t, http.MethodGet, c.path, "")
+		if status != http.StatusOK {
+			t.Errorf("GET %s: got %d %q", c.path, status, body)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: %q missing from %q", c.path, want, body)
+			}
+		}
+		for _, dontWant := range c.dontWant {
+			if strings.Contains(body, dontWant) {
+				t.Errorf("GET %s: %q in %q", c.path, dontWant, body)
+			}
+		}
+	}
+}
+
+// a note's metadata has the same hash as reading it does
+func TestMetadataHash(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/todo", "hello"); status != http.StatusCreated {
+		t.Fatalf("creating todo: got %d %q", status, body)
+	}
+	// the SHA-256 of "hello"
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for _, path := range []string{"/api/note/todo", "/api/note/todo/metadata"} {
+		resp, err := s.Client().Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Content-SHA256"); got != want {
+			t.Errorf("GET %s: X-Content-SHA256 is %q, want %q", path, got, want)
+		}
+	}
+	_, body := s.Do(t, http.MethodGet, "/api/note/todo/metadata", "")
+	var metadata struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal([]byte(body), &metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Hash != want {
+		t.Errorf("the metadata's hash is %q, want %q", metadata.Hash, want)
+	}
+}
+
+// a write over -quota is refused with 507 and the quota's numbers, from the api and the paste form alike
+func TestQuotaExceeded(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t, "-quota", "10"))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/todo", "123456"); status != http.StatusCreated {
+		t.Fatalf("creating todo: got %d %q", status, body)
+	}
+	status, body := s.Do(t, http.MethodPut, "/api/note/more", "12345")
+	if status != http.StatusInsufficientStorage {
+		t.Fatalf("writing over the quota: got %d %q, want 507", status, body)
+	}
+	var apiErr struct {
+		Error struct {
+			Code  string `json:"code"`
+			Quota struct {
+				Used   int64 `json:"used"`
+				Needed int64 `json:"needed"`
+				Quota  int64 `json:"quota"`
+			} `json:"quota"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &apiErr); err != nil {
+		t.Fatal(err)
+	}
+	if apiErr.Error.Code != "quota_exceeded" || apiErr.Error.Quota.Used != 6 || apiErr.Error.Quota.Needed != 5 || apiErr.Error.Quota.Quota != 10 {
+		t.Errorf("the error is %+v, want quota_exceeded with 6 used, 5 needed and a quota of 10", apiErr.Error)
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/more", ""); status != http.StatusNotFound {
+		t.Errorf("the refused note: got %d %q, want 404", status, body)
+	}
+
+	resp, err := s.Client().PostForm(s.URL+"/paste", url.Values{"f": {"12345"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInsufficientStorage {
+		t.Errorf("pasting over the quota: got %d, want 507", resp.StatusCode)
+	}
+}
+
+// each failure of the api is reported with its status and a stable code in the error envelope;
+// each case starts with a note "todo" saying "1", with alice's lock on it, then sends its request as bob,
+// twice if the case is to be rate limited
+func TestAPIErrorCodes(t *testing.T) {
+	cases := []struct {
+		name       string
+		args       []string
+		method     string
+		path       string
+		body       string
+		header     http.Header
+		wantStatus int
+		wantCode   string
+	}{
+		{"not found", nil, http.MethodGet, "/api/note/missing", "", nil, http.StatusNotFound, "not_found"},
+		{"note exists", nil, http.MethodPost, "/api/note/todo", "2", nil, http.StatusConflict, "note_exists"},
+		{"invalid name", nil, http.MethodPut, "/api/note/_reserved", "2", nil, http.StatusBadRequest, "invalid_name"},
+		{"empty body", nil, http.MethodPut, "/api/note/todo", "", nil, http.StatusBadRequest, "empty_body"},
+		{"payload too large", nil, http.MethodPost, "/api/note/todo/comments", strings.Repeat("x", 2001), nil, http.StatusRequestEntityTooLarge, "payload_too_large"},
+		{"read only", []string{"-read-only"}, http.MethodPut, "/api/note/todo", "2", nil, http.StatusServiceUnavailable, "read_only"},
+		{"precondition failed", nil, http.MethodPut, "/api/note/todo?if-unmodified-since=2000-01-01T00:00:00Z", "2", nil, http.StatusPreconditionFailed, "precondition_failed"},
+		{"rate limited", []string{"-rate-read", "1", "-rate-read-burst", "1"}, http.MethodGet, "/api/note/todo", "", nil, http.StatusTooManyRequests, "rate_limited"},
+		{"hash mismatch", nil, http.MethodPut, "/api/note/todo", "2", http.Header{"X-Content-Sha256": {strings.Repeat("0", 64)}}, http.StatusUnprocessableEntity, "hash_mismatch"},
+		{"bad request", nil, http.MethodPost, "/api/note/todo/increment?by=x", "", nil, http.StatusBadRequest, "bad_request"},
+		{"locked", nil, http.MethodDelete, "/api/note/todo/lock", "", nil, http.StatusConflict, "locked"},
+		{"unauthorized", nil, http.MethodGet, "/api/note/todo", "", http.Header{"Authorization": {"Basic Ym9iOndyb25n"}}, http.StatusUnauthorized, "unauthorized"},
+		{"quota exceeded", []string{"-quota", "5"}, http.MethodPut, "/api/note/more", "123456", nil, http.StatusInsufficientStorage, "quota_exceeded"},
+		{"too large for -max-note-size", []string{"-max-note-size", "5"}, http.MethodPut, "/api/note/more", "123456", nil, http.StatusUnprocessableEntity, "rejected"},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			config := servertest.Config(t, c.args...)
+			config.Credentials = map[string]bool{"alice:secret": true, "bob:secret": true}
+			s := servertest.New(t, config)
+			if c.wantCode != "read_only" {
+				if status, body := s.DoAs(t, "alice:secret", http.MethodPut, "/api/note/todo", "1"); status != http.StatusCreated {
+					t.Fatalf("creating todo: got %d %q", status, body)
+				}
+				if status, body := s.DoAs(t, "alice:secret", http.MethodPost, "/api/note/todo/lock", ""); status != http.StatusOK {
+					t.Fatalf("locking todo: got %d %q", status, body)
+				}
+			}
+
+			req, err := http.NewRequest(c.method, s.URL+c.path, strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.SetBasicAuth("bob", "secret")
+			for key, values := range c.header {
+				req.Header[key] = values
+			}
+			if c.wantCode == "rate_limited" {
+				resp, err := s.Client().Do(req.Clone(req.Context()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			resp, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var envelope struct {
+				Error struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+					Status  int    `json:"status"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+				t.Fatalf("got %d, which isn't an error envelope: %v", resp.StatusCode, err)
+			}
+			got := envelope.Error
+			if resp.StatusCode != c.wantStatus || got.Status != c.wantStatus || got.Code != c.wantCode || got.Message == "" {
+				t.Errorf("got %d with %+v, want %d with the code %s", resp.StatusCode, got, c.wantStatus, c.wantCode)
+			}
+		})
+	}
+}
+
+// pages keep their plain error pages, where the api would give json
+func TestPageErrorsArentJSON(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	resp, err := s.Client().Get(s.URL + "/note/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusNotFound || strings.Contains(resp.Header.Get("Content-Type"), "json") || !strings.HasPrefix(string(body), "404 Not Found") {
+		t.Errorf("got %d %q as %s, want a 404 page", resp.StatusCode, body, resp.Header.Get("Content-Type"))
+	}
+}
+
+// a note which was deleted is 410 wherever it's read, with when it was deleted, and a note which never was is 404
+func TestDeletedNotesAreGone(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/gone", "hello"); status != http.StatusCreated {
+		t.Fatalf("creating gone: got %d %q", status, body)
+	}
+	if status, body := s.Do(t, http.MethodDelete, "/api/note/gone", ""); status != http.StatusOK {
+		t.Fatalf("deleting gone: got %d %q", status, body)
+	}
+	paths := []struct {
+		path string
+		api  bool
+	}{
+		{"/note/%s", false},
+		{"/note/%s/print", false},
+		{"/go/%s", false},
+		{"/api/note/%s", true},
+		{"/api/note/%s/lines", true},
+		{"/api/note/%s/export", true},
+		{"/api/note/%s/metadata", true},
+		{"/api/note/%s/text", true},
+		{"/api/note/%s/links", true},
+	}
+	for _, p := range paths {
+		gone := strings.Replace(p.path, "%s", "gone", 1)
+		status, body := s.Do(t, http.MethodGet, gone, "")
+		if status != http.StatusGone {
+			t.Errorf("%s: got %d %q, want 410", gone, status, body)
+		} else if p.api {
+			var apiErr struct {
+				Error struct {
+					Code      string     `json:"code"`
+					DeletedAt *time.Time `json:"deletedAt"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(body), &apiErr); err != nil || apiErr.Error.Code != "deleted" || apiErr.Error.DeletedAt == nil {
+				t.Errorf("%s: the error is %q, want deleted, with when", gone, body)
+			}
+		} else if !strings.Contains(body, "deleted") {
+			t.Errorf("%s: the page says %q, want that the note was deleted", gone, body)
+		}
+
+		never := strings.Replace(p.path, "%s", "never", 1)
+		if status, body := s.Do(t, http.MethodGet, never, ""); status != http.StatusNotFound {
+			t.Errorf("%s: got %d %q, want 404", never, status, body)
+		}
+	}
+
+	// writing it again makes it an ordinary note
+	if status, body := s.Do(t, http.MethodPut, "/api/note/gone", "again"); status != http.StatusCreated {
+		t.Fatalf("writing gone again: got %d %q", status, body)
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/gone", ""); status != http.StatusOK || body != "again" {
+		t.Errorf("after writing it again: got %d %q", status, body)
+	}
+}
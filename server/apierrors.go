@@ -0,0 +1,149 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// stable codes identifying why an api request failed, so clients needn't match on messages
+const (
+	ERR_BAD_REQUEST          = "bad_request"
+	ERR_INVALID_NAME         = "invalid_name"
+	ERR_EMPTY_BODY           = "empty_body"
+	ERR_UNAUTHORIZED         = "unauthorized"
+	ERR_FORBIDDEN            = "forbidden"
+	ERR_NOT_FOUND            = "not_found"
+	ERR_TEMPLATE_NOT_FOUND   = "template_not_found"
+	ERR_CONFLICT             = "conflict"
+	ERR_NOTE_EXISTS          = "note_exists"
+	ERR_ATTACHMENT_EXISTS    = "attachment_exists"
+	ERR_DUPLICATE            = "duplicate"
+	ERR_LOCKED               = "locked"
+	ERR_PRECONDITION_FAILED  = "precondition_failed"
+	ERR_PAYLOAD_TOO_LARGE    = "payload_too_large"
+	ERR_UNSUPPORTED_ENCODING = "unsupported_encoding"
+	ERR_NOT_TEXT             = "not_text"
+	ERR_HASH_MISMATCH        = "hash_mismatch"
+	ERR_NOT_A_NUMBER         = "not_a_number"
+	ERR_CURSOR_EXPIRED       = "cursor_expired"
+	ERR_RATE_LIMITED         = "rate_limited"
+	ERR_INTERNAL             = "internal_error"
+	ERR_BUSY                 = "busy"
+	ERR_READ_ONLY            = "read_only"
+	ERR_FETCH_FAILED         = "fetch_failed"
+	ERR_DELETED              = "deleted"
+	ERR_REJECTED             = "rejected"
+	ERR_QUOTA_EXCEEDED       = "quota_exceeded"
+)
+
+// the code for an error with each status, when nothing more specific applies
+var defaultErrorCodes = map[int]string{
+	http.StatusBadRequest:            ERR_BAD_REQUEST,
+	http.StatusUnauthorized:          ERR_UNAUTHORIZED,
+	http.StatusForbidden:             ERR_FORBIDDEN,
+	http.StatusNotFound:              ERR_NOT_FOUND,
+	http.StatusGone:                  ERR_DELETED,
+	http.StatusConflict:              ERR_CONFLICT,
+	http.StatusRequestEntityTooLarge: ERR_PAYLOAD_TOO_LARGE,
+	http.StatusUnsupportedMediaType:  ERR_UNSUPPORTED_ENCODING,
+	http.StatusTooManyRequests:       ERR_RATE_LIMITED,
+	http.StatusInternalServerError:   ERR_INTERNAL,
+	http.StatusServiceUnavailable:    ERR_BUSY,
+	http.StatusBadGateway:            ERR_FETCH_FAILED,
+	http.StatusInsufficientStorage:   ERR_QUOTA_EXCEEDED,
+}
+
+// explanations of the codes which need more than the status text
+var errorMessages = map[string]string{
+	ERR_NOTE_EXISTS:         "a note with that name already exists",
+	ERR_ATTACHMENT_EXISTS:   "an attachment with that name already exists",
+	ERR_TEMPLATE_NOT_FOUND:  "that template doesn't exist",
+	ERR_LOCKED:              "someone else holds the lock",
+	ERR_PRECONDITION_FAILED: "the note's contents aren't what was expected",
+	ERR_HASH_MISMATCH:       "the body doesn't match " + hashHeader,
+	ERR_NOT_A_NUMBER:        "the note isn't an integer",
+	ERR_CURSOR_EXPIRED:      "changes since that cursor have been pruned; fetch every note again",
+	ERR_RATE_LIMITED:        "too many requests; retry later",
+	ERR_BUSY:                "too many writes at once; retry later",
+	ERR_READ_ONLY:           "corkboard is read-only for now; retry later",
+	ERR_NOT_TEXT:            notTextMessage,
+}
+
+// APIErrorData is the body of every error response from an /api/ route
+type APIErrorData struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"status"`
+	// for ERR_DUPLICATE, the note with the same contents
+	DuplicateOf string `json:"duplicateOf,omitempty"`
+	// for ERR_DELETED, when the note was deleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// for ERR_QUOTA_EXCEEDED, how much of the quota is used, and how much more the write needed
+	Quota *QuotaExceeded `json:"quota,omitempty"`
+}
+
+// the api's counterpart to ErrorPage
+func APIError(resp http.ResponseWriter, status int, code string) {
+	message, ok := errorMessages[code]
+	if !ok {
+		message = http.StatusText(status)
+	}
+	APIErrorMessage(resp, status, code, message)
+}
+
+// the api's counterpart to ErrorMessage
+func APIErrorMessage(resp http.ResponseWriter, status int, code string, message string) {
+	writeAPIError(resp, APIErrorDetail{Code: code, Message: message, Status: status})
+}
+
+func writeAPIError(resp http.ResponseWriter, detail APIErrorDetail) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.Header().Set("X-Content-Type-Options", "nosniff")
+	resp.WriteHeader(detail.Status)
+	err := json.NewEncoder(resp).Encode(APIErrorData{Error: detail})
+	if err != nil {
+		log.Printf("responding with error: %v", err)
+	}
+}
+
+// whether a request is to the api, rather than for a page
+func isAPIRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, "/api/")
+}
+
+// for middleware shared by pages and the api: responds with an error envelope to api requests,
+// or an error page otherwise
+func errorResponse(resp http.ResponseWriter, req *http.Request, status int) {
+	if isAPIRequest(req) {
+		APIError(resp, status, defaultErrorCodes[status])
+	} else {
+		ErrorPage(resp, status)
+	}
+}
+
+// responds to a request for a note which doesn't exist: 410 if it was deleted, as far as the change log
+// remembers, so clients can tell a note which is gone from one which never was, or 404 otherwise
+func noteNotFound(resp http.ResponseWriter, req *http.Request, datastore Datastore, name string) {
+	deleted, ok, err := datastore.deletedAt(name)
+	if err != nil {
+		// the note is missing either way, so this isn't worth failing the request over
+		log.Printf("looking up deletion of %s: %v", name, err)
+	}
+	if !ok {
+		errorResponse(resp, req, http.StatusNotFound)
+		return
+	}
+	message := "the note was deleted at " + formatTime(deleted) + "; writing it again creates a new note"
+	if !isAPIRequest(req) {
+		ErrorMessage(resp, http.StatusGone, message)
+		return
+	}
+	writeAPIError(resp, APIErrorDetail{Code: ERR_DELETED, Message: message, Status: http.StatusGone, DeletedAt: &deleted})
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// App is a corkboard server: its databases, the router serving them, and the work done in the background
+// NewApp sets it up without starting anything, Run serves it until its context is done, and Close closes its databases
+type App struct {
+	config      Config
+	datastore   Datastore
+	maintenance *Maintenance
+	settings    *Settings
+	listeners   Listeners
+	watcher     *Watcher
+	// each of these is nil if it isn't configured
+	mirror      *Mirror
+	notifier    *Notifier
+	analytics   *Analytics
+	scheduler   *Scheduler
+	replicator  *Replicator
+	linkChecker *LinkChecker
+	digester    *Digester
+	boards      []*Board
+	diagnostics *Diagnostics
+	router      http.Handler
+}
+
+// NewApp opens and checks the database, and builds everything config asks for
+// the database's schema must be up to date, unless config says otherwise; see checkSchema
+func NewApp(config Config) (*App, error) {
+	templates, static, err := loadAssets(config)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		return nil, err
+	}
+	// every datastore shares the hooks, so those registered with the app later apply to all of them
+	config.Hooks = config.Hooks.withDefaults(config)
+	datastore, err := openDatastore(config)
+	if err != nil {
+		return nil, fmt.Errorf("error opening db %s: %s", config.DatabasePath, err)
+	}
+	config.requestStats = NewRequestStats(config.StatsWindow)
+	app := &App{config: config, datastore: datastore, maintenance: NewMaintenance(datastore), diagnostics: NewDiagnostics()}
+	err = app.setup(templates, static, migrations)
+	if err != nil {
+		app.Close()
+		return nil, err
+	}
+	return app, nil
+}
+
+func (app *App) setup(templates *template.Template, static *StaticAssets, migrations fs.FS) error {
+	config := app.config
+	err := checkIntegrity(app.maintenance, config)
+	if err != nil {
+		return err
+	}
+
+	err = checkSchema(app.datastore, migrations, config.Schema)
+	if err != nil {
+		return err
+	}
+	err = app.datastore.backfillHashes()
+	if err != nil {
+		return fmt.Errorf("error hashing notes: %s", err)
+	}
+	err = app.datastore.backfillRetentionOverrides()
+	if err != nil {
+		return fmt.Errorf("error applying retention hooks: %s", err)
+	}
+
+	// everything which is told about changes to notes
+	index := &IndexCache{}
+	app.listeners = Listeners{index}
+
+	app.settings, err = loadSettings(app.datastore, index, config)
+	if err != nil {
+		return fmt.Errorf("error loading settings: %s", err)
+	}
+
+	// the background work and state reported on SIGUSR2
+	app.diagnostics.register("database", app.datastore.diagnostics)
+	app.diagnostics.register("settings", func() interface{} { return app.settings.list() })
+	app.diagnostics.register("maintenance", app.maintenance.diagnostics)
+
+	if config.MirrorURL != "" {
+		app.mirror = NewMirror(app.datastore, config.MirrorURL, config.MirrorCredentials)
+		app.listeners = append(app.listeners, app.mirror)
+		app.diagnostics.register("mirror", app.mirror.diagnostics)
+	}
+
+	if config.Notify.SlackWebhook != "" || config.Notify.MatrixServer != "" {
+		app.notifier = NewNotifier(config.Notify, config.BaseURL)
+		app.listeners = append(app.listeners, app.notifier)
+		app.diagnostics.register("notifications", app.notifier.diagnostics)
+		app.maintenance.warnExpiring = app.notifier.warnExpiring
+	}
+
+	app.watcher = NewWatcher(app.datastore, config.BaseURL, config.AllowInternalFetch)
+	app.listeners = append(app.listeners, app.watcher)
+	app.diagnostics.register("watches", app.watcher.diagnostics)
+
+	if config.ReplicaDir != "" {
+		app.replicator = NewReplicator(app.datastore, config.ReplicaDir, config.ReplicaKeep)
+	}
+
+	if config.ScheduleFile != "" {
+		app.scheduler = NewScheduler(app.datastore, config.Schedule, app.listeners)
+	}
+
+	if config.Analytics {
+		app.analytics = NewAnalytics(app.datastore, config.RateLimits.TrustedProxies)
+		app.diagnostics.register("analytics", app.analytics.diagnostics)
+	}
+
+	if config.LinkCheck.Interval != 0 {
+		app.linkChecker = NewLinkChecker(app.datastore, config.LinkCheck, config.AllowInternalFetch)
+		app.diagnostics.register("link checks", app.linkChecker.diagnostics)
+	}
+
+	if config.Digest.schedule != nil {
+		digestTemplate, err := loadDigestTemplate(config.TemplatesDir, config.Dev)
+		if err != nil {
+			return fmt.Errorf("error loading templates: %s", err)
+		}
+		app.digester = NewDigester(app.datastore, config.Digest, digestTemplate, app.settings.expiry, app.listeners, config.BaseURL)
+		if config.Digest.Notify {
+			app.digester.notify = app.notifier.send
+		}
+		app.diagnostics.register("digests", app.digester.diagnostics)
+	}
+
+	// commands only work on the main board
+	if config.command == "" {
+		for _, boardConfig := range config.Boards {
+			board, err := openBoard(boardConfig, config, templates, static, migrations)
+			if err != nil {
+				return err
+			}
+			app.boards = append(app.boards, board)
+			app.diagnostics.register("board "+board.config.name+" database", board.datastore.diagnostics)
+			app.diagnostics.register("board "+board.config.name+" maintenance", board.maintenance.diagnostics)
+		}
+	}
+
+	app.router = makeRouter(templates, static, config, app.datastore, app.listeners, app.maintenance, index, app.settings, app.analytics, app.boards)
+	if len(app.boards) > 0 {
+		app.router = routeBoards(app.router, app.boards)
+	}
+	return nil
+}
+
+// OnWrite adds a write hook to those in Config.Hooks; see Hooks
+// it must be called before the app starts serving
+func (app *App) OnWrite(hook WriteHook) {
+	app.config.Hooks.OnWrite(hook)
+}
+
+// OnRetention adds a retention hook to those in Config.Hooks, and asks it about every note; see Hooks
+// it must be called before the app starts serving
+func (app *App) OnRetention(hook RetentionHook) error {
+	app.config.Hooks.OnRetention(hook)
+	datastores := []Datastore{app.datastore}
+	for _, board := range app.boards {
+		datastores = append(datastores, board.datastore)
+	}
+	for _, datastore := range datastores {
+		if err := datastore.backfillRetentionOverrides(); err != nil {
+			return fmt.Errorf("error applying retention hooks: %s", err)
+		}
+	}
+	return nil
+}
+
+// Router is the handler serving every request, including to boards
+func (app *App) Router() http.Handler {
+	return app.config.requestStats.middleware(app.diagnostics.countRequests(app.router))
+}
+
+// Run serves the app on config.Port, and tcp pastes on their port, until ctx is done or serving fails,
+// doing the background work config asks for meanwhile
+// either way, it waits up to shutdownTimeout for requests to finish and stops the background work,
+// then returns why serving failed, or nil if ctx is done
+func (app *App) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(app.config.Port))
+	if err != nil {
+		return err
+	}
+	return app.Serve(ctx, listener)
+}
+
+// Serve is like Run, but serves http on a listener of the caller's, which it closes
+func (app *App) Serve(ctx context.Context, listener net.Listener) error {
+	config := app.config
+	server := &http.Server{Handler: app.Router()}
+	var tcpListener net.Listener
+	var err error
+	if config.TCPPaste.Port != 0 {
+		tcpListener, err = net.Listen("tcp", ":"+strconv.Itoa(config.TCPPaste.Port))
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("error listening for tcp pastes: %v", err)
+		}
+	}
+
+	app.start()
+	defer app.stop()
+
+	// whichever way of serving fails first
+	failed := make(chan error, 2)
+	go func() {
+		failed <- server.Serve(listener)
+	}()
+	if tcpListener != nil {
+		go func() {
+			failed <- serveTCPPaste(tcpListener, app.datastore, config.Names, config.TCPPaste, config.BaseURL, app.listeners, app.settings)
+		}()
+	}
+
+	for _, line := range app.summary() {
+		log.Print(line)
+	}
+	log.Print("Running")
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+	log.Print("Shutting down")
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		log.Printf("error shutting down server: %v", shutdownErr)
+	}
+	return err
+}
+
+// the summary of what the app has enabled, logged when it starts serving; see configSummary
+func (app *App) summary() []string {
+	config := app.config
+	// settings changed at runtime win over the flags
+	config.ReadOnly = app.settings.readOnly()
+	schema, err := app.datastore.schemaVersion()
+	if err != nil {
+		log.Printf("finding the database's schema version: %v", err)
+	}
+	return configSummary(config, app.settings.expiry(), schema)
+}
+
+// starts the background work
+func (app *App) start() {
+	config := app.config
+	if app.mirror != nil {
+		go app.mirror.run()
+	}
+	if app.notifier != nil {
+		go app.notifier.run()
+	}
+	go app.watcher.run()
+	// begin deleting expired notes and locks every hour
+	app.maintenance.publishStats()
+	go app.maintenance.runCleanup(cleanupInterval, app.settings.expiry)
+	if config.VacuumInterval != 0 {
+		go app.maintenance.runVacuum(config.VacuumInterval, config.VacuumWindow)
+	}
+	if app.replicator != nil {
+		go app.replicator.run(config.ReplicaInterval)
+	}
+	if app.scheduler != nil {
+		go app.scheduler.run()
+	}
+	if app.analytics != nil {
+		go app.analytics.run()
+	}
+	if app.linkChecker != nil {
+		go app.linkChecker.run()
+	}
+	if app.digester != nil {
+		go app.digester.run()
+	}
+	for _, board := range app.boards {
+		board.start()
+	}
+}
+
+// stops the background work, waiting for whatever's in progress to finish
+func (app *App) stop() {
+	for _, board := range app.boards {
+		board.stop()
+	}
+	if app.scheduler != nil {
+		app.scheduler.shutdown()
+	}
+	if app.analytics != nil {
+		app.analytics.shutdown()
+	}
+	if app.linkChecker != nil {
+		app.linkChecker.shutdown()
+	}
+	if app.digester != nil {
+		app.digester.shutdown()
+	}
+	if app.notifier != nil {
+		app.notifier.shutdown()
+	}
+	app.watcher.shutdown()
+	if app.mirror != nil {
+		app.mirror.shutdown()
+	}
+	app.maintenance.shutdown()
+	// the final snapshot comes last, so it has everything
+	if app.replicator != nil {
+		app.replicator.shutdown()
+	}
+}
+
+// Close closes the app's databases; it mustn't be running
+func (app *App) Close() error {
+	for _, board := range app.boards {
+		board.close()
+	}
+	return app.datastore.Close()
+}
@@ -0,0 +1,95 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FBemf/corkboard/server"
+)
+
+// a program embedding corkboard can start it, use it and stop it with only what the package exports
+func TestAppLifecycle(t *testing.T) {
+	config, err := server.ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	config.Credentials = nil
+	app, err := server.NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Serve(ctx, listener)
+	}()
+
+	url := "http://" + listener.Addr().String() + "/health"
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var health struct {
+		Status string `json:"status"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&health)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || health.Status != "ok" {
+		t.Fatalf("got %d %+v (%v), want 200 and status ok", resp.StatusCode, health, err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Serve returned %v after its context was done, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after its context was done")
+	}
+	// it stopped listening, too
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	if resp, err := client.Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatalf("got %d after shutting down, want the connection refused", resp.StatusCode)
+	}
+}
+
+// Run returns at once if it can't listen, rather than starting anything
+func TestAppRunFailsToListen(t *testing.T) {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	config, err := server.ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-db-path", filepath.Join(t.TempDir(), "notes.db"), "-create-db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Port = listener.Addr().(*net.TCPAddr).Port
+	app, err := server.NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+	if err := app.Run(context.Background()); err == nil {
+		t.Fatal("Run succeeded on a port in use")
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArchivedNote is the metadata written alongside an archived note's body
+type ArchivedNote struct {
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	Unlisted    bool      `json:"unlisted"`
+	Title       string    `json:"title,omitempty"`
+	CreateTime  time.Time `json:"create_time"`
+	LastViewed  time.Time `json:"last_viewed"`
+	ArchiveTime time.Time `json:"archive_time"`
+}
+
+// suffix of the metadata file written alongside each archived note
+const archiveMetadataSuffix = ".json"
+
+// longest file name archiveFileName gives, leaving room for archiveMetadataSuffix and a temporary file's suffix
+// within the 255 bytes most filesystems allow
+const maxArchiveFileName = 200
+
+// names Windows reserves for devices, with or without an extension
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// escapes a note name for use as a file name, on any platform
+// names which differ only in case, or are too long to use whole, get a suffix made from a hash of the name,
+// so they don't overwrite each other on case-insensitive filesystems like Windows' and macOS'
+func archiveFileName(name string) string {
+	// ~ is escaped too, so only the hash suffix has one
+	escaped := strings.NewReplacer(":", "%3A", "~", "%7E").Replace(url.PathEscape(name))
+	// don't let names like ".." refer to directories, or create hidden files
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	// Windows drops trailing dots
+	if strings.HasSuffix(escaped, ".") {
+		escaped = escaped[:len(escaped)-1] + "%2E"
+	}
+	base := strings.ToUpper(escaped)
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if reservedFileNames[base] {
+		escaped = fmt.Sprintf("%%%02X", escaped[0]) + escaped[1:]
+	}
+	// only ascii letters are left unescaped, so they're the only ones whose case matters
+	upper := strings.IndexFunc(name, func(r rune) bool { return 'A' <= r && r <= 'Z' }) >= 0
+	if !upper && len(escaped) <= maxArchiveFileName {
+		return escaped
+	}
+	hash := sha256.Sum256([]byte(name))
+	suffix := "~" + hex.EncodeToString(hash[:4])
+	if len(escaped) > maxArchiveFileName-len(suffix) {
+		escaped = escaped[:maxArchiveFileName-len(suffix)]
+		// don't cut an escape in half
+		if i := strings.LastIndex(escaped, "%"); i >= len(escaped)-2 {
+			escaped = escaped[:i]
+		}
+	}
+	return escaped + suffix
+}
+
+// writes a note's body and metadata into a subdirectory of dir for the day it was created
+// archiving the same note again overwrites the same files, so an interrupted archive can be retried
+// both files are synced to disk before this returns
+func archiveNote(dir string, note ArchivedNote, body []byte) error {
+	dayDir := filepath.Join(dir, note.CreateTime.UTC().Format("2006-01-02"))
+	err := os.MkdirAll(dayDir, 0755)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.MarshalIndent(note, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dayDir, archiveFileName(note.Name))
+	err = writeFileSynced(path, body)
+	if err != nil {
+		return err
+	}
+	return writeFileSynced(path+archiveMetadataSuffix, append(metadata, '\n'))
+}
+
+// writes a file through a temporary file, so it's never seen half written,
+// and syncs it and its directory to disk
+func writeFileSynced(path string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncs a directory to disk, so that files renamed into it stay there
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// reads an archived note, given the path of either its body or its metadata
+// returns an error if the body doesn't match the hash in the metadata
+func readArchivedNote(path string) (ArchivedNote, []byte, error) {
+	path = strings.TrimSuffix(path, archiveMetadataSuffix)
+	var note ArchivedNote
+	metadata, err := os.ReadFile(path + archiveMetadataSuffix)
+	if err != nil {
+		return note, nil, err
+	}
+	err = json.Unmarshal(metadata, &note)
+	if err != nil {
+		return note, nil, fmt.Errorf("parsing %s: %v", path+archiveMetadataSuffix, err)
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return note, nil, err
+	}
+	if hashBody(body) != note.Hash {
+		return note, nil, fmt.Errorf("%s doesn't match the hash in its metadata", path)
+	}
+	return note, body, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// response header carrying the banner, so api users see it too
+const bannerHeader = "X-Corkboard-Banner"
+
+// longest banner accepted, in bytes
+const maxBannerSize = 1024
+
+// checks a banner fits in a single header line
+func validateBanner(text string) error {
+	if len(text) > maxBannerSize {
+		return fmt.Errorf("must be at most %d bytes", maxBannerSize)
+	}
+	if !utf8.ValidString(text) {
+		return fmt.Errorf("must be a single line of text")
+	}
+	for _, r := range text {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("must be a single line of text")
+		}
+	}
+	return nil
+}
+
+// adds the banner, the operator's message shown on every page, to every response
+func announceBanner(settings *Settings, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if text := settings.banner(); text != "" {
+			resp.Header().Set(bannerHeader, text)
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// sets the banner to the request body
+// an empty body removes it
+func SetBanner(settings *Settings) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxBannerSize+1))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error reading request body: %v", err)
+			return
+		}
+		if len(data) > maxBannerSize {
+			APIError(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE)
+			return
+		}
+		text := strings.TrimSpace(string(data))
+		changes := map[string]*string{SETTING_BANNER: &text}
+		if text == "" {
+			changes[SETTING_BANNER] = nil
+		}
+		if status, code, message := settings.check(changes); status != 0 {
+			APIErrorMessage(resp, status, code, message)
+			return
+		}
+		err = settings.update(changes)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error setting banner: %v", err)
+			return
+		}
+		if text == "" {
+			resp.Header().Del(bannerHeader)
+			log.Printf("Removed banner")
+		} else {
+			resp.Header().Set(bannerHeader, text)
+			log.Printf("Set banner to %q", text)
+		}
+	}
+}
+
+// removes the banner
+func DeleteBanner(settings *Settings) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		changes := map[string]*string{SETTING_BANNER: nil}
+		if status, code, message := settings.check(changes); status != 0 {
+			APIErrorMessage(resp, status, code, message)
+			return
+		}
+		err := settings.update(changes)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error removing banner: %v", err)
+			return
+		}
+		resp.Header().Del(bannerHeader)
+		log.Printf("Removed banner")
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// the number of notes on the board the benchmarks run against
+const benchmarkNotes = 50000
+
+// rolls back the transaction it's returned from, so a benchmark can delete the same notes each time
+var errRollback = errors.New("rolled back")
+
+// the queries and page which slow down as a board grows, against one board of benchmarkNotes notes,
+// seeded once for all of them
+func BenchmarkLargeBoard(b *testing.B) {
+	path := seededDatabase(b, benchmarkNotes, sizeRange{100, 2000})
+	config := testConfig(b)
+	config.DatabasePath = path
+	datastore, err := openDatastore(config)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer datastore.Close()
+	datastore.setClock(newFakeClock(testEpoch))
+
+	b.Run("getLatestNotes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := datastore.getLatestNotes(config.NumRecentNotes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("deleteOldNotes", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var deleted int64
+			err := datastore.withTx(context.Background(), func(tx Datastore) error {
+				var err error
+				deleted, err = tx.deleteOldNotes(context.Background(), 3*24*time.Hour, 0, EXPIRE_VIEWED, nil)
+				if err != nil {
+					return err
+				}
+				return errRollback
+			})
+			if err != errRollback {
+				b.Fatal(err)
+			}
+			if deleted == 0 {
+				b.Fatal("no notes expired")
+			}
+		}
+	})
+
+	b.Run("Index", func(b *testing.B) {
+		app, err := NewApp(config)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer app.Close()
+		router := app.Router()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/", nil))
+			if resp.Code != http.StatusOK {
+				b.Fatalf("got %d", resp.Code)
+			}
+		}
+	})
+}
+
+// the number of notes on the boards BenchmarkListingBodySize compares
+const listingBenchmarkNotes = 300
+
+// listing notes reads only the note_listing indexes, never the notes' bodies,
+// so it should take as long on a board of notes of a megabyte or two as on one of small notes
+func BenchmarkListingBodySize(b *testing.B) {
+	boards := []struct {
+		name  string
+		sizes sizeRange
+	}{
+		{"small", sizeRange{100, 2000}},
+		{"large", sizeRange{1 << 20, 2 << 20}},
+	}
+	for _, board := range boards {
+		config := testConfig(b)
+		config.DatabasePath = seededDatabase(b, listingBenchmarkNotes, board.sizes)
+		datastore, err := openDatastore(config)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { datastore.Close() })
+		datastore.setClock(newFakeClock(testEpoch))
+		listed, err := datastore.listNotesWithPrefix("")
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(board.name+"/listNotesWithPrefix", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				notes, err := datastore.listNotesWithPrefix("")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if len(notes) != listingBenchmarkNotes {
+					b.Fatalf("listed %d notes, want %d", len(notes), listingBenchmarkNotes)
+				}
+			}
+		})
+		b.Run(board.name+"/getNewestNotes", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := datastore.getNewestNotes(config.NumRecentNotes); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(board.name+"/getNoteInfo", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, ok, err := datastore.getNoteInfo(listed[i%len(listed)].Name); err != nil || !ok {
+					b.Fatal(ok, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// board names, which appear in urls like /b/name/
+var boardNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// the options a board can set, and whether they're settings, which a board's options pin like flags do
+var boardOptions = map[string]bool{
+	"db":                    false,
+	"creds-file":            false,
+	"expiry-policy":         false,
+	SETTING_NOTE_EXPIRY:     true,
+	SETTING_UNVIEWED_EXPIRY: true,
+	SETTING_RECENT_NOTES:    true,
+}
+
+// BoardConfig describes a board: a set of notes served under /b/name/ from its own database,
+// with its own credentials and expiry
+type BoardConfig struct {
+	name string
+	// options overriding the flags of the same names, like "note-expiry"
+	options map[string]string
+}
+
+// parses a boards file
+// each line is a board's name followed by its options as key=value, e.g.
+//
+//	work  db=./work.db  creds-file=./work.creds  note-expiry=30
+//
+// db is required, and the other options override the flags of the same names for that board
+// blank lines and lines beginning with '#' are ignored
+func parseBoards(r io.Reader, source string) ([]BoardConfig, error) {
+	boards := []BoardConfig{}
+	names := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		board := BoardConfig{name: fields[0], options: make(map[string]string)}
+		if !boardNamePattern.MatchString(board.name) {
+			return nil, fmt.Errorf("%s line %d: board names may only contain lowercase letters, digits and '-'", source, lineNumber)
+		}
+		if names[board.name] {
+			return nil, fmt.Errorf("%s line %d: there's already a board named %s", source, lineNumber, board.name)
+		}
+		names[board.name] = true
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if _, ok := boardOptions[parts[0]]; !ok || len(parts) != 2 {
+				return nil, fmt.Errorf("%s line %d: unknown option %q", source, lineNumber, field)
+			}
+			board.options[parts[0]] = parts[1]
+		}
+		if board.options["db"] == "" {
+			return nil, fmt.Errorf("%s line %d: board %s needs a db", source, lineNumber, board.name)
+		}
+		boards = append(boards, board)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", source, err)
+	}
+	return boards, nil
+}
+
+// the path every url of the board is under
+func (b BoardConfig) prefix() string {
+	return "/b/" + b.name
+}
+
+// derives a board's config from the main board's, overriding it with the board's options
+// a board without a creds-file shares the main board's credentials
+func (b BoardConfig) apply(config Config) (Config, error) {
+	config.DatabasePath = b.options["db"]
+	// boards have their own users, whom the acl doesn't know
+	config.ACL = nil
+	config.pinnedSettings = make(map[string]bool)
+	for option, value := range b.options {
+		if boardOptions[option] {
+			if err := settingValidators[option](value); err != nil {
+				return config, fmt.Errorf("board %s: %s %v", b.name, option, err)
+			}
+			config.pinnedSettings[option] = true
+		}
+	}
+	if days, ok := b.options[SETTING_NOTE_EXPIRY]; ok {
+		n, _ := strconv.Atoi(days)
+		config.NoteExpiryTime = time.Duration(n*24) * time.Hour
+	}
+	if age, ok := b.options[SETTING_UNVIEWED_EXPIRY]; ok {
+		config.UnviewedExpiryTime, _ = time.ParseDuration(age)
+	}
+	if n, ok := b.options[SETTING_RECENT_NOTES]; ok {
+		config.NumRecentNotes, _ = strconv.Atoi(n)
+	}
+	if policy, ok := b.options["expiry-policy"]; ok {
+		if policy != EXPIRE_VIEWED && policy != EXPIRE_CREATED {
+			return config, fmt.Errorf("board %s: expiry-policy must be %q or %q", b.name, EXPIRE_VIEWED, EXPIRE_CREATED)
+		}
+		config.ExpiryPolicy = policy
+	}
+	if path, ok := b.options["creds-file"]; ok {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, fmt.Errorf("board %s: %v", b.name, err)
+		}
+		defer file.Close()
+		config.Credentials = make(map[string]bool)
+		err = parseCredentials(file, "credentials file "+path, config.Credentials)
+		if err != nil {
+			return config, fmt.Errorf("board %s: %v", b.name, err)
+		}
+		if len(config.Credentials) == 0 {
+			return config, fmt.Errorf("board %s: %s holds no credentials", b.name, path)
+		}
+		if user, ok := unownableUser(config.Credentials); ok && config.PrivateNotes {
+			return config, fmt.Errorf("board %s: user %q can't own private notes, since their name contains '/'", b.name, user)
+		}
+	}
+	return config, nil
+}
+
+// Board is a board which is being served
+type Board struct {
+	config      BoardConfig
+	datastore   Datastore
+	maintenance *Maintenance
+	settings    *Settings
+	// nil if views aren't recorded
+	analytics *Analytics
+	watcher   *Watcher
+	handler   http.Handler
+	// the board's credentials, or nil if it's open to anyone
+	credentials map[string]bool
+}
+
+// opens a board's database and checks it like the main board's
+// mirroring, notifications, schedules, digests, replicas, vacuuming, link checks and tcp pastes are only for the main board
+func openBoard(board BoardConfig, config Config, templates *template.Template, static *StaticAssets, migrations fs.FS) (*Board, error) {
+	config, err := board.apply(config)
+	if err != nil {
+		return nil, err
+	}
+	datastore, err := openDatastore(config)
+	if err != nil {
+		return nil, fmt.Errorf("board %s: opening db %s: %v", board.name, config.DatabasePath, err)
+	}
+	maintenance := NewMaintenance(datastore)
+	if !config.SkipIntegrityCheck {
+		result, err := maintenance.check(false)
+		if err != nil {
+			datastore.Close()
+			return nil, fmt.Errorf("board %s: checking db %s: %v", board.name, config.DatabasePath, err)
+		}
+		if !result.OK {
+			datastore.Close()
+			return nil, fmt.Errorf("board %s: db %s is corrupted", board.name, config.DatabasePath)
+		}
+	}
+	err = checkSchema(datastore, migrations, config.Schema)
+	if err == nil {
+		err = datastore.backfillHashes()
+	}
+	if err == nil {
+		err = datastore.backfillRetentionOverrides()
+	}
+	if err != nil {
+		datastore.Close()
+		return nil, fmt.Errorf("board %s: %v", board.name, err)
+	}
+	index := &IndexCache{}
+	baseURL := config.BaseURL
+	if baseURL != "" {
+		baseURL = strings.TrimSuffix(baseURL, "/") + board.prefix()
+	}
+	watcher := NewWatcher(datastore, baseURL, config.AllowInternalFetch)
+	listeners := Listeners{index, watcher}
+	settings, err := loadSettings(datastore, index, config)
+	if err != nil {
+		datastore.Close()
+		return nil, fmt.Errorf("board %s: loading settings: %v", board.name, err)
+	}
+	var analytics *Analytics
+	if config.Analytics {
+		analytics = NewAnalytics(datastore, config.RateLimits.TrustedProxies)
+	}
+	return &Board{
+		config:      board,
+		datastore:   datastore,
+		maintenance: maintenance,
+		settings:    settings,
+		analytics:   analytics,
+		watcher:     watcher,
+		handler:     makeRouter(templates, static, config, datastore, listeners, maintenance, index, settings, analytics, nil),
+		credentials: config.Credentials,
+	}, nil
+}
+
+// starts expiring the board's notes, recording views and delivering to watches
+func (b *Board) start() {
+	go b.maintenance.runCleanup(cleanupInterval, b.settings.expiry)
+	go b.watcher.run()
+	if b.analytics != nil {
+		go b.analytics.run()
+	}
+}
+
+// stops expiring the board's notes, recording views and delivering to watches
+func (b *Board) stop() {
+	b.watcher.shutdown()
+	if b.analytics != nil {
+		b.analytics.shutdown()
+	}
+	b.maintenance.shutdown()
+}
+
+// closes the board's database
+func (b *Board) close() {
+	b.datastore.Close()
+}
+
+// whether a request's credentials can open the board
+func (b *Board) allows(req *http.Request) bool {
+	if b.credentials == nil {
+		return true
+	}
+	user, password, _ := req.BasicAuth()
+	return b.credentials[user+":"+password]
+}
+
+// the names of the boards a request's credentials can open
+func accessibleBoards(boards []*Board, req *http.Request) []string {
+	names := []string{}
+	for _, board := range boards {
+		if board.allows(req) {
+			names = append(names, board.config.name)
+		}
+	}
+	return names
+}
+
+// serves each board's requests under its prefix, and every other request from the main board
+// the board sees paths without its prefix, which boardPrefix recovers for links
+func routeBoards(main http.Handler, boards []*Board) http.Handler {
+	byName := make(map[string]*Board)
+	for _, board := range boards {
+		byName[board.config.name] = board
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		rest := strings.TrimPrefix(req.URL.Path, "/b/")
+		if rest != req.URL.Path {
+			name := strings.SplitN(rest, "/", 2)[0]
+			if board, ok := byName[name]; ok {
+				prefix := board.config.prefix()
+				if req.URL.Path == prefix {
+					http.Redirect(resp, req, prefix+"/", http.StatusMovedPermanently)
+					return
+				}
+				req = req.WithContext(context.WithValue(req.Context(), boardKey, prefix))
+				http.StripPrefix(prefix, board.handler).ServeHTTP(resp, req)
+				return
+			}
+		}
+		main.ServeHTTP(resp, req)
+	})
+}
+
+// the path the board serving a request is under, like "/b/work", or "" for the main board
+func boardPrefix(req *http.Request) string {
+	prefix, _ := req.Context().Value(boardKey).(string)
+	return prefix
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most changes returned at once by GET /api/changes
+const maxChangesPerPage = 1000
+
+// ChangesData is a page of the change log
+type ChangesData struct {
+	Changes []Change `json:"changes"`
+	// pass this as since to get the changes after these
+	Next string `json:"next"`
+	// whether there are more changes after these already
+	More bool `json:"more"`
+}
+
+// lists the changes to notes after the since query parameter, oldest first
+// without since, the list starts from the oldest change still in the log
+// if changes after since have been pruned, responds 410, so the client knows to fetch everything again
+func ListChanges(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		query := req.URL.Query()
+		since := int64(0)
+		if query.Get("since") != "" {
+			var err error
+			since, err = strconv.ParseInt(query.Get("since"), 10, 64)
+			if err != nil || since < 0 {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "since must be a cursor returned as next")
+				return
+			}
+		}
+		limit := maxChangesPerPage
+		if query.Get("limit") != "" {
+			var err error
+			limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || limit < 1 || limit > maxChangesPerPage {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "limit must be between 1 and "+strconv.Itoa(maxChangesPerPage))
+				return
+			}
+		}
+		changes, oldest, err := datastore.listChanges(since, limit)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing changes since %d: %v", since, err)
+			return
+		}
+		if query.Get("since") != "" && since < oldest-1 {
+			APIError(resp, http.StatusGone, ERR_CURSOR_EXPIRED)
+			return
+		}
+		next := since
+		if len(changes) > 0 {
+			next = changes[len(changes)-1].Cursor
+		} else if next < oldest-1 {
+			next = oldest - 1
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(ChangesData{
+			Changes: changes,
+			Next:    strconv.FormatInt(next, 10),
+			More:    len(changes) == limit,
+		})
+		if err != nil {
+			log.Printf("responding with changes: %v", err)
+		}
+	}
+}
@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// exit codes for the client commands
+const (
+	EXIT_OK           = 0
+	EXIT_ERROR        = 1
+	EXIT_USAGE        = 2
+	EXIT_UNAUTHORIZED = 3
+	EXIT_NOT_FOUND    = 4
+	EXIT_CONFLICT     = 5
+)
+
+// commands which talk to a corkboard server over its api, instead of serving one
+// each takes the arguments following its name and returns an exit code
+var clientCommands = map[string]func(args []string) int{
+	"push":  pushCommand,
+	"pull":  pullCommand,
+	"watch": watchCommand,
+}
+
+// Client makes requests against a corkboard server's api
+type Client struct {
+	url   string
+	creds string
+	token string
+	http  *http.Client
+}
+
+// adds the flags every client command shares
+func clientFlags(fs *flag.FlagSet) *Client {
+	client := &Client{http: &http.Client{Timeout: time.Minute}}
+	fs.StringVar(&client.url, "url", "", "URL of the corkboard server, e.g. \"https://corkboard.example.com\".")
+	fs.StringVar(&client.creds, "creds", "", "Credentials for the server in the form \"username:password\".")
+	fs.StringVar(&client.token, "token", "", "Bearer token for the server, for use behind an authenticating proxy.")
+	return client
+}
+
+// parses flags which may come before, after or between positional arguments
+// returns the positional arguments
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// makes a request against a note, returning the response
+func (c *Client) request(method string, name string, body io.Reader, header http.Header) (*http.Response, error) {
+	return c.requestPath(method, "/api/note/"+escapeNoteName(name), body, header)
+}
+
+// makes a request against a path of the server's, returning the response
+func (c *Client) requestPath(method string, path string, body io.Reader, header http.Header) (*http.Response, error) {
+	if c.url == "" {
+		return nil, fmt.Errorf("-url is required")
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.url, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.creds != "" {
+		parts := strings.SplitN(c.creds, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("-creds must be in the form \"username:password\"")
+		}
+		req.SetBasicAuth(parts[0], parts[1])
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.http.Do(req)
+}
+
+// reports an unsuccessful response and returns the matching exit code
+// api errors carry a code saying what went wrong, which is more specific than the status
+func statusExitCode(resp *http.Response, name string) int {
+	var apiError APIErrorData
+	if resp.Header.Get("Content-Type") == "application/json" {
+		json.NewDecoder(resp.Body).Decode(&apiError)
+	}
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		fmt.Fprintln(os.Stderr, "error: unauthorized; check -creds or -token")
+		return EXIT_UNAUTHORIZED
+	case apiError.Error.Code == ERR_DUPLICATE:
+		fmt.Fprintf(os.Stderr, "error: note %s has the same contents as %s\n", name, apiError.Error.DuplicateOf)
+		return EXIT_CONFLICT
+	case resp.StatusCode == http.StatusNotFound:
+		fmt.Fprintf(os.Stderr, "error: note %s not found\n", name)
+		return EXIT_NOT_FOUND
+	case resp.StatusCode == http.StatusGone:
+		fmt.Fprintf(os.Stderr, "error: note %s was deleted\n", name)
+		return EXIT_NOT_FOUND
+	case resp.StatusCode == http.StatusConflict:
+		fmt.Fprintf(os.Stderr, "error: note %s already exists\n", name)
+		return EXIT_CONFLICT
+	case apiError.Error.Message != "":
+		fmt.Fprintf(os.Stderr, "error: server responded %s: %s\n", resp.Status, apiError.Error.Message)
+		return EXIT_ERROR
+	default:
+		fmt.Fprintf(os.Stderr, "error: server responded %s\n", resp.Status)
+		return EXIT_ERROR
+	}
+}
+
+// corkboard push [flags] name [file]
+// uploads a file, or standard input, as a note
+func pushCommand(args []string) int {
+	fs := flag.NewFlagSet("push", flag.ContinueOnError)
+	client := clientFlags(fs)
+	noClobber := fs.Bool("no-clobber", false, "Fail if the note already exists, rather than overwriting it.")
+	delta := fs.Bool("delta", false, "Send only what changed, as a delta against the note on the server,\nif the file is at least -delta-threshold bytes.")
+	deltaThreshold := fs.Int("delta-threshold", 64<<10, "With -delta, the size in bytes from which a delta is sent.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: corkboard push [flags] name [file]\nUploads file, or standard input, as the note named name.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return EXIT_USAGE
+	}
+	if len(positional) < 1 || len(positional) > 2 {
+		fs.Usage()
+		return EXIT_USAGE
+	}
+	name := positional[0]
+
+	var input io.Reader = os.Stdin
+	if len(positional) == 2 && positional[1] != "-" {
+		file, err := os.Open(positional[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return EXIT_ERROR
+		}
+		defer file.Close()
+		input = file
+	}
+	body, err := io.ReadAll(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: reading note: %v\n", err)
+		return EXIT_ERROR
+	}
+
+	if *delta && !*noClobber && len(body) >= *deltaThreshold {
+		patched, code := pushDelta(client, name, body)
+		if patched {
+			return code
+		}
+	}
+
+	method := http.MethodPut
+	if *noClobber {
+		method = http.MethodPost
+	}
+	// let the server check the note arrived intact
+	header := http.Header{}
+	header.Set(hashHeader, hashBody(body))
+	resp, err := client.request(method, name, bytes.NewReader(body), header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return EXIT_ERROR
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return statusExitCode(resp, name)
+	}
+	if resp.Header.Get("Content-Type") == "application/json" {
+		// the note was created, but has the same contents as another
+		var duplicate DuplicateData
+		if json.NewDecoder(resp.Body).Decode(&duplicate) == nil {
+			fmt.Fprintf(os.Stderr, "warning: note %s has the same contents as %s\n", name, duplicate.DuplicateOf)
+		}
+	}
+	return EXIT_OK
+}
+
+// pushes a note as a delta against its current contents on the server
+// returns false if the whole note should be pushed instead, because the note doesn't exist yet,
+// changed in the meantime, or the server can't take deltas; otherwise returns the exit code
+func pushDelta(client *Client, name string, body []byte) (bool, int) {
+	notePath := "/api/note/" + url.PathEscape(name)
+	resp, err := client.requestPath(http.MethodGet, notePath+"/signature", nil, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return true, EXIT_ERROR
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusMethodNotAllowed:
+		return false, EXIT_OK
+	case resp.StatusCode >= 300:
+		return true, statusExitCode(resp, name)
+	}
+	var signature NoteSignature
+	err = json.NewDecoder(resp.Body).Decode(&signature)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: reading signature: %v\n", err)
+		return true, EXIT_ERROR
+	}
+	hash := hashBody(body)
+	if signature.Hash == hash {
+		return true, EXIT_OK
+	}
+	delta, err := makeDelta(signature, body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return true, EXIT_ERROR
+	}
+	header := http.Header{}
+	header.Set(baseHashHeader, signature.Hash)
+	header.Set(hashHeader, hash)
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = client.requestPath(http.MethodPost, notePath+"/patch", bytes.NewReader(delta), header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return true, EXIT_ERROR
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return false, EXIT_OK
+	case resp.StatusCode >= 300:
+		return true, statusExitCode(resp, name)
+	}
+	return true, EXIT_OK
+}
+
+// corkboard pull [flags] name [-o file]
+// downloads a note to a file, or standard output
+func pullCommand(args []string) int {
+	fs := flag.NewFlagSet("pull", flag.ContinueOnError)
+	client := clientFlags(fs)
+	output := fs.String("o", "", "Write the note to this file rather than standard output.\nIf the file is already up to date, it isn't downloaded again.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: corkboard pull [flags] name\nDownloads the note named name.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return EXIT_USAGE
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return EXIT_USAGE
+	}
+	name := positional[0]
+
+	header := http.Header{}
+	if *output != "" {
+		// the etag is the hash of the note, so an unchanged file needn't be downloaded
+		if hash, err := hashFile(*output); err == nil {
+			header.Set("If-None-Match", `"`+hash+`"`)
+		}
+	}
+	resp, err := client.request(http.MethodGet, name, nil, header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return EXIT_ERROR
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return EXIT_OK
+	}
+	if resp.StatusCode >= 300 {
+		return statusExitCode(resp, name)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: downloading note: %v\n", err)
+		return EXIT_ERROR
+	}
+	if expected := resp.Header.Get(hashHeader); expected != "" && expected != hashBody(body) {
+		fmt.Fprintln(os.Stderr, "error: note was corrupted in transit")
+		return EXIT_ERROR
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(body)
+	} else {
+		err = os.WriteFile(*output, body, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return EXIT_ERROR
+	}
+	return EXIT_OK
+}
+
+// corkboard watch [flags] name -cmd command
+// polls a note and runs a command whenever it changes
+func watchCommand(args []string) int {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	client := clientFlags(fs)
+	command := fs.String("cmd", "", "Shell command to run when the note changes. The note is passed on standard input.")
+	interval := fs.Duration("interval", 10*time.Second, "How often to check the note.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: corkboard watch [flags] name -cmd command\nRuns command whenever the note named name changes.")
+		fs.PrintDefaults()
+	}
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return EXIT_USAGE
+	}
+	if len(positional) != 1 || *command == "" || *interval <= 0 {
+		fs.Usage()
+		return EXIT_USAGE
+	}
+	return client.watch(positional[0], *command, *interval, nil)
+}
+
+// polls a note every interval and runs command whenever it changes, until stop is closed, if it isn't nil
+// returns an exit code if the client can't go on watching
+func (c *Client) watch(name string, command string, interval time.Duration, stop <-chan struct{}) int {
+	etag := ""
+	first := true
+	for ; ; time.Sleep(interval) {
+		select {
+		case <-stop:
+			return EXIT_OK
+		default:
+		}
+		header := http.Header{}
+		if etag != "" {
+			header.Set("If-None-Match", etag)
+		}
+		resp, err := c.request(http.MethodGet, name, nil, header)
+		if err != nil {
+			// keep watching through network blips
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: downloading note: %v\n", err)
+			continue
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotModified:
+			continue
+		case resp.StatusCode == http.StatusUnauthorized:
+			return statusExitCode(resp, name)
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			// the note may not have been created yet, and its creation will count as a change
+			etag = ""
+			first = false
+			continue
+		case resp.StatusCode >= 300:
+			statusExitCode(resp, name)
+			continue
+		}
+		etag = resp.Header.Get("ETag")
+		if first {
+			// the note's current contents aren't a change
+			first = false
+			continue
+		}
+		err = runWatchCommand(command, body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: running command: %v\n", err)
+		}
+	}
+}
+
+// runs a shell command with the note on standard input
+func runWatchCommand(command string, body []byte) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// the sha-256 of a file's contents, as hex
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, file)
+	return hex.EncodeToString(hasher.Sum(nil)), err
+}
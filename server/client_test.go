@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the client commands against the real handlers
+func TestClientCommands(t *testing.T) {
+	config := testConfig(t)
+	config.Credentials = map[string]bool{"alice:secret": true}
+	server := testServer(t, config)
+	recorder := &statusRecorder{}
+	server.Config.Handler = recorder.wrap(server.Config.Handler)
+	dir := t.TempDir()
+	file := func(name string, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	hello := file("hello", "hello")
+	bye := file("bye", "bye")
+	pulled := filepath.Join(dir, "pulled")
+	creds := []string{"-url", server.URL, "-creds", "alice:secret"}
+
+	cases := []struct {
+		name    string
+		command func(args []string) int
+		args    []string
+		want    int
+		// the status of the last response the server gave
+		wantStatus int
+		// what pulled should then say, if it should exist
+		wantPulled string
+	}{
+		{"push", pushCommand, append(creds, "todo", hello), EXIT_OK, http.StatusCreated, ""},
+		{"push over", pushCommand, append(creds, "todo", bye), EXIT_OK, http.StatusOK, ""},
+		{"push without clobbering", pushCommand, append(creds, "-no-clobber", "todo", hello), EXIT_CONFLICT, http.StatusConflict, ""},
+		{"push with flags after the name", pushCommand, append([]string{"done", hello}, creds...), EXIT_OK, http.StatusCreated, ""},
+		{"push unauthorized", pushCommand, []string{"-url", server.URL, "-creds", "alice:wrong", "todo", hello}, EXIT_UNAUTHORIZED, http.StatusUnauthorized, ""},
+		{"push without a url", pushCommand, []string{"todo", hello}, EXIT_ERROR, http.StatusUnauthorized, ""},
+		{"push without a name", pushCommand, creds, EXIT_USAGE, http.StatusUnauthorized, ""},
+		{"pull", pullCommand, append(creds, "todo", "-o", pulled), EXIT_OK, http.StatusOK, "bye"},
+		{"pull unchanged", pullCommand, append(creds, "todo", "-o", pulled), EXIT_OK, http.StatusNotModified, "bye"},
+		{"pull missing", pullCommand, append(creds, "missing", "-o", pulled), EXIT_NOT_FOUND, http.StatusNotFound, "bye"},
+		{"pull unauthorized", pullCommand, []string{"-url", server.URL, "todo", "-o", pulled}, EXIT_UNAUTHORIZED, http.StatusUnauthorized, "bye"},
+	}
+	for _, c := range cases {
+		if got := c.command(c.args); got != c.want {
+			t.Errorf("%s: exited %d, want %d", c.name, got, c.want)
+		}
+		if got := recorder.last(); got != c.wantStatus {
+			t.Errorf("%s: the server responded %d, want %d", c.name, got, c.wantStatus)
+		}
+		if c.wantPulled == "" {
+			continue
+		}
+		body, err := os.ReadFile(pulled)
+		if err != nil || string(body) != c.wantPulled {
+			t.Errorf("%s: pulled %q, %v, want %q", c.name, body, err, c.wantPulled)
+		}
+	}
+}
+
+// a deleted note is reported like a missing one
+func TestClientPullDeleted(t *testing.T) {
+	server := testServer(t, testConfig(t))
+	path := filepath.Join(t.TempDir(), "note")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := pushCommand([]string{"-url", server.URL, "todo", path}); code != EXIT_OK {
+		t.Fatalf("push: exited %d", code)
+	}
+	req, err := http.NewRequest(http.MethodDelete, server.URL+"/api/note/todo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if code := pullCommand([]string{"-url", server.URL, "todo", "-o", path}); code != EXIT_NOT_FOUND {
+		t.Errorf("pulling a deleted note: exited %d, want %d", code, EXIT_NOT_FOUND)
+	}
+}
+
+// watch runs its command for each change, but not for the note's contents when it starts watching
+func TestClientWatch(t *testing.T) {
+	server := testServer(t, testConfig(t))
+	dir := t.TempDir()
+	push := func(body string) {
+		t.Helper()
+		path := filepath.Join(dir, "body")
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if code := pushCommand([]string{"-url", server.URL, "deploy", path}); code != EXIT_OK {
+			t.Fatalf("push: exited %d", code)
+		}
+	}
+	push("v1")
+
+	out := filepath.Join(dir, "out")
+	client := &Client{url: server.URL, http: &http.Client{Timeout: time.Minute}}
+	stop := make(chan struct{})
+	done := make(chan int, 1)
+	go func() {
+		done <- client.watch("deploy", "cat >> "+out, 10*time.Millisecond, stop)
+	}()
+	// long enough for the first poll, whose contents aren't a change
+	time.Sleep(100 * time.Millisecond)
+	push("v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		body, _ := os.ReadFile(out)
+		if string(body) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the command saw %q, want v2", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(stop)
+	if code := <-done; code != EXIT_OK {
+		t.Errorf("watch exited %d", code)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes tickers, so that code which depends on the time can be run
+// against a fake clock
+type Clock interface {
+	Now() time.Time
+	NewTicker(interval time.Duration) Ticker
+}
+
+// Ticker delivers ticks on a channel, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// the clock on the wall
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(interval time.Duration) Ticker {
+	return realTicker{time.NewTicker(interval)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// a clock which every copy of a Datastore shares, so the time sqlite's triggers read through corkboard_now()
+// is the one the datastore tells; see openDatabase
+// it can be swapped for a fake clock while the database is open
+type sharedClock struct {
+	lock  sync.RWMutex
+	clock Clock
+}
+
+func (c *sharedClock) get() Clock {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.clock
+}
+
+func (c *sharedClock) set(clock Clock) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.clock = clock
+}
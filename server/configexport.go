@@ -0,0 +1,405 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// how many rounds of PBKDF2 each exported password is hashed with
+const credentialHashRounds = 100000
+
+// ConfigDocument is everything needed to rebuild a corkboard host besides its notes, as written by config export
+// the same configuration is always written the same way, so exports can be diffed
+type ConfigDocument struct {
+	Version string `json:"version"`
+	// every flag's value, in the form it's given on the command line, with secrets redacted
+	Flags map[string]string `json:"flags"`
+	// the settings stored at runtime, which win over the flags of the same names unless those were given
+	Settings map[string]string `json:"settings"`
+	ACL      *ACLDocument      `json:"acl,omitempty"`
+	Boards   []BoardDocument   `json:"boards,omitempty"`
+	// with -include-credentials, each set of credentials as "username:hash"; see hashCredential
+	Credentials []string `json:"credentials,omitempty"`
+}
+
+// ACLDocument is the -acl-file and -acl-default in a ConfigDocument
+type ACLDocument struct {
+	Default string            `json:"default"`
+	Rules   []ACLRuleDocument `json:"rules"`
+}
+
+// ACLRuleDocument is a line of the -acl-file
+type ACLRuleDocument struct {
+	Prefix string   `json:"prefix"`
+	Read   []string `json:"read"`
+	Write  []string `json:"write"`
+}
+
+// BoardDocument is a line of the -boards-file, with the board's own stored settings and credentials
+type BoardDocument struct {
+	Name     string            `json:"name"`
+	Options  map[string]string `json:"options"`
+	Settings map[string]string `json:"settings"`
+	// only if the board has its own creds-file
+	Credentials []string `json:"credentials,omitempty"`
+}
+
+// corkboard config export|import [flags] file
+// export writes everything needed to rebuild this host besides its notes to a file, never including a plaintext secret;
+// import checks this host is configured the same, reporting every difference, and restores the stored settings
+func configCommand(app *App, args []string) error {
+	flags := flag.NewFlagSet("config", flag.ContinueOnError)
+	includeCredentials := flags.Bool("include-credentials", false, "With export, include each user's credentials, hashed with PBKDF2.\nImport then checks this host's credentials are the same.")
+	dryRun := flags.Bool("dry-run", false, "With import, check the configuration without restoring the settings.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] config export|import [config flags] file\n"+
+			"export writes the flags, stored settings, boards and acl to file as JSON, or to standard output if it's \"-\".\n"+
+			"Secrets are never written, though with -include-credentials, hashed credentials are.\n"+
+			"import checks this corkboard's flags, boards, acl and credentials are the same as in file,\n"+
+			"reporting each difference, then restores its stored settings and its boards'.\n"+
+			"Config flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 || (positional[0] != "export" && positional[0] != "import") {
+		flags.Usage()
+		return errors.New("expected export or import, and a file")
+	}
+	if positional[0] == "export" {
+		return exportConfig(app, positional[1], *includeCredentials)
+	}
+	return importConfig(app, positional[1], *dryRun)
+}
+
+// writes the ConfigDocument for this corkboard to path, or standard output if it's "-"
+func exportConfig(app *App, path string, includeCredentials bool) error {
+	config := app.config
+	doc := ConfigDocument{Version: corkboardVersion, Flags: make(map[string]string)}
+	flag.VisitAll(func(f *flag.Flag) {
+		doc.Flags[f.Name] = redactedFlagValue(f)
+	})
+	var err error
+	doc.Settings, err = app.datastore.listSettings()
+	if err != nil {
+		return fmt.Errorf("listing settings: %v", err)
+	}
+	if config.ACL != nil {
+		doc.ACL = aclDocument(config.ACL)
+	}
+	if includeCredentials {
+		doc.Credentials = hashCredentials(config.Credentials)
+	}
+	for _, board := range config.Boards {
+		boardDoc := BoardDocument{Name: board.name, Options: board.options}
+		err := withBoardDatastore(app, board, func(datastore Datastore) error {
+			var err error
+			boardDoc.Settings, err = datastore.listSettings()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if _, ok := board.options["creds-file"]; ok && includeCredentials {
+			boardConfig, err := board.apply(config)
+			if err != nil {
+				return err
+			}
+			boardDoc.Credentials = hashCredentials(boardConfig.Credentials)
+		}
+		doc.Boards = append(doc.Boards, boardDoc)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	// hashed credentials are still worth keeping private
+	return os.WriteFile(path, data, 0600)
+}
+
+// checks this corkboard against the ConfigDocument at path, or standard input if it's "-",
+// printing each difference, then restores the stored settings in it unless dryRun is set
+// the settings are restored even if there are differences, but then it fails, so they're noticed
+func importConfig(app *App, path string, dryRun bool) error {
+	config := app.config
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	var doc ConfigDocument
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("%s isn't a config export: %v", path, err)
+	}
+	changes, err := settingChanges(doc.Settings)
+	if err != nil {
+		return err
+	}
+
+	differences := []string{}
+	if doc.Version != corkboardVersion {
+		fmt.Printf("note: the export is from corkboard %s, and this is %s\n", doc.Version, corkboardVersion)
+	}
+	differences = append(differences, compareFlags(doc.Flags)...)
+	var acl *ACLDocument
+	if config.ACL != nil {
+		acl = aclDocument(config.ACL)
+	}
+	if !reflect.DeepEqual(acl, doc.ACL) {
+		differences = append(differences, "the acl isn't the same")
+	}
+	if doc.Credentials != nil {
+		differences = append(differences, compareCredentials(doc.Credentials, config.Credentials, "")...)
+	}
+
+	boards := make(map[string]BoardConfig)
+	for _, board := range config.Boards {
+		boards[board.name] = board
+	}
+	boardChanges := make(map[string]map[string]*string)
+	for _, boardDoc := range doc.Boards {
+		board, ok := boards[boardDoc.Name]
+		if !ok {
+			differences = append(differences, fmt.Sprintf("board %s isn't in the -boards-file", boardDoc.Name))
+			continue
+		}
+		delete(boards, boardDoc.Name)
+		if !reflect.DeepEqual(board.options, boardDoc.Options) {
+			differences = append(differences, fmt.Sprintf("board %s has other options in the -boards-file", board.name))
+		}
+		if boardDoc.Credentials != nil {
+			boardConfig, err := board.apply(config)
+			if err != nil {
+				return err
+			}
+			differences = append(differences, compareCredentials(boardDoc.Credentials, boardConfig.Credentials, "board "+board.name+": ")...)
+		}
+		boardChanges[board.name], err = settingChanges(boardDoc.Settings)
+		if err != nil {
+			return fmt.Errorf("board %s: %v", board.name, err)
+		}
+	}
+	for _, board := range config.Boards {
+		if _, ok := boards[board.name]; ok {
+			differences = append(differences, fmt.Sprintf("board %s isn't in the export", board.name))
+		}
+	}
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+
+	if !dryRun {
+		err = app.settings.update(changes)
+		if err != nil {
+			return fmt.Errorf("restoring settings: %v", err)
+		}
+		for _, board := range config.Boards {
+			if changes, ok := boardChanges[board.name]; ok {
+				err := withBoardDatastore(app, board, func(datastore Datastore) error {
+					return datastore.setSettings(changes)
+				})
+				if err != nil {
+					return fmt.Errorf("restoring settings: %v", err)
+				}
+			}
+		}
+		fmt.Printf("restored %d settings\n", len(doc.Settings))
+	}
+	if len(differences) > 0 {
+		return fmt.Errorf("this corkboard's configuration differs from %s in %d ways", path, len(differences))
+	}
+	fmt.Println("the configuration is the same as the export")
+	return nil
+}
+
+// the changes which make the stored settings exactly these, removing any others
+// fails if any of them isn't a valid setting
+func settingChanges(settings map[string]string) (map[string]*string, error) {
+	changes := make(map[string]*string)
+	for name := range settingValidators {
+		changes[name] = nil
+	}
+	for name, value := range settings {
+		validate, ok := settingValidators[name]
+		if !ok {
+			return nil, fmt.Errorf("there's no setting %q", name)
+		}
+		if err := validate(value); err != nil {
+			return nil, fmt.Errorf("setting %s %v", name, err)
+		}
+		value := value
+		changes[name] = &value
+	}
+	return changes, nil
+}
+
+// the differences between the flags this corkboard was given and the exported ones
+func compareFlags(exported map[string]string) []string {
+	differences := []string{}
+	names := make([]string, 0, len(exported))
+	for name := range exported {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			differences = append(differences, fmt.Sprintf("-%s isn't a flag of this corkboard", name))
+			continue
+		}
+		if value := redactedFlagValue(f); value != exported[name] {
+			differences = append(differences, fmt.Sprintf("-%s is %s here, but %s in the export", name, strconv.Quote(value), strconv.Quote(exported[name])))
+		}
+	}
+	return differences
+}
+
+// the acl as it's exported, with each rule's users sorted
+func aclDocument(acl *ACL) *ACLDocument {
+	doc := &ACLDocument{Default: "deny", Rules: []ACLRuleDocument{}}
+	if acl.defaultAllow {
+		doc.Default = "allow"
+	}
+	for _, rule := range acl.rules {
+		doc.Rules = append(doc.Rules, ACLRuleDocument{Prefix: rule.prefix, Read: sortedKeys(rule.read), Write: sortedKeys(rule.write)})
+	}
+	return doc
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// opens a board's database for a command, which doesn't open boards itself
+func withBoardDatastore(app *App, board BoardConfig, f func(Datastore) error) error {
+	config, err := board.apply(app.config)
+	if err != nil {
+		return err
+	}
+	datastore, err := openDatastore(config)
+	if err != nil {
+		return fmt.Errorf("board %s: opening db %s: %v", board.name, config.DatabasePath, err)
+	}
+	defer datastore.Close()
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		return err
+	}
+	err = checkSchema(datastore, migrations, config.Schema)
+	if err != nil {
+		return fmt.Errorf("board %s: %v", board.name, err)
+	}
+	err = f(datastore)
+	if err != nil {
+		return fmt.Errorf("board %s: %v", board.name, err)
+	}
+	return nil
+}
+
+// each set of credentials as "username:hash", sorted; see hashCredential
+func hashCredentials(credentials map[string]bool) []string {
+	hashed := []string{}
+	for cred := range credentials {
+		parts := strings.SplitN(cred, ":", 2)
+		hashed = append(hashed, parts[0]+":"+hashCredential(parts[0], parts[1]))
+	}
+	sort.Strings(hashed)
+	return hashed
+}
+
+// hashes a password with PBKDF2-HMAC-SHA256, salted with its username, as "pbkdf2-sha256$rounds$hash"
+// the salt doesn't change, so the same credentials always hash the same, and exports can be diffed
+func hashCredential(user string, password string) string {
+	key := pbkdf2SHA256([]byte(password), []byte("corkboard:"+user), credentialHashRounds)
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s", credentialHashRounds, hex.EncodeToString(key))
+}
+
+// PBKDF2 with HMAC-SHA256, giving a key of one block
+func pbkdf2SHA256(password []byte, salt []byte, rounds int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var blockIndex [4]byte
+	binary.BigEndian.PutUint32(blockIndex[:], 1)
+	prf.Write(salt)
+	prf.Write(blockIndex[:])
+	u := prf.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < rounds; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// the differences between the exported credentials and these, by user
+// prefix says whose credentials they are, for boards
+func compareCredentials(exported []string, credentials map[string]bool, prefix string) []string {
+	exportedByUser := make(map[string][]string)
+	for _, cred := range exported {
+		parts := strings.SplitN(cred, ":", 2)
+		exportedByUser[parts[0]] = append(exportedByUser[parts[0]], cred)
+	}
+	currentByUser := make(map[string][]string)
+	for _, cred := range hashCredentials(credentials) {
+		user := strings.SplitN(cred, ":", 2)[0]
+		currentByUser[user] = append(currentByUser[user], cred)
+	}
+	users := []string{}
+	for user := range exportedByUser {
+		users = append(users, user)
+	}
+	for user := range currentByUser {
+		if _, ok := exportedByUser[user]; !ok {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	differences := []string{}
+	for _, user := range users {
+		exported, current := exportedByUser[user], currentByUser[user]
+		switch {
+		case current == nil:
+			differences = append(differences, fmt.Sprintf("%sthere are no credentials for %q, unlike in the export", prefix, user))
+		case exported == nil:
+			differences = append(differences, fmt.Sprintf("%sthere are credentials for %q, which aren't in the export", prefix, user))
+		case !reflect.DeepEqual(exported, current):
+			differences = append(differences, fmt.Sprintf("%sthe password of %q isn't the same as in the export", prefix, user))
+		}
+	}
+	return differences
+}
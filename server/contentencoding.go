@@ -0,0 +1,134 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// the Content-Encodings an uploaded note may be compressed with, and how to decompress each
+var uploadDecoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip": func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	},
+	"deflate": func(r io.Reader) (io.Reader, error) {
+		return flate.NewReader(r), nil
+	},
+	"br": func(r io.Reader) (io.Reader, error) {
+		return brotli.NewReader(r), nil
+	},
+}
+
+// a Content-Encoding corkboard can't decompress
+type unsupportedEncodingError struct {
+	encoding string
+}
+
+func (e unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("can't decompress Content-Encoding %q; use gzip, deflate or br, or add ?store-compressed=true", e.encoding)
+}
+
+// a compressed body which isn't valid
+// it doesn't unwrap, so a truncated stream isn't mistaken for an upload which was cut short
+type decodeError struct {
+	encoding string
+	err      error
+}
+
+func (e decodeError) Error() string {
+	return fmt.Sprintf("the body isn't valid %s: %v", e.encoding, e.err)
+}
+
+// a compressed body which decompresses to more than the limit
+type decompressedTooLargeError struct {
+	maxSize int64
+}
+
+func (e decompressedTooLargeError) Error() string {
+	return fmt.Sprintf("the body decompresses to more than %d bytes", e.maxSize)
+}
+
+// replaces the body of a request with its decompressed contents, if it has a Content-Encoding,
+// so the note is stored as plain content; the ?store-compressed=true query parameter stores it as it was sent
+// reading more than maxSize bytes of the decompressed body fails, so a small upload can't fill the disk
+func decodeRequestBody(req *http.Request, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || req.URL.Query().Get("store-compressed") == "true" {
+		return nil
+	}
+	newDecoder, ok := uploadDecoders[encoding]
+	if !ok {
+		return unsupportedEncodingError{encoding}
+	}
+	decoder, err := newDecoder(req.Body)
+	if err != nil {
+		return decodeError{encoding, err}
+	}
+	req.Body = &decodedBody{
+		decoder:   decoder,
+		encoding:  encoding,
+		remaining: maxSize,
+		maxSize:   maxSize,
+		original:  req.Body,
+	}
+	// the length was of the compressed body
+	req.ContentLength = -1
+	req.Header.Del("Content-Encoding")
+	return nil
+}
+
+// a request body being decompressed, which fails once it's read past its limit
+type decodedBody struct {
+	decoder   io.Reader
+	encoding  string
+	remaining int64
+	maxSize   int64
+	original  io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, decompressedTooLargeError{b.maxSize}
+	}
+	// read one byte past the limit, to tell a body of exactly maxSize bytes from a larger one
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.decoder.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), decompressedTooLargeError{b.maxSize}
+	}
+	if err != nil && err != io.EOF {
+		err = decodeError{b.encoding, err}
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	return b.original.Close()
+}
+
+// responds to an error decompressing an uploaded note, returning whether it was one
+func respondDecodeError(resp http.ResponseWriter, err error) bool {
+	var unsupported unsupportedEncodingError
+	var invalid decodeError
+	var tooLarge decompressedTooLargeError
+	switch {
+	case errors.As(err, &unsupported):
+		APIErrorMessage(resp, http.StatusUnsupportedMediaType, ERR_UNSUPPORTED_ENCODING, err.Error())
+	case errors.As(err, &invalid):
+		APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, invalid.Error())
+	case errors.As(err, &tooLarge):
+		APIErrorMessage(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE, tooLarge.Error())
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// body compressed with encoding
+func compress(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.BestCompression); err != nil {
+			t.Fatal(err)
+		}
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("no encoding %s", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// sends body with a Content-Encoding, counting how much of it is read
+func putEncoded(handler http.Handler, path string, encoding string, body []byte) (*httptest.ResponseRecorder, int) {
+	counted := &countingReader{Reader: bytes.NewReader(body)}
+	req := httptest.NewRequest(http.MethodPut, path, counted)
+	req.Header.Set("Content-Encoding", encoding)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp, counted.read
+}
+
+// the code of an api error response
+func errorCode(t *testing.T, resp *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope struct {
+		Error APIErrorDetail `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("%q isn't an api error: %v", resp.Body, err)
+	}
+	return envelope.Error.Code
+}
+
+func TestCompressedUploads(t *testing.T) {
+	handler := testServer(t, testConfig(t, "-decompressed-max-size", "1000")).Config.Handler
+	text := []byte(strings.Repeat("a line of a log\n", 50))
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		path := "/api/note/" + encoding
+		if resp, _ := putEncoded(handler, path, encoding, compress(t, encoding, text)); resp.Code != http.StatusCreated {
+			t.Errorf("%s: got %d %q", encoding, resp.Code, resp.Body)
+		}
+		if resp := serveRequest(t, handler, http.MethodGet, path, ""); resp.Body.String() != string(text) {
+			t.Errorf("%s: the note says %q, want it decompressed", encoding, resp.Body)
+		}
+	}
+
+	// exactly the limit is allowed, and a byte more isn't
+	atLimit := bytes.Repeat([]byte("x"), 1000)
+	if resp, _ := putEncoded(handler, "/api/note/at-limit", "gzip", compress(t, "gzip", atLimit)); resp.Code != http.StatusCreated {
+		t.Errorf("decompressing to the limit: got %d %q", resp.Code, resp.Body)
+	}
+	resp, _ := putEncoded(handler, "/api/note/over-limit", "gzip", compress(t, "gzip", append(atLimit, 'x')))
+	if resp.Code != http.StatusRequestEntityTooLarge || errorCode(t, resp) != ERR_PAYLOAD_TOO_LARGE {
+		t.Errorf("decompressing past the limit: got %d %q", resp.Code, resp.Body)
+	}
+
+	// ?store-compressed=true keeps the body as it was sent, and isn't limited by its decompressed size
+	compressed := compress(t, "gzip", append(atLimit, atLimit...))
+	if resp, _ := putEncoded(handler, "/api/note/opaque?store-compressed=true", "gzip", compressed); resp.Code != http.StatusCreated {
+		t.Errorf("storing compressed: got %d %q", resp.Code, resp.Body)
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/opaque", ""); !bytes.Equal(resp.Body.Bytes(), compressed) {
+		t.Errorf("the compressed note is %d bytes, want the %d sent", resp.Body.Len(), len(compressed))
+	}
+}
+
+// a small upload which decompresses to far more than the limit is refused once the limit is reached,
+// without reading the rest of it
+func TestDecompressionBomb(t *testing.T) {
+	handler := testServer(t, testConfig(t, "-decompressed-max-size", "1048576")).Config.Handler
+	var bomb bytes.Buffer
+	w, err := gzip.NewWriterLevel(&bomb, gzip.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeros := make([]byte, 1<<20)
+	for i := 0; i < 64; i++ {
+		if _, err := w.Write(zeros); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ratio := (64 << 20) / bomb.Len(); ratio < 500 {
+		t.Fatalf("the bomb only decompresses %d times over", ratio)
+	}
+
+	resp, read := putEncoded(handler, "/api/note/bomb", "gzip", bomb.Bytes())
+	if resp.Code != http.StatusRequestEntityTooLarge || errorCode(t, resp) != ERR_PAYLOAD_TOO_LARGE {
+		t.Errorf("got %d %q, want 413", resp.Code, resp.Body)
+	}
+	if read > bomb.Len()/4 {
+		t.Errorf("read %d bytes of the %d byte bomb, past the limit", read, bomb.Len())
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/bomb", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("the bomb was stored, as %d bytes", resp.Body.Len())
+	}
+}
+
+func TestMalformedCompressedUploads(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	valid := compress(t, "gzip", []byte(strings.Repeat("hello\n", 100)))
+	cases := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantCode   string
+	}{
+		{"not gzip", "gzip", []byte("plain text"), http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"cut short", "gzip", valid[:len(valid)/2], http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"a bad checksum", "gzip", append(append([]byte{}, valid[:len(valid)-8]...), 0, 0, 0, 0, 0, 0, 0, 0), http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"not deflate", "deflate", []byte{0xff, 0xff, 0xff}, http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"unsupported", "zstd", valid, http.StatusUnsupportedMediaType, ERR_UNSUPPORTED_ENCODING},
+	}
+	for _, c := range cases {
+		resp, _ := putEncoded(handler, "/api/note/malformed", c.encoding, c.body)
+		if resp.Code != c.wantStatus || errorCode(t, resp) != c.wantCode {
+			t.Errorf("%s: got %d %q, want %d and %s", c.name, resp.Code, resp.Body, c.wantStatus, c.wantCode)
+		}
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/malformed", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("a malformed upload was stored, as %q", resp.Body)
+	}
+}
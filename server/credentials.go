@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// parses credentials in the form "username:password", one set per line
+// blank lines and lines beginning with '#' are ignored
+// source is used to identify where the credentials came from in errors and warnings
+// adds each valid set of credentials to creds and returns an error on the first malformed line
+func parseCredentials(r io.Reader, source string, creds map[string]bool) error {
+	users := make(map[string]bool)
+	for cred := range creds {
+		users[strings.SplitN(cred, ":", 2)[0]] = true
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		// trim trailing whitespace, including the CR from windows line endings
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		user, err := validateCredential(line)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %s", source, lineNumber, err)
+		}
+		if users[user] {
+			log.Printf("warning: %s line %d: duplicate credentials for user %q", source, lineNumber, user)
+		}
+		users[user] = true
+		creds[line] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading %s: %s", source, err)
+	}
+	return nil
+}
+
+// the lines of credentials in $CORKBOARD_CREDS, whose entries may be separated by commas as well as newlines
+// a backslash escapes a comma or another backslash, so a password with a comma in it is written like pa\,ss
+// a backslash before anything else is kept, so most passwords with one needn't change
+func splitCredentialsEnv(value string) string {
+	var lines strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && i+1 < len(value) && (value[i+1] == ',' || value[i+1] == '\\'):
+			i++
+			lines.WriteByte(value[i])
+		case value[i] == ',':
+			lines.WriteByte('\n')
+		default:
+			lines.WriteByte(value[i])
+		}
+	}
+	return lines.String()
+}
+
+// checks that a set of credentials is a non-empty username and password separated by a single colon
+// returns the username
+func validateCredential(cred string) (string, error) {
+	if strings.Count(cred, ":") != 1 {
+		return "", fmt.Errorf("credentials must contain exactly one ':'")
+	}
+	parts := strings.SplitN(cred, ":", 2)
+	if parts[0] == "" {
+		return "", fmt.Errorf("credentials have an empty username")
+	}
+	if parts[1] == "" {
+		return "", fmt.Errorf("credentials have an empty password")
+	}
+	return parts[0], nil
+}
+
+// finds a user who can't own private notes, because their name contains a '/'
+// which would make the names their notes are stored under ambiguous
+func unownableUser(creds map[string]bool) (string, bool) {
+	for cred := range creds {
+		user := strings.SplitN(cred, ":", 2)[0]
+		if strings.Contains(user, "/") {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// whether there are credentials for a user
+func hasUser(creds map[string]bool, user string) bool {
+	for cred := range creds {
+		if strings.SplitN(cred, ":", 2)[0] == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCredentials(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		// the credentials parsed, if it's valid
+		want map[string]bool
+		// the start of the error, if it isn't
+		err string
+	}{
+		{"one", "alice:pw\n", map[string]bool{"alice:pw": true}, ""},
+		{"no final newline", "alice:pw", map[string]bool{"alice:pw": true}, ""},
+		{"several", "alice:pw\nbob:hunter2\n", map[string]bool{"alice:pw": true, "bob:hunter2": true}, ""},
+		{"empty", "", map[string]bool{}, ""},
+		{"blank lines", "\n\nalice:pw\n   \n\t\n", map[string]bool{"alice:pw": true}, ""},
+		{"comments", "# managed by ansible\nalice:pw\n  # indented\n", map[string]bool{"alice:pw": true}, ""},
+		{"trailing whitespace", "alice:pw \t\nbob:x  \n", map[string]bool{"alice:pw": true, "bob:x": true}, ""},
+		{"windows line endings", "alice:pw\r\nbob:x\r\n", map[string]bool{"alice:pw": true, "bob:x": true}, ""},
+		{"hash inside password", "alice:p#w\n", map[string]bool{"alice:p#w": true}, ""},
+		{"no colon", "# fine\nalice\n", nil, "test line 2: credentials must contain exactly one ':'"},
+		{"two colons", "alice:pw:extra\n", nil, "test line 1: credentials must contain exactly one ':'"},
+		{"empty user", "alice:pw\n:pw\n", nil, "test line 2: credentials have an empty username"},
+		{"empty password", "alice:\n", nil, "test line 1: credentials have an empty password"},
+		{"whitespace password", "alice: \n", nil, "test line 1: credentials have an empty password"},
+		{"only a colon", ":\r\n", nil, "test line 1: credentials have an empty username"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			creds := make(map[string]bool)
+			err := parseCredentials(strings.NewReader(test.input), "test", creds)
+			if test.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(creds, test.want) {
+				t.Errorf("got %v, want %v", creds, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCredentialsWarnsOfDuplicateUsers(t *testing.T) {
+	var logged bytes.Buffer
+	saved := log.Writer()
+	log.SetOutput(&logged)
+	defer log.SetOutput(saved)
+
+	// a user may have several passwords, but is warned about, whichever source they're in first
+	creds := map[string]bool{"alice:old": true}
+	err := parseCredentials(strings.NewReader("bob:x\nalice:new\nbob:y\n"), "test", creds)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(creds) != 4 {
+		t.Errorf("got %v, want every set of credentials", creds)
+	}
+	for _, warning := range []string{`test line 2: duplicate credentials for user "alice"`, `test line 3: duplicate credentials for user "bob"`} {
+		if !strings.Contains(logged.String(), warning) {
+			t.Errorf("log %q doesn't warn %q", logged.String(), warning)
+		}
+	}
+}
+
+func TestSplitCredentialsEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"alice:pw", "alice:pw"},
+		{"alice:pw,bob:x", "alice:pw\nbob:x"},
+		{"alice:pw\nbob:x,carol:y", "alice:pw\nbob:x\ncarol:y"},
+		{`alice:pa\,ss,bob:x`, "alice:pa,ss\nbob:x"},
+		{`alice:pw\\,bob:x`, "alice:pw\\\nbob:x"},
+		{`alice:a\b`, `alice:a\b`},
+		{`alice:pw\`, `alice:pw\`},
+		{",alice:pw,", "\nalice:pw\n"},
+	}
+	for _, test := range tests {
+		if got := splitCredentialsEnv(test.value); got != test.want {
+			t.Errorf("splitCredentialsEnv(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestCredentialsFromEverySourceAreMerged(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("# from a file\nfile:pw\n")
+	file.Close()
+
+	stdin, input, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.WriteString("stdin:pw\n")
+	input.Close()
+	savedStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = savedStdin }()
+
+	os.Setenv(credentialsEnvVar, `env:pa\,ss,other:pw`)
+	defer os.Unsetenv(credentialsEnvVar)
+
+	config, err := ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError),
+		[]string{"-creds", "flag:pw", "-creds-file", file.Name(), "-creds-stdin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"flag:pw": true, "file:pw": true, "stdin:pw": true, "env:pa,ss": true, "other:pw": true}
+	if !reflect.DeepEqual(config.Credentials, want) {
+		t.Errorf("got %v, want %v", config.Credentials, want)
+	}
+}
+
+// whatever a credentials file holds, parsing it either fails or yields only valid credentials
+func FuzzParseCredentials(f *testing.F) {
+	f.Add("alice:secret\nbob:hunter2\n")
+	f.Add("# comment\n\n  alice:pa:ss \r\n")
+	f.Add(":nouser\n")
+	f.Add("nopassword\n")
+	f.Add("alice:\n")
+	f.Add("alice:one\nalice:two\n")
+	f.Fuzz(func(t *testing.T, file string) {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+		creds := make(map[string]bool)
+		if err := parseCredentials(strings.NewReader(file), "fuzz", creds); err != nil {
+			return
+		}
+		for cred := range creds {
+			if _, err := validateCredential(cred); err != nil {
+				t.Fatalf("parsed invalid credentials %q: %v", cred, err)
+			}
+		}
+	})
+}
+
+// credentials escaped as the README says come out of $CORKBOARD_CREDS as they went in
+func FuzzSplitCredentialsEnv(f *testing.F) {
+	f.Add("alice:pa,ss", "bob:hunter2")
+	f.Add(`alice:back\slash`, `bob:trailing\`)
+	f.Add(`alice:\,`, ",")
+	f.Fuzz(func(t *testing.T, first string, second string) {
+		if strings.Contains(first+second, "\n") {
+			return
+		}
+		escape := strings.NewReplacer(`\`, `\\`, ",", `\,`).Replace
+		value := escape(first) + "," + escape(second)
+		if got := splitCredentialsEnv(value); got != first+"\n"+second {
+			t.Fatalf("%q split into %q, want %q", value, got, first+"\n"+second)
+		}
+	})
+}
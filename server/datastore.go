@@ -0,0 +1,3332 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	NO_CLOBBER = iota
+	CREATED
+	UPDATED
+	NO_NOTE
+	MISMATCH
+	NOT_A_NUMBER
+	DELETED
+	// the note wasn't overwritten, since it had changed since NoteOptions.UnmodifiedSince
+	MODIFIED
+)
+
+// expiry policies
+// notes expire a set time after they were last viewed, or after they were created
+const (
+	EXPIRE_VIEWED  = "viewed"
+	EXPIRE_CREATED = "created"
+)
+
+// times are stored as RFC 3339 strings in UTC, written from go rather than by sqlite,
+// so that they compare correctly as strings and don't depend on sqlite's idea of the time
+const timeFormat = "2006-01-02T15:04:05Z"
+
+// formats a time for storage
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// formats a time which may be unset, for a nullable column
+func formatOptionalTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return formatTime(*t)
+}
+
+type Datastore struct {
+	// every write goes through this handle, which has a single connection
+	database *sql.DB
+	// reads go through this handle, whose connections can't write
+	reader *sql.DB
+	// whether new note bodies are stored once per distinct body in "blob"
+	dedupe bool
+	// if set, expired notes are archived here before they're deleted
+	archiveDir string
+	// tells the time for timestamps and expiry, to go code and sqlite alike; if nil, the real clock is used
+	clock *sharedClock
+	// whether notes are private to the users who own them; see scoped
+	private bool
+	// the most bytes of notes each owner may keep, or 0 if there's no limit; see checkQuota
+	quota int64
+	// if private is set, the user whose notes this datastore sees, or "" for the shared notes
+	owner string
+	// who may read and write which notes, with -acl-file, or nil if everyone may; see permits
+	acl *ACL
+	// if acl is set, the user it's applied to, or "" if the datastore isn't acting for a request
+	user string
+	// collects the time each method takes; if nil, nothing is collected
+	metrics *DatastoreMetrics
+	// if set, the transaction every statement goes through, reads included; see withTx
+	tx *sql.Tx
+	// asked about writes, and how long notes are kept; nil if there are none
+	hooks *Hooks
+	// views of notes waiting to be written to their last_viewed; see touchNote
+	viewed *pendingViews
+	// the request the datastore is acting for, passed to hooks; nil if it isn't acting for one
+	ctx context.Context
+}
+
+type migration struct {
+	date   string
+	number int
+}
+
+func (m1 migration) before(m2 migration) bool {
+	return m1.date < m2.date || (m1.date == m2.date && m1.number < m2.number)
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%s.%d", m.date, m.number)
+}
+
+// a migration file, embedded in the binary
+type migrationFile struct {
+	migration
+	path string
+}
+
+// parses a migration's filename, like 2026-10-17.3.sql
+func parseMigrationName(filename string) (migration, error) {
+	match, err := regexp.MatchString(`^\d{4}-\d{2}-\d{2}\.\d+\.sql$`, filename)
+	if err != nil {
+		return migration{}, fmt.Errorf("validating migration name: %s", err)
+	}
+	if !match {
+		return migration{}, fmt.Errorf("parsing migration name %s: bad format", filename)
+	}
+	nameComponents := strings.Split(filename, ".")
+	if len(nameComponents) != 3 {
+		return migration{}, fmt.Errorf("parsing migration name %s: bad format", filename)
+	}
+	number64, err := strconv.ParseInt(nameComponents[1], 10, 32)
+	if err != nil {
+		return migration{}, fmt.Errorf("parsing migration name %s: number too large", filename)
+	}
+	return migration{nameComponents[0], int(number64)}, nil
+}
+
+// finds the migrations in a directory, in the order they must be applied
+func findMigrations(migrations fs.FS) ([]migrationFile, error) {
+	files := []migrationFile{}
+	err := fs.WalkDir(migrations, ".", func(filepath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("walking dir: %s", err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		m, err := parseMigrationName(path.Base(filepath))
+		if err != nil {
+			return err
+		}
+		files = append(files, migrationFile{m, filepath})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// walkdir goes in lexicographical order, which would put 10 before 9
+	sort.Slice(files, func(i, j int) bool { return files[i].before(files[j].migration) })
+	for i := 1; i < len(files); i++ {
+		if files[i-1].migration == files[i].migration {
+			return nil, fmt.Errorf("duplicate migration: %s", files[i].migration)
+		}
+	}
+	return files, nil
+}
+
+// finds the migrations which have been applied to the database
+func (ds *Datastore) appliedMigrations() (map[migration]bool, error) {
+	// initialize _migration table
+	_, err := ds.writer().Exec(`create table if not exists _migration (
+		date	text,
+		number	number,
+		primary key (date, number))`)
+	if err != nil {
+		return nil, fmt.Errorf("creating _migration: %s", err)
+	}
+
+	rows, err := ds.writer().Query(`select * from _migration`)
+	if err != nil {
+		return nil, fmt.Errorf("finding migrations: %s", err)
+	}
+	defer rows.Close()
+	applied := make(map[migration]bool)
+	for rows.Next() {
+		var date string
+		var number int
+		err = rows.Scan(&date, &number)
+		if err != nil {
+			return nil, fmt.Errorf("reading row of migrations: %s", err)
+		}
+		applied[migration{date, number}] = true
+	}
+	return applied, rows.Err()
+}
+
+// the last migration applied to the database, like "2026-10-17.26", or "" if none have been
+func (ds *Datastore) schemaVersion() (string, error) {
+	applied, err := ds.appliedMigrations()
+	if err != nil {
+		return "", err
+	}
+	migrations := []migration{}
+	for m := range applied {
+		migrations = append(migrations, m)
+	}
+	if len(migrations) == 0 {
+		return "", nil
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].before(migrations[j]) })
+	return migrations[len(migrations)-1].String(), nil
+}
+
+// SchemaStatus compares the migrations applied to the database with those in the binary
+type SchemaStatus struct {
+	// no migrations have been applied, so the database is new
+	New bool
+	// migrations in the binary which haven't been applied to the database
+	Pending []migration
+	// migrations applied to the database which aren't in the binary,
+	// probably because a newer corkboard has used it
+	Unknown []migration
+}
+
+// checks whether the database's schema is the one the binary expects
+func (ds *Datastore) schemaStatus(migrations fs.FS) (SchemaStatus, error) {
+	files, err := findMigrations(migrations)
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	applied, err := ds.appliedMigrations()
+	if err != nil {
+		return SchemaStatus{}, err
+	}
+	status := SchemaStatus{New: len(applied) == 0}
+	known := make(map[migration]bool)
+	for _, file := range files {
+		known[file.migration] = true
+		if !applied[file.migration] {
+			status.Pending = append(status.Pending, file.migration)
+		}
+	}
+	for m := range applied {
+		if !known[m] {
+			status.Unknown = append(status.Unknown, m)
+		}
+	}
+	sort.Slice(status.Unknown, func(i, j int) bool { return status.Unknown[i].before(status.Unknown[j]) })
+	return status, nil
+}
+
+// applies the migrations which haven't been applied yet
+// migrations applied to the database which aren't in migrations are ignored; see schemaStatus
+func (ds *Datastore) RunMigrations(migrations fs.FS) error {
+	files, err := findMigrations(migrations)
+	if err != nil {
+		return err
+	}
+	applied, err := ds.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	// if there have been no migrations (including original schema), this DB is new
+	if len(applied) == 0 {
+		log.Println("creating database")
+	}
+	latestMigration := migration{}
+	for m := range applied {
+		if latestMigration.before(m) {
+			latestMigration = m
+		}
+	}
+
+	migrationsPerformed := 0
+	for _, file := range files {
+		if applied[file.migration] {
+			continue
+		}
+		if !latestMigration.before(file.migration) {
+			// this migration is somehow out of order
+			return fmt.Errorf("migrations are out of order: new migration %s is no newer than latest migration %s", file.migration, latestMigration)
+		}
+		err = ds.applyMigration(migrations, file)
+		if err != nil {
+			return err
+		}
+		latestMigration = file.migration
+		migrationsPerformed += 1
+	}
+	if migrationsPerformed > 0 {
+		log.Printf("applied %d migrations", migrationsPerformed)
+	}
+	return nil
+}
+
+// runs a migration and records it, in one transaction
+func (ds *Datastore) applyMigration(migrations fs.FS, file migrationFile) error {
+	contents, err := fs.ReadFile(migrations, file.path)
+	if err != nil {
+		return fmt.Errorf("reading migration file %s: %s", file.path, err)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	tx, err := ds.database.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %s", err)
+	}
+	_, err = tx.Exec(string(contents))
+	if err != nil {
+		return fmt.Errorf("running migration %s: %s", file.path, err)
+	}
+	_, err = tx.Exec(`insert into _migration values (?, ?)`, file.date, file.number)
+	if err != nil {
+		return fmt.Errorf("inserting migration into table: %s", err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing migration %s: %s", file.path, err)
+	}
+	return nil
+}
+
+// opens the database at path, returning a handle for writes and a handle for reads
+// sqlite allows only one writer at a time, and a writer which finds another in progress
+// can fail with "database is locked" rather than waiting, so all writes share one connection
+// and queue for it instead; in WAL mode, reads don't wait for writes, so they have their own pool
+// deleted and overwritten rows are zeroed, so old contents of notes don't linger in pages which are still in use;
+// free pages are left as they are, which costs nothing, until a note is wiped
+// the schema's triggers stamp what they write with corkboard_now(), which the writer's connection answers
+// from clock, so they keep the same time as the rest of corkboard, fake clocks included
+func openDatabase(path string, clock *sharedClock) (*sql.DB, *sql.DB, error) {
+	writer := sql.OpenDB(sqliteConnector{
+		dsn: databaseURI(path) + "?_foreign_keys=1&_auto_vacuum=incremental&_journal_mode=WAL&_txlock=immediate&_secure_delete=fast",
+		driver: &sqlite3.SQLiteDriver{ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("corkboard_now", func() string {
+				return formatTime(clock.get().Now().Truncate(time.Second))
+			}, false)
+		}},
+	})
+	writer.SetMaxOpenConns(1)
+	// make sure the database exists and is in WAL mode before any reads
+	err := writer.Ping()
+	if err != nil {
+		writer.Close()
+		return nil, nil, err
+	}
+	reader, err := sql.Open("sqlite3", databaseURI(path)+"?_foreign_keys=1&_query_only=1")
+	if err != nil {
+		writer.Close()
+		return nil, nil, err
+	}
+	return writer, reader, nil
+}
+
+// opens connections to one database with a driver of its own, so its ConnectHook can be given state
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// the sqlite uri for the database at path, which may be a Windows path like C:\corkboard\notes.db
+// sqlite wants forward slashes, and would take ? or # for the start of the query, and % for an escape
+func databaseURI(path string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(filepath.ToSlash(path))
+}
+
+// how often to try opening the database again while waiting for it; see waitForDatabase
+const databaseRetryInterval = time.Second
+
+// checks the database at path can be opened for writing, as can the directory it's in,
+// where sqlite keeps its journal; sql.Open doesn't touch the file, so this catches a bad path at startup
+// a database which doesn't exist is an error unless create is set, so a mistyped path isn't taken for a new database
+func checkDatabasePath(path string, create bool) error {
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("its directory is missing: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s isn't a directory", dir)
+	}
+	info, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		if !create {
+			return errors.New("it doesn't exist. To create it, start with -create-db or -auto-migrate")
+		}
+	} else if err != nil {
+		return err
+	} else if info.IsDir() {
+		return errors.New("it's a directory")
+	} else {
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("it can't be written: %w", err)
+		}
+		file.Close()
+	}
+	file, err := os.CreateTemp(dir, ".corkboard-check-*")
+	if err != nil {
+		return fmt.Errorf("its directory can't be written, which sqlite needs for its journal: %w", err)
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// opens the database at path, trying again for up to wait if it can't be, e.g. while a network filesystem is mounted
+// see checkDatabasePath for create
+func waitForDatabase(path string, create bool, wait time.Duration, clock *sharedClock) (*sql.DB, *sql.DB, error) {
+	deadline := time.Now().Add(wait)
+	for attempt := 0; ; attempt++ {
+		err := checkDatabasePath(path, create)
+		if err == nil {
+			var writer, reader *sql.DB
+			writer, reader, err = openDatabase(path, clock)
+			if err == nil {
+				return writer, reader, nil
+			}
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, nil, err
+		}
+		if attempt == 0 {
+			log.Printf("waiting up to %s for database %s: %v", wait, path, err)
+		}
+		if remaining > databaseRetryInterval {
+			remaining = databaseRetryInterval
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// opens the database at config.DatabasePath as a Datastore, set up as config says
+// it's created if it doesn't exist only with -create-db or -auto-migrate
+func openDatastore(config Config) (Datastore, error) {
+	clock := &sharedClock{clock: realClock{}}
+	writer, reader, err := waitForDatabase(config.DatabasePath, config.CreateDB || config.Schema.AutoMigrate, config.DBWait, clock)
+	if err != nil {
+		return Datastore{}, err
+	}
+	return Datastore{database: writer, reader: reader, dedupe: config.Dedupe, archiveDir: config.ArchiveDir, clock: clock, private: config.PrivateNotes,
+		quota: config.WritePolicy.Quota, acl: config.ACL, metrics: NewDatastoreMetrics(config.DatabasePath), hooks: config.Hooks,
+		viewed: newPendingViews()}, nil
+}
+
+// the state of the database's connections, for diagnostics
+func (ds *Datastore) diagnostics() interface{} {
+	return map[string]sql.DBStats{
+		"writer": ds.database.Stats(),
+		"reader": ds.reader.Stats(),
+	}
+}
+
+func (ds *Datastore) Close() error {
+	if _, err := ds.flushViews(); err != nil {
+		log.Printf("recording views of notes: %v", err)
+	}
+	err := ds.reader.Close()
+	if closeErr := ds.database.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// checks that the database can be read
+// this reads a table rather than just opening a connection, which may succeed even if the database is broken
+// reads don't wait for writes, so this is quick even while the database is busy
+func (ds *Datastore) ping(ctx context.Context) (err error) {
+	defer ds.metrics.observe("ping", time.Now(), &err)
+	var exists bool
+	return ds.read().QueryRowContext(ctx, `select exists (select 1 from "note")`).Scan(&exists)
+}
+
+// what statements are run on: one of the database's handles, or a transaction
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// a transaction from begin, which is either one of its own or a savepoint in the datastore's
+type transaction interface {
+	queryer
+	Commit() error
+	Rollback() error
+}
+
+// the handle writes go through
+func (ds *Datastore) writer() queryer {
+	if ds.tx != nil {
+		return ds.tx
+	}
+	return ds.database
+}
+
+// the handle reads go through
+// in a transaction, that's the transaction too, so reads see what it has written and nothing written since it began
+func (ds *Datastore) read() queryer {
+	if ds.tx != nil {
+		return ds.tx
+	}
+	return ds.reader
+}
+
+// begins a transaction for a method which makes several changes at once
+// in a transaction from withTx, the writer's only connection is already taken, so it's a savepoint in that one instead
+func (ds *Datastore) begin() (transaction, error) {
+	if ds.tx != nil {
+		return beginSavepoint(ds.tx)
+	}
+	return ds.database.Begin()
+}
+
+// like begin, for a method which reads several times and needs them all to see the same database
+func (ds *Datastore) beginRead() (transaction, error) {
+	if ds.tx != nil {
+		return beginSavepoint(ds.tx)
+	}
+	return ds.reader.Begin()
+}
+
+// a transaction nested in another, which commits into it, so it's only kept if the other is
+type savepoint struct {
+	*sql.Tx
+	done bool
+}
+
+// every savepoint has the same name, since sqlite releases or rolls back to the innermost one with it
+func beginSavepoint(tx *sql.Tx) (*savepoint, error) {
+	_, err := tx.Exec(`savepoint nested`)
+	if err != nil {
+		return nil, err
+	}
+	return &savepoint{Tx: tx}, nil
+}
+
+func (s *savepoint) Commit() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Tx.Exec(`release nested`)
+	return err
+}
+
+// undoes the savepoint's changes, leaving the rest of the transaction
+// like a transaction's, rolling back after committing does nothing, so it can be deferred
+func (s *savepoint) Rollback() error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.Tx.Exec(`rollback to nested`)
+	if err != nil {
+		return err
+	}
+	_, err = s.Tx.Exec(`release nested`)
+	return err
+}
+
+// returned by the methods which need connections of their own, which can't wait for a transaction they're in to end
+var errInTransaction = errors.New("can't be done in a transaction")
+
+// runs fn with a datastore whose every statement goes through one transaction, so fn's changes are made together or not at all:
+// the transaction is committed if fn returns nil, and rolled back if it returns an error or panics, or ctx is cancelled
+// fn's datastore mustn't be used once it returns
+// in a transaction, withTx reuses it, with fn's changes in a savepoint: an error from fn only undoes those,
+// and it's up to the outer fn whether to return it and undo the rest
+// wipeNote, vacuum and snapshot return errInTransaction in one
+func (ds *Datastore) withTx(ctx context.Context, fn func(tx Datastore) error) (err error) {
+	defer ds.metrics.observe("withTx", time.Now(), &err)
+	inner := *ds
+	var tx transaction
+	if ds.tx != nil {
+		tx, err = beginSavepoint(ds.tx)
+	} else {
+		inner.tx, err = ds.database.BeginTx(ctx, nil)
+		tx = inner.tx
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = fn(inner)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// selects the body of a note, whether or not it has been deduplicated
+const selectBody = `select coalesce("blob".body, "note".body) from "note"
+	left join "blob" on "blob".hash = "note".blob_hash`
+
+func (ds *Datastore) getNote(name string) (_ []byte, _ bool, err error) {
+	defer ds.metrics.observe("getNote", time.Now(), &err)
+	row := ds.read().QueryRow(selectBody+` where name = ?`, ds.key(name))
+	buf := []byte{}
+	if err := row.Scan(&buf); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		} else {
+			return nil, false, err
+		}
+	}
+	return buf, true, ds.touchNote(name)
+}
+
+// gets at most the first maxSize bytes of a note, and its full size
+// the rest of the note isn't read from the database at all
+func (ds *Datastore) getNotePrefix(name string, maxSize int) (_ []byte, _ int64, _ bool, err error) {
+	defer ds.metrics.observe("getNotePrefix", time.Now(), &err)
+	buf, size, exists, err := ds.peekNotePrefix(name, maxSize)
+	if err != nil || !exists {
+		return nil, 0, exists, err
+	}
+	return buf, size, true, ds.touchNote(name)
+}
+
+// like getNotePrefix, but without counting it as a view
+func (ds *Datastore) peekNotePrefix(name string, maxSize int) (_ []byte, _ int64, _ bool, err error) {
+	defer ds.metrics.observe("peekNotePrefix", time.Now(), &err)
+	row := ds.read().QueryRow(`select substr(cast(coalesce("blob".body, "note".body) as blob), 1, ?),
+		length(cast(coalesce("blob".body, "note".body) as blob)) from "note"
+		left join "blob" on "blob".hash = "note".blob_hash where name = ?`, maxSize, ds.key(name))
+	buf := []byte{}
+	var size int64
+	if err := row.Scan(&buf, &size); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, 0, false, nil
+		}
+		return nil, 0, false, err
+	}
+	return buf, size, true, nil
+}
+
+// records that a note was viewed
+// outside a transaction, the view waits in a batch, which is written by flushViews, and by Close
+func (ds *Datastore) touchNote(name string) (err error) {
+	defer ds.metrics.observe("touchNote", time.Now(), &err)
+	if ds.viewed != nil && ds.tx == nil {
+		if ds.viewed.add(ds.key(name), ds.now()) >= maxPendingViews {
+			_, err = ds.flushViews()
+		}
+		return err
+	}
+	_, err = ds.writer().Exec(
+		`update "note" set last_viewed = ? where name = ?`, formatTime(ds.now()), ds.key(name))
+	return err
+}
+
+// gets when anything shown on a note's page last changed, other than when it was viewed; see the page_time column
+func (ds *Datastore) getPageTime(name string) (_ time.Time, _ bool, err error) {
+	defer ds.metrics.observe("getPageTime", time.Now(), &err)
+	var changed sql.NullTime
+	var created time.Time
+	err = ds.read().QueryRow(`select page_time, create_time from "note" indexed by note_page_time where name = ?`,
+		ds.key(name)).Scan(&changed, &created)
+	if err == sql.ErrNoRows {
+		return created, false, nil
+	}
+	if err != nil {
+		return created, false, err
+	}
+	if changed.Valid {
+		return changed.Time, true, nil
+	}
+	return created, true, nil
+}
+
+// gets the time a note was created and the time it was last viewed
+func (ds *Datastore) getNoteTimes(name string) (_ time.Time, _ time.Time, _ bool, err error) {
+	defer ds.metrics.observe("getNoteTimes", time.Now(), &err)
+	row := ds.read().QueryRow(`select create_time, last_viewed from "note" where name = ?`, ds.key(name))
+	var created, viewed time.Time
+	if err := row.Scan(&created, &viewed); err != nil {
+		if err == sql.ErrNoRows {
+			return created, viewed, false, nil
+		} else {
+			return created, viewed, false, err
+		}
+	}
+	return created, viewed, true, nil
+}
+
+// gets a note's body without counting it as a view
+func (ds *Datastore) peekNote(name string) (_ []byte, _ bool, err error) {
+	defer ds.metrics.observe("peekNote", time.Now(), &err)
+	row := ds.read().QueryRow(selectBody+` where name = ?`, ds.key(name))
+	buf := []byte{}
+	if err := row.Scan(&buf); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		} else {
+			return nil, false, err
+		}
+	}
+	return buf, true, nil
+}
+
+// NoteOptions are settings for a note which are given when it's created
+type NoteOptions struct {
+	// left out of listings of notes
+	Unlisted bool
+	// shown instead of the note's name, if it isn't ""
+	Title string
+	// the type the note is served as, or "" for plain text; see storedContentType
+	// unlike the other options, this describes the body, so it's also set when a note is overwritten
+	ContentType string
+	// if set, an existing note is only overwritten if it hasn't changed since
+	UnmodifiedSince time.Time
+	// when the note is shown
+	Visibility Visibility
+}
+
+// adds the condition that a note hasn't changed since options.UnmodifiedSince, if it's set,
+// to an update which ends with a where clause
+func (options NoteOptions) unmodified(query string, args []interface{}) (string, []interface{}) {
+	if options.UnmodifiedSince.IsZero() {
+		return query, args
+	}
+	return query + ` and coalesce(modify_time, create_time) <= ?`, append(args, formatTime(options.UnmodifiedSince))
+}
+
+// the status of an update made with NoteOptions.unmodified
+func updateStatus(result sql.Result) (int, error) {
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if updated == 0 {
+		return MODIFIED, nil
+	}
+	return UPDATED, nil
+}
+
+// the type notes are served as unless they were uploaded as a file of another type
+const defaultNoteContentType = "text/plain; charset=UTF-8"
+
+// the content type stored for a note, which is "" for the default, or if contentType isn't valid
+func storedContentType(contentType string) string {
+	if _, _, err := mime.ParseMediaType(contentType); err != nil || contentType == defaultNoteContentType {
+		return ""
+	}
+	return contentType
+}
+
+// the content type a note is served as
+func servedContentType(stored string) string {
+	if stored == "" {
+		return defaultNoteContentType
+	}
+	return stored
+}
+
+func (ds *Datastore) setNote(name string, body []byte, clobber bool) (_ int, err error) {
+	defer ds.metrics.observe("setNote", time.Now(), &err)
+	return ds.setNoteWithHash(name, body, hashBody(body), clobber, NoteOptions{})
+}
+
+// like setNote, but for callers which have already hashed the body with hashBody
+// options are only applied if the note is created
+func (ds *Datastore) setNoteWithHash(name string, body []byte, hash string, clobber bool, options NoteOptions) (_ int, err error) {
+	defer ds.metrics.observe("setNoteWithHash", time.Now(), &err)
+	err = ds.hooks.beforeWrite(ds.context(), ds.key(name), body, options)
+	if err != nil {
+		return 0, err
+	}
+	if ds.dedupe {
+		status, err := ds.setDedupedNote(name, body, hash, clobber, options)
+		if err == nil && status != NO_CLOBBER && status != MODIFIED {
+			ds.applyRetentionHooks(name)
+		}
+		return status, err
+	}
+	// the insert and the update which overwrites the note instead are one transaction,
+	// so a note deleted between them isn't taken to have been modified
+	status := CREATED
+	now := formatTime(ds.now())
+	err = ds.withTx(context.Background(), func(tx Datastore) error {
+		_, err := tx.writer().Exec(`insert into "note" (name, body, hash, unlisted, title, content_type, create_time, last_viewed, publish_at, hide_after)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, tx.key(name), body, hash, options.Unlisted, options.Title, options.ContentType, now, now,
+			formatOptionalTime(options.Visibility.PublishAt), formatOptionalTime(options.Visibility.HideAfter))
+		if err == nil {
+			// a note over the quota is rolled back
+			return tx.checkGrowth(tx.writer(), name, 0, int64(len(body)))
+		}
+		if !strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return err
+		}
+		if !clobber {
+			// don't clobber a note
+			status = NO_CLOBBER
+			return nil
+		}
+		err = tx.checkQuota(tx.writer(), name, int64(len(body)))
+		if err != nil {
+			return err
+		}
+		// overwrite the body
+		query, args := options.unmodified(`update "note" set body = ?, hash = ?, blob_hash = null, content_type = ? where name = ?`,
+			[]interface{}{body, hash, options.ContentType, tx.key(name)})
+		result, err := tx.writer().Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		status, err = updateStatus(result)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if status != NO_CLOBBER && status != MODIFIED {
+		ds.applyRetentionHooks(name)
+	}
+	return status, nil
+}
+
+// like setNote, but dates the note as created, last viewed and last modified at the given times,
+// for notes imported from elsewhere
+// unlike setNoteWithHash, options are applied even if the note already existed
+func (ds *Datastore) setNoteWithTimes(name string, body []byte, clobber bool, options NoteOptions, created time.Time, viewed time.Time,
+	modified time.Time) (_ int, err error) {
+	defer ds.metrics.observe("setNoteWithTimes", time.Now(), &err)
+	// in one transaction, so a note is never left with the new body and the old times
+	var status int
+	err = ds.withTx(context.Background(), func(tx Datastore) error {
+		var err error
+		status, err = tx.setNoteWithHash(name, body, hashBody(body), clobber, options)
+		if err != nil || status == NO_CLOBBER || status == MODIFIED {
+			return err
+		}
+		// after setNoteWithHash, since overwriting a note dates it as modified now
+		_, err = tx.writer().Exec(`update "note" set create_time = ?, last_viewed = ?, modify_time = ?, unlisted = ?, title = ?, publish_at = ?,
+			hide_after = ? where name = ?`, formatTime(created), formatTime(viewed), formatTime(modified), options.Unlisted, options.Title,
+			formatOptionalTime(options.Visibility.PublishAt), formatOptionalTime(options.Visibility.HideAfter), tx.key(name))
+		if err != nil {
+			return err
+		}
+		tx.applyRetentionHooks(name)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// like setNoteWithHash, but stores the body in "blob", reusing an identical body if there is one
+// blob refcounts are kept up to date by triggers
+func (ds *Datastore) setDedupedNote(name string, body []byte, hash string, clobber bool, options NoteOptions) (_ int, err error) {
+	defer ds.metrics.observe("setDedupedNote", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`insert or ignore into "blob" (hash, body) values (?, ?)`, hash, body)
+	if err != nil {
+		return 0, err
+	}
+	status := CREATED
+	now := formatTime(ds.now())
+	_, err = tx.Exec(`insert into "note" (name, body, hash, blob_hash, unlisted, title, content_type, create_time, last_viewed, publish_at, hide_after)
+			values (?, x'', ?, ?, ?, ?, ?, ?, ?, ?, ?)`, ds.key(name), hash, hash, options.Unlisted, options.Title, options.ContentType, now, now,
+		formatOptionalTime(options.Visibility.PublishAt), formatOptionalTime(options.Visibility.HideAfter))
+	if err == nil {
+		err = ds.checkGrowth(tx, name, 0, int64(len(body)))
+	} else if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		if !clobber {
+			// don't clobber a note
+			return NO_CLOBBER, nil
+		}
+		err = ds.checkQuota(tx, name, int64(len(body)))
+		if err != nil {
+			return 0, err
+		}
+		query, args := options.unmodified(`update "note" set body = x'', hash = ?, blob_hash = ?, content_type = ? where name = ?`,
+			[]interface{}{hash, hash, options.ContentType, ds.key(name)})
+		var result sql.Result
+		result, err = tx.Exec(query, args...)
+		if err == nil {
+			status, err = updateStatus(result)
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == MODIFIED {
+		// rolled back, so the blob inserted for the new body isn't kept
+		return status, nil
+	}
+	return status, tx.Commit()
+}
+
+// replaces a note's body, but only if the hash of its current body is oldHash
+// returns false if the note has changed since, or doesn't exist
+func (ds *Datastore) swapNote(name string, oldHash string, body []byte) (_ bool, err error) {
+	defer ds.metrics.observe("swapNote", time.Now(), &err)
+	err = ds.hooks.beforeWrite(ds.context(), ds.key(name), body, NoteOptions{})
+	if err != nil {
+		return false, err
+	}
+	hash := hashBody(body)
+	tx, err := ds.begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	err = ds.checkQuota(tx, name, int64(len(body)))
+	if err != nil {
+		return false, err
+	}
+	var result sql.Result
+	if ds.dedupe {
+		_, err = tx.Exec(`insert or ignore into "blob" (hash, body) values (?, ?)`, hash, body)
+		if err != nil {
+			return false, err
+		}
+		result, err = tx.Exec(`update "note" set body = x'', hash = ?, blob_hash = ? where name = ? and hash = ?`,
+			hash, hash, ds.key(name), oldHash)
+	} else {
+		result, err = tx.Exec(`update "note" set body = ?, hash = ?, blob_hash = null where name = ? and hash = ?`,
+			body, hash, ds.key(name), oldHash)
+	}
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows == 0 {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+	ds.applyRetentionHooks(name)
+	return true, nil
+}
+
+// the body of the note an update is changing, trimmed of whitespace like strings.TrimSpace does to ascii,
+// whether or not it's deduplicated; see incrementNote
+const incrementedBody = `trim(cast(coalesce((select "blob".body from "blob" where "blob".hash = "note".blob_hash), "note".body) as text),
+	' ' || char(9, 10, 11, 12, 13))`
+
+// its digits, without a sign
+const incrementedDigits = `substr(` + incrementedBody + `, 1 + (substr(` + incrementedBody + `, 1, 1) in ('+', '-')))`
+
+// adds ?2 to the note named ?1, if its body is an integer which can have ?2 added without overflowing,
+// as strconv.ParseInt would read it
+// the note is left undeduplicated, since it's as small as the hash it would be replaced with
+const incrementUpdate = `update "note" set body = cast(cast(` + incrementedBody + ` as integer) + ?2 as text), blob_hash = null
+	where name = ?1 and ` + incrementedDigits + ` <> '' and ` + incrementedDigits + ` not glob '*[^0-9]*'
+	and length(ltrim(` + incrementedDigits + `, '0')) <= 19
+	and (length(ltrim(` + incrementedDigits + `, '0')) < 19 or ltrim(` + incrementedDigits + `, '0') <= '922337203685477580' || case when substr(` + incrementedBody + `, 1, 1) = '-' then '8' else '7' end)
+	and case when ?2 >= 0 then cast(` + incrementedBody + ` as integer) <= 9223372036854775807 - ?2
+		else cast(` + incrementedBody + ` as integer) >= -9223372036854775807 - 1 - ?2 end`
+
+// atomically adds to a note whose body is an integer, returning the new value
+// the addition is one update, so concurrent increments can't lose each other's
+// if create is true, a missing note is created as if its value was 0
+// returns NO_NOTE if the note doesn't exist, and NOT_A_NUMBER if its body isn't an integer or would overflow
+func (ds *Datastore) incrementNote(name string, by int64, create bool) (value int64, status int, err error) {
+	defer ds.metrics.observe("incrementNote", time.Now(), &err)
+	err = ds.withTx(ds.context(), func(tx Datastore) error {
+		oldSize, err := tx.noteSize(tx.writer(), name)
+		if err != nil {
+			return err
+		}
+		result, err := tx.writer().Exec(incrementUpdate, tx.key(name), by)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			exists, err := tx.noteExists(name)
+			if err != nil || exists {
+				status = NOT_A_NUMBER
+				return err
+			}
+			if !create {
+				status = NO_NOTE
+				return nil
+			}
+			value = by
+			status, err = tx.setNote(name, []byte(strconv.FormatInt(by, 10)), false)
+			return err
+		}
+		// sqlite too old for returning, so the new value is read back in the same transaction
+		var body []byte
+		err = tx.writer().QueryRow(`select body from "note" where name = ?`, tx.key(name)).Scan(&body)
+		if err != nil {
+			return err
+		}
+		value, err = strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return fmt.Errorf("incremented %s to %q: %w", name, body, err)
+		}
+		// a rejected write rolls the increment back
+		err = tx.hooks.beforeWrite(tx.context(), tx.key(name), body, NoteOptions{})
+		if err != nil {
+			return err
+		}
+		err = tx.checkGrowth(tx.writer(), name, oldSize, int64(len(body)))
+		if err != nil {
+			return err
+		}
+		status = UPDATED
+		_, err = tx.writer().Exec(`update "note" set hash = ? where name = ?`, hashBody(body), tx.key(name))
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if status == UPDATED {
+		ds.applyRetentionHooks(name)
+	}
+	return value, status, nil
+}
+
+// atomically replaces a note's body with set, if its body is currently expect
+// returns NO_NOTE if the note doesn't exist, and MISMATCH if its body isn't expect
+func (ds *Datastore) compareAndSwapNote(name string, expect []byte, set []byte) (_ int, err error) {
+	defer ds.metrics.observe("compareAndSwapNote", time.Now(), &err)
+	swapped, err := ds.swapNote(name, hashBody(expect), set)
+	if err != nil || swapped {
+		return UPDATED, err
+	}
+	exists, err := ds.noteExists(name)
+	if err != nil || exists {
+		return MISMATCH, err
+	}
+	return NO_NOTE, nil
+}
+
+// the datastore's clock
+func (ds *Datastore) getClock() Clock {
+	if ds.clock == nil {
+		return realClock{}
+	}
+	return ds.clock.get()
+}
+
+// makes the datastore, and every copy of it, tell the time by clock, as do its triggers
+func (ds *Datastore) setClock(clock Clock) {
+	ds.clock.set(clock)
+}
+
+// the current time, as stored in the database
+func (ds *Datastore) now() time.Time {
+	return ds.getClock().Now().UTC().Truncate(time.Second)
+}
+
+// moves the bodies of all notes which aren't deduplicated yet into "blob"
+// returns the number of notes converted and the number of bytes of note bodies saved
+func (ds *Datastore) dedupeNotes() (_ int64, _ int64, err error) {
+	defer ds.metrics.observe("dedupeNotes", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	var noteBytes, blobBytesBefore, blobBytesAfter int64
+	err = tx.QueryRow(`select coalesce(sum(length(body)), 0) from "note" where blob_hash is null`).Scan(&noteBytes)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = tx.QueryRow(`select coalesce(sum(length(body)), 0) from "blob"`).Scan(&blobBytesBefore)
+	if err != nil {
+		return 0, 0, err
+	}
+	_, err = tx.Exec(`insert or ignore into "blob" (hash, body)
+		select hash, body from "note" where blob_hash is null`)
+	if err != nil {
+		return 0, 0, err
+	}
+	result, err := tx.Exec(`update "note" set blob_hash = hash, body = x'' where blob_hash is null`)
+	if err != nil {
+		return 0, 0, err
+	}
+	converted, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	err = tx.QueryRow(`select coalesce(sum(length(body)), 0) from "blob"`).Scan(&blobBytesAfter)
+	if err != nil {
+		return 0, 0, err
+	}
+	return converted, noteBytes - (blobBytesAfter - blobBytesBefore), tx.Commit()
+}
+
+// gets the SHA-256 of a note's body, as lowercase hex
+func (ds *Datastore) getNoteHash(name string) (_ string, _ bool, err error) {
+	defer ds.metrics.observe("getNoteHash", time.Now(), &err)
+	row := ds.read().QueryRow(`select hash from "note" where name = ?`, ds.key(name))
+	var hash string
+	if err := row.Scan(&hash); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		} else {
+			return "", false, err
+		}
+	}
+	return hash, true, nil
+}
+
+// hashes every note which was created before hashes were stored
+func (ds *Datastore) backfillHashes() (err error) {
+	defer ds.metrics.observe("backfillHashes", time.Now(), &err)
+	rows, err := ds.read().Query(`select name, body from "note" where hash is null`)
+	if err != nil {
+		return err
+	}
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var name string
+		var body []byte
+		err = rows.Scan(&name, &body)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		hashes[name] = hashBody(body)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for name, hash := range hashes {
+		_, err = ds.writer().Exec(`update "note" set hash = ? where name = ?`, hash, name)
+		if err != nil {
+			return err
+		}
+	}
+	if len(hashes) > 0 {
+		log.Printf("hashed %d existing notes", len(hashes))
+	}
+	return nil
+}
+
+// the SHA-256 of a note's body, as lowercase hex
+func hashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// finds a listed note other than name whose body has the given hash, preferring the oldest
+func (ds *Datastore) findDuplicate(hash string, name string) (_ string, _ bool, err error) {
+	defer ds.metrics.observe("findDuplicate", time.Now(), &err)
+	var duplicate string
+	clause, args := ds.listed(ds.scope())
+	err = ds.read().QueryRow(`select name from "note" where hash = ? and name != ? and `+clause+`
+		order by create_time asc limit 1`, append([]interface{}{hash, ds.key(name)}, args...)...).Scan(&duplicate)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	return ds.unkey(duplicate), err == nil, err
+}
+
+// checks whether a note exists
+func (ds *Datastore) noteExists(name string) (_ bool, err error) {
+	defer ds.metrics.observe("noteExists", time.Now(), &err)
+	var exists bool
+	err = ds.read().QueryRow(`select exists (select 1 from "note" where name = ?)`, ds.key(name)).Scan(&exists)
+	return exists, err
+}
+
+func (ds *Datastore) deleteNote(name string) (err error) {
+	defer ds.metrics.observe("deleteNote", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "note" where name = ?`, ds.key(name))
+	return err
+}
+
+// WipeResult says what wiping a note did
+type WipeResult struct {
+	// whether the note existed to be deleted
+	Deleted bool `json:"deleted"`
+	// whether every copy of the note's contents in the database was overwritten, and the write-ahead log emptied,
+	// so the contents are no longer anywhere in the database's files
+	SecureDelete bool `json:"secure_delete"`
+	// why not, if they might be
+	Reason string `json:"reason,omitempty"`
+}
+
+// deletes a note, overwriting its contents and everything kept about them, like its attachments and snapshots,
+// rather than only marking the space they took as free
+// the change log and watch deliveries keep that the note changed, but not the hashes of its contents
+// contents shared with other notes, or their snapshots, are left for them
+// the note is wiped even if it was already deleted, for its snapshots and its hashes in the change log
+func (ds *Datastore) wipeNote(name string) (result WipeResult, err error) {
+	defer ds.metrics.observe("wipeNote", time.Now(), &err)
+	if ds.tx != nil {
+		return result, errInTransaction
+	}
+	ctx := context.Background()
+	// secure_delete is a setting of the connection, so the wipe has to keep to one
+	conn, err := ds.database.Conn(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `pragma secure_delete = on`)
+	if err != nil {
+		return result, err
+	}
+	// as openDatabase sets it
+	defer conn.ExecContext(ctx, `pragma secure_delete = fast`)
+	reasons, err := ds.wipeNoteContents(ctx, conn, ds.key(name), &result)
+	if err != nil {
+		return result, err
+	}
+	// free pages are moved to the end of the file and cut off, so nothing deleted earlier is left in them either
+	var mode int
+	err = conn.QueryRowContext(ctx, `pragma auto_vacuum`).Scan(&mode)
+	if err != nil {
+		return result, err
+	}
+	// 2 is incremental
+	if mode == 2 {
+		var rows *sql.Rows
+		rows, err = conn.QueryContext(ctx, `pragma incremental_vacuum`)
+		if err != nil {
+			return result, err
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return result, err
+		}
+	} else {
+		reasons = append(reasons, "the database isn't auto-vacuumed, so notes deleted before may be left in free pages; see -vacuum-interval")
+	}
+	// the write-ahead log still holds the pages as they were before
+	var busy, logged, checkpointed int
+	err = conn.QueryRowContext(ctx, `pragma wal_checkpoint(truncate)`).Scan(&busy, &logged, &checkpointed)
+	if err != nil {
+		return result, err
+	}
+	if busy != 0 {
+		reasons = append(reasons, "the write-ahead log couldn't be emptied while the database was being read")
+	}
+	result.SecureDelete = len(reasons) == 0
+	result.Reason = strings.Join(reasons, "; ")
+	return result, nil
+}
+
+// overwrites and deletes everything about the note with the given key, in one transaction
+// returns why some of its contents had to be left
+func (ds *Datastore) wipeNoteContents(ctx context.Context, conn *sql.Conn, key string, result *WipeResult) ([]string, error) {
+	reasons := []string{}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var blobHash sql.NullString
+	var others int64
+	err = tx.QueryRow(`select blob_hash, (select count(*) from "note" other where other.hash = "note".hash and other.name != ?1)
+		from "note" where name = ?1`, key).Scan(&blobHash, &others)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	result.Deleted = err == nil
+	if result.Deleted {
+		_, err = tx.Exec(`update "note" set body = zeroblob(length(body)) where name = ?`, key)
+		if err != nil {
+			return nil, err
+		}
+		// with -dedupe, they share its blob
+		if others > 0 {
+			reasons = append(reasons, "other notes have the same contents")
+		} else if blobHash.Valid {
+			_, err = tx.Exec(`update "blob" set body = zeroblob(length(body)) where hash = ?`, blobHash.String)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.Exec(`update "attachment" set body = zeroblob(length(body)) where note = ?`, key)
+		if err != nil {
+			return nil, err
+		}
+		// comments and the rest cascade
+		_, err = tx.Exec(`delete from "note" where name = ?`, key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// snapshots only this note has are overwritten and deleted; others only lose their link to it
+	var shared int64
+	err = tx.QueryRow(`select count(*) from "note_snapshot"
+		where note = ?1 and hash in (select hash from "note_snapshot" where note != ?1)`, key).Scan(&shared)
+	if err != nil {
+		return nil, err
+	}
+	if shared > 0 {
+		reasons = append(reasons, "other notes have snapshots with the same contents")
+	}
+	const ownSnapshots = `hash in (select hash from "note_snapshot" where note = ?1)
+		and hash not in (select hash from "note_snapshot" where note != ?1)`
+	_, err = tx.Exec(`update "snapshot" set body = zeroblob(length(body)) where `+ownSnapshots, key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`delete from "snapshot" where `+ownSnapshots, key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`delete from "note_snapshot" where note = ?`, key)
+	if err != nil {
+		return nil, err
+	}
+	// a hash is enough to check a guess at the contents, like a password
+	_, err = tx.Exec(`update "change_log" set hash = null where name = ?`, key)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`update "watch_delivery" set hash = null where note = ?`, key)
+	if err != nil {
+		return nil, err
+	}
+	return reasons, tx.Commit()
+}
+
+// gets the `maxNotes` most recently-created notes, leaving out unlisted notes
+func (ds *Datastore) getLatestNotes(maxNotes int) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("getLatestNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	clause, args := ds.listed(ds.scope())
+	rows, err := ds.read().Query(selectNoteInfo+` where `+clause+` order by create_time asc limit ?`,
+		append(args, maxNotes)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	err = rows.Err()
+	return notes, err
+}
+
+// gets up to maxNotes of the listed notes, newest first
+func (ds *Datastore) getNewestNotes(maxNotes int) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("getNewestNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	clause, args := ds.listed(ds.scope())
+	rows, err := ds.read().Query(selectNoteInfo+` where `+clause+` order by create_time desc, name desc limit ?`,
+		append(args, maxNotes)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	err = rows.Err()
+	return notes, err
+}
+
+// gets the names of every note, including unlisted notes
+func (ds *Datastore) getAllNotes() (_ []string, err error) {
+	defer ds.metrics.observe("getAllNotes", time.Now(), &err)
+	clause, args := ds.scope()
+	return ds.queryNames(`select (name) from "note" where `+clause+` order by create_time asc`, args...)
+}
+
+// runs a query which selects a list of note names
+func (ds *Datastore) queryNames(query string, args ...interface{}) (_ []string, err error) {
+	defer ds.metrics.observe("queryNames", time.Now(), &err)
+	var names = make([]string, 0)
+	rows, err := ds.read().Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, ds.unkey(name))
+	}
+	err = rows.Err()
+	return names, err
+}
+
+// gets the name of the most recently-created note
+func (ds *Datastore) getLatestNote() (_ string, _ bool, err error) {
+	defer ds.metrics.observe("getLatestNote", time.Now(), &err)
+	clause, args := ds.listed(ds.scope())
+	return ds.pickNote(`select name from "note" where `+clause+`
+		order by create_time desc, rowid desc limit 1`, args...)
+}
+
+// gets the name of a note picked uniformly at random
+func (ds *Datastore) getRandomNote() (_ string, _ bool, err error) {
+	defer ds.metrics.observe("getRandomNote", time.Now(), &err)
+	clause, args := ds.listed(ds.scope())
+	return ds.pickNote(`select name from "note" where `+clause+` order by random() limit 1`, args...)
+}
+
+// runs a query which selects a single note name
+func (ds *Datastore) pickNote(query string, args ...interface{}) (_ string, _ bool, err error) {
+	defer ds.metrics.observe("pickNote", time.Now(), &err)
+	var name string
+	if err := ds.read().QueryRow(query, args...).Scan(&name); err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		} else {
+			return "", false, err
+		}
+	}
+	return ds.unkey(name), true, nil
+}
+
+// NoteInfo describes a note, without its body
+type NoteInfo struct {
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`
+	CreateTime time.Time `json:"create_time"`
+	LastViewed time.Time `json:"last_viewed"`
+	Unlisted   bool      `json:"unlisted"`
+	// "" if the note has no title, and is shown by its name
+	Title string `json:"title,omitempty"`
+	// "" if the note is plain text
+	ContentType string `json:"content_type,omitempty"`
+	// when the note's contents last changed, or when it was created if they haven't
+	ModifyTime time.Time `json:"modify_time"`
+	// set with PUT /api/note/:note/meta
+	Meta NoteMeta `json:"meta,omitempty"`
+	// when the note is shown, set when it's created or with PATCH /api/note/:note/metadata
+	Visibility
+	// the SHA-256 of the note's body, as lowercase hex, as in X-Content-SHA256
+	Hash string `json:"hash"`
+	// how long a retention hook keeps the note, instead of -note-expiry and -retention; nil if none decided
+	retentionOverride *time.Duration
+}
+
+// selects the columns of a NoteInfo, in order
+// every column it reads is in the note_listing indexes, so listings never read notes' bodies
+const selectNoteInfo = `select name, size, create_time, last_viewed, unlisted, title, content_type, meta, modify_time,
+	publish_at, hide_after, retention_override, coalesce(hash, '') from "note"`
+
+// scans a row selected by selectNoteInfo
+func scanNoteInfo(row interface{ Scan(...interface{}) error }) (NoteInfo, error) {
+	var note NoteInfo
+	var meta string
+	var modified, publishAt, hideAfter sql.NullTime
+	var override sql.NullInt64
+	err := row.Scan(&note.Name, &note.Size, &note.CreateTime, &note.LastViewed, &note.Unlisted, &note.Title, &note.ContentType, &meta, &modified,
+		&publishAt, &hideAfter, &override, &note.Hash)
+	if err != nil {
+		return note, err
+	}
+	if override.Valid {
+		age := time.Duration(override.Int64) * time.Second
+		note.retentionOverride = &age
+	}
+	note.Visibility = scanVisibility(publishAt, hideAfter)
+	note.ModifyTime = note.CreateTime
+	if modified.Valid {
+		note.ModifyTime = modified.Time
+	}
+	if meta != "{}" {
+		note.Meta, err = parseNoteMeta([]byte(meta))
+	}
+	return note, err
+}
+
+// gets a note's metadata without counting it as a view
+func (ds *Datastore) getNoteInfo(name string) (_ NoteInfo, _ bool, err error) {
+	defer ds.metrics.observe("getNoteInfo", time.Now(), &err)
+	// sqlite would otherwise look the name up in the primary key, and read the note's row, body and all
+	note, err := scanNoteInfo(ds.read().QueryRow(selectNoteInfo+` indexed by note_listing_name where name = ?`, ds.key(name)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return note, false, nil
+		} else {
+			return note, false, err
+		}
+	}
+	note.Name = ds.unkey(note.Name)
+	return note, true, nil
+}
+
+// lists or unlists a note
+// returns false if the note doesn't exist
+func (ds *Datastore) setUnlisted(name string, unlisted bool) (_ bool, err error) {
+	defer ds.metrics.observe("setUnlisted", time.Now(), &err)
+	result, err := ds.writer().Exec(`update "note" set unlisted = ? where name = ?`, unlisted, ds.key(name))
+	if err != nil {
+		return false, err
+	}
+	return ds.updatedNote(name, result)
+}
+
+// sets a note's title, or removes it if title is ""
+// returns false if the note doesn't exist
+func (ds *Datastore) setNoteTitle(name string, title string) (_ bool, err error) {
+	defer ds.metrics.observe("setNoteTitle", time.Now(), &err)
+	result, err := ds.writer().Exec(`update "note" set title = ? where name = ?`, title, ds.key(name))
+	if err != nil {
+		return false, err
+	}
+	return ds.updatedNote(name, result)
+}
+
+// sets the content type a note is served as, which is "" for plain text; see storedContentType
+// returns false if the note doesn't exist
+func (ds *Datastore) setContentType(name string, contentType string) (_ bool, err error) {
+	defer ds.metrics.observe("setContentType", time.Now(), &err)
+	result, err := ds.writer().Exec(`update "note" set content_type = ? where name = ?`, contentType, ds.key(name))
+	if err != nil {
+		return false, err
+	}
+	return ds.updatedNote(name, result)
+}
+
+// the visibility of a note, from its nullable columns
+func scanVisibility(publishAt sql.NullTime, hideAfter sql.NullTime) Visibility {
+	var visibility Visibility
+	if publishAt.Valid {
+		visibility.PublishAt = &publishAt.Time
+	}
+	if hideAfter.Valid {
+		visibility.HideAfter = &hideAfter.Time
+	}
+	return visibility
+}
+
+// gets when a note is shown
+func (ds *Datastore) getNoteVisibility(name string) (_ Visibility, _ bool, err error) {
+	defer ds.metrics.observe("getNoteVisibility", time.Now(), &err)
+	var publishAt, hideAfter sql.NullTime
+	err = ds.read().QueryRow(`select publish_at, hide_after from "note" where name = ?`, ds.key(name)).Scan(&publishAt, &hideAfter)
+	if err == sql.ErrNoRows {
+		return Visibility{}, false, nil
+	}
+	if err != nil {
+		return Visibility{}, false, err
+	}
+	return scanVisibility(publishAt, hideAfter), true, nil
+}
+
+// sets when a note is shown
+// returns false if the note doesn't exist
+func (ds *Datastore) setNoteVisibility(name string, visibility Visibility) (_ bool, err error) {
+	defer ds.metrics.observe("setNoteVisibility", time.Now(), &err)
+	result, err := ds.writer().Exec(`update "note" set publish_at = ?, hide_after = ? where name = ?`,
+		formatOptionalTime(visibility.PublishAt), formatOptionalTime(visibility.HideAfter), ds.key(name))
+	if err != nil {
+		return false, err
+	}
+	return ds.updatedNote(name, result)
+}
+
+// narrows a where clause and its arguments to the notes which are listed:
+// those which aren't unlisted, and are visible now; see Visibility
+func (ds *Datastore) listed(clause string, args []interface{}) (string, []interface{}) {
+	now := formatTime(ds.now())
+	return `not unlisted and (publish_at is null or publish_at <= ?) and (hide_after is null or hide_after > ?) and ` + clause,
+		append([]interface{}{now, now}, args...)
+}
+
+// replaces a note's meta
+// returns false if the note doesn't exist
+func (ds *Datastore) setNoteMeta(name string, meta NoteMeta) (_ bool, err error) {
+	defer ds.metrics.observe("setNoteMeta", time.Now(), &err)
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return false, err
+	}
+	result, err := ds.writer().Exec(`update "note" set meta = ? where name = ?`, string(data), ds.key(name))
+	if err != nil {
+		return false, err
+	}
+	return ds.updatedNote(name, result)
+}
+
+// whether a statement changing a note's metadata found the note, applying the retention hooks to it if it did
+func (ds *Datastore) updatedNote(name string, result sql.Result) (bool, error) {
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+	ds.applyRetentionHooks(name)
+	return true, nil
+}
+
+// stores what the retention hooks decide about a note which was just changed, so expiryClause can apply it
+// the change is already saved, so failing to is only logged, and the note is decided on again at startup
+func (ds *Datastore) applyRetentionHooks(name string) {
+	if !ds.hooks.retains() {
+		return
+	}
+	err := func() (err error) {
+		defer ds.metrics.observe("applyRetentionHooks", time.Now(), &err)
+		note, err := scanNoteInfo(ds.read().QueryRow(selectNoteInfo+` indexed by note_listing_name where name = ?`, ds.key(name)))
+		if err == sql.ErrNoRows {
+			// deleted since
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return ds.storeRetentionOverride(note)
+	}()
+	if err != nil {
+		log.Printf("applying retention hooks to %s: %v", name, err)
+	}
+}
+
+// asks the retention hooks about every note, at startup, since they may have changed since the last run,
+// or clears what they decided if there are none now
+func (ds *Datastore) backfillRetentionOverrides() (err error) {
+	defer ds.metrics.observe("backfillRetentionOverrides", time.Now(), &err)
+	if !ds.hooks.retains() {
+		_, err = ds.writer().Exec(`update "note" set retention_override = null where retention_override is not null`)
+		return err
+	}
+	rows, err := ds.read().Query(selectNoteInfo)
+	if err != nil {
+		return err
+	}
+	notes := []NoteInfo{}
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		notes = append(notes, note)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, note := range notes {
+		err = ds.storeRetentionOverride(note)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stores how long the retention hooks keep a note, read with its stored name, if that's changed
+func (ds *Datastore) storeRetentionOverride(note NoteInfo) error {
+	var override interface{}
+	if age, ok := ds.hooks.retentionOverride(ds.context(), note); ok {
+		override = int64(age / time.Second)
+	}
+	_, err := ds.writer().Exec(`update "note" set retention_override = ? where name = ? and retention_override is not ?`,
+		override, note.Name, override)
+	return err
+}
+
+// the context of the request the datastore is acting for, for hooks
+func (ds *Datastore) context() context.Context {
+	if ds.ctx == nil {
+		return context.Background()
+	}
+	return ds.ctx
+}
+
+// gets the bounds of the range of names beginning with prefix, for an indexed range query
+// this is equivalent to `name like 'prefix%'` with % and _ escaped, but is case-sensitive
+// and can use the primary key index. if there is no upper bound, upper is ""
+func prefixBounds(prefix string) (string, string) {
+	upper := []byte(prefix)
+	for len(upper) > 0 {
+		last := len(upper) - 1
+		if upper[last] < 0xff {
+			upper[last] += 1
+			return prefix, string(upper)
+		}
+		upper = upper[:last]
+	}
+	return prefix, ""
+}
+
+// the where clause and arguments matching names which begin with prefix
+func prefixClause(prefix string) (string, []interface{}) {
+	lower, upper := prefixBounds(prefix)
+	if upper == "" {
+		return `name >= ?`, []interface{}{lower}
+	}
+	return `name >= ? and name < ?`, []interface{}{lower, upper}
+}
+
+// the name a note is stored under; see scoped
+func (ds *Datastore) key(name string) string {
+	if ds.owner == "" {
+		return name
+	}
+	return ds.owner + "/" + name
+}
+
+// the name a note is seen by, from the name it's stored under
+func (ds *Datastore) unkey(key string) string {
+	if ds.owner == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, ds.owner+"/")
+}
+
+// the where clause and arguments matching the notes the datastore sees
+// notes the acl doesn't let the datastore's user read are left out, so listings never show them
+func (ds *Datastore) scope() (string, []interface{}) {
+	if !ds.private {
+		return ds.restrict(`1`, nil, ACCESS_READ)
+	}
+	return ds.scopeWithPrefix("")
+}
+
+// the where clause and arguments matching the notes the datastore sees whose names begin with prefix
+func (ds *Datastore) scopeWithPrefix(prefix string) (string, []interface{}) {
+	clause, args := prefixClause(ds.key(prefix))
+	if ds.private && ds.owner == "" {
+		clause += ` and instr(name, '/') = 0`
+	}
+	return ds.restrict(clause, args, ACCESS_READ)
+}
+
+// whether the acl applies to the datastore: it's acting for a request, and not on the user's own private notes
+func (ds *Datastore) aclApplies() bool {
+	return ds.acl != nil && ds.user != "" && ds.owner == ""
+}
+
+// narrows a where clause to the notes the acl lets the datastore's user have access to, if it applies
+func (ds *Datastore) restrict(clause string, args []interface{}, access string) (string, []interface{}) {
+	if !ds.aclApplies() {
+		return clause, args
+	}
+	aclClause, aclArgs := ds.acl.clause(ds.user, access)
+	return clause + ` and ` + aclClause, append(args, aclArgs...)
+}
+
+// whether the acl lets the datastore's user have access to a note; it always does if the acl doesn't apply
+func (ds *Datastore) permits(name string, access string) bool {
+	return !ds.aclApplies() || ds.acl.allows(ds.user, name, access)
+}
+
+// lists the notes whose names begin with prefix, in order of name, leaving out unlisted notes
+func (ds *Datastore) listNotesWithPrefix(prefix string) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("listNotesWithPrefix", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	clause, args := ds.listed(ds.scopeWithPrefix(prefix))
+	rows, err := ds.read().Query(selectNoteInfo+` where `+clause+` order by name asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	err = rows.Err()
+	return notes, err
+}
+
+// lists every note the datastore sees, including unlisted notes, in order of name
+func (ds *Datastore) listEveryNote() (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("listEveryNote", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	clause, args := ds.scope()
+	rows, err := ds.read().Query(selectNoteInfo+` where `+clause+` order by name asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	err = rows.Err()
+	return notes, err
+}
+
+// moves the notes which belong to no one into owner's private notes, for -private-notes
+// a note is left where it is if owner already has one of the same name
+// attachments, comments and locks follow their notes by cascading
+// returns how many notes were moved and how many were left
+func (ds *Datastore) assignOwner(owner string) (_ int64, _ int64, err error) {
+	defer ds.metrics.observe("assignOwner", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(`update "note" set name = ?1 || '/' || name
+		where instr(name, '/') = 0
+		and not exists (select 1 from "note" taken where taken.name = ?1 || '/' || "note".name)`, owner)
+	if err != nil {
+		return 0, 0, err
+	}
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	var left int64
+	err = tx.QueryRow(`select count(*) from "note" where instr(name, '/') = 0`).Scan(&left)
+	if err != nil {
+		return 0, 0, err
+	}
+	return moved, left, tx.Commit()
+}
+
+// deletes every note whose name begins with prefix
+// returns the names of the deleted notes
+func (ds *Datastore) deleteNotesWithPrefix(prefix string) (_ []string, err error) {
+	defer ds.metrics.observe("deleteNotesWithPrefix", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// notes the user may read but not write are left alone
+	clause, args := ds.scopeWithPrefix(prefix)
+	clause, args = ds.restrict(clause, args, ACCESS_WRITE)
+	names := make([]string, 0)
+	rows, err := tx.Query(`select name from "note" where `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, ds.unkey(name))
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`delete from "note" where `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	return names, tx.Commit()
+}
+
+// lists the names of all note templates, without noteTemplatePrefix
+func (ds *Datastore) listTemplates() (_ []string, err error) {
+	defer ds.metrics.observe("listTemplates", time.Now(), &err)
+	var names = make([]string, 0)
+	clause, args := ds.scopeWithPrefix(noteTemplatePrefix)
+	rows, err := ds.read().Query(
+		`select substr(name, ?) from "note" where `+clause+` order by name asc`,
+		append([]interface{}{len(ds.key(noteTemplatePrefix)) + 1}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return names, err
+		}
+		names = append(names, name)
+	}
+	err = rows.Err()
+	return names, err
+}
+
+// the where clause matching notes which have expired by at
+// under EXPIRE_VIEWED, age is measured from when the note was last viewed,
+// and under EXPIRE_CREATED, from when it was created
+// notes a retention hook decided on, including those matching a -retention rule, are kept for what it decided instead; see Hooks
+// notes which were never viewed after being created have also expired once they are older than `unviewedAge`
+// a zero duration disables the corresponding rule; if no notes could expire, returns false
+// this is the one definition of expiry, so listing expiring notes can't disagree with deleting them
+func (ds *Datastore) expiryClause(age time.Duration, unviewedAge time.Duration, policy string, at time.Time) (string, []interface{}, bool) {
+	column := "last_viewed"
+	if policy == EXPIRE_CREATED {
+		column = "create_time"
+	}
+	// a note's age counts from when it's published, if that's later, so it can't expire before anyone could see it
+	column = `max(` + column + `, coalesce(publish_at, ` + column + `))`
+	predicates := []string{}
+	args := []interface{}{}
+	if age != 0 || ds.hooks.retains() {
+		var bound interface{}
+		if age != 0 {
+			bound = formatTime(at.Add(-age))
+		}
+		threshold, thresholdArgs := `?`, []interface{}{bound}
+		if ds.hooks.retains() {
+			threshold = `(case when retention_override is null then ? when retention_override = 0 then null
+				else strftime('%Y-%m-%dT%H:%M:%SZ', ?, '-' || retention_override || ' seconds') end)`
+			thresholdArgs = append(thresholdArgs, formatTime(at))
+		}
+		predicates = append(predicates, column+` < `+threshold)
+		args = append(args, thresholdArgs...)
+	}
+	if unviewedAge != 0 {
+		predicates = append(predicates, `(last_viewed = create_time and max(create_time, coalesce(publish_at, create_time)) < ?)`)
+		args = append(args, formatTime(at.Add(-unviewedAge)))
+	}
+	if len(predicates) == 0 {
+		return "", nil, false
+	}
+	return "(" + strings.Join(predicates, " or ") + ")", args, true
+}
+
+// lists up to limit notes which have expired, as defined by expiryClause, least recently viewed first
+func (ds *Datastore) listExpiringNotes(age time.Duration, unviewedAge time.Duration, policy string, limit int) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("listExpiringNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	where, args, ok := ds.expiryClause(age, unviewedAge, policy, ds.now())
+	if !ok {
+		return notes, nil
+	}
+	rows, err := ds.read().Query(selectNoteInfo+` where `+where+` order by last_viewed asc, name asc limit ?`,
+		append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// finds the notes which will have expired within the next `within`, as defined by expiryClause,
+// and which haven't already been warned about, recording that they now have been
+// under EXPIRE_VIEWED, a note which is viewed after it's warned about, putting off its expiry,
+// is warned about again when it's next expiring
+// private notes are left out, since warnings are sent where everyone can see them
+func (ds *Datastore) warnExpiringNotes(within time.Duration, age time.Duration, unviewedAge time.Duration, policy string) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("warnExpiringNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	now := ds.now()
+	where, args, ok := ds.expiryClause(age, unviewedAge, policy, now.Add(within))
+	if !ok {
+		return notes, nil
+	}
+	warned := `warning.note = "note".name`
+	if policy != EXPIRE_CREATED {
+		warned += ` and warning.last_viewed = "note".last_viewed`
+	}
+	tx, err := ds.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if ds.private {
+		// private notes aren't in the shared warning
+		where += ` and instr(name, '/') = 0`
+	}
+	rows, err := tx.Query(selectNoteInfo+` where `+where+`
+		and not exists (select 1 from "expiry_warning" warning where `+warned+`)
+		order by last_viewed asc, name asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		notes = append(notes, note)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, note := range notes {
+		_, err = tx.Exec(`insert or replace into "expiry_warning" (note, last_viewed, warn_time) values (?, ?, ?)`,
+			note.Name, formatTime(note.LastViewed), formatTime(now))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return notes, tx.Commit()
+}
+
+// deletes notes which have expired, as defined by expiryClause
+// if only isn't nil, just the expired notes named in it are deleted
+// returns the number of notes deleted
+// gives up once ctx is done
+func (ds *Datastore) deleteOldNotes(ctx context.Context, age time.Duration, unviewedAge time.Duration, policy string, only []string) (_ int64, err error) {
+	defer ds.metrics.observe("deleteOldNotes", time.Now(), &err)
+	where, args, ok := ds.expiryClause(age, unviewedAge, policy, ds.now())
+	if !ok || (only != nil && len(only) == 0) {
+		return 0, nil
+	}
+	if only != nil {
+		where += ` and name in (?` + strings.Repeat(`, ?`, len(only)-1) + `)`
+		for _, name := range only {
+			args = append(args, name)
+		}
+	}
+	if ds.archiveDir != "" {
+		return ds.archiveOldNotes(ctx, where, args)
+	}
+	result, err := ds.writer().ExecContext(ctx, `delete from "note" where `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// archives and deletes the notes matching a where clause
+// a note is only deleted once its archive is safely on disk, and only if it hasn't changed since;
+// notes which can't be archived are logged and left for next time
+func (ds *Datastore) archiveOldNotes(ctx context.Context, where string, args []interface{}) (_ int64, err error) {
+	defer ds.metrics.observe("archiveOldNotes", time.Now(), &err)
+	names, err := ds.queryNames(`select name from "note" where `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	deleted := int64(0)
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return deleted, ctx.Err()
+		}
+		info, ok, err := ds.getNoteInfo(name)
+		if err != nil {
+			return deleted, err
+		}
+		hash, _, err := ds.getNoteHash(name)
+		if err != nil {
+			return deleted, err
+		}
+		body, _, err := ds.peekNote(name)
+		if err != nil {
+			return deleted, err
+		}
+		if !ok || hashBody(body) != hash {
+			// deleted or changed while we were reading it
+			continue
+		}
+		note := ArchivedNote{
+			Name:        name,
+			Hash:        hash,
+			Unlisted:    info.Unlisted,
+			Title:       info.Title,
+			CreateTime:  info.CreateTime,
+			LastViewed:  info.LastViewed,
+			ArchiveTime: time.Now().UTC(),
+		}
+		err = archiveNote(ds.archiveDir, note, body)
+		if err != nil {
+			log.Printf("archiving note %s: %v; not deleting it", name, err)
+			continue
+		}
+		result, err := ds.writer().ExecContext(ctx, `delete from "note" where name = ? and hash = ?`, name, hash)
+		if err != nil {
+			return deleted, err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += rows
+	}
+	return deleted, nil
+}
+
+// checks the database for corruption, returning a description of each problem found
+// a full check also checks indexes match their tables, and takes much longer on large databases
+func (ds *Datastore) integrityCheck(full bool) (_ []string, err error) {
+	defer ds.metrics.observe("integrityCheck", time.Now(), &err)
+	pragma := `pragma quick_check`
+	if full {
+		pragma = `pragma integrity_check`
+	}
+	rows, err := ds.read().Query(pragma)
+	if err != nil {
+		return corruptionProblems(err)
+	}
+	defer rows.Close()
+	problems := []string{}
+	for rows.Next() {
+		var problem string
+		err = rows.Scan(&problem)
+		if err != nil {
+			return corruptionProblems(err)
+		}
+		// a healthy database gives a single "ok"
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return corruptionProblems(err)
+	}
+	return problems, nil
+}
+
+// a database can be too badly corrupted to check at all, which is a problem rather than an error
+func corruptionProblems(err error) ([]string, error) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB) {
+		return []string{err.Error()}, nil
+	}
+	return nil, err
+}
+
+// checks whether an error means the database was busy, so that trying again later may succeed
+func busyError(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// the size of the database file, in bytes
+func (ds *Datastore) databaseSize() (_ int64, err error) {
+	defer ds.metrics.observe("databaseSize", time.Now(), &err)
+	var pages, pageSize int64
+	err = ds.read().QueryRow(`pragma page_count`).Scan(&pages)
+	if err != nil {
+		return 0, err
+	}
+	err = ds.read().QueryRow(`pragma page_size`).Scan(&pageSize)
+	return pages * pageSize, err
+}
+
+// returns free pages in the database file to the filesystem
+// databases with incremental auto-vacuum are vacuumed incrementally, and others in full,
+// which also switches them to incremental auto-vacuum
+// returns the number of bytes reclaimed
+func (ds *Datastore) vacuum() (_ int64, err error) {
+	defer ds.metrics.observe("vacuum", time.Now(), &err)
+	if ds.tx != nil {
+		return 0, errInTransaction
+	}
+	before, err := ds.databaseSize()
+	if err != nil {
+		return 0, err
+	}
+	var mode int
+	err = ds.writer().QueryRow(`pragma auto_vacuum`).Scan(&mode)
+	if err != nil {
+		return 0, err
+	}
+	// 2 is incremental
+	if mode == 2 {
+		// the pragma frees a page each step, so its rows must all be read
+		var rows *sql.Rows
+		rows, err = ds.writer().Query(`pragma incremental_vacuum`)
+		if err != nil {
+			return 0, err
+		}
+		for rows.Next() {
+		}
+		err = rows.Err()
+		rows.Close()
+	} else {
+		_, err = ds.writer().Exec(`vacuum`)
+	}
+	if err != nil {
+		return 0, err
+	}
+	after, err := ds.databaseSize()
+	return before - after, err
+}
+
+// writes a consistent copy of the database to path, which mustn't exist
+// the WAL is checkpointed first, so the database file itself is brought up to date too
+// writes wait while the copy is made, since they share its connection
+func (ds *Datastore) snapshot(path string) (err error) {
+	defer ds.metrics.observe("snapshot", time.Now(), &err)
+	if ds.tx != nil {
+		return errInTransaction
+	}
+	_, err = ds.writer().Exec(`pragma wal_checkpoint(passive)`)
+	if err != nil {
+		return fmt.Errorf("checkpointing: %s", err)
+	}
+	_, err = ds.writer().Exec(`vacuum into ?`, path)
+	return err
+}
+
+// NoteLock is an advisory lock on a note, held while someone edits it
+type NoteLock struct {
+	Holder   string    `json:"holder"`
+	Acquired time.Time `json:"acquired"`
+	Expires  time.Time `json:"expires"`
+}
+
+// gets the unexpired lock on a note, if there is one
+func (ds *Datastore) getNoteLock(name string) (_ NoteLock, _ bool, err error) {
+	defer ds.metrics.observe("getNoteLock", time.Now(), &err)
+	var lock NoteLock
+	err = ds.read().QueryRow(`select holder, acquired, expires from "note_lock"
+			where note = ? and expires > ?`, ds.key(name), formatTime(ds.now())).Scan(&lock.Holder, &lock.Acquired, &lock.Expires)
+	if err == sql.ErrNoRows {
+		return lock, false, nil
+	}
+	return lock, err == nil, err
+}
+
+// locks a note for holder until duration from now
+// a lock which the holder already holds is renewed, and one which has expired is taken over
+// if force is true, a lock held by someone else is taken over too
+// returns the note's lock afterwards, and whether holder holds it
+func (ds *Datastore) lockNote(name string, holder string, duration time.Duration, force bool) (_ NoteLock, _ bool, err error) {
+	defer ds.metrics.observe("lockNote", time.Now(), &err)
+	now := ds.now()
+	_, err = ds.writer().Exec(`insert into "note_lock" (note, holder, acquired, expires)
+			values (?1, ?2, ?3, ?4)
+			on conflict (note) do update set
+				acquired = case when holder = excluded.holder and expires > ?3
+					then acquired else excluded.acquired end,
+				holder = excluded.holder,
+				expires = excluded.expires
+			where holder = excluded.holder or expires <= ?3 or ?5`,
+		ds.key(name), holder, formatTime(now), formatTime(now.Add(duration)), force)
+	if err != nil {
+		return NoteLock{}, false, err
+	}
+	lock, _, err := ds.getNoteLock(name)
+	return lock, lock.Holder == holder, err
+}
+
+// releases holder's lock on a note
+// if force is true, a lock held by someone else is released too
+// returns false if the note is locked by someone else
+func (ds *Datastore) unlockNote(name string, holder string, force bool) (_ bool, err error) {
+	defer ds.metrics.observe("unlockNote", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "note_lock"
+			where note = ? and (holder = ? or expires <= ? or ?)`, ds.key(name), holder, formatTime(ds.now()), force)
+	if err != nil {
+		return false, err
+	}
+	_, locked, err := ds.getNoteLock(name)
+	return !locked, err
+}
+
+// deletes locks which have expired, returning how many were deleted
+func (ds *Datastore) deleteExpiredLocks() (_ int64, err error) {
+	defer ds.metrics.observe("deleteExpiredLocks", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "note_lock" where expires <= ?`, formatTime(ds.now()))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// replication actions
+const (
+	REPLICATE_PUT    = "put"
+	REPLICATE_DELETE = "delete"
+)
+
+// a change waiting to be pushed to a mirror
+type replicationTask struct {
+	id       int64
+	name     string
+	action   string
+	attempts int
+}
+
+// queues a change to a note to be pushed to the mirror
+func (ds *Datastore) enqueueReplication(name string, action string) (err error) {
+	defer ds.metrics.observe("enqueueReplication", time.Now(), &err)
+	now := formatTime(ds.now())
+	_, err = ds.writer().Exec(`insert into "replication" (name, action, next_attempt, create_time)
+		values (?, ?, ?, ?)`, name, action, now, now)
+	return err
+}
+
+// counts the replication tasks waiting to be pushed to the mirror
+func (ds *Datastore) countReplications() (_ int64, err error) {
+	defer ds.metrics.observe("countReplications", time.Now(), &err)
+	var count int64
+	err = ds.read().QueryRow(`select count(*) from "replication"`).Scan(&count)
+	return count, err
+}
+
+// gets the oldest replication task which is ready to be attempted
+// a task is never returned while an older task for the same note is still queued,
+// so changes to a note are always replicated in order
+func (ds *Datastore) nextReplication() (_ replicationTask, _ bool, err error) {
+	defer ds.metrics.observe("nextReplication", time.Now(), &err)
+	row := ds.read().QueryRow(`select id, name, action, attempts from "replication" r
+		where next_attempt <= ?
+		and not exists (select 1 from "replication" e where e.name = r.name and e.id < r.id)
+		order by id asc limit 1`, formatTime(ds.now()))
+	task := replicationTask{}
+	if err := row.Scan(&task.id, &task.name, &task.action, &task.attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return task, false, nil
+		} else {
+			return task, false, err
+		}
+	}
+	return task, true, nil
+}
+
+// removes a replication task from the queue once it has succeeded
+func (ds *Datastore) finishReplication(id int64) (err error) {
+	defer ds.metrics.observe("finishReplication", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "replication" where id = ?`, id)
+	return err
+}
+
+// records a failed replication attempt and schedules the next one after `delay`
+func (ds *Datastore) retryReplication(id int64, delay time.Duration) (err error) {
+	defer ds.metrics.observe("retryReplication", time.Now(), &err)
+	_, err = ds.writer().Exec(`update "replication"
+		set attempts = attempts + 1, next_attempt = ?
+		where id = ?`, formatTime(ds.now().Add(delay)), id)
+	return err
+}
+
+// Change is an entry in the change log: a note was created, updated or deleted
+type Change struct {
+	// position in the log, which a client can resume from
+	Cursor int64  `json:"cursor"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	// the hash of the note's new body, or "" if it was deleted
+	Hash string    `json:"hash,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// lists up to limit changes after the cursor since, oldest first
+// also returns the oldest cursor still in the log, or the next to be used if the log is empty;
+// if since is before it, changes after since have been pruned
+func (ds *Datastore) listChanges(since int64, limit int) (_ []Change, _ int64, err error) {
+	defer ds.metrics.observe("listChanges", time.Now(), &err)
+	tx, err := ds.beginRead()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	// ids are contiguous, since the log is only ever pruned from the start
+	var oldest int64
+	err = tx.QueryRow(`select coalesce(
+			(select min(id) from "change_log"),
+			(select seq + 1 from sqlite_sequence where name = 'change_log'),
+			1)`).Scan(&oldest)
+	if err != nil {
+		return nil, 0, err
+	}
+	clause, args := ds.scope()
+	rows, err := tx.Query(`select id, name, action, coalesce(hash, ''), change_time from "change_log"
+		where id > ? and `+clause+` order by id asc limit ?`, append(append([]interface{}{since}, args...), limit)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	changes := make([]Change, 0)
+	for rows.Next() {
+		var change Change
+		err := rows.Scan(&change.Cursor, &change.Name, &change.Action, &change.Hash, &change.Time)
+		if err != nil {
+			return nil, 0, err
+		}
+		change.Name = ds.unkey(change.Name)
+		changes = append(changes, change)
+	}
+	return changes, oldest, rows.Err()
+}
+
+// DigestChange is what was done to a note during a digest's period
+type DigestChange struct {
+	Name string
+	// whether the note was created during the period, rather than before it
+	Created bool
+	// the last change made to the note, NOTE_CREATED, NOTE_UPDATED or NOTE_DELETED, and when it was made
+	Action string
+	Time   time.Time
+}
+
+// lists what the change log says was done to each note after start and up to end, in the order they were last changed
+// notes which are unlisted or outside their visibility window now are left out, as on the main page,
+// and so are notes whose names begin with exclude, so digests don't report on each other
+func (ds *Datastore) digestChanges(start time.Time, end time.Time, exclude string) (_ []DigestChange, err error) {
+	defer ds.metrics.observe("digestChanges", time.Now(), &err)
+	clause, args := ds.scope()
+	excluded, excludedArgs := prefixClause(ds.key(exclude))
+	listed, listedArgs := ds.listed(`1`, nil)
+	args = append([]interface{}{formatTime(start), formatTime(end)}, args...)
+	args = append(append(args, excludedArgs...), listedArgs...)
+	rows, err := ds.read().Query(`select name, action, change_time from "change_log"
+		where change_time > ? and change_time <= ? and `+clause+` and not (`+excluded+`)
+		and not exists (select 1 from "note" where "note".name = "change_log".name and not (`+listed+`))
+		order by id asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byName := map[string]*DigestChange{}
+	names := []string{}
+	for rows.Next() {
+		var change DigestChange
+		err := rows.Scan(&change.Name, &change.Action, &change.Time)
+		if err != nil {
+			return nil, err
+		}
+		change.Name = ds.unkey(change.Name)
+		last, ok := byName[change.Name]
+		if !ok {
+			last = &change
+			byName[change.Name] = last
+			names = append(names, change.Name)
+		} else {
+			last.Action = change.Action
+			last.Time = change.Time
+		}
+		if change.Action == NOTE_CREATED {
+			last.Created = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	changes := make([]DigestChange, 0, len(names))
+	for _, name := range names {
+		changes = append(changes, *byName[name])
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Time.Before(changes[j].Time)
+	})
+	return changes, nil
+}
+
+// lists the listed notes which will have expired by before, as defined by expiryClause, by name
+// unlike warnExpiringNotes, it doesn't remember them; notes whose names begin with exclude are left out
+func (ds *Datastore) expiringNotes(before time.Time, age time.Duration, unviewedAge time.Duration, policy string, exclude string) (_ []NoteInfo, err error) {
+	defer ds.metrics.observe("expiringNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	where, args, ok := ds.expiryClause(age, unviewedAge, policy, before)
+	if !ok {
+		return notes, nil
+	}
+	clause, scopeArgs := ds.listed(ds.scope())
+	excluded, excludedArgs := prefixClause(ds.key(exclude))
+	args = append(append(args, scopeArgs...), excludedArgs...)
+	rows, err := ds.read().Query(selectNoteInfo+` where `+where+` and `+clause+` and not (`+excluded+`) order by name asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return nil, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	return notes, rows.Err()
+}
+
+// when a note which doesn't exist was deleted, if its deletion is still in the change log
+func (ds *Datastore) deletedAt(name string) (_ time.Time, _ bool, err error) {
+	defer ds.metrics.observe("deletedAt", time.Now(), &err)
+	var action string
+	var deleted time.Time
+	err = ds.read().QueryRow(`select action, change_time from "change_log" where name = ? order by id desc limit 1`,
+		ds.key(name)).Scan(&action, &deleted)
+	if err == sql.ErrNoRows || (err == nil && action != "delete") {
+		return deleted, false, nil
+	}
+	return deleted, err == nil, err
+}
+
+// deletes changes older than age from the change log
+func (ds *Datastore) pruneChanges(age time.Duration) (_ int64, err error) {
+	defer ds.metrics.observe("pruneChanges", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "change_log" where change_time < ?`, formatTime(ds.now().Add(-age)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// counts the notes, including unlisted notes
+func (ds *Datastore) countNotes() (_ int64, err error) {
+	defer ds.metrics.observe("countNotes", time.Now(), &err)
+	var count int64
+	err = ds.read().QueryRow(`select count(*) from "note"`).Scan(&count)
+	return count, err
+}
+
+// gets every stored setting
+func (ds *Datastore) listSettings() (_ map[string]string, err error) {
+	defer ds.metrics.observe("listSettings", time.Now(), &err)
+	rows, err := ds.read().Query(`select name, value from "setting"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	settings := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		settings[name] = value
+	}
+	return settings, rows.Err()
+}
+
+// stores settings, all or nothing
+// a nil value removes the setting
+func (ds *Datastore) setSettings(changes map[string]*string) (err error) {
+	defer ds.metrics.observe("setSettings", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for name, value := range changes {
+		if value == nil {
+			_, err = tx.Exec(`delete from "setting" where name = ?`, name)
+		} else {
+			_, err = tx.Exec(`insert into "setting" (name, value) values (?, ?)
+					on conflict (name) do update set value = excluded.value`, name, *value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Attachment is a file attached to a note
+type Attachment struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+	Type string `json:"type"`
+	body []byte
+}
+
+// attaches files to a note, all or nothing
+// returns NO_NOTE if the note doesn't exist, and NO_CLOBBER if an attachment
+// already exists with the same name and clobber is false
+func (ds *Datastore) addAttachments(note string, attachments []Attachment, clobber bool) (_ int, err error) {
+	defer ds.metrics.observe("addAttachments", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var exists bool
+	err = tx.QueryRow(`select exists (select 1 from "note" where name = ?)`, ds.key(note)).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return NO_NOTE, nil
+	}
+	status := CREATED
+	now := formatTime(ds.now())
+	for _, attachment := range attachments {
+		_, err = tx.Exec(`insert into "attachment" (note, name, content_type, body, create_time) values (?, ?, ?, ?, ?)`,
+			ds.key(note), attachment.Name, attachment.Type, attachment.body, now)
+		if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			if !clobber {
+				return NO_CLOBBER, nil
+			}
+			status = UPDATED
+			_, err = tx.Exec(`update "attachment" set content_type = ?, body = ?, create_time = ?
+				where note = ? and name = ?`, attachment.Type, attachment.body, now, ds.key(note), attachment.Name)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return status, tx.Commit()
+}
+
+// lists the attachments on a note, without their bodies
+func (ds *Datastore) listAttachments(note string) (_ []Attachment, err error) {
+	defer ds.metrics.observe("listAttachments", time.Now(), &err)
+	attachments := make([]Attachment, 0)
+	rows, err := ds.read().Query(`select name, length(body), content_type from "attachment"
+		where note = ? order by name asc`, ds.key(note))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var attachment Attachment
+		err := rows.Scan(&attachment.Name, &attachment.Size, &attachment.Type)
+		if err != nil {
+			return attachments, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	err = rows.Err()
+	return attachments, err
+}
+
+// gets an attachment, including its body
+func (ds *Datastore) getAttachment(note string, name string) (_ Attachment, _ bool, err error) {
+	defer ds.metrics.observe("getAttachment", time.Now(), &err)
+	attachment := Attachment{Name: name}
+	row := ds.read().QueryRow(`select content_type, body from "attachment" where note = ? and name = ?`, ds.key(note), name)
+	if err := row.Scan(&attachment.Type, &attachment.body); err != nil {
+		if err == sql.ErrNoRows {
+			return attachment, false, nil
+		} else {
+			return attachment, false, err
+		}
+	}
+	attachment.Size = len(attachment.body)
+	return attachment, true, nil
+}
+
+func (ds *Datastore) deleteAttachment(note string, name string) (err error) {
+	defer ds.metrics.observe("deleteAttachment", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "attachment" where note = ? and name = ?`, ds.key(note), name)
+	return err
+}
+
+// Comment is a plain text comment left on a note
+type Comment struct {
+	ID         int64     `json:"id"`
+	Author     string    `json:"author"`
+	Body       string    `json:"body"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+// adds a comment to a note
+// returns NO_NOTE if the note doesn't exist
+func (ds *Datastore) addComment(note string, author string, body string) (_ Comment, _ int, err error) {
+	defer ds.metrics.observe("addComment", time.Now(), &err)
+	now := ds.now()
+	result, err := ds.writer().Exec(`insert into "comment" (note, author, body, create_time) values (?, ?, ?, ?)`,
+		ds.key(note), author, body, formatTime(now))
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		return Comment{}, NO_NOTE, nil
+	}
+	if err != nil {
+		return Comment{}, 0, err
+	}
+	comment := Comment{Author: author, Body: body, CreateTime: now}
+	comment.ID, err = result.LastInsertId()
+	return comment, CREATED, err
+}
+
+// lists the comments on a note, oldest first
+func (ds *Datastore) listComments(note string) (_ []Comment, err error) {
+	defer ds.metrics.observe("listComments", time.Now(), &err)
+	comments := make([]Comment, 0)
+	rows, err := ds.read().Query(`select id, author, body, create_time from "comment"
+		where note = ? order by id asc`, ds.key(note))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(&comment.ID, &comment.Author, &comment.Body, &comment.CreateTime)
+		if err != nil {
+			return comments, err
+		}
+		comments = append(comments, comment)
+	}
+	err = rows.Err()
+	return comments, err
+}
+
+// deletes a comment, if it was written by author
+// returns NO_NOTE if there's no such comment, and MISMATCH if someone else wrote it
+func (ds *Datastore) deleteComment(note string, id int64, author string) (_ int, err error) {
+	defer ds.metrics.observe("deleteComment", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "comment" where note = ? and id = ? and author = ?`,
+		ds.key(note), id, author)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return DELETED, err
+	}
+	var exists bool
+	err = ds.read().QueryRow(`select exists (select 1 from "comment" where note = ? and id = ?)`,
+		ds.key(note), id).Scan(&exists)
+	if err != nil || exists {
+		return MISMATCH, err
+	}
+	return NO_NOTE, nil
+}
+
+// NoteWatch is a callback told when a single note is updated or deleted
+type NoteWatch struct {
+	ID         int64     `json:"id"`
+	Note       string    `json:"note"`
+	URL        string    `json:"url"`
+	CreatedBy  string    `json:"created_by"`
+	CreateTime time.Time `json:"create_time"`
+	// set once deliveries to the url have failed watchMaxAttempts times
+	Disabled bool `json:"disabled"`
+}
+
+// registers a watch on a note
+// returns NO_NOTE if the note doesn't exist
+func (ds *Datastore) addWatch(note string, watchURL string, secret string, createdBy string) (_ NoteWatch, _ int, err error) {
+	defer ds.metrics.observe("addWatch", time.Now(), &err)
+	now := ds.now()
+	result, err := ds.writer().Exec(`insert into "note_watch" (note, url, secret, created_by, create_time) values (?, ?, ?, ?, ?)`,
+		ds.key(note), watchURL, secret, createdBy, formatTime(now))
+	if err != nil && strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		return NoteWatch{}, NO_NOTE, nil
+	}
+	if err != nil {
+		return NoteWatch{}, 0, err
+	}
+	watch := NoteWatch{Note: note, URL: watchURL, CreatedBy: createdBy, CreateTime: now}
+	watch.ID, err = result.LastInsertId()
+	return watch, CREATED, err
+}
+
+// lists the watches on a note, oldest first
+// if createdBy isn't nil, only those it registered
+func (ds *Datastore) listWatches(note string, createdBy *string) (_ []NoteWatch, err error) {
+	defer ds.metrics.observe("listWatches", time.Now(), &err)
+	watches := make([]NoteWatch, 0)
+	query := `select id, url, created_by, create_time, disabled from "note_watch" where note = ?`
+	args := []interface{}{ds.key(note)}
+	if createdBy != nil {
+		query += ` and created_by = ?`
+		args = append(args, *createdBy)
+	}
+	rows, err := ds.read().Query(query+` order by id asc`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		watch := NoteWatch{Note: note}
+		err := rows.Scan(&watch.ID, &watch.URL, &watch.CreatedBy, &watch.CreateTime, &watch.Disabled)
+		if err != nil {
+			return watches, err
+		}
+		watches = append(watches, watch)
+	}
+	err = rows.Err()
+	return watches, err
+}
+
+// unregisters a watch, and drops its deliveries which haven't been made yet
+// if createdBy isn't nil, the watch must have been registered by it
+// returns NO_NOTE if there's no such watch, and MISMATCH if someone else registered it
+func (ds *Datastore) deleteWatch(note string, id int64, createdBy *string) (_ int, err error) {
+	defer ds.metrics.observe("deleteWatch", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var owner string
+	err = tx.QueryRow(`select created_by from "note_watch" where note = ? and id = ?`, ds.key(note), id).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return NO_NOTE, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if createdBy != nil && owner != *createdBy {
+		return MISMATCH, nil
+	}
+	_, err = tx.Exec(`delete from "note_watch" where id = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`delete from "watch_delivery" where watch = ?`, id)
+	if err != nil {
+		return 0, err
+	}
+	return DELETED, tx.Commit()
+}
+
+// a change to a watched note, waiting to be delivered to the watch's url
+type watchDelivery struct {
+	id    int64
+	watch int64
+	// the name the note is stored under
+	note     string
+	url      string
+	secret   string
+	action   string
+	hash     sql.NullString
+	time     time.Time
+	attempts int
+}
+
+// counts the deliveries waiting to be made to watches
+func (ds *Datastore) countWatchDeliveries() (_ int64, err error) {
+	defer ds.metrics.observe("countWatchDeliveries", time.Now(), &err)
+	var count int64
+	err = ds.read().QueryRow(`select count(*) from "watch_delivery"`).Scan(&count)
+	return count, err
+}
+
+// gets the oldest delivery to a watch which is ready to be attempted
+// a delivery is never returned while an older one to the same watch is still queued,
+// so a watch is always told about changes in order
+func (ds *Datastore) nextWatchDelivery() (_ watchDelivery, _ bool, err error) {
+	defer ds.metrics.observe("nextWatchDelivery", time.Now(), &err)
+	row := ds.read().QueryRow(`select id, watch, note, url, secret, action, hash, event_time, attempts from "watch_delivery" d
+		where next_attempt <= ?
+		and not exists (select 1 from "watch_delivery" e where e.watch = d.watch and e.id < d.id)
+		order by id asc limit 1`, formatTime(ds.now()))
+	delivery := watchDelivery{}
+	err = row.Scan(&delivery.id, &delivery.watch, &delivery.note, &delivery.url, &delivery.secret,
+		&delivery.action, &delivery.hash, &delivery.time, &delivery.attempts)
+	if err == sql.ErrNoRows {
+		return delivery, false, nil
+	}
+	return delivery, err == nil, err
+}
+
+// removes a delivery from the queue once it has been made
+func (ds *Datastore) finishWatchDelivery(id int64) (err error) {
+	defer ds.metrics.observe("finishWatchDelivery", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "watch_delivery" where id = ?`, id)
+	return err
+}
+
+// records a failed delivery and schedules the next attempt after `delay`
+func (ds *Datastore) retryWatchDelivery(id int64, delay time.Duration) (err error) {
+	defer ds.metrics.observe("retryWatchDelivery", time.Now(), &err)
+	_, err = ds.writer().Exec(`update "watch_delivery"
+		set attempts = attempts + 1, next_attempt = ?
+		where id = ?`, formatTime(ds.now().Add(delay)), id)
+	return err
+}
+
+// stops delivering to a watch, dropping the deliveries to it which haven't been made yet
+// the watch is kept, disabled, so whoever registered it can see why it went quiet
+func (ds *Datastore) disableWatch(id int64) (err error) {
+	defer ds.metrics.observe("disableWatch", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`update "note_watch" set disabled = 1 where id = ?`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`delete from "watch_delivery" where watch = ?`, id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// a view of a note, waiting to be recorded
+type viewEvent struct {
+	// the name the note is stored under
+	note string
+	// the network the viewer's address is in, like 203.0.113.0/24
+	client string
+	time   time.Time
+}
+
+// records views of notes, all or nothing
+// views of notes which have been deleted since are skipped
+func (ds *Datastore) addViews(views []viewEvent) (err error) {
+	defer ds.metrics.observe("addViews", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, view := range views {
+		result, err := tx.Exec(`update "note" set views = views + 1 where name = ?`, view.note)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			continue
+		}
+		_, err = tx.Exec(`insert into "view_event" (note, client, view_time) values (?, ?, ?)`,
+			view.note, view.client, formatTime(view.time))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NoteStats describes how often a note has been viewed, for GET /api/note/:note/stats
+type NoteStats struct {
+	// every view since views were first recorded, including views which have been pruned
+	TotalViews int64 `json:"total_views"`
+	// views on each day, in UTC, oldest first
+	Days []DayViews `json:"days"`
+	// the networks of the most recent distinct viewers, most recent first
+	RecentViewers []string `json:"recent_viewers"`
+}
+
+// DayViews is the number of views of a note on a day
+type DayViews struct {
+	Date  string `json:"date"`
+	Views int64  `json:"views"`
+}
+
+// gets a note's views, including its views on each of the last `days` days and its last `viewers` distinct viewers
+func (ds *Datastore) getNoteStats(name string, days int, viewers int) (_ NoteStats, _ bool, err error) {
+	defer ds.metrics.observe("getNoteStats", time.Now(), &err)
+	stats := NoteStats{Days: make([]DayViews, 0, days), RecentViewers: make([]string, 0)}
+	tx, err := ds.beginRead()
+	if err != nil {
+		return stats, false, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`select views from "note" where name = ?`, ds.key(name)).Scan(&stats.TotalViews)
+	if err == sql.ErrNoRows {
+		return stats, false, nil
+	}
+	if err != nil {
+		return stats, false, err
+	}
+	// days start at midnight UTC, and times are stored in UTC, so a time's date is its first 10 characters
+	first := ds.now().Truncate(24*time.Hour).AddDate(0, 0, 1-days)
+	rows, err := tx.Query(`select substr(view_time, 1, 10), count(*) from "view_event"
+		where note = ? and view_time >= ? group by 1`, ds.key(name), formatTime(first))
+	if err != nil {
+		return stats, false, err
+	}
+	perDay := make(map[string]int64)
+	for rows.Next() {
+		var date string
+		var views int64
+		if err := rows.Scan(&date, &views); err != nil {
+			rows.Close()
+			return stats, false, err
+		}
+		perDay[date] = views
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return stats, false, err
+	}
+	for day := 0; day < days; day++ {
+		date := first.AddDate(0, 0, day).Format("2006-01-02")
+		stats.Days = append(stats.Days, DayViews{Date: date, Views: perDay[date]})
+	}
+	rows, err = tx.Query(`select client from "view_event" where note = ?
+		group by client order by max(id) desc limit ?`, ds.key(name), viewers)
+	if err != nil {
+		return stats, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var client string
+		if err := rows.Scan(&client); err != nil {
+			return stats, false, err
+		}
+		stats.RecentViewers = append(stats.RecentViewers, client)
+	}
+	return stats, true, rows.Err()
+}
+
+// gets how many times each of the named notes has been viewed
+// notes which don't exist are left out
+func (ds *Datastore) getViewCounts(names []string) (_ map[string]int64, err error) {
+	defer ds.metrics.observe("getViewCounts", time.Now(), &err)
+	counts := make(map[string]int64)
+	if len(names) == 0 {
+		return counts, nil
+	}
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		args[i] = ds.key(name)
+	}
+	rows, err := ds.read().Query(`select name, views from "note"
+		where name in (?`+strings.Repeat(`, ?`, len(names)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var views int64
+		if err := rows.Scan(&name, &views); err != nil {
+			return nil, err
+		}
+		counts[ds.unkey(name)] = views
+	}
+	return counts, rows.Err()
+}
+
+// deletes views older than age, returning how many were deleted
+func (ds *Datastore) pruneViews(age time.Duration) (_ int64, err error) {
+	defer ds.metrics.observe("pruneViews", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "view_event" where view_time < ?`, formatTime(ds.now().Add(-age)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// IdempotentPost is what a POST with an X-Idempotency-Key did, so a retry can do the same
+type IdempotentPost struct {
+	// of the note the POST created
+	Hash string
+	// the note it had the same contents as, if any
+	DuplicateOf string
+}
+
+// records that a POST with an idempotency key created a note
+func (ds *Datastore) saveIdempotencyKey(name string, key string, post IdempotentPost) (err error) {
+	defer ds.metrics.observe("saveIdempotencyKey", time.Now(), &err)
+	_, err = ds.writer().Exec(`insert or ignore into "idempotency_key" (note, key, hash, duplicate_of, create_time)
+		values (?, ?, ?, ?, ?)`, ds.key(name), key, post.Hash, post.DuplicateOf, formatTime(ds.now()))
+	return err
+}
+
+// gets what a POST with an idempotency key did, if it created a note within age
+// if age is zero, keys never expire
+func (ds *Datastore) getIdempotencyKey(name string, key string, age time.Duration) (_ IdempotentPost, _ bool, err error) {
+	defer ds.metrics.observe("getIdempotencyKey", time.Now(), &err)
+	var post IdempotentPost
+	since := ""
+	if age != 0 {
+		since = formatTime(ds.now().Add(-age))
+	}
+	err = ds.read().QueryRow(`select hash, duplicate_of from "idempotency_key" where note = ? and key = ? and create_time >= ?`,
+		ds.key(name), key, since).Scan(&post.Hash, &post.DuplicateOf)
+	if err == sql.ErrNoRows {
+		return post, false, nil
+	}
+	return post, err == nil, err
+}
+
+// deletes idempotency keys older than age, returning how many were deleted
+func (ds *Datastore) pruneIdempotencyKeys(age time.Duration) (_ int64, err error) {
+	defer ds.metrics.observe("pruneIdempotencyKeys", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "idempotency_key" where create_time < ?`, formatTime(ds.now().Add(-age)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// records that the form with a one-time token was submitted, creating or commenting on note
+// if it already was, returns the note it was submitted for and false, and records nothing
+func (ds *Datastore) claimFormToken(token string, note string) (_ string, _ bool, err error) {
+	defer ds.metrics.observe("claimFormToken", time.Now(), &err)
+	result, err := ds.writer().Exec(`insert or ignore into "form_submission" (token, note, submit_time) values (?, ?, ?)`,
+		token, ds.key(note), formatTime(ds.now()))
+	if err != nil {
+		return "", false, err
+	}
+	claimed, err := result.RowsAffected()
+	if err != nil || claimed > 0 {
+		return note, err == nil, err
+	}
+	var submitted string
+	err = ds.writer().QueryRow(`select note from "form_submission" where token = ?`, token).Scan(&submitted)
+	return ds.unkey(submitted), false, err
+}
+
+// forgets that the form with a one-time token was submitted, since it failed, so it can be submitted again
+func (ds *Datastore) releaseFormToken(token string) (err error) {
+	defer ds.metrics.observe("releaseFormToken", time.Now(), &err)
+	_, err = ds.writer().Exec(`delete from "form_submission" where token = ?`, token)
+	return err
+}
+
+// deletes the tokens of forms submitted longer ago than age
+func (ds *Datastore) pruneFormTokens(age time.Duration) (_ int64, err error) {
+	defer ds.metrics.observe("pruneFormTokens", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "form_submission" where submit_time < ?`, formatTime(ds.now().Add(-age)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Snapshot is a frozen copy of a note's contents, served at /snap/:hash
+type Snapshot struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+	// "" if the note was plain text
+	ContentType string `json:"content_type,omitempty"`
+	// when the note was snapshotted with these contents
+	CreateTime time.Time `json:"create_time"`
+	body       []byte
+}
+
+// freezes a note's current contents as a snapshot
+// returns NO_NOTE if the note doesn't exist, NO_CLOBBER if it was already snapshotted with the same contents,
+// and CREATED otherwise
+func (ds *Datastore) snapshotNote(name string) (_ Snapshot, _ int, err error) {
+	defer ds.metrics.observe("snapshotNote", time.Now(), &err)
+	var snapshot Snapshot
+	tx, err := ds.begin()
+	if err != nil {
+		return snapshot, 0, err
+	}
+	defer tx.Rollback()
+	err = tx.QueryRow(`select coalesce("blob".body, "note".body), content_type from "note"
+		left join "blob" on "blob".hash = "note".blob_hash where name = ?`, ds.key(name)).Scan(&snapshot.body, &snapshot.ContentType)
+	if err == sql.ErrNoRows {
+		return snapshot, NO_NOTE, nil
+	}
+	if err != nil {
+		return snapshot, 0, err
+	}
+	snapshot.Hash = hashBody(snapshot.body)
+	snapshot.Size = len(snapshot.body)
+	snapshot.CreateTime = ds.now().UTC().Truncate(time.Second)
+	// the same contents snapshotted before, from this note or another, keep their first content type
+	_, err = tx.Exec(`insert or ignore into "snapshot" (hash, body, content_type, create_time) values (?, ?, ?, ?)`,
+		snapshot.Hash, snapshot.body, snapshot.ContentType, formatTime(snapshot.CreateTime))
+	if err != nil {
+		return snapshot, 0, err
+	}
+	result, err := tx.Exec(`insert or ignore into "note_snapshot" (note, hash, create_time) values (?, ?, ?)`,
+		ds.key(name), snapshot.Hash, formatTime(snapshot.CreateTime))
+	if err != nil {
+		return snapshot, 0, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return snapshot, 0, err
+	}
+	if added == 0 {
+		err = tx.QueryRow(`select create_time from "note_snapshot" where note = ? and hash = ?`,
+			ds.key(name), snapshot.Hash).Scan(&snapshot.CreateTime)
+		return snapshot, NO_CLOBBER, err
+	}
+	return snapshot, CREATED, tx.Commit()
+}
+
+// lists the snapshots of a note, newest first, without their bodies
+// they're listed even if the note has since been deleted
+func (ds *Datastore) listSnapshots(name string) (_ []Snapshot, err error) {
+	defer ds.metrics.observe("listSnapshots", time.Now(), &err)
+	snapshots := make([]Snapshot, 0)
+	rows, err := ds.read().Query(`select "snapshot".hash, length("snapshot".body), "snapshot".content_type, "note_snapshot".create_time
+		from "note_snapshot" join "snapshot" on "snapshot".hash = "note_snapshot".hash
+		where note = ? order by "note_snapshot".create_time desc, "snapshot".hash asc`, ds.key(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var snapshot Snapshot
+		err := rows.Scan(&snapshot.Hash, &snapshot.Size, &snapshot.ContentType, &snapshot.CreateTime)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+// gets a snapshot, including its body
+func (ds *Datastore) getSnapshot(hash string) (_ Snapshot, _ bool, err error) {
+	defer ds.metrics.observe("getSnapshot", time.Now(), &err)
+	snapshot := Snapshot{Hash: hash}
+	err = ds.read().QueryRow(`select body, content_type, create_time from "snapshot" where hash = ?`, hash).
+		Scan(&snapshot.body, &snapshot.ContentType, &snapshot.CreateTime)
+	if err == sql.ErrNoRows {
+		return snapshot, false, nil
+	}
+	snapshot.Size = len(snapshot.body)
+	return snapshot, err == nil, err
+}
+
+// deletes a snapshot, whichever notes it was taken of, returning whether it existed
+func (ds *Datastore) deleteSnapshot(hash string) (_ bool, err error) {
+	defer ds.metrics.observe("deleteSnapshot", time.Now(), &err)
+	result, err := ds.writer().Exec(`delete from "snapshot" where hash = ?`, hash)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// deletes the snapshots of notes which have been deleted, keeping those still belonging to a note,
+// and returns how many snapshots were deleted
+func (ds *Datastore) pruneOrphanedSnapshots() (_ int64, err error) {
+	defer ds.metrics.observe("pruneOrphanedSnapshots", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`delete from "note_snapshot" where not exists (select 1 from "note" where name = "note_snapshot".note)`)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec(`delete from "snapshot" where not exists (select 1 from "note_snapshot" where hash = "snapshot".hash)`)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// LinkCheck is the last check of a link in a note
+type LinkCheck struct {
+	URL string `json:"url"`
+	// the status of the response, or 0 if there wasn't one
+	Status int `json:"status"`
+	// why there was no response, or why the link is broken
+	Error     string    `json:"error,omitempty"`
+	Broken    bool      `json:"broken"`
+	CheckTime time.Time `json:"check_time"`
+}
+
+// gets the names of the notes whose links may be checked: those no larger than maxSize,
+// whose content types are text; their bodies may still not be
+func (ds *Datastore) linkCheckNotes(maxSize int) (_ []string, err error) {
+	defer ds.metrics.observe("linkCheckNotes", time.Now(), &err)
+	names := make([]string, 0)
+	rows, err := ds.read().Query(`select name, content_type from "note" indexed by note_listing_name where size <= ?`, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, contentType string
+		err := rows.Scan(&name, &contentType)
+		if err != nil {
+			return names, err
+		}
+		if textContentType(contentType) {
+			names = append(names, name)
+		}
+	}
+	return names, rows.Err()
+}
+
+// replaces the checks of a note's links, unless it's been deleted since they were made
+func (ds *Datastore) saveLinkChecks(name string, checks []LinkCheck) (err error) {
+	defer ds.metrics.observe("saveLinkChecks", time.Now(), &err)
+	tx, err := ds.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`delete from "link_check" where note = ?`, ds.key(name))
+	if err != nil {
+		return err
+	}
+	for _, check := range checks {
+		_, err = tx.Exec(`insert into "link_check" (note, url, status, error, check_time)
+			select ?1, ?2, ?3, ?4, ?5 where exists (select 1 from "note" where name = ?1)`,
+			ds.key(name), check.URL, check.Status, check.Error, formatTime(check.CheckTime))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// gets the last checks of a note's links, in order of their urls
+func (ds *Datastore) listLinkChecks(name string) (_ []LinkCheck, err error) {
+	defer ds.metrics.observe("listLinkChecks", time.Now(), &err)
+	checks := make([]LinkCheck, 0)
+	rows, err := ds.read().Query(`select url, status, error, check_time from "link_check" where note = ? order by url`, ds.key(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var check LinkCheck
+		err := rows.Scan(&check.URL, &check.Status, &check.Error, &check.CheckTime)
+		if err != nil {
+			return checks, err
+		}
+		check.Broken = linkBroken(check.Status)
+		checks = append(checks, check)
+	}
+	return checks, rows.Err()
+}
+
+// gets when links were last checked, or the zero time if they never have been
+func (ds *Datastore) lastLinkCheck() (_ time.Time, err error) {
+	defer ds.metrics.observe("lastLinkCheck", time.Now(), &err)
+	var last sql.NullString
+	err = ds.read().QueryRow(`select max(check_time) from "link_check"`).Scan(&last)
+	if err != nil || !last.Valid {
+		return time.Time{}, err
+	}
+	return time.Parse(timeFormat, last.String)
+}
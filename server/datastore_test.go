@@ -0,0 +1,336 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseMigrationName(t *testing.T) {
+	cases := []struct {
+		filename string
+		want     migration
+		ok       bool
+	}{
+		{"2026-10-17.3.sql", migration{"2026-10-17", 3}, true},
+		{"2026-10-17.10.sql", migration{"2026-10-17", 10}, true},
+		{"2026-10-17.03.sql", migration{"2026-10-17", 3}, true},
+		{"2026-10-17.sql", migration{}, false},
+		{"2026-10-17.3.sql.bak", migration{}, false},
+		{"2026-10-17.-3.sql", migration{}, false},
+		{"2026-10-17.99999999999.sql", migration{}, false},
+		{"26-10-17.3.sql", migration{}, false},
+		{"README.md", migration{}, false},
+	}
+	for _, c := range cases {
+		got, err := parseMigrationName(c.filename)
+		if (err == nil) != c.ok || got != c.want {
+			t.Errorf("parseMigrationName(%q) = %v, %v; want %v, ok %v", c.filename, got, err, c.want, c.ok)
+		}
+	}
+}
+
+// the migrations built into corkboard are all found, in order
+func TestFindEmbeddedMigrations(t *testing.T) {
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := findMigrations(migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("found no migrations")
+	}
+	for i := 1; i < len(files); i++ {
+		if !files[i-1].before(files[i].migration) {
+			t.Fatalf("migration %v comes before %v", files[i-1], files[i])
+		}
+	}
+}
+
+// a migration's name parses back to the same migration
+func FuzzParseMigrationName(f *testing.F) {
+	f.Add("2026-10-17.3.sql")
+	f.Add("2026-10-17.0010.sql")
+	f.Add("2026-10-17.2147483648.sql")
+	f.Add("2026-10-17.3.sql\n")
+	f.Add("٢٠٢٦-10-17.3.sql")
+	f.Fuzz(func(t *testing.T, filename string) {
+		m, err := parseMigrationName(filename)
+		if err != nil {
+			return
+		}
+		if m.number < 0 {
+			t.Fatalf("%q parsed as negative migration %v", filename, m)
+		}
+		again, err := parseMigrationName(m.String() + ".sql")
+		if err != nil || again != m {
+			t.Fatalf("%q parsed as %v, whose name parses as %v, %v", filename, m, again, err)
+		}
+	})
+}
+
+// what the triggers write is stamped with the datastore's clock, not sqlite's
+func TestChangeTimesFollowTheClock(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	// far enough from the real time that sqlite's couldn't be taken for it
+	clock.Advance(1000 * time.Hour)
+	if _, err := datastore.setNote("todo", []byte("one"), false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := datastore.setNote("todo", []byte("two"), true); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if err := datastore.deleteNote("todo"); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, _, err := datastore.listChanges(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"create", "update", "delete"}
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, change := range changes {
+		wantTime := testEpoch.Add(time.Duration(1000+i) * time.Hour)
+		if change.Action != want[i] || !change.Time.Equal(wantTime) {
+			t.Errorf("change %d: got %s at %s, want %s at %s", i, change.Action, change.Time, want[i], wantTime)
+		}
+	}
+}
+
+// rebuilding the change log keeps its times, and the cursor after the last change, even once it's been trimmed
+func TestChangeLogRebuild(t *testing.T) {
+	datastore, clock := testDatastoreThrough(t, testConfig(t), "2026-10-17.27.sql")
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := datastore.setNote(name, []byte("body"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, _, err := datastore.listChanges(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := datastore.writer().Exec(`delete from "change_log" where id > 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := datastore.RunMigrations(testMigrations(t, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, oldest, err := datastore.listChanges(0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != before[0] || oldest != 1 {
+		t.Fatalf("got %v from %d, want %v from 1", changes, oldest, before[:1])
+	}
+	if _, err := datastore.setNote("d", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+	changes, _, err = datastore.listChanges(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Cursor != 4 || !changes[0].Time.Equal(testEpoch.Add(time.Hour)) {
+		t.Fatalf("after the rebuild, got %v, want d's creation at cursor 4 and %s", changes, testEpoch.Add(time.Hour))
+	}
+}
+
+func TestModifyTimeFollowsTheClock(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	clock.Advance(1000 * time.Hour)
+	if _, err := datastore.setNote("todo", []byte("one"), false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, err := datastore.setNote("todo", []byte("two"), true); err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := datastore.getNoteInfo("todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testEpoch.Add(1001 * time.Hour); !info.ModifyTime.Equal(want) {
+		t.Errorf("modified at %s, want %s", info.ModifyTime, want)
+	}
+}
+
+// a delivery is queued at the datastore's time, and is due at once by the same clock
+func TestWatchDeliveryTimesFollowTheClock(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	if _, err := datastore.setNote("todo", []byte("one"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := datastore.addWatch("todo", "http://example.com/hook", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	// before the real time, so a delivery sqlite stamped wouldn't be due yet
+	clock.Advance(-1000 * time.Hour)
+	if _, err := datastore.setNote("todo", []byte("two"), true); err != nil {
+		t.Fatal(err)
+	}
+	delivery, ok, err := datastore.nextWatchDelivery()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("the delivery isn't due")
+	}
+	if want := testEpoch.Add(-1000 * time.Hour); !delivery.time.Equal(want) {
+		t.Errorf("queued at %s, want %s", delivery.time, want)
+	}
+}
+
+// each kind of change to a note's page moves its page time to the datastore's time
+func TestPageTimeFollowsTheClock(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	if _, err := datastore.setNote("todo", []byte("one"), false); err != nil {
+		t.Fatal(err)
+	}
+	changes := []struct {
+		name   string
+		change func() error
+	}{
+		{"body", func() error { _, err := datastore.setNote("todo", []byte("two"), true); return err }},
+		{"title", func() error { _, err := datastore.setNoteTitle("todo", "Todo"); return err }},
+		{"comment", func() error { _, _, err := datastore.addComment("todo", "alice", "hi"); return err }},
+	}
+	for i, c := range changes {
+		clock.Advance(1000 * time.Hour)
+		if err := c.change(); err != nil {
+			t.Fatal(err)
+		}
+		modified, _, err := datastore.getPageTime("todo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := testEpoch.Add(time.Duration(i+1) * 1000 * time.Hour); !modified.Equal(want) {
+			t.Errorf("after a change to its %s, the page was modified at %s, want %s", c.name, modified, want)
+		}
+	}
+}
+
+func TestIncrementNote(t *testing.T) {
+	cases := []struct {
+		body   string
+		by     int64
+		want   int64
+		status int
+	}{
+		{"41", 1, 42, UPDATED},
+		{" 7\n", 1, 8, UPDATED},
+		{"+5", 1, 6, UPDATED},
+		{"-3", 1, -2, UPDATED},
+		{"007", 1, 8, UPDATED},
+		{"10", -20, -10, UPDATED},
+		{"9223372036854775806", 1, 9223372036854775807, UPDATED},
+		{"-9223372036854775808", 1, -9223372036854775807, UPDATED},
+		{"9223372036854775807", 1, 0, NOT_A_NUMBER},
+		{"-9223372036854775808", -1, 0, NOT_A_NUMBER},
+		{"9223372036854775808", 0, 0, NOT_A_NUMBER},
+		{"99999999999999999999", 0, 0, NOT_A_NUMBER},
+		{"abc", 1, 0, NOT_A_NUMBER},
+		{"12abc", 1, 0, NOT_A_NUMBER},
+		{"1.5", 1, 0, NOT_A_NUMBER},
+		{"--5", 1, 0, NOT_A_NUMBER},
+		{"+", 1, 0, NOT_A_NUMBER},
+		{"", 1, 0, NOT_A_NUMBER},
+	}
+	for _, dedupe := range []bool{false, true} {
+		config := testConfig(t)
+		config.Dedupe = dedupe
+		datastore, _ := testDatastore(t, config)
+		for _, c := range cases {
+			name := fmt.Sprintf("counter %q %d", c.body, c.by)
+			if _, err := datastore.setNote(name, []byte(c.body), false); err != nil {
+				t.Fatal(err)
+			}
+			value, status, err := datastore.incrementNote(name, c.by, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != c.want || status != c.status {
+				t.Errorf("dedupe %v, adding %d to %q: got %d, status %d; want %d, status %d", dedupe, c.by, c.body, value, status, c.want, c.status)
+			}
+			body, _, err := datastore.getNote(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash, _, err := datastore.getNoteHash(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != hashBody(body) {
+				t.Errorf("dedupe %v, adding %d to %q: the hash isn't the body's", dedupe, c.by, c.body)
+			}
+			if c.status == UPDATED && string(body) != strconv.FormatInt(c.want, 10) {
+				t.Errorf("dedupe %v, adding %d to %q: the note says %q", dedupe, c.by, c.body, body)
+			}
+		}
+	}
+}
+
+// no increment is lost to another made at the same time
+func TestIncrementNoteConcurrently(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t))
+	const workers, each = 8, 25
+	var wait sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < each; j++ {
+				if _, _, err := datastore.incrementNote("counter", 1, true); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wait.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	body, _, err := datastore.getNote("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != strconv.Itoa(workers*each) {
+		t.Errorf("the counter says %q, want %d", body, workers*each)
+	}
+}
+
+// a write hook which refuses the new value leaves the note as it was
+func TestIncrementNoteRejected(t *testing.T) {
+	config := testConfig(t, "-max-note-size", "2")
+	datastore, _ := testDatastore(t, config)
+	if _, err := datastore.setNote("counter", []byte("99"), false); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := datastore.incrementNote("counter", 1, false)
+	var rejection WriteRejection
+	if !errors.As(err, &rejection) {
+		t.Fatalf("got %v, want a rejection", err)
+	}
+	body, _, err := datastore.getNote("counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "99" {
+		t.Errorf("the counter says %q, want 99", body)
+	}
+}
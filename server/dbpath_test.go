@@ -0,0 +1,108 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckDatabasePath(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.db")
+	if err := os.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		path   string
+		create bool
+		// what the error should contain, or "" for none
+		want string
+	}{
+		{"missing directory", filepath.Join(dir, "missing", "notes.db"), true, "its directory is missing"},
+		{"a file for a directory", filepath.Join(existing, "notes.db"), true, "isn't a directory"},
+		{"missing, without -create-db", filepath.Join(dir, "new.db"), false, "-create-db"},
+		{"missing, with -create-db", filepath.Join(dir, "new.db"), true, ""},
+		{"existing", existing, false, ""},
+		{"a directory", dir, false, "it's a directory"},
+	}
+	for _, c := range cases {
+		err := checkDatabasePath(c.path, c.create)
+		switch {
+		case c.want == "" && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.want != "" && (err == nil || !strings.Contains(err.Error(), c.want)):
+			t.Errorf("%s: got %v, want an error saying %q", c.name, err, c.want)
+		}
+	}
+}
+
+// permissions don't stop root, or apply on windows, so these only run as anyone else
+func TestCheckDatabasePathReadOnly(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions aren't enforced")
+	}
+	dir := t.TempDir()
+	readOnlyFile := filepath.Join(dir, "read-only.db")
+	if err := os.WriteFile(readOnlyFile, nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkDatabasePath(readOnlyFile, false); err == nil || !strings.Contains(err.Error(), "it can't be written") {
+		t.Errorf("a read-only database: got %v", err)
+	}
+
+	readOnlyDir := filepath.Join(dir, "read-only")
+	if err := os.Mkdir(readOnlyDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(readOnlyDir, "notes.db"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(readOnlyDir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(readOnlyDir, 0755) })
+	err := checkDatabasePath(filepath.Join(readOnlyDir, "notes.db"), false)
+	if err == nil || !strings.Contains(err.Error(), "its directory can't be written") {
+		t.Errorf("a database in a read-only directory: got %v", err)
+	}
+}
+
+// with -db-wait, a database whose directory appears while waiting is opened, as if a network filesystem was mounted
+func TestWaitForDatabase(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mount")
+	path := filepath.Join(dir, "notes.db")
+	clock := &sharedClock{clock: realClock{}}
+	if _, _, err := waitForDatabase(path, true, 0, clock); err == nil {
+		t.Fatal("opened a database in a missing directory without waiting")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Mkdir(dir, 0755)
+	}()
+	start := time.Now()
+	writer, reader, err := waitForDatabase(path, true, 30*time.Second, clock)
+	if err != nil {
+		t.Fatalf("the directory appeared while waiting, but: %v", err)
+	}
+	writer.Close()
+	reader.Close()
+	if waited := time.Since(start); waited > 10*time.Second {
+		t.Errorf("waited %s, long after the directory appeared", waited)
+	}
+}
+
+// a database which can't be opened fails NewApp, naming the database and why
+func TestNewAppBadDatabase(t *testing.T) {
+	config := testConfig(t)
+	config.DatabasePath = filepath.Join(t.TempDir(), "missing", "notes.db")
+	config.CreateDB = true
+	_, err := NewApp(config)
+	if err == nil || !strings.Contains(err.Error(), config.DatabasePath) || !strings.Contains(err.Error(), "its directory is missing") {
+		t.Errorf("got %v, want an error naming the database", err)
+	}
+}
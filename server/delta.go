@@ -0,0 +1,444 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// deltas are in librsync's format, so "rdiff patch" applies the same ones the server does
+// a client builds one against the signature of the note's current body, which sends only the checksums of its blocks;
+// see SignNote and PatchNote
+
+// the first four bytes of a delta
+const deltaMagic = 0x72730236
+
+// delta commands
+// literals of 1 to 64 bytes are the commands 0x01 to 0x40, with their length as the command
+const (
+	deltaEnd = 0x00
+	// literals with their length in the next 1, 2, 4 or 8 bytes are 0x41 to 0x44
+	deltaLiteralN1 = 0x41
+	// copies are 0x45 to 0x54, by the widths of their offset then their length
+	deltaCopyN1N1 = 0x45
+	deltaCopyN8N8 = 0x54
+)
+
+// the widths integers in delta commands may have, in bytes
+var deltaIntWidths = []int{1, 2, 4, 8}
+
+// largest body a delta may patch a note into
+// copies may repeat the note any number of times, so a small delta could otherwise make an enormous one
+const maxPatchedSize = 64 << 20
+
+// room in a delta for its commands, besides the literal bytes of the body it patches a note into
+const deltaOverhead = 1 << 10
+
+// the largest delta which may be sent to a note, given the largest note which may be written, or zero if there's no limit;
+// a delta larger than the note it makes is only ever literals and commands, so it needn't be larger than that note
+func maxDeltaSize(maxNoteSize int64) int64 {
+	if maxNoteSize > 0 && maxNoteSize < maxPatchedSize {
+		return maxNoteSize + deltaOverhead
+	}
+	return maxPatchedSize + deltaOverhead
+}
+
+// the bytes of each block in a signature: its weak checksum, then the start of its sha-256
+const (
+	weakSumSize   = 4
+	strongSumSize = 8
+	blockSumSize  = weakSumSize + strongSumSize
+)
+
+// NoteSignature is the json response to a signature request: the checksums of each whole block of a note,
+// which a client builds a delta against
+type NoteSignature struct {
+	Hash      string `json:"hash"`
+	Size      int    `json:"size"`
+	BlockSize int    `json:"block_size"`
+	// blockSumSize bytes for each block, base64-encoded
+	Blocks []byte `json:"blocks"`
+}
+
+// the size of the blocks a note of size bytes is signed in
+// like rsync, about the square root of its size, so larger notes don't need many more checksums
+func signatureBlockSize(size int) int {
+	blockSize := 512
+	for blockSize*blockSize < size && blockSize < 64<<10 {
+		blockSize *= 2
+	}
+	return blockSize
+}
+
+// the signature of a note's body
+// a partial block at the end is left out, since a delta can't copy it anywhere but the end
+func signBody(body []byte) NoteSignature {
+	blockSize := signatureBlockSize(len(body))
+	blocks := make([]byte, 0, len(body)/blockSize*blockSumSize)
+	for start := 0; start+blockSize <= len(body); start += blockSize {
+		block := body[start : start+blockSize]
+		var weak [weakSumSize]byte
+		binary.BigEndian.PutUint32(weak[:], newRollsum(block).digest())
+		strong := sha256.Sum256(block)
+		blocks = append(append(blocks, weak[:]...), strong[:strongSumSize]...)
+	}
+	return NoteSignature{Hash: hashBody(body), Size: len(body), BlockSize: blockSize, Blocks: blocks}
+}
+
+// rsync's rolling checksum of a block, which is quick to move along by a byte
+type rollsum struct {
+	a, b   uint32
+	length uint32
+}
+
+func newRollsum(block []byte) rollsum {
+	sum := rollsum{length: uint32(len(block))}
+	for i, c := range block {
+		sum.a += uint32(c)
+		sum.b += uint32(len(block)-i) * uint32(c)
+	}
+	return sum
+}
+
+// moves the block along by a byte, dropping out and taking in in
+func (sum *rollsum) roll(out byte, in byte) {
+	sum.a += uint32(in) - uint32(out)
+	sum.b += sum.a - sum.length*uint32(out)
+}
+
+func (sum rollsum) digest() uint32 {
+	return sum.a&0xffff | sum.b<<16
+}
+
+// builds a delta which turns the note signed by signature into body
+func makeDelta(signature NoteSignature, body []byte) ([]byte, error) {
+	blockSize := signature.BlockSize
+	if blockSize <= 0 || len(signature.Blocks)%blockSumSize != 0 {
+		return nil, errors.New("the signature is malformed")
+	}
+	// the blocks with each weak checksum
+	blocks := make(map[uint32][]int)
+	for i := 0; i < len(signature.Blocks)/blockSumSize; i++ {
+		weak := binary.BigEndian.Uint32(signature.Blocks[i*blockSumSize:])
+		blocks[weak] = append(blocks[weak], i)
+	}
+	delta := newDeltaWriter()
+	literalStart := 0
+	var sum rollsum
+	if len(body) >= blockSize {
+		sum = newRollsum(body[:blockSize])
+	}
+	for i := 0; i+blockSize <= len(body); {
+		if block, ok := findBlock(signature, blocks[sum.digest()], body[i:i+blockSize]); ok {
+			delta.literal(body[literalStart:i])
+			delta.copy(uint64(block*blockSize), uint64(blockSize))
+			i += blockSize
+			literalStart = i
+			if i+blockSize <= len(body) {
+				sum = newRollsum(body[i : i+blockSize])
+			}
+			continue
+		}
+		if i+blockSize < len(body) {
+			sum.roll(body[i], body[i+blockSize])
+		}
+		i += 1
+	}
+	delta.literal(body[literalStart:])
+	return delta.finish(), nil
+}
+
+// which of the candidate blocks of a signature, whose weak checksums match, is the same as block
+func findBlock(signature NoteSignature, candidates []int, block []byte) (int, bool) {
+	if len(candidates) == 0 {
+		return 0, false
+	}
+	strong := sha256.Sum256(block)
+	for _, candidate := range candidates {
+		sums := signature.Blocks[candidate*blockSumSize : (candidate+1)*blockSumSize]
+		if bytes.Equal(sums[weakSumSize:], strong[:strongSumSize]) {
+			return candidate, true
+		}
+	}
+	return 0, false
+}
+
+// writes a delta, joining adjacent copies into one
+type deltaWriter struct {
+	buf bytes.Buffer
+	// a copy which hasn't been written yet, in case the next one continues it
+	copyOffset, copyLength uint64
+}
+
+func newDeltaWriter() *deltaWriter {
+	writer := &deltaWriter{}
+	writer.writeInt(deltaMagic, intWidth(deltaMagic))
+	return writer
+}
+
+func (w *deltaWriter) literal(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.flushCopy()
+	if len(data) < deltaLiteralN1 {
+		w.buf.WriteByte(byte(len(data)))
+	} else {
+		width := intWidth(uint64(len(data)))
+		w.buf.WriteByte(byte(deltaLiteralN1 + width))
+		w.writeInt(uint64(len(data)), width)
+	}
+	w.buf.Write(data)
+}
+
+func (w *deltaWriter) copy(offset uint64, length uint64) {
+	if w.copyLength > 0 && w.copyOffset+w.copyLength == offset {
+		w.copyLength += length
+		return
+	}
+	w.flushCopy()
+	w.copyOffset, w.copyLength = offset, length
+}
+
+func (w *deltaWriter) flushCopy() {
+	if w.copyLength == 0 {
+		return
+	}
+	offsetWidth, lengthWidth := intWidth(w.copyOffset), intWidth(w.copyLength)
+	w.buf.WriteByte(byte(deltaCopyN1N1 + offsetWidth*len(deltaIntWidths) + lengthWidth))
+	w.writeInt(w.copyOffset, offsetWidth)
+	w.writeInt(w.copyLength, lengthWidth)
+	w.copyLength = 0
+}
+
+// the delta, ended
+func (w *deltaWriter) finish() []byte {
+	w.flushCopy()
+	w.buf.WriteByte(deltaEnd)
+	return w.buf.Bytes()
+}
+
+// writes n big-endian in the width deltaIntWidths[width]
+func (w *deltaWriter) writeInt(n uint64, width int) {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], n)
+	w.buf.Write(encoded[8-deltaIntWidths[width]:])
+}
+
+// the index in deltaIntWidths of the narrowest width n fits in
+func intWidth(n uint64) int {
+	for i, width := range deltaIntWidths[:len(deltaIntWidths)-1] {
+		if n < 1<<(8*width) {
+			return i
+		}
+	}
+	return len(deltaIntWidths) - 1
+}
+
+// returned when a delta ends in the middle of a command
+var errDeltaTruncated = errors.New("the delta is truncated")
+
+// applies a delta to base
+// fails if the delta is malformed, copies from outside base, or would make a body larger than maxPatchedSize
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	if len(delta) < 4 || binary.BigEndian.Uint32(delta) != deltaMagic {
+		return nil, errors.New("the delta isn't in librsync's format")
+	}
+	reader := deltaReader{data: delta[4:]}
+	patched := bytes.NewBuffer(nil)
+	for {
+		command, err := reader.next(1)
+		if err != nil {
+			return nil, err
+		}
+		switch op := command[0]; {
+		case op == deltaEnd:
+			if len(reader.data) > 0 {
+				return nil, errors.New("the delta goes on after its end")
+			}
+			return patched.Bytes(), nil
+		case op <= deltaCopyN8N8:
+			var offset, length uint64
+			if op < deltaLiteralN1 {
+				length = uint64(op)
+			} else if op < deltaCopyN1N1 {
+				length, err = reader.int(int(op - deltaLiteralN1))
+			} else {
+				offset, err = reader.int(int(op-deltaCopyN1N1) / len(deltaIntWidths))
+				if err == nil {
+					length, err = reader.int(int(op-deltaCopyN1N1) % len(deltaIntWidths))
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if length > uint64(maxPatchedSize-patched.Len()) {
+				return nil, fmt.Errorf("the patched note would be larger than %d bytes", maxPatchedSize)
+			}
+			if op < deltaCopyN1N1 {
+				literal, err := reader.next(int(length))
+				if err != nil {
+					return nil, err
+				}
+				patched.Write(literal)
+			} else {
+				if offset > uint64(len(base)) || length > uint64(len(base))-offset {
+					return nil, errors.New("the delta copies from past the end of the note")
+				}
+				patched.Write(base[offset : offset+length])
+			}
+		default:
+			return nil, fmt.Errorf("the delta has an unknown command 0x%02x", op)
+		}
+	}
+}
+
+// reads the commands of a delta
+type deltaReader struct {
+	data []byte
+}
+
+// the next n bytes
+func (r *deltaReader) next(n int) ([]byte, error) {
+	if n > len(r.data) {
+		return nil, errDeltaTruncated
+	}
+	read := r.data[:n]
+	r.data = r.data[n:]
+	return read, nil
+}
+
+// the next big-endian integer, of the width deltaIntWidths[width]
+func (r *deltaReader) int(width int) (uint64, error) {
+	encoded, err := r.next(deltaIntWidths[width])
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for _, b := range encoded {
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+// header carrying the SHA-256 of the note a delta was made against, as hex
+const baseHashHeader = "X-Base-SHA256"
+
+// responds with the signature of a note as json, for a client to build a delta against
+func SignNote(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		body, ok, err := datastore.peekNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		signature := signBody(body)
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set(hashHeader, signature.Hash)
+		err = json.NewEncoder(resp).Encode(signature)
+		if err != nil {
+			log.Printf("responding with signature of %s: %v", noteName, err)
+		}
+	}
+}
+
+// atomically applies the delta in the request body to a note
+// the delta must have been made against the note whose hash is in X-Base-SHA256,
+// and the patched note's hash must be the one in X-Content-SHA256
+// responds 409, with the note's current hash in X-Content-SHA256, if either doesn't match,
+// and 413 if the delta is larger than any note it could make; see maxDeltaSize
+func PatchNote(datastore Datastore, policy WritePolicy, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		baseHash := strings.ToLower(req.Header.Get(baseHashHeader))
+		resultHash := strings.ToLower(req.Header.Get(hashHeader))
+		if !validHash(baseHash) || !validHash(resultHash) {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, baseHashHeader+" and "+hashHeader+" must both be given")
+			return
+		}
+		maxSize := maxDeltaSize(policy.MaxNoteSize)
+		delta, err := io.ReadAll(http.MaxBytesReader(resp, req.Body, maxSize))
+		if err != nil && int64(len(delta)) >= maxSize {
+			APIErrorMessage(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE, fmt.Sprintf("deltas can't be larger than %d bytes", maxSize))
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			log.Printf("reading delta for %s: %v", noteName, err)
+			return
+		}
+		base, ok, err := datastore.peekNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		if hash := hashBody(base); hash != baseHash {
+			patchConflict(resp, hash, ERR_CONFLICT, "the note has changed since the delta was made")
+			return
+		}
+		patched, err := applyDelta(base, delta)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		if hashBody(patched) != resultHash {
+			patchConflict(resp, baseHash, ERR_HASH_MISMATCH, "the patched note doesn't match "+hashHeader)
+			return
+		}
+		swapped, err := datastore.swapNote(noteName, baseHash, patched)
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		if !swapped {
+			// changed or deleted while the delta was applied
+			current, ok, err := datastore.peekNote(noteName)
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("accessing %s: %v", noteName, err)
+				return
+			}
+			if !ok {
+				noteNotFound(resp, req, datastore, noteName)
+				return
+			}
+			patchConflict(resp, hashBody(current), ERR_CONFLICT, "the note has changed since the delta was made")
+			return
+		}
+		resp.Header().Set(hashHeader, resultHash)
+		listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(patched)))
+		log.Printf("Patched note %s", noteName)
+	}
+}
+
+// responds 409 to a delta, with the note's current hash
+func patchConflict(resp http.ResponseWriter, currentHash string, code string, message string) {
+	resp.Header().Set(hashHeader, currentHash)
+	APIErrorMessage(resp, http.StatusConflict, code, message+"; its hash is now "+currentHash)
+}
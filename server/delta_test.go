@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// the status, error code and X-Content-SHA256 of the response to a delta sent to a note,
+// made against baseHash and said to patch it into resultHash
+func postDelta(t *testing.T, url string, name string, baseHash string, resultHash string, delta []byte) (int, string, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url+"/api/note/"+name+"/patch", bytes.NewReader(delta))
+	if err != nil {
+		t.Error(err)
+		return 0, "", ""
+	}
+	req.Header.Set(baseHashHeader, baseHash)
+	req.Header.Set(hashHeader, resultHash)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0, "", ""
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var envelope struct {
+		Error APIErrorDetail `json:"error"`
+	}
+	json.Unmarshal(body, &envelope)
+	return resp.StatusCode, envelope.Error.Code, resp.Header.Get(hashHeader)
+}
+
+// a delta from base to body, made as a client would, against base's signature
+func deltaTo(t *testing.T, base []byte, body []byte) []byte {
+	t.Helper()
+	delta, err := makeDelta(signBody(base), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delta
+}
+
+func TestPatchNote(t *testing.T) {
+	server := testServer(t, testConfig(t, "-max-note-size", "100000"))
+	base := []byte(strings.Repeat("a line which stays the same\n", 1000))
+	edited := append([]byte("a new first line\n"), base...)
+	changed := []byte(strings.Repeat("a line which was changed\n", 1000))
+	delta := deltaTo(t, base, edited)
+	large := bytes.Repeat([]byte("x"), 200000)
+	cases := []struct {
+		name       string
+		baseHash   string
+		resultHash string
+		delta      []byte
+		wantStatus int
+		wantCode   string
+		// what X-Content-SHA256 should say, if anything
+		wantHash string
+	}{
+		{"no base hash", "", hashBody(edited), delta, http.StatusBadRequest, ERR_BAD_REQUEST, ""},
+		{"base mismatch", hashBody(changed), hashBody(edited), delta, http.StatusConflict, ERR_CONFLICT, hashBody(base)},
+		{"corrupt magic", hashBody(base), hashBody(edited), []byte("not a delta at all"), http.StatusBadRequest, ERR_BAD_REQUEST, ""},
+		{"truncated", hashBody(base), hashBody(edited), delta[:len(delta)/2], http.StatusBadRequest, ERR_BAD_REQUEST, ""},
+		{"copy past the end", hashBody(base), hashBody(edited),
+			[]byte{0x72, 0x73, 0x02, 0x36, deltaCopyN8N8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0, 0, 0, deltaEnd},
+			http.StatusBadRequest, ERR_BAD_REQUEST, ""},
+		{"result mismatch", hashBody(base), hashBody(changed), delta, http.StatusConflict, ERR_HASH_MISMATCH, hashBody(base)},
+		{"too large", hashBody(base), hashBody(large), deltaTo(t, base, large), http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE, ""},
+		{"patched", hashBody(base), hashBody(edited), delta, http.StatusOK, "", hashBody(edited)},
+	}
+	if resp := serveRequest(t, server.Config.Handler, http.MethodPut, "/api/note/todo", string(base)); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", resp.Code)
+	}
+	for _, c := range cases {
+		status, code, hash := postDelta(t, server.URL, "todo", c.baseHash, c.resultHash, c.delta)
+		if status != c.wantStatus || code != c.wantCode {
+			t.Errorf("%s: got %d %q, want %d %q", c.name, status, code, c.wantStatus, c.wantCode)
+		}
+		if c.wantHash != "" && hash != c.wantHash {
+			t.Errorf("%s: %s is %q, want %q", c.name, hashHeader, hash, c.wantHash)
+		}
+		want := base
+		if status == http.StatusOK {
+			want = edited
+		}
+		if resp := serveRequest(t, server.Config.Handler, http.MethodGet, "/api/note/todo", ""); !bytes.Equal(resp.Body.Bytes(), want) {
+			t.Fatalf("%s: the note is %d bytes, want %d", c.name, resp.Body.Len(), len(want))
+		}
+	}
+	if status, code, _ := postDelta(t, server.URL, "missing", hashBody(base), hashBody(edited), delta); status != http.StatusNotFound {
+		t.Errorf("a missing note: got %d %q, want 404", status, code)
+	}
+}
+
+// of several clients patching the same note at once from the same base, exactly one wins,
+// and the rest are told the note has changed and what its hash now is
+func TestConcurrentPatches(t *testing.T) {
+	server := testServer(t, testConfig(t))
+	base := []byte(strings.Repeat("a line which stays the same\n", 1000))
+	if resp := serveRequest(t, server.Config.Handler, http.MethodPut, "/api/note/todo", string(base)); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", resp.Code)
+	}
+	const patchers = 8
+	edits := make([][]byte, patchers)
+	deltas := make([][]byte, patchers)
+	for i := range edits {
+		edits[i] = append([]byte(fmt.Sprintf("patcher %d was here\n", i)), base...)
+		deltas[i] = deltaTo(t, base, edits[i])
+	}
+	statuses := make([]int, patchers)
+	hashes := make([]string, patchers)
+	var wait sync.WaitGroup
+	for i := 0; i < patchers; i++ {
+		wait.Add(1)
+		go func(i int) {
+			defer wait.Done()
+			statuses[i], _, hashes[i] = postDelta(t, server.URL, "todo", hashBody(base), hashBody(edits[i]), deltas[i])
+		}(i)
+	}
+	wait.Wait()
+
+	winner := -1
+	for i, status := range statuses {
+		switch {
+		case status == http.StatusOK && winner == -1:
+			winner = i
+		case status == http.StatusOK:
+			t.Errorf("patchers %d and %d both succeeded", winner, i)
+		case status != http.StatusConflict:
+			t.Errorf("patcher %d: got %d, want 200 or 409", i, status)
+		}
+	}
+	if winner == -1 {
+		t.Fatalf("no patcher succeeded: %v", statuses)
+	}
+	note := serveRequest(t, server.Config.Handler, http.MethodGet, "/api/note/todo", "").Body.Bytes()
+	if !bytes.Equal(note, edits[winner]) {
+		t.Errorf("the note starts %q, want patcher %d's edit", note[:30], winner)
+	}
+	for i, status := range statuses {
+		if status == http.StatusConflict && hashes[i] != hashBody(edits[winner]) {
+			t.Errorf("patcher %d was told the hash is %s, want the winner's", i, hashes[i])
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// flags whose values are secrets, which diagnostics and config export leave out
+var secretFlags = map[string]bool{
+	"creds":                true,
+	"mirror-creds":         true,
+	"tcp-paste-token":      true,
+	"notify-matrix-token":  true,
+	"notify-slack-webhook": true,
+}
+
+// a flag's value, unless it's a secret which was given, in which case "(redacted)"
+func redactedFlagValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if secretFlags[f.Name] && value != "" {
+		return "(redacted)"
+	}
+	return value
+}
+
+// Diagnostics is where the parts of corkboard which run in the background report on themselves,
+// so that a snapshot of everything can be taken at once, on SIGUSR2
+type Diagnostics struct {
+	// requests being handled, see countRequests
+	// first, so it's aligned for atomic access on 32-bit platforms
+	inFlight int64
+	lock     sync.Mutex
+	// in the order they were registered
+	names   []string
+	reports map[string]func() interface{}
+}
+
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{reports: make(map[string]func() interface{})}
+}
+
+// adds a part of corkboard to snapshots
+// report is called for each snapshot, and its result written as json
+func (d *Diagnostics) register(name string, report func() interface{}) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if _, ok := d.reports[name]; !ok {
+		d.names = append(d.names, name)
+	}
+	d.reports[name] = report
+}
+
+// counts the requests being handled, for snapshots
+func (d *Diagnostics) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		atomic.AddInt64(&d.inFlight, 1)
+		defer atomic.AddInt64(&d.inFlight, -1)
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// writes a snapshot of everything registered, the flags corkboard was started with, and every goroutine's stack
+func (d *Diagnostics) snapshot() []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "corkboard %s diagnostics at %s\n", corkboardVersion, time.Now().UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(buf, "\n== flags\n")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Fprintf(buf, "-%s=%s\n", f.Name, redactedFlagValue(f))
+	})
+
+	fmt.Fprintf(buf, "\n== requests\n")
+	fmt.Fprintf(buf, "in flight: %d\nwrites in flight: %d\n", atomic.LoadInt64(&d.inFlight), writesInFlight.Value())
+
+	d.lock.Lock()
+	names := append([]string(nil), d.names...)
+	d.lock.Unlock()
+	for _, name := range names {
+		d.lock.Lock()
+		report := d.reports[name]
+		d.lock.Unlock()
+		fmt.Fprintf(buf, "\n== %s\n", name)
+		data, err := json.MarshalIndent(report(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(buf, "error: %v\n", err)
+			continue
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	}
+
+	fmt.Fprintf(buf, "\n== goroutines\n")
+	pprof.Lookup("goroutine").WriteTo(buf, 2)
+	return buf.Bytes()
+}
+
+// writes a snapshot to a timestamped file in dir, or to the log if dir is ""
+func (d *Diagnostics) dump(dir string) {
+	snapshot := d.snapshot()
+	if dir == "" {
+		log.Printf("diagnostics:\n%s", snapshot)
+		return
+	}
+	path := filepath.Join(dir, "diag-"+time.Now().UTC().Format("20060102T150405Z")+".txt")
+	err := os.WriteFile(path, snapshot, 0600)
+	if err != nil {
+		log.Printf("error writing diagnostics to %s: %v", path, err)
+		return
+	}
+	log.Printf("wrote diagnostics to %s", path)
+}
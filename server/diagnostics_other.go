@@ -0,0 +1,7 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package server
+
+// there is no SIGUSR2 on this platform, so diagnostics are never dumped
+func notifyDiagnostics(dump func()) {}
@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// calls dump whenever the process receives SIGUSR2
+func notifyDiagnostics(dump func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			dump()
+		}
+	}()
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+// how far back the last digest's slot is looked for, which is where the next digest's period begins;
+// a digest whose schedule didn't fire in this long covers just this long
+const maxDigestPeriod = 366 * 24 * time.Hour
+
+// most notes listed in each section of a digest; any more are only counted
+const maxDigestNotes = 200
+
+// DigestConfig says when digests of the changes to notes are written, and what they're called
+type DigestConfig struct {
+	// when digests are written; if nil, they aren't
+	schedule *CronSchedule
+	// digests are named this followed by the date of their slot, and its time if the schedule fires more than once a day
+	Prefix string
+	// whether to post a link to each digest to -notify-slack-webhook or -notify-matrix-server
+	Notify bool
+}
+
+// DigestData is passed to the digest.md template
+type DigestData struct {
+	// the digest's own name
+	Name string
+	// the period the digest covers, from the last digest's slot to this one's
+	Start time.Time
+	End   time.Time
+	// notes created during the period, and notes which already existed and were updated or deleted,
+	// each with when it was last changed
+	Created DigestList
+	Updated DigestList
+	Deleted DigestList
+	// notes which will expire before the next digest, each with when it will
+	Expiring DigestList
+}
+
+// DigestList is one section of a digest
+type DigestList struct {
+	// how many notes the section has, of which Notes lists up to maxDigestNotes
+	Total int
+	Notes []DigestNote
+	// how many notes were left out of Notes
+	More int
+}
+
+// DigestNote is a note listed in a digest
+type DigestNote struct {
+	Name string
+	// the note's page, or "" if -base-url isn't set or the note was deleted
+	URL  string
+	Time time.Time
+}
+
+func (list *DigestList) add(note DigestNote) {
+	list.Total++
+	if len(list.Notes) < maxDigestNotes {
+		list.Notes = append(list.Notes, note)
+	} else {
+		list.More++
+	}
+}
+
+// escapes the characters markdown gives a meaning to, so a note's name is shown as it is
+func escapeMarkdown(text string) string {
+	var escaped strings.Builder
+	for _, c := range text {
+		if strings.ContainsRune("\\`*_{}[]<>()#+-.!|~", c) {
+			escaped.WriteByte('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
+// Digester writes a note summarizing the changes to notes every time its schedule fires
+type Digester struct {
+	datastore Datastore
+	config    DigestConfig
+	template  *texttemplate.Template
+	// which notes expire, as currently set
+	expiry    func() ExpiryConfig
+	listeners Listeners
+	baseURL   string
+	// posts a message to chat, if config.Notify asks for it; nil otherwise
+	notify func(message string)
+	stop   chan struct{}
+	done   chan struct{}
+
+	// the last digest written, for diagnostics
+	mutex sync.Mutex
+	last  string
+}
+
+func NewDigester(datastore Datastore, config DigestConfig, digestTemplate *texttemplate.Template, expiry func() ExpiryConfig, listeners Listeners, baseURL string) *Digester {
+	return &Digester{
+		datastore: datastore,
+		config:    config,
+		template:  digestTemplate,
+		expiry:    expiry,
+		listeners: listeners,
+		baseURL:   baseURL,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// the last digest written, for diagnostics
+func (d *Digester) diagnostics() interface{} {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return map[string]string{"last": d.last}
+}
+
+// writes digests on schedule until stopped
+// on startup, the most recent slot is written in case it was missed while corkboard was down, like a -schedule-file's;
+// since a digest which already exists is skipped, none is ever written twice
+func (d *Digester) run() {
+	defer close(d.done)
+	now := time.Now().Truncate(time.Minute)
+	if d.config.schedule.matches(now) {
+		d.write(now)
+	} else if slot, ok := d.config.schedule.previous(now, scheduleCatchUpWindow); ok {
+		d.write(slot)
+	}
+	last := now
+	for {
+		next := last.Add(time.Minute)
+		select {
+		case <-d.stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if d.config.schedule.matches(next) {
+			d.write(next)
+		}
+		last = next
+	}
+}
+
+// stops writing digests, waiting for one being written to finish
+func (d *Digester) shutdown() {
+	close(d.stop)
+	<-d.done
+}
+
+// the name of the digest for a slot
+func (d *Digester) name(slot time.Time) string {
+	if d.config.schedule.daily() {
+		return d.config.Prefix + slot.Format("2006-01-02")
+	}
+	return d.config.Prefix + slot.Format("2006-01-02-1504")
+}
+
+// writes the digest for a slot, covering the changes since the slot before it,
+// unless it already exists or nothing changed
+func (d *Digester) write(slot time.Time) {
+	name := d.name(slot)
+	exists, err := d.datastore.noteExists(name)
+	if err != nil {
+		log.Printf("writing digest %s: %v", name, err)
+		return
+	}
+	if exists {
+		return
+	}
+	start, ok := d.config.schedule.previous(slot, maxDigestPeriod)
+	if !ok {
+		start = slot.Add(-maxDigestPeriod)
+	}
+	data, err := d.collect(name, start, slot)
+	if err != nil {
+		log.Printf("writing digest %s: %v", name, err)
+		return
+	}
+	if data.Created.Total == 0 && data.Updated.Total == 0 && data.Deleted.Total == 0 {
+		log.Printf("Nothing changed for digest %s, so it wasn't written", name)
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	err = d.template.Execute(buf, data)
+	if err != nil {
+		log.Printf("writing digest %s: rendering %s: %v", name, digestTemplateName, err)
+		return
+	}
+	body := buf.Bytes()
+	status, err := d.datastore.setNoteWithHash(name, body, hashBody(body), false, NoteOptions{ContentType: "text/markdown; charset=UTF-8"})
+	if err != nil {
+		log.Printf("writing digest %s: %v", name, err)
+		return
+	}
+	if status != CREATED {
+		return
+	}
+	d.listeners.publish(NoteEvent{Action: NOTE_CREATED, Name: name, Size: len(body)})
+	log.Printf("New digest %s", name)
+	d.mutex.Lock()
+	d.last = name
+	d.mutex.Unlock()
+	if d.notify != nil {
+		d.notify(d.describe(data))
+	}
+}
+
+// gathers what a digest says about the period from start to end
+func (d *Digester) collect(name string, start time.Time, end time.Time) (DigestData, error) {
+	location := end.Location()
+	data := DigestData{Name: name, Start: start, End: end}
+	changes, err := d.datastore.digestChanges(start, end, d.config.Prefix)
+	if err != nil {
+		return data, fmt.Errorf("listing changes: %v", err)
+	}
+	for _, change := range changes {
+		note := DigestNote{Name: change.Name, Time: change.Time.In(location)}
+		switch {
+		case change.Action == NOTE_DELETED:
+			data.Deleted.add(note)
+		case change.Created:
+			note.URL = d.url(change.Name)
+			data.Created.add(note)
+		default:
+			note.URL = d.url(change.Name)
+			data.Updated.add(note)
+		}
+	}
+	// notes expiring before the next digest, or within as long as this one covers if there won't be one
+	next, ok := d.config.schedule.next(end, maxDigestPeriod)
+	if !ok {
+		next = end.Add(end.Sub(start))
+	}
+	expiry := d.expiry()
+	notes, err := d.datastore.expiringNotes(next, expiry.age, expiry.unviewedAge, expiry.policy, d.config.Prefix)
+	if err != nil {
+		return data, fmt.Errorf("listing expiring notes: %v", err)
+	}
+	expiring := []DigestNote{}
+	for _, note := range notes {
+		expires := expiry.noteExpiry(note.Name, note).Expires
+		if expires == nil {
+			continue
+		}
+		expiring = append(expiring, DigestNote{Name: note.Name, URL: d.url(note.Name), Time: expires.In(location)})
+	}
+	sort.SliceStable(expiring, func(i, j int) bool {
+		return expiring[i].Time.Before(expiring[j].Time)
+	})
+	for _, note := range expiring {
+		data.Expiring.add(note)
+	}
+	return data, nil
+}
+
+// the page of a note, or "" without -base-url
+func (d *Digester) url(name string) string {
+	if d.baseURL == "" {
+		return ""
+	}
+	return noteURL(d.baseURL, name)
+}
+
+// the chat message announcing a digest, like "Digest digest-2026-10-17: 3 created, 1 updated"
+func (d *Digester) describe(data DigestData) string {
+	parts := []string{}
+	for _, section := range []struct {
+		list DigestList
+		verb string
+	}{{data.Created, "created"}, {data.Updated, "updated"}, {data.Deleted, "deleted"}, {data.Expiring, "expiring soon"}} {
+		if section.list.Total > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", section.list.Total, section.verb))
+		}
+	}
+	message := fmt.Sprintf("Digest %s: %s", data.Name, strings.Join(parts, ", "))
+	if d.baseURL != "" {
+		message += ": " + noteURL(d.baseURL, data.Name)
+	}
+	return message
+}
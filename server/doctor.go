@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// how long "corkboard doctor" waits for each host name to resolve
+const doctorLookupTimeout = 5 * time.Second
+
+// Doctor checks that what a corkboard's flags point at, like its database and the hosts it posts to, can be used,
+// printing what it found
+type Doctor struct {
+	config Config
+	// what each check found, printed after the summary
+	results  []string
+	problems int
+}
+
+// prints the summary logged at startup, then checks everything corkboard needs from its surroundings,
+// without creating or changing anything; returns an error if any check failed
+func runDoctor(config Config) error {
+	d := &Doctor{config: config}
+	expiry := config.expiry()
+	schema := ""
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		return err
+	}
+
+	datastore, ok := d.checkDatabase("database", config.DatabasePath, migrations)
+	if ok {
+		if settings, err := loadSettings(datastore, &IndexCache{}, config); err == nil {
+			expiry = settings.expiry()
+			config.ReadOnly = settings.readOnly()
+		}
+		schema, _ = datastore.schemaVersion()
+		datastore.Close()
+	}
+	for _, board := range config.Boards {
+		boardConfig, err := board.apply(config)
+		if err != nil {
+			d.fail("board "+board.name, "%v", err)
+			continue
+		}
+		if datastore, ok := d.checkDatabase("board "+board.name, boardConfig.DatabasePath, migrations); ok {
+			datastore.Close()
+		}
+	}
+
+	for _, dir := range []struct{ flag, path string }{
+		{"-archive-dir", config.ArchiveDir},
+		{"-replica-dir", config.ReplicaDir},
+		{"-diag-dir", config.DiagDir},
+	} {
+		if dir.path != "" {
+			d.checkWritableDir(dir.flag, dir.path)
+		}
+	}
+	if config.Logging.File != "" {
+		d.checkWritableDir("-log-file", filepath.Dir(config.Logging.File))
+	}
+	for _, file := range []struct{ flag, path string }{
+		{"-custom-css", config.CustomCSS},
+		{"-custom-js", config.CustomJS},
+	} {
+		if file.path != "" {
+			d.checkReadable(file.flag, file.path)
+		}
+	}
+	if config.TemplatesDir != "" {
+		_, err := loadTemplates(config.TemplatesDir, true)
+		if err == nil {
+			_, err = loadDigestTemplate(config.TemplatesDir, true)
+		}
+		if err != nil {
+			d.fail("-templates-dir", "%v; fix the template, or remove it to use the built-in one", err)
+		} else {
+			d.ok("-templates-dir", "every template can be rendered")
+		}
+	}
+
+	if config.Credentials != nil {
+		d.ok("credentials", "%s parsed", plural(countUsers(config.Credentials), "user"))
+	}
+	for _, host := range []struct{ flag, url string }{
+		{"-base-url", config.BaseURL},
+		{"-mirror-url", config.MirrorURL},
+		{"-notify-slack-webhook", config.Notify.SlackWebhook},
+		{"-notify-matrix-server", config.Notify.MatrixServer},
+	} {
+		if host.url != "" {
+			d.checkResolvable(host.flag, host.url)
+		}
+	}
+	d.checkPort("-port", config.Port)
+	if config.TCPPaste.Port != 0 {
+		d.checkPort("-tcp-paste-port", config.TCPPaste.Port)
+	}
+
+	fmt.Printf("corkboard %s\n", corkboardVersion)
+	for _, line := range configSummary(config, expiry, schema) {
+		fmt.Println("  " + line)
+	}
+	fmt.Println()
+	for _, result := range d.results {
+		fmt.Println(result)
+	}
+	if d.problems > 0 {
+		return fmt.Errorf("found %s", plural(d.problems, "problem"))
+	}
+	fmt.Println("no problems found")
+	return nil
+}
+
+func (d *Doctor) ok(topic string, format string, args ...interface{}) {
+	d.results = append(d.results, fmt.Sprintf("ok       %s: %s", topic, fmt.Sprintf(format, args...)))
+}
+
+// a problem which doesn't stop corkboard working, like a port in use, which may be by corkboard itself
+func (d *Doctor) warn(topic string, format string, args ...interface{}) {
+	d.results = append(d.results, fmt.Sprintf("warning  %s: %s", topic, fmt.Sprintf(format, args...)))
+}
+
+func (d *Doctor) fail(topic string, format string, args ...interface{}) {
+	d.problems++
+	d.results = append(d.results, fmt.Sprintf("PROBLEM  %s: %s", topic, fmt.Sprintf(format, args...)))
+}
+
+// checks that a database exists and can be written, isn't corrupted, and has the schema this corkboard expects
+// returns it open if it could be opened, for the caller to close
+func (d *Doctor) checkDatabase(topic string, path string, migrations fs.FS) (Datastore, bool) {
+	err := checkDatabasePath(path, false)
+	if err != nil {
+		d.fail(topic, "%s: %v", path, err)
+		return Datastore{}, false
+	}
+	config := d.config
+	config.DatabasePath = path
+	config.CreateDB = false
+	config.DBWait = 0
+	datastore, err := openDatastore(config)
+	if err != nil {
+		d.fail(topic, "opening %s: %v", path, err)
+		return Datastore{}, false
+	}
+	problems, err := datastore.integrityCheck(false)
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("%s, e.g. %s", plural(len(problems), "problem"), problems[0])
+	}
+	if err != nil {
+		d.fail(topic, "%s is corrupted: %v; run \"corkboard check\" for every problem, and restore it from a backup", path, err)
+		return datastore, true
+	}
+	status, err := datastore.schemaStatus(migrations)
+	switch {
+	case err != nil:
+		d.fail(topic, "checking the schema of %s: %v", path, err)
+	case len(status.Unknown) > 0:
+		d.fail(topic, "%s has migrations this corkboard doesn't know about (%s); upgrade corkboard, or start with -allow-newer-schema",
+			path, joinMigrations(status.Unknown))
+	case len(status.Pending) > 0 && !status.New:
+		d.fail(topic, "%s is missing migrations %s; back it up and run \"corkboard migrate\", or start with -auto-migrate",
+			path, joinMigrations(status.Pending))
+	default:
+		d.ok(topic, "%s can be written, isn't corrupted and its schema is up to date", path)
+	}
+	return datastore, true
+}
+
+// checks that files can be made in a directory
+func (d *Doctor) checkWritableDir(topic string, dir string) {
+	info, err := os.Stat(dir)
+	if err == nil && !info.IsDir() {
+		err = errors.New("it isn't a directory")
+	}
+	if err == nil {
+		var file *os.File
+		file, err = os.CreateTemp(dir, ".corkboard-doctor-*")
+		if err == nil {
+			file.Close()
+			err = os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		d.fail(topic, "%s can't be written: %v; create it, or give corkboard's user permission to write to it", dir, err)
+		return
+	}
+	d.ok(topic, "%s can be written", dir)
+}
+
+func (d *Doctor) checkReadable(topic string, path string) {
+	file, err := os.Open(path)
+	if err != nil {
+		d.fail(topic, "%v", err)
+		return
+	}
+	file.Close()
+	d.ok(topic, "%s can be read", path)
+}
+
+// checks that the host of a url resolves; only the host is printed, since the rest of the url may be a secret
+func (d *Doctor) checkResolvable(topic string, raw string) {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Hostname() == "" {
+		d.fail(topic, "it isn't a url like https://example.com")
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorLookupTimeout)
+	defer cancel()
+	_, err = net.DefaultResolver.LookupHost(ctx, parsed.Hostname())
+	if err != nil {
+		d.fail(topic, "%s doesn't resolve: %v; check the host name and this machine's DNS", parsed.Hostname(), err)
+		return
+	}
+	d.ok(topic, "%s resolves", parsed.Hostname())
+}
+
+// checks that a port can be listened on
+func (d *Doctor) checkPort(topic string, port int) {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		d.warn(topic, "port %d can't be listened on: %v; fine if corkboard is already running on it", port, err)
+		return
+	}
+	listener.Close()
+	d.ok(topic, "port %d is free", port)
+}
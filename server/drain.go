@@ -0,0 +1,83 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most of a request body left unread which is read and thrown away so its connection can be reused
+// any more, and the connection is closed instead, rather than waiting for the client to finish sending it
+const maxDrainSize = 64 << 10
+
+// finishes with the rest of a request's body before responding, e.g. because the request was refused
+// a little is read and thrown away so the connection can serve the client's next request,
+// but the connection is closed rather than reading a lot
+// must be called before the response's header is written, so that it can say the connection will close
+func discardBody(resp http.ResponseWriter, req *http.Request) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return
+	}
+	if req.ContentLength > maxDrainSize {
+		resp.Header().Set("Connection", "close")
+		return
+	}
+	discarded, _ := io.CopyN(io.Discard, req.Body, maxDrainSize+1)
+	if discarded > maxDrainSize {
+		resp.Header().Set("Connection", "close")
+	}
+}
+
+// a request body which remembers whether it's been read to the end
+type trackedBody struct {
+	io.ReadCloser
+	finished bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.finished = true
+	}
+	return n, err
+}
+
+// a response which calls discardBody before its header is written, if its request's body hasn't been read
+type drainingWriter struct {
+	http.ResponseWriter
+	req         *http.Request
+	body        *trackedBody
+	wroteHeader bool
+}
+
+func (w *drainingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if !w.body.finished {
+			discardBody(w.ResponseWriter, w.req)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *drainingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// middleware which deals with whatever a handler leaves of the request body, like discardBody,
+// so a handler can respond early, e.g. with 409, without thinking about the body it didn't read
+func drainBody(h httprouter.Handle) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if req.Body == nil || req.Body == http.NoBody {
+			h(resp, req, params)
+			return
+		}
+		body := &trackedBody{ReadCloser: req.Body}
+		req.Body = body
+		h(&drainingWriter{ResponseWriter: resp, req: req, body: body}, req, params)
+	}
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a refused upload, small or large, is followed on the same client by another request without a hang or an error;
+// a small one's connection is reused, and a large one's is closed rather than read to the end
+func TestRefusedUploadsKeepClientWorking(t *testing.T) {
+	config := testConfig(t)
+	config.Credentials = map[string]bool{"alice:secret": true}
+	server := testServer(t, config)
+	client := &http.Client{Timeout: 10 * time.Second}
+	// sends a request, and returns its status, and whether it was sent on a connection used before
+	do := func(method string, path string, body []byte, creds bool) (int, bool) {
+		t.Helper()
+		var reused bool
+		trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+		req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		if creds {
+			req.SetBasicAuth("alice", "secret")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, reused
+	}
+	if status, _ := do(http.MethodPut, "/api/note/todo", []byte("hello"), true); status != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", status)
+	}
+
+	small := bytes.Repeat([]byte("x"), maxDrainSize/2)
+	large := bytes.Repeat([]byte("x"), 8*maxDrainSize)
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		body       []byte
+		creds      bool
+		wantStatus int
+		// whether the request after it should reuse its connection
+		wantReused bool
+	}{
+		{"small, unauthorized", http.MethodPut, "/api/note/todo", small, false, http.StatusUnauthorized, true},
+		{"large, unauthorized", http.MethodPut, "/api/note/todo", large, false, http.StatusUnauthorized, false},
+		{"small, conflicting", http.MethodPost, "/api/note/todo", small, true, http.StatusConflict, true},
+		// a conflict is only found once the body has been read, so there's nothing left to drain
+		{"large, conflicting", http.MethodPost, "/api/note/todo", large, true, http.StatusConflict, true},
+		{"small, to a bad name", http.MethodPut, "/api/note/_reserved", small, true, http.StatusBadRequest, true},
+		{"large, to a bad name", http.MethodPut, "/api/note/_reserved", large, true, http.StatusBadRequest, false},
+	}
+	for _, c := range cases {
+		// warms up a connection, so the refused request is sent on one which could be reused
+		do(http.MethodGet, "/api/note/todo", nil, true)
+		if status, _ := do(c.method, c.path, c.body, c.creds); status != c.wantStatus {
+			t.Errorf("%s: got %d, want %d", c.name, status, c.wantStatus)
+		}
+		status, reused := do(http.MethodGet, "/api/note/todo", nil, true)
+		if status != http.StatusOK {
+			t.Errorf("%s: the next request got %d", c.name, status)
+		}
+		if reused != c.wantReused {
+			t.Errorf("%s: the next request reused the connection: %v, want %v", c.name, reused, c.wantReused)
+		}
+	}
+}
+
+func TestDiscardBody(t *testing.T) {
+	cases := []struct {
+		size          int
+		contentLength bool
+		wantClose     bool
+		wantRead      int
+	}{
+		{100, true, false, 100},
+		{maxDrainSize, true, false, maxDrainSize},
+		// the client said it would send too much, so none of it is read
+		{maxDrainSize + 1, true, true, 0},
+		// without a length, it's read until it turns out to be too much
+		{100, false, false, 100},
+		{4 * maxDrainSize, false, true, maxDrainSize + 1},
+	}
+	for _, c := range cases {
+		body := &countingReader{Reader: strings.NewReader(strings.Repeat("x", c.size))}
+		req := httptest.NewRequest(http.MethodPut, "/api/note/todo", body)
+		req.ContentLength = -1
+		if c.contentLength {
+			req.ContentLength = int64(c.size)
+		}
+		resp := httptest.NewRecorder()
+		discardBody(resp, req)
+		name := fmt.Sprintf("%d bytes, with a length %v", c.size, c.contentLength)
+		if closed := resp.Header().Get("Connection") == "close"; closed != c.wantClose {
+			t.Errorf("%s: closed %v, want %v", name, closed, c.wantClose)
+		}
+		if body.read != c.wantRead {
+			t.Errorf("%s: read %d bytes, want %d", name, body.read, c.wantRead)
+		}
+	}
+}
+
+// a reader which counts the bytes read from it
+type countingReader struct {
+	io.Reader
+	read int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
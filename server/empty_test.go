@@ -0,0 +1,133 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// every combination of how an empty note can be written, each with an empty body and one of only whitespace
+// the note starts out saying "hello" if exists is set, and then should say wantNote, or not exist if wantNote is "-"
+func TestEmptyBodies(t *testing.T) {
+	cases := []struct {
+		method     string
+		exists     bool
+		allowEmpty bool
+		truncates  bool
+		wantStatus int
+		wantNote   string
+	}{
+		{http.MethodPost, false, false, false, http.StatusBadRequest, "-"},
+		{http.MethodPost, false, false, true, http.StatusBadRequest, "-"},
+		{http.MethodPost, false, true, false, http.StatusCreated, ""},
+		{http.MethodPost, false, true, true, http.StatusCreated, ""},
+		{http.MethodPost, true, false, false, http.StatusBadRequest, "hello"},
+		{http.MethodPost, true, false, true, http.StatusBadRequest, "hello"},
+		{http.MethodPost, true, true, false, http.StatusConflict, "hello"},
+		{http.MethodPost, true, true, true, http.StatusConflict, "hello"},
+		{http.MethodPut, false, false, false, http.StatusBadRequest, "-"},
+		// -empty-put-truncates only empties notes, and never creates an empty one
+		{http.MethodPut, false, false, true, http.StatusBadRequest, "-"},
+		{http.MethodPut, false, true, false, http.StatusCreated, ""},
+		{http.MethodPut, false, true, true, http.StatusCreated, ""},
+		{http.MethodPut, true, false, false, http.StatusBadRequest, "hello"},
+		{http.MethodPut, true, false, true, http.StatusOK, ""},
+		{http.MethodPut, true, true, false, http.StatusOK, ""},
+		{http.MethodPut, true, true, true, http.StatusOK, ""},
+	}
+	for _, c := range cases {
+		for _, body := range []string{"", " \n\t"} {
+			c, body := c, body
+			name := fmt.Sprintf("%s exists=%v allow-empty=%v truncates=%v body=%q", c.method, c.exists, c.allowEmpty, c.truncates, body)
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				config := servertest.Config(t)
+				config.WritePolicy.EmptyTruncates = c.truncates
+				s := servertest.New(t, config)
+				if c.exists {
+					if status, body := s.Do(t, http.MethodPut, "/api/note/todo", "hello"); status != http.StatusCreated {
+						t.Fatalf("creating the note: got %d %q", status, body)
+					}
+				}
+				path := "/api/note/todo"
+				if c.allowEmpty {
+					path += "?allow-empty=true"
+				}
+				status, respBody := s.Do(t, c.method, path, body)
+				if status != c.wantStatus {
+					t.Fatalf("got %d %q, want %d", status, respBody, c.wantStatus)
+				}
+				if status == http.StatusBadRequest {
+					var apiErr struct {
+						Error struct {
+							Code string `json:"code"`
+						} `json:"error"`
+					}
+					if err := json.Unmarshal([]byte(respBody), &apiErr); err != nil || apiErr.Error.Code != "empty_body" {
+						t.Errorf("the error is %q, want the code empty_body", respBody)
+					}
+				}
+
+				status, note := s.Do(t, http.MethodGet, "/api/note/todo", "")
+				want := c.wantNote
+				if want == "" {
+					// an empty note is saved as it was sent
+					want = body
+				}
+				switch {
+				case c.wantNote == "-" && status != http.StatusNotFound:
+					t.Errorf("the note exists, saying %q", note)
+				case c.wantNote != "-" && (status != http.StatusOK || note != want):
+					t.Errorf("the note: got %d %q, want %q", status, note, want)
+				}
+			})
+		}
+	}
+}
+
+// pastes follow the same policy as the api
+func TestEmptyPastes(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	cases := []struct {
+		path string
+		want int
+	}{
+		{"/paste", http.StatusBadRequest},
+		{"/paste?allow-empty=true", http.StatusOK},
+	}
+	for _, c := range cases {
+		resp, err := s.Client().PostForm(s.URL+c.path, url.Values{"f": {" \n"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("POST %s: got %d, want %d", c.path, resp.StatusCode, c.want)
+		}
+	}
+}
+
+// the form on the main page refuses an empty note, and has no way to allow one
+func TestEmptyForm(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	for _, body := range []string{"", " \n"} {
+		resp, err := s.Client().PostForm(s.URL+"/new", url.Values{"name": {"todo"}, "body": {body}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(page), "the note is empty") {
+			t.Errorf("body %q: got %d %q, want 400 saying the note is empty", body, resp.StatusCode, page)
+		}
+	}
+	if status, note := s.Do(t, http.MethodGet, "/api/note/todo", ""); status != http.StatusNotFound {
+		t.Errorf("the note was created, saying %q", note)
+	}
+}
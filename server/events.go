@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// kinds of change to a note
+const (
+	NOTE_CREATED = "create"
+	NOTE_UPDATED = "update"
+	NOTE_DELETED = "delete"
+)
+
+// NoteEvent describes a change to a note
+type NoteEvent struct {
+	Action string
+	Name   string
+	// the authenticated user who made the change, if any
+	User string
+	// size of the note's body in bytes
+	Size int
+	// whether the change was pushed to us by a mirror
+	Replicated bool
+}
+
+// NoteListener is told about every change to a note
+// noteChanged is called from the request handler, so it must not block
+type NoteListener interface {
+	noteChanged(event NoteEvent)
+}
+
+// Listeners is the set of everything which is told about changes to notes
+type Listeners []NoteListener
+
+func (listeners Listeners) publish(event NoteEvent) {
+	for _, listener := range listeners {
+		listener.noteChanged(event)
+	}
+}
+
+// builds an event for a change made by an http request
+func newNoteEvent(req *http.Request, action string, name string, size int) NoteEvent {
+	return NoteEvent{
+		Action:     action,
+		Name:       name,
+		User:       requestUser(req),
+		Size:       size,
+		Replicated: req.Header.Get(replicatedHeader) != "",
+	}
+}
+
+type contextKey int
+
+const (
+	// context key for the authenticated username
+	userKey contextKey = iota
+	// context key for the path of the board serving the request, see boardPrefix
+	boardKey
+	// context key set on requests for the shared notes, see sharedNotes
+	sharedKey
+	// context key for the route a request matched, see labelledRouter
+	routeKey
+)
+
+// stores the authenticated username in a request's context
+func withUser(req *http.Request, user string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userKey, user))
+}
+
+// gets the authenticated username from a request, or "" if authentication is off
+func requestUser(req *http.Request) string {
+	user, _ := req.Context().Value(userKey).(string)
+	return user
+}
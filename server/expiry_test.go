@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// each case writes a note at testEpoch, then after viewed, if it isn't zero, views it,
+// and checks it's kept at keptAt and deleted at deletedAt, both since testEpoch; a zero deletedAt means never
+func TestNotesExpireOnTime(t *testing.T) {
+	cases := []struct {
+		name      string
+		args      []string
+		note      string
+		viewed    time.Duration
+		keptAt    time.Duration
+		deletedAt time.Duration
+	}{
+		{"unviewed", []string{"-note-expiry", "1"}, "note", 0, 24 * time.Hour, 24*time.Hour + time.Second},
+		{"viewed", []string{"-note-expiry", "1"}, "note", 12 * time.Hour, 36 * time.Hour, 36*time.Hour + time.Second},
+		{"viewed, by creation", []string{"-note-expiry", "1", "-expiry-policy", "created"}, "note", 12 * time.Hour, 24 * time.Hour, 24*time.Hour + time.Second},
+		{"never viewed", []string{"-note-expiry", "0", "-unviewed-expiry", "2h"}, "note", 0, 2 * time.Hour, 2*time.Hour + time.Second},
+		{"viewed, so not unviewed", []string{"-note-expiry", "0", "-unviewed-expiry", "2h"}, "note", time.Hour, 1000 * time.Hour, 0},
+		{"retention rule", []string{"-note-expiry", "1", "-retention", "tmp-=1h"}, "tmp-note", 0, time.Hour, time.Hour + time.Second},
+		{"pinned", []string{"-note-expiry", "1", "-retention", "keep-=never"}, "keep-note", 0, 1000 * time.Hour, 0},
+		{"no expiry", []string{"-note-expiry", "0"}, "note", 0, 1000 * time.Hour, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := testConfig(t, c.args...)
+			datastore, clock := testDatastore(t, config)
+			expiry := config.expiry()
+			maintenance := NewMaintenance(datastore)
+			if _, err := datastore.setNote(c.note, []byte("body"), false); err != nil {
+				t.Fatal(err)
+			}
+			expireAt := func(at time.Duration) bool {
+				t.Helper()
+				clock.Advance(testEpoch.Add(at).Sub(clock.Now()))
+				if _, err := maintenance.expire(context.Background(), expiry, nil); err != nil {
+					t.Fatal(err)
+				}
+				exists, err := datastore.noteExists(c.note)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return !exists
+			}
+			if c.viewed != 0 {
+				clock.Advance(c.viewed)
+				if _, _, err := datastore.getNote(c.note); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if expireAt(c.keptAt) {
+				t.Fatalf("deleted %s after testEpoch, want kept", c.keptAt)
+			}
+			if c.deletedAt != 0 && !expireAt(c.deletedAt) {
+				t.Fatalf("kept %s after testEpoch, want deleted", c.deletedAt)
+			}
+		})
+	}
+}
+
+// the cleanup loop cleans up once an interval of the datastore's clock has passed, and stops when shut down
+func TestCleanupLoop(t *testing.T) {
+	config := testConfig(t, "-note-expiry", "1")
+	datastore, clock := testDatastore(t, config)
+	maintenance := NewMaintenance(datastore)
+	for _, name := range []string{"first", "second"} {
+		if _, err := datastore.setNote(name, []byte("body"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go maintenance.runCleanup(time.Hour, config.expiry)
+	// the cleanup ticker and the one flushing views
+	clock.waitForTickers(t, 2)
+
+	clock.Advance(59 * time.Minute)
+	if _, ok := maintenance.lastCleanupRun(); ok {
+		t.Fatal("cleaned up before the interval passed")
+	}
+	clock.Advance(24 * time.Hour)
+	run := waitForCleanup(t, maintenance, time.Time{})
+	if run.Deleted != 2 || run.Error != "" {
+		t.Errorf("the first cleanup: got %+v, want 2 notes deleted", run)
+	}
+
+	if _, err := datastore.setNote("third", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+	maintenance.shutdown()
+	clock.Advance(48 * time.Hour)
+	// a cleanup still running would have finished before shutdown returned
+	if last, _ := maintenance.lastCleanupRun(); last.Time != run.Time {
+		t.Errorf("cleaned up at %v after shutting down", last.Time)
+	}
+	if exists, err := datastore.noteExists("third"); err != nil || !exists {
+		t.Errorf("third: exists %v, %v after shutting down, want kept", exists, err)
+	}
+}
+
+// waits for a cleanup later than after to be recorded, and returns it
+func waitForCleanup(t *testing.T, maintenance *Maintenance, after time.Time) CleanupRun {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if run, ok := maintenance.lastCleanupRun(); ok && run.Time.After(after) {
+			return run
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no cleanup ran")
+	return CleanupRun{}
+}
+
+// notes are warned about once, a warning window before they'd be deleted, by the same rules as deleting them;
+// each case writes its notes at testEpoch, then runs a cleanup at each step's time since testEpoch, after viewing the step's notes
+func TestExpiryWarnings(t *testing.T) {
+	type step struct {
+		at         time.Duration
+		view       []string
+		wantWarned []string
+		wantKept   []string
+	}
+	cases := []struct {
+		name  string
+		args  []string
+		notes []string
+		steps []step
+	}{
+		{"warned, then expired", []string{"-note-expiry", "1"}, []string{"note"}, []step{
+			{21*time.Hour + 59*time.Minute, nil, nil, []string{"note"}},
+			{22*time.Hour + time.Second, nil, []string{"note"}, []string{"note"}},
+			// once is enough
+			{23 * time.Hour, nil, nil, []string{"note"}},
+			{24*time.Hour + time.Second, nil, nil, nil},
+		}},
+		{"warned, then viewed", []string{"-note-expiry", "1"}, []string{"note"}, []step{
+			{22*time.Hour + time.Second, nil, []string{"note"}, []string{"note"}},
+			{24*time.Hour + time.Second, []string{"note"}, nil, []string{"note"}},
+			// warned again, a day after it was viewed
+			{46*time.Hour + 2*time.Second, nil, []string{"note"}, []string{"note"}},
+			{48*time.Hour + 2*time.Second, nil, nil, nil},
+		}},
+		{"viewed, by creation", []string{"-note-expiry", "1", "-expiry-policy", "created"}, []string{"note"}, []step{
+			{12 * time.Hour, []string{"note"}, nil, []string{"note"}},
+			{22*time.Hour + time.Second, nil, []string{"note"}, []string{"note"}},
+			{24*time.Hour + time.Second, nil, nil, nil},
+		}},
+		{"unviewed expiry", []string{"-note-expiry", "0", "-unviewed-expiry", "3h"}, []string{"unviewed", "viewed"}, []step{
+			{30 * time.Minute, []string{"viewed"}, nil, []string{"unviewed", "viewed"}},
+			{time.Hour + time.Second, nil, []string{"unviewed"}, []string{"unviewed", "viewed"}},
+			{3*time.Hour + time.Second, nil, nil, []string{"viewed"}},
+		}},
+		{"pinned", []string{"-note-expiry", "1", "-retention", "keep-=never"}, []string{"keep-note", "note"}, []step{
+			{22*time.Hour + time.Second, nil, []string{"note"}, []string{"keep-note", "note"}},
+			{24*time.Hour + time.Second, nil, nil, []string{"keep-note"}},
+			{1000 * time.Hour, nil, nil, []string{"keep-note"}},
+		}},
+		{"no expiry", []string{"-note-expiry", "0"}, []string{"note"}, []step{
+			{1000 * time.Hour, nil, nil, []string{"note"}},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := testConfig(t, c.args...)
+			config.ExpiryWarning = 2 * time.Hour
+			datastore, clock := testDatastore(t, config)
+			expiry := config.expiry()
+			maintenance := NewMaintenance(datastore)
+			var warned []string
+			maintenance.warnExpiring = func(notes []NoteInfo, within time.Duration) {
+				if within != config.ExpiryWarning {
+					t.Errorf("warned about notes expiring within %s, want %s", within, config.ExpiryWarning)
+				}
+				for _, note := range notes {
+					warned = append(warned, note.Name)
+				}
+			}
+			for _, name := range c.notes {
+				if _, err := datastore.setNote(name, []byte("body"), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, step := range c.steps {
+				clock.Advance(testEpoch.Add(step.at).Sub(clock.Now()))
+				for _, name := range step.view {
+					if _, _, err := datastore.getNote(name); err != nil {
+						t.Fatal(err)
+					}
+				}
+				warned = nil
+				if _, err := maintenance.expire(context.Background(), expiry, nil); err != nil {
+					t.Fatal(err)
+				}
+				maintenance.warn(expiry)
+				if !equalStrings(warned, step.wantWarned) {
+					t.Errorf("at %s: warned about %v, want %v", step.at, warned, step.wantWarned)
+				}
+				var kept []string
+				for _, name := range c.notes {
+					exists, err := datastore.noteExists(name)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if exists {
+						kept = append(kept, name)
+					}
+				}
+				if !equalStrings(kept, step.wantKept) {
+					t.Errorf("at %s: kept %v, want %v", step.at, kept, step.wantKept)
+				}
+			}
+		})
+	}
+}
+
+// whether a and b hold the same strings in the same order, taking nil to be empty
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// exporting a note, deleting it and importing the export gives back the same note: its bytes, metadata and times,
+// so exporting it again gives the same document
+func TestExportRoundTrip(t *testing.T) {
+	app, clock := testApp(t, testConfig(t))
+	handler := app.Router()
+	notes := []struct {
+		name string
+		// what the note is created with
+		query        string
+		body         []byte
+		wantEncoding string
+	}{
+		{"todo", "?title=Things+to+do", []byte("milk\neggs\n"), ENCODING_UTF8},
+		{"hidden", "?unlisted=true", []byte("ünïcödé and a tab\t"), ENCODING_UTF8},
+		{"blob", "", []byte{0x00, 0xff, 0xfe, 'b', 'i', 'n', 0x80, '\n'}, ENCODING_BASE64},
+		{"readme.md", "", []byte("# heading\n\n*text*\n"), ENCODING_UTF8},
+	}
+	for _, note := range notes {
+		if resp := serveRequest(t, handler, http.MethodPut, "/api/note/"+note.name+note.query, string(note.body)); resp.Code != http.StatusCreated {
+			t.Fatalf("%s: creating the note: got %d %q", note.name, resp.Code, resp.Body)
+		}
+		// so each note has its own times
+		clock.Advance(time.Hour)
+	}
+	// moves todo's modify_time, and everyone's last_viewed
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo?title=Things+to+do", "milk\neggs\nbread\n"); resp.Code != http.StatusOK {
+		t.Fatalf("changing todo: got %d", resp.Code)
+	}
+	notes[0].body = []byte("milk\neggs\nbread\n")
+	clock.Advance(time.Hour)
+	for _, note := range notes {
+		serveRequest(t, handler, http.MethodGet, "/api/note/"+note.name, "")
+	}
+	if _, err := app.datastore.flushViews(); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+
+	for i, note := range notes {
+		path := "/api/note/" + note.name
+		exported := serveRequest(t, handler, http.MethodGet, path+"/export", "")
+		if exported.Code != http.StatusOK {
+			t.Fatalf("%s: exporting: got %d %q", note.name, exported.Code, exported.Body)
+		}
+		var document ExportedNote
+		if err := json.Unmarshal(exported.Body.Bytes(), &document); err != nil {
+			t.Fatalf("%s: the export %q isn't json: %v", note.name, exported.Body, err)
+		}
+		if document.Encoding != note.wantEncoding {
+			t.Errorf("%s: exported as %s, want %s", note.name, document.Encoding, note.wantEncoding)
+		}
+		body := []byte(document.Body)
+		if document.Encoding == ENCODING_BASE64 {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(document.Body); err != nil {
+				t.Fatalf("%s: %q isn't base64: %v", note.name, document.Body, err)
+			}
+		}
+		if !bytes.Equal(body, note.body) || document.Size != len(note.body) || document.Hash != hashBody(note.body) {
+			t.Errorf("%s: exported %q, of size %d and hash %s, want %q", note.name, body, document.Size, document.Hash, note.body)
+		}
+
+		created, viewed := testEpoch.Add(time.Duration(i)*time.Hour), testEpoch.Add(5*time.Hour)
+		if !document.CreateTime.Equal(created) || !document.LastViewed.Equal(viewed) {
+			t.Errorf("%s: exported as created %v and viewed %v, want %v and %v", note.name, document.CreateTime, document.LastViewed, created, viewed)
+		}
+
+		if resp := serveRequest(t, handler, http.MethodDelete, path, ""); resp.Code != http.StatusOK {
+			t.Fatalf("%s: deleting: got %d", note.name, resp.Code)
+		}
+		clock.Advance(time.Hour)
+		if resp := serveRequest(t, handler, http.MethodPut, path+"/export", exported.Body.String()); resp.Code != http.StatusCreated {
+			t.Fatalf("%s: importing: got %d %q", note.name, resp.Code, resp.Body)
+		}
+		again := serveRequest(t, handler, http.MethodGet, path+"/export", "")
+		if again.Body.String() != exported.Body.String() {
+			t.Errorf("%s: exported\n%s\nthen after importing\n%s", note.name, exported.Body, again.Body)
+		}
+		if raw := serveRequest(t, handler, http.MethodGet, path, ""); !bytes.Equal(raw.Body.Bytes(), note.body) {
+			t.Errorf("%s: imported %q, want %q", note.name, raw.Body, note.body)
+		}
+	}
+}
+
+// an import whose body doesn't match its hash, or isn't in the encoding it says, is refused, and changes nothing
+func TestImportErrors(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "original"); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", resp.Code)
+	}
+	cases := []struct {
+		name       string
+		document   string
+		wantStatus int
+		wantCode   string
+	}{
+		{"not json", "milk", http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"hash mismatch", `{"body":"changed","hash":"` + hashBody([]byte("other")) + `"}`, http.StatusUnprocessableEntity, ERR_HASH_MISMATCH},
+		{"bad base64", `{"body":"not base64!","encoding":"base64"}`, http.StatusBadRequest, ERR_BAD_REQUEST},
+		{"unknown encoding", `{"body":"changed","encoding":"rot13"}`, http.StatusBadRequest, ERR_BAD_REQUEST},
+	}
+	for _, c := range cases {
+		resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo/export", c.document)
+		if resp.Code != c.wantStatus || errorCode(t, resp) != c.wantCode {
+			t.Errorf("%s: got %d %q, want %d %s", c.name, resp.Code, resp.Body, c.wantStatus, c.wantCode)
+		}
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/todo", ""); resp.Body.String() != "original" {
+		t.Errorf("todo says %q, want original", resp.Body)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// how many of the newest notes a feed lists
+const feedSize = 20
+
+// how much of each note's body a feed shows
+const feedExcerptSize = 500
+
+// FeedEntry is a note as a feed lists it
+type FeedEntry struct {
+	NoteInfo
+	URL string
+	// the beginning of the note's body, or a description of it if it isn't text
+	Excerpt string
+}
+
+// the newest listed notes the request may see, with their excerpts
+// every kind of feed is built from these, so they list the same notes in the same way
+func feedEntries(datastore Datastore, req *http.Request, baseURL string) ([]FeedEntry, error) {
+	notes, err := datastore.getNewestNotes(feedSize)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]FeedEntry, 0, len(notes))
+	for _, note := range notes {
+		// reading a note's excerpt into a feed isn't a view of it
+		prefix, size, exists, err := datastore.peekNotePrefix(note.Name, feedExcerptSize)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			// deleted since it was listed
+			continue
+		}
+		entries = append(entries, FeedEntry{
+			NoteInfo: note,
+			URL:      noteURL(requestBaseURL(baseURL, req)+boardPrefix(req), note.Name),
+			Excerpt:  feedExcerpt(note, prefix, size),
+		})
+	}
+	return entries, nil
+}
+
+// the beginning of a note's body, ending with "…" if there's more
+// binaries are described instead, since they can't be shown as text
+func feedExcerpt(note NoteInfo, prefix []byte, size int64) string {
+	truncated := size > int64(len(prefix))
+	if !validUTF8Prefix(prefix, truncated) {
+		contentType := note.ContentType
+		if contentType == "" {
+			contentType = "binary data"
+		}
+		return fmt.Sprintf("%d bytes of %s", size, contentType)
+	}
+	for !utf8.Valid(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	excerpt := strings.TrimPrefix(string(prefix), string(utf8BOM))
+	if truncated {
+		excerpt += "…"
+	}
+	return excerpt
+}
+
+// JSONFeed is a JSON Feed, version 1.1; see https://jsonfeed.org/version/1.1
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+// JSONFeedItem is a note in a JSONFeed
+type JSONFeedItem struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	ContentText string `json:"content_text"`
+	// when the note was created, and its contents last changed
+	DatePublished time.Time `json:"date_published"`
+	DateModified  time.Time `json:"date_modified"`
+}
+
+// responds with the newest listed notes as a JSON Feed, titled title
+func NotesFeed(datastore Datastore, title string, baseURL string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		entries, err := feedEntries(datastore, req, baseURL)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing notes for feed: %v", err)
+			return
+		}
+		home := requestBaseURL(baseURL, req) + boardPrefix(req) + "/"
+		feed := JSONFeed{
+			Version:     "https://jsonfeed.org/version/1.1",
+			Title:       title,
+			HomePageURL: home,
+			FeedURL:     home + "feed.json",
+			Items:       make([]JSONFeedItem, 0, len(entries)),
+		}
+		for _, entry := range entries {
+			itemTitle := entry.Title
+			if itemTitle == "" {
+				itemTitle = entry.Name
+			}
+			feed.Items = append(feed.Items, JSONFeedItem{
+				ID:            entry.URL,
+				URL:           entry.URL,
+				Title:         itemTitle,
+				ContentText:   entry.Excerpt,
+				DatePublished: entry.CreateTime.UTC(),
+				DateModified:  entry.ModifyTime.UTC(),
+			})
+		}
+		resp.Header().Set("Content-Type", "application/feed+json")
+		err = json.NewEncoder(resp).Encode(feed)
+		if err != nil {
+			log.Printf("responding with feed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// how long fetching a url may take, from connecting to reading the last byte
+const fetchTimeout = 10 * time.Second
+
+// largest file fetched into a note, in bytes
+const maxFetchSize = 4 << 20
+
+// most redirects followed when fetching a url
+const maxFetchRedirects = 5
+
+// the key of a note's meta recording the url it was fetched from
+const META_SOURCE = "source"
+
+// types of files which may be fetched into a note, besides text/*
+var fetchableTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/yaml":       true,
+	"application/x-yaml":     true,
+	"application/toml":       true,
+	"application/javascript": true,
+	"application/x-sh":       true,
+}
+
+// networks which are fetched from only with -allow-internal-fetch:
+// loopback, link-local, private and other networks which aren't on the public internet
+var internalNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// whether an address isn't on the public internet
+func internalAddress(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, network := range internalNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// a fetch which was refused because of where it would go
+type fetchRefusedError struct {
+	reason string
+}
+
+func (e fetchRefusedError) Error() string {
+	return e.reason
+}
+
+// a fetch which failed because of what it got back
+type fetchFailedError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e fetchFailedError) Error() string {
+	return e.message
+}
+
+// Fetcher fetches files from urls given by users into notes
+// unless internal fetches are allowed, it refuses to connect to addresses which aren't on the public internet,
+// checking each address it actually connects to, so neither redirects nor dns can lead it astray
+type Fetcher struct {
+	client *http.Client
+}
+
+func NewFetcher(allowInternal bool) *Fetcher {
+	dialer := &net.Dialer{Timeout: fetchTimeout}
+	if !allowInternal {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || internalAddress(ip) {
+				return fetchRefusedError{fmt.Sprintf("%s isn't on the public internet; see -allow-internal-fetch", host)}
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		// a proxy would be connected to instead of the url's host, so it couldn't be checked
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   fetchTimeout,
+		ResponseHeaderTimeout: fetchTimeout,
+		MaxIdleConns:          4,
+		IdleConnTimeout:       time.Minute,
+	}
+	return &Fetcher{client: &http.Client{
+		Transport: transport,
+		Timeout:   fetchTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fetchRefusedError{"too many redirects"}
+			}
+			return checkFetchURL(req.URL)
+		},
+	}}
+}
+
+// refuses urls which aren't http or https
+func checkFetchURL(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fetchRefusedError{"only http and https urls can be fetched"}
+	}
+	if target.Host == "" {
+		return fetchRefusedError{"the url has no host"}
+	}
+	if target.User != nil {
+		return fetchRefusedError{"urls with credentials can't be fetched"}
+	}
+	return nil
+}
+
+// fetches a text file
+func (f *Fetcher) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fetchRefusedError{"the url isn't valid"}
+	}
+	if err := checkFetchURL(target); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, fetchRefusedError{"the url isn't valid"}
+	}
+	req.Header.Set("User-Agent", "corkboard/"+corkboardVersion)
+	resp, err := f.client.Do(req)
+	if err != nil {
+		var refused fetchRefusedError
+		if errors.As(err, &refused) {
+			return nil, refused
+		}
+		return nil, fetchFailedError{http.StatusBadGateway, ERR_FETCH_FAILED, "fetching the url failed: " + err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fetchFailedError{http.StatusBadGateway, ERR_FETCH_FAILED, "fetching the url failed: " + resp.Status}
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "text/") && !fetchableTypes[mediaType] {
+		return nil, fetchFailedError{http.StatusUnsupportedMediaType, ERR_FETCH_FAILED,
+			fmt.Sprintf("the url is %q, which isn't text", mediaType)}
+	}
+	if resp.ContentLength > maxFetchSize {
+		return nil, fetchFailedError{http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE,
+			"the file is larger than " + strconv.Itoa(maxFetchSize) + " bytes"}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize+1))
+	if err != nil {
+		return nil, fetchFailedError{http.StatusBadGateway, ERR_FETCH_FAILED, "fetching the url failed: " + err.Error()}
+	}
+	if len(body) > maxFetchSize {
+		return nil, fetchFailedError{http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE,
+			"the file is larger than " + strconv.Itoa(maxFetchSize) + " bytes"}
+	}
+	return body, nil
+}
+
+// creates a note from the file at the url query parameter, recording the url in the note's meta
+// if refresh is "true", an existing note is fetched again from the url, or from the url in its meta if none is
+// given, and updated if its contents have changed; otherwise an existing note is a conflict
+// responds 201 if the note was created, 200 if it was updated, and 204 if it was refreshed but hadn't changed
+func FetchNote(datastore Datastore, fetcher *Fetcher, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		if err := datastore.validateNoteName(noteName); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+			return
+		}
+		refresh := req.URL.Query().Get("refresh") == "true"
+		source := req.URL.Query().Get("url")
+		info, exists, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if exists && !refresh {
+			APIError(resp, http.StatusConflict, ERR_NOTE_EXISTS)
+			return
+		}
+		if source == "" && exists {
+			source = info.Meta.text(META_SOURCE)
+		}
+		if source == "" {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "the url query parameter is missing")
+			return
+		}
+		if len(source) > maxLinkSize {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "the url is too long")
+			return
+		}
+
+		body, err := fetcher.fetch(req.Context(), source)
+		var refused fetchRefusedError
+		var failed fetchFailedError
+		switch {
+		case errors.As(err, &refused):
+			APIErrorMessage(resp, http.StatusForbidden, ERR_FORBIDDEN, refused.Error())
+			return
+		case errors.As(err, &failed):
+			APIErrorMessage(resp, failed.status, failed.code, failed.message)
+			return
+		case err != nil:
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("fetching %s into %s: %v", source, noteName, err)
+			return
+		}
+
+		hash := hashBody(body)
+		if exists {
+			oldHash, _, err := datastore.getNoteHash(noteName)
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("accessing %s: %v", noteName, err)
+				return
+			}
+			if oldHash == hash {
+				resp.Header().Set(hashHeader, hash)
+				resp.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		status, err := datastore.setNoteWithHash(noteName, body, hash, refresh, NoteOptions{ContentType: sniffContentType(noteName, body)})
+		if err == nil && status != NO_CLOBBER {
+			meta := NoteMeta{}
+			for key, value := range info.Meta {
+				meta[key] = value
+			}
+			meta[META_SOURCE] = source
+			_, err = datastore.setNoteMeta(noteName, meta)
+		}
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s fetched from %s: %v", noteName, source, err)
+			return
+		}
+		if status == NO_CLOBBER {
+			// created by someone else while the url was being fetched
+			APIError(resp, http.StatusConflict, ERR_NOTE_EXISTS)
+			return
+		}
+		resp.Header().Set(hashHeader, hash)
+		if status == CREATED {
+			listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+			log.Printf("New note %s fetched from %s", noteName, source)
+			ErrorPage(resp, http.StatusCreated)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(body)))
+		log.Printf("Updated note %s from %s", noteName, source)
+	}
+}
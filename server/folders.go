@@ -0,0 +1,118 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Folder is the part of some notes' names before their first '/', and how many notes are in it
+type Folder struct {
+	// "" for the notes whose names have no '/'
+	Name  string
+	Count int64
+}
+
+// FoldersData is passed to the folders.html template
+type FoldersData struct {
+	PageData
+	Folders []Folder
+	// the folder opened, whose notes are listed, if Open is set
+	Open   bool
+	Opened string
+	Notes  []NoteInfo
+}
+
+// the folders the listed notes the datastore sees are in, in order of name, with the root group first
+// the grouping is done by the database, so the notes' names aren't all read
+func (ds *Datastore) listFolders() (_ []Folder, err error) {
+	defer ds.metrics.observe("listFolders", time.Now(), &err)
+	folders := make([]Folder, 0)
+	clause, args := ds.listed(ds.scope())
+	// a private note's name follows its owner's in the key, and sqlite counts characters, not bytes
+	start := utf8.RuneCountInString(ds.key("")) + 1
+	rows, err := ds.read().Query(`select case when instr(rest, '/') = 0 then '' else substr(rest, 1, instr(rest, '/') - 1) end as folder, count(*)
+		from (select substr(name, ?) as rest from "note" where `+clause+`)
+		group by folder order by folder asc`, append([]interface{}{start}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var folder Folder
+		if err := rows.Scan(&folder.Name, &folder.Count); err != nil {
+			return folders, err
+		}
+		folders = append(folders, folder)
+	}
+	err = rows.Err()
+	return folders, err
+}
+
+// lists the notes in a folder, in order of name, leaving out unlisted notes
+// the root group, "", has the notes whose names have no '/'
+func (ds *Datastore) listFolderNotes(folder string) (_ []NoteInfo, err error) {
+	if folder != "" {
+		return ds.listNotesWithPrefix(folder + "/")
+	}
+	defer ds.metrics.observe("listFolderNotes", time.Now(), &err)
+	notes := make([]NoteInfo, 0)
+	clause, args := ds.listed(ds.scope())
+	start := utf8.RuneCountInString(ds.key("")) + 1
+	rows, err := ds.read().Query(selectNoteInfo+` where `+clause+` and instr(substr(name, ?), '/') = 0 order by name asc`, append(args, start)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		note, err := scanNoteInfo(rows)
+		if err != nil {
+			return notes, err
+		}
+		note.Name = ds.unkey(note.Name)
+		notes = append(notes, note)
+	}
+	err = rows.Err()
+	return notes, err
+}
+
+// lists the notes grouped into folders by the part of their names before the first '/', for GET /notes?view=tree
+// the folder query parameter opens a folder, listing its notes; it's empty for the root group
+// any other view is the index's
+func Folders(templates *template.Template, pages Pages, datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		query := req.URL.Query()
+		if query.Get("view") != "tree" {
+			http.Redirect(resp, req, boardPrefix(req)+"/", http.StatusFound)
+			return
+		}
+		folders, err := datastore.listFolders()
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("listing folders: %v", err)
+			return
+		}
+		data := FoldersData{PageData: pages.data(req), Folders: folders}
+		if opened, ok := query["folder"]; ok {
+			data.Open = true
+			data.Opened = opened[0]
+			data.Notes, err = datastore.listFolderNotes(data.Opened)
+			if err != nil {
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("listing folder %s: %v", data.Opened, err)
+				return
+			}
+		}
+		resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		err = templates.ExecuteTemplate(resp, "folders.html", data)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("writing template: %v", err)
+		}
+	}
+}
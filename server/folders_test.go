@@ -0,0 +1,62 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListFolders(t *testing.T) {
+	config := testConfig(t)
+	config.PrivateNotes = true
+	shared, _ := testDatastore(t, config)
+	// an owner whose name isn't all ascii, since sqlite's substr counts characters
+	mine := shared
+	mine.owner = "zoë"
+	for _, name := range []string{"todo", "work/plan", "work/notes", "work/2026/q4", "recipes/bread", "recipes/hidden"} {
+		if _, err := mine.setNote(name, []byte("body"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := mine.setUnlisted("recipes/hidden", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.setNote("lobby", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	folders, err := mine.listFolders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Folder{{"", 1}, {"recipes", 1}, {"work", 3}}
+	if !reflect.DeepEqual(folders, want) {
+		t.Errorf("folders: got %v, want %v", folders, want)
+	}
+	folders, err = shared.listFolders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []Folder{{"", 1}}; !reflect.DeepEqual(folders, want) {
+		t.Errorf("shared folders: got %v, want %v", folders, want)
+	}
+
+	cases := map[string][]string{
+		"":        {"todo"},
+		"work":    {"work/2026/q4", "work/notes", "work/plan"},
+		"recipes": {"recipes/bread"},
+		"missing": {},
+	}
+	for folder, want := range cases {
+		notes, err := mine.listFolderNotes(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(notes))
+		for i, note := range notes {
+			got[i] = note.Name
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("folder %q: got %v, want %v", folder, got, want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the field of a web form holding its one-time token; see claimForm
+const formTokenField = "form-token"
+
+// stands in for the form token in the cached index page, and is replaced with a new token each time it's served
+// it's random, so nothing else on the page can be mistaken for it
+var formTokenPlaceholder = newFormToken()
+
+// a new one-time token for a web form
+func newFormToken() string {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
+
+// SubmittedData is passed to the submitted.html template
+type SubmittedData struct {
+	PageData
+	// the note the form was already submitted for, and where to see it
+	Note string
+	Link string
+}
+
+// claims a web form's one-time token for the note it creates or comments on, so it's only submitted once
+// if it was already submitted, responds 409 with a page linking to the note it was submitted for
+// a form without a token is let through, since it's from a client which doesn't use them
+// returns whether the request should go on; if it fails after this, it must release the token
+func claimForm(resp http.ResponseWriter, req *http.Request, templates *template.Template, pages Pages, datastore Datastore, token string, note string, fragment string) bool {
+	if token == "" {
+		return true
+	}
+	submitted, claimed, err := datastore.claimFormToken(token, note)
+	if err != nil {
+		ErrorPage(resp, http.StatusInternalServerError)
+		log.Printf("claiming form token for %s: %v", note, err)
+		return false
+	}
+	if claimed {
+		return true
+	}
+	log.Printf("Refused submitting a form for %s again", submitted)
+	resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	resp.WriteHeader(http.StatusConflict)
+	err = templates.ExecuteTemplate(resp, "submitted.html", SubmittedData{
+		PageData: pages.data(req),
+		Note:     submitted,
+		Link:     notePath(boardPrefix(req), submitted) + fragment,
+	})
+	if err != nil {
+		log.Printf("rendering page: %v", err)
+	}
+	return false
+}
+
+// lets a web form's one-time token be submitted again, after what it was submitted for failed
+func releaseForm(datastore Datastore, token string) {
+	if token == "" {
+		return
+	}
+	err := datastore.releaseFormToken(token)
+	if err != nil {
+		log.Printf("releasing form token: %v", err)
+	}
+}
+
+// creates a note from the form on the main page, then redirects to it with 303,
+// so reloading the page it lands on doesn't submit the form again
+// the form's fields are the note's name, body and title, and a template to fill in instead of the body
+// a form submitted again with the same token is answered with a link to the note it created; see claimForm
+func CreateFromForm(templates *template.Template, pages Pages, datastore Datastore, policy WritePolicy, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		err := req.ParseForm()
+		if err != nil {
+			ErrorMessage(resp, http.StatusBadRequest, "the form couldn't be read")
+			return
+		}
+		noteName := req.PostForm.Get("name")
+		if err := datastore.validateNoteName(noteName); err != nil {
+			ErrorMessage(resp, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !datastore.permits(noteName, ACCESS_WRITE) {
+			ErrorPage(resp, http.StatusForbidden)
+			return
+		}
+		title := strings.TrimSpace(req.PostForm.Get("title"))
+		if err := validateNoteTitle(title); err != nil {
+			ErrorMessage(resp, http.StatusBadRequest, err.Error())
+			return
+		}
+		body := []byte(req.PostForm.Get("body"))
+		if templateName := req.PostForm.Get("template"); templateName != "" {
+			templateBody, ok, err := datastore.peekNote(noteTemplatePrefix + templateName)
+			if err != nil {
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("accessing template %s: %v", templateName, err)
+				return
+			}
+			if !ok || !datastore.permits(noteTemplatePrefix+templateName, ACCESS_READ) {
+				ErrorMessage(resp, http.StatusNotFound, "there's no template named "+templateName)
+				return
+			}
+			body = fillNoteTemplate(templateBody, map[string]string{"name": noteName}, time.Now())
+		}
+		if emptyBody(body) {
+			ErrorMessage(resp, http.StatusBadRequest, "the note is empty")
+			return
+		}
+		hash := hashBody(body)
+		token := req.PostForm.Get(formTokenField)
+		if !claimForm(resp, req, templates, pages, datastore, token, noteName, "") {
+			return
+		}
+		if policy.RejectDuplicates {
+			duplicate, found, err := datastore.findDuplicate(hash, noteName)
+			if err != nil {
+				releaseForm(datastore, token)
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("looking for duplicates of %s: %v", noteName, err)
+				return
+			}
+			if found {
+				releaseForm(datastore, token)
+				ErrorMessage(resp, http.StatusConflict, "the note has the same contents as "+duplicate)
+				return
+			}
+		}
+		status, err := datastore.setNoteWithHash(noteName, body, hash, false, NoteOptions{Title: title, ContentType: sniffContentType(noteName, body)})
+		if message, ok := rejectionMessage(err); ok {
+			releaseForm(datastore, token)
+			ErrorMessage(resp, rejectionStatus(err), message)
+			return
+		}
+		if err != nil {
+			releaseForm(datastore, token)
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		if status == NO_CLOBBER {
+			releaseForm(datastore, token)
+			ErrorMessage(resp, http.StatusConflict, "there's already a note named "+noteName)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+		log.Printf("New note %s", noteName)
+		http.Redirect(resp, req, notePath(boardPrefix(req), noteName), http.StatusSeeOther)
+	}
+}
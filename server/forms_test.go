@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+var formTokenPattern = regexp.MustCompile(`name="form-token" value="([0-9a-f]+)"`)
+
+// a client for forms, which doesn't follow redirects, so the 303s can be seen
+func formClient(s *servertest.Server) *http.Client {
+	client := *s.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &client
+}
+
+// the form token on the page at path
+func formToken(t *testing.T, s *servertest.Server, path string) string {
+	t.Helper()
+	status, page := s.Do(t, http.MethodGet, path, "")
+	match := formTokenPattern.FindStringSubmatch(page)
+	if status != http.StatusOK || match == nil {
+		t.Fatalf("%s: got %d with no form token", path, status)
+	}
+	return match[1]
+}
+
+// submits a form, and returns the response's status, Location and body
+func submit(t *testing.T, client *http.Client, url string, form url.Values) (int, string, string) {
+	t.Helper()
+	resp, err := client.PostForm(url, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get("Location"), string(body)
+}
+
+// submitting the form on the main page again, e.g. by reloading the page it lands on, doesn't write the note twice
+func TestFormDoubleSubmit(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	client := formClient(s)
+	token := formToken(t, s, "/")
+	form := url.Values{"name": {"todo"}, "body": {"first"}, "form-token": {token}}
+	if status, location, body := submit(t, client, s.URL+"/new", form); status != http.StatusSeeOther || location != "/note/todo" {
+		t.Fatalf("submitting: got %d to %q, %q, want 303 to /note/todo", status, location, body)
+	}
+
+	form.Set("body", "second")
+	status, _, page := submit(t, client, s.URL+"/new", form)
+	if status != http.StatusConflict || !strings.Contains(page, "Already submitted") || !strings.Contains(page, `href="/note/todo"`) {
+		t.Errorf("submitting again: got %d %q, want 409 linking to the note", status, page)
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/todo", ""); status != http.StatusOK || body != "first" {
+		t.Errorf("the note: got %d %q, want it as first submitted", status, body)
+	}
+
+	// a new token for the same name is an ordinary conflict
+	form.Set("form-token", formToken(t, s, "/"))
+	if status, _, page := submit(t, client, s.URL+"/new", form); status != http.StatusConflict || !strings.Contains(page, "there's already a note named todo") {
+		t.Errorf("submitting a new form for todo: got %d %q, want 409 saying it exists", status, page)
+	}
+	// which doesn't use the token up, so it can be submitted again with another name
+	form.Set("name", "done")
+	if status, location, body := submit(t, client, s.URL+"/new", form); status != http.StatusSeeOther || location != "/note/done" {
+		t.Errorf("submitting the form again for done: got %d to %q, %q, want 303 to /note/done", status, location, body)
+	}
+
+	// a form without a token is let through, since it's from a client which doesn't use them
+	form = url.Values{"name": {"untokened"}, "body": {"hello"}}
+	if status, _, body := submit(t, client, s.URL+"/new", form); status != http.StatusSeeOther {
+		t.Errorf("submitting without a token: got %d %q, want 303", status, body)
+	}
+}
+
+// the comment form on a note's page is only submitted once too
+func TestCommentFormDoubleSubmit(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	client := formClient(s)
+	if status, body := s.Do(t, http.MethodPut, "/api/note/todo", "hello"); status != http.StatusCreated {
+		t.Fatalf("creating todo: got %d %q", status, body)
+	}
+	form := url.Values{"body": {"a comment"}, "form-token": {formToken(t, s, "/note/todo")}}
+	if status, location, body := submit(t, client, s.URL+"/api/note/todo/comments", form); status != http.StatusSeeOther || !strings.HasPrefix(location, "/note/todo") {
+		t.Fatalf("commenting: got %d to %q, %q, want 303 to the note", status, location, body)
+	}
+	if status, _, page := submit(t, client, s.URL+"/api/note/todo/comments", form); status != http.StatusConflict || !strings.Contains(page, "Already submitted") {
+		t.Errorf("commenting again: got %d %q, want 409", status, page)
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/todo/comments", ""); status != http.StatusOK || strings.Count(body, "a comment") != 1 {
+		t.Errorf("the comments: got %d %q, want the comment once", status, body)
+	}
+}
@@ -0,0 +1,63 @@
+package server_test
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// any name and body make it through creating, listing, showing, reading and deleting a note
+// without breaking the server or the pages it's shown on
+func FuzzNoteRoundTrip(f *testing.F) {
+	f.Add("note", "hello")
+	f.Add("<script>alert(1)</script>", "<b>bold</b>")
+	f.Add(`"onmouseover="alert(1)`, "# heading\n\n[link](javascript:alert(1))")
+	f.Add("it's", "\x00\xff\xfe binary")
+	f.Add("100%", "  \n")
+	f.Add("a?b#c", "crlf\r\n")
+	f.Add("..", "dots")
+	f.Add("ünïcödé", "ünïcödé")
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+	s := servertest.New(f, servertest.Config(f))
+
+	f.Fuzz(func(t *testing.T, name string, body string) {
+		// pages are full of quotes and brackets, so the name needs something of its own in it to be looked for
+		name = "fz" + name
+		path := "/api/note/" + url.PathEscape(name)
+		status, response := s.Do(t, http.MethodPut, path, body)
+		if status >= 500 {
+			t.Fatalf("PUT %q: got %d %q", name, status, response)
+		}
+		if status != http.StatusCreated {
+			// refused, like an invalid name or an empty body
+			return
+		}
+		defer func() {
+			if status, response := s.Do(t, http.MethodDelete, path, ""); status != http.StatusOK {
+				t.Fatalf("DELETE %q: got %d %q", name, status, response)
+			}
+		}()
+
+		if status, raw := s.Do(t, http.MethodGet, path, ""); status != http.StatusOK || raw != body {
+			t.Fatalf("GET %q: got %d %q, want %q", name, status, raw, body)
+		}
+		// a name which html has to escape must never show up unescaped
+		unsafe := strings.ContainsAny(name, `<>"'`)
+		for _, page := range []string{"/", "/note/" + url.PathEscape(name)} {
+			status, html := s.Do(t, http.MethodGet, page, "")
+			if status != http.StatusOK {
+				t.Fatalf("GET %s after creating %q: got %d %q", page, name, status, html)
+			}
+			if unsafe && strings.Contains(html, name) {
+				t.Fatalf("GET %s shows %q unescaped", page, name)
+			}
+		}
+	})
+}
@@ -0,0 +1,2146 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// creates an http router and registers all the endpoints
+// requests are rate limited before they reach it
+// the templates the handlers render, with the data each is given, so they can be checked at startup; see validateTemplates
+// a handler rendering another template must add it here
+var pageTemplates = map[string]interface{}{
+	"folders.html":   FoldersData{},
+	"index.html":     IndexData{},
+	"note.html":      NoteData{},
+	"print.html":     PrintData{},
+	"submitted.html": SubmittedData{},
+}
+
+func makeRouter(templates *template.Template, static *StaticAssets, config Config, datastore Datastore, listeners Listeners, maintenance *Maintenance, index *IndexCache, settings *Settings, analytics *Analytics, boards []*Board) http.Handler {
+	router := labelledRouter{httprouter.New()}
+	pages := Pages{static: static, settings: settings}
+	// httprouter would redirect any request to a path with a stray slash or mistyped case,
+	// which could send an api write to a note other than the one named; notFound decides instead
+	router.RedirectTrailingSlash = false
+	router.RedirectFixedPath = false
+	router.NotFound = http.HandlerFunc(notFound)
+	writes := NewWriteLimiter(config.MaxConcurrentWrites)
+	// reads of a note outside its visibility window are refused to everyone but admins
+	visibleOnly := func(h httprouter.Handle) httprouter.Handle {
+		return VisibleOnly(h, datastore, config.Admins)
+	}
+	// with -acl-file, a note is only read and written by the users its rules allow
+	canRead := func(h httprouter.Handle) httprouter.Handle {
+		return Permitted(h, datastore, ACCESS_READ)
+	}
+	canWrite := func(h httprouter.Handle) httprouter.Handle {
+		return Permitted(h, datastore, ACCESS_WRITE)
+	}
+	// note names may contain '/', so the routes with one in them are told apart by noteRouter
+	notes := newNoteRouter(router)
+	router.GET("/", Auth(Index(templates, pages, datastore, settings, analytics, boards, index, config.StrictIndex), config.Credentials))
+	notes.GET("/go/:note", Auth(canRead(visibleOnly(GoNote(datastore, analytics, config.BaseURL))), config.Credentials))
+	notes.GET("/note/:note", Auth(canRead(visibleOnly(Note(templates, pages, datastore, settings, analytics, !config.DisableComments, config.HTMLMaxSize))), config.Credentials))
+	notes.GET("/note/:note/print", Auth(canRead(visibleOnly(PrintNote(templates, pages, datastore, analytics, config.HTMLMaxSize))), config.Credentials))
+	notes.POST("/api/note/:note", Auth(canWrite(writes.limit(SetNote(datastore, false, config.WritePolicy, listeners))), config.Credentials))
+	notes.PUT("/api/note/:note", Auth(canWrite(writes.limit(SetNote(datastore, true, config.WritePolicy, listeners))), config.Credentials))
+	notes.DELETE("/api/note/:note", Auth(canWrite(DeleteNote(datastore, listeners)), config.Credentials))
+	notes.GET("/api/note/:note", Auth(canRead(visibleOnly(RawNote(datastore, analytics))), config.Credentials))
+	router.GET("/notes", Auth(Folders(templates, pages, datastore), config.Credentials))
+	router.GET("/api/notes", Auth(ListNotes(datastore), config.Credentials))
+	router.GET("/api/changes", Auth(ListChanges(datastore), config.Credentials))
+	router.GET("/feed.json", Auth(NotesFeed(datastore, config.FeedTitle, config.BaseURL), config.Credentials))
+	router.DELETE("/api/notes", Auth(DeleteNotes(datastore, listeners), config.Credentials))
+	notes.POST("/api/note/:note/increment", Auth(canWrite(writes.limit(IncrementNote(datastore, listeners))), config.Credentials))
+	notes.POST("/api/note/:note/cas", Auth(canWrite(writes.limit(CompareAndSwapNote(datastore, listeners))), config.Credentials))
+	notes.GET("/api/note/:note/signature", Auth(canRead(visibleOnly(SignNote(datastore))), config.Credentials))
+	notes.POST("/api/note/:note/patch", Auth(canWrite(writes.limit(PatchNote(datastore, config.WritePolicy, listeners))), config.Credentials))
+	notes.POST("/api/note/:note/fetch", Auth(canWrite(writes.limit(FetchNote(datastore, NewFetcher(config.AllowInternalFetch), listeners))), config.Credentials))
+	notes.POST("/api/note/:note/from-template", Auth(canWrite(writes.limit(FromTemplate(datastore, listeners))), config.Credentials))
+	notes.POST("/api/note/:note/attachments", Auth(canWrite(writes.limit(AddAttachments(datastore))), config.Credentials))
+	notes.GET("/api/note/:note/attachments", Auth(canRead(visibleOnly(ListAttachments(datastore))), config.Credentials))
+	notes.GET("/api/note/:note/attachments/:attachment", Auth(canRead(visibleOnly(GetAttachment(datastore))), config.Credentials))
+	notes.DELETE("/api/note/:note/attachments/:attachment", Auth(canWrite(DeleteAttachment(datastore)), config.Credentials))
+	if !config.DisableComments {
+		notes.POST("/api/note/:note/comments", Auth(canWrite(writes.limit(AddComment(templates, pages, datastore))), config.Credentials))
+		notes.GET("/api/note/:note/comments", Auth(canRead(visibleOnly(ListComments(datastore))), config.Credentials))
+		notes.DELETE("/api/note/:note/comments/:comment", Auth(canWrite(DeleteComment(datastore)), config.Credentials))
+	}
+	notes.POST("/api/note/:note/watch", Auth(canRead(writes.limit(visibleOnly(WatchNote(datastore)))), config.Credentials))
+	notes.GET("/api/note/:note/watch", Auth(canRead(visibleOnly(ListWatches(datastore, config.Admins))), config.Credentials))
+	notes.DELETE("/api/note/:note/watch/:watch", Auth(canRead(UnwatchNote(datastore, config.Admins)), config.Credentials))
+	notes.GET("/api/note/:note/lock", Auth(canRead(visibleOnly(GetLock(datastore))), config.Credentials))
+	notes.POST("/api/note/:note/lock", Auth(canWrite(writes.limit(LockNote(datastore))), config.Credentials))
+	notes.DELETE("/api/note/:note/lock", Auth(canWrite(UnlockNote(datastore)), config.Credentials))
+	notes.GET("/api/note/:note/export", Auth(canRead(visibleOnly(ExportNote(datastore))), config.Credentials))
+	notes.PUT("/api/note/:note/export", Auth(canWrite(writes.limit(ImportNote(datastore, listeners))), config.Credentials))
+	notes.GET("/api/note/:note/lines", Auth(canRead(visibleOnly(NoteLines(datastore, analytics))), config.Credentials))
+	if analytics != nil {
+		notes.GET("/api/note/:note/stats", Auth(canRead(visibleOnly(GetNoteStats(datastore))), config.Credentials))
+	}
+	notes.GET("/api/note/:note/metadata", Auth(canRead(visibleOnly(GetMetadata(datastore, settings.expiry))), config.Credentials))
+	notes.PATCH("/api/note/:note/metadata", Auth(canWrite(writes.limit(SetMetadata(datastore, listeners))), config.Credentials))
+	notes.PUT("/api/note/:note/meta", Auth(canWrite(writes.limit(SetNoteMeta(datastore))), config.Credentials))
+	notes.PUT("/api/note/:note/content-type", Auth(canWrite(writes.limit(SetContentType(datastore))), config.Credentials))
+	notes.POST("/api/note/:note/snapshot", Auth(canRead(writes.limit(visibleOnly(SnapshotNote(datastore, config.BaseURL)))), config.Credentials))
+	notes.GET("/api/note/:note/snapshots", Auth(canRead(visibleOnly(ListSnapshots(datastore, config.BaseURL))), config.Credentials))
+	notes.GET("/api/note/:note/links", Auth(canRead(visibleOnly(NoteLinks(datastore))), config.Credentials))
+	notes.GET("/api/note/:note/text", Auth(canRead(visibleOnly(NoteText(datastore, analytics))), config.Credentials))
+	router.GET("/snap/:hash", Auth(GetSnapshot(datastore), config.Credentials))
+	router.HEAD("/snap/:hash", Auth(GetSnapshot(datastore), config.Credentials))
+	router.GET("/health", Health(datastore, maintenance))
+	router.GET("/debug/vars", Auth(AdminOnly(DebugVars(), config.Admins), config.Credentials))
+	router.GET("/metrics", Auth(Metrics(datastore, config.requestStats), config.Credentials))
+	if config.requestStats != nil {
+		router.GET("/api/stats", Auth(AdminOnly(GetRequestStats(config.requestStats), config.Admins), config.Credentials))
+	}
+	router.POST("/api/admin/vacuum", Auth(AdminOnly(Vacuum(maintenance), config.Admins), config.Credentials))
+	router.GET("/api/admin/cleanup", Auth(AdminOnly(CleanupPreview(maintenance, settings.expiry), config.Admins), config.Credentials))
+	router.POST("/api/admin/cleanup", Auth(AdminOnly(Cleanup(maintenance, settings.expiry), config.Admins), config.Credentials))
+	router.PUT("/api/admin/banner", Auth(AdminOnly(SetBanner(settings), config.Admins), config.Credentials))
+	router.DELETE("/api/admin/banner", Auth(AdminOnly(DeleteBanner(settings), config.Admins), config.Credentials))
+	router.GET("/api/admin/settings", Auth(AdminOnly(GetSettings(settings), config.Admins), config.Credentials))
+	router.PUT("/api/admin/settings", Auth(AdminOnly(SetSettings(settings), config.Admins), config.Credentials))
+	router.DELETE("/api/admin/snapshots", Auth(AdminOnly(PruneSnapshots(datastore), config.Admins), config.Credentials))
+	router.DELETE("/api/admin/snapshots/:hash", Auth(AdminOnly(DeleteSnapshot(datastore), config.Admins), config.Credentials))
+	if config.PrivateNotes {
+		router.GET("/api/admin/notes", Auth(AdminOnly(ListOwnedNotes(datastore), config.Admins), config.Credentials))
+	}
+	router.POST("/new", Auth(writes.limit(CreateFromForm(templates, pages, datastore, config.WritePolicy, listeners)), config.Credentials))
+	router.POST("/", Auth(writes.limit(Paste(datastore, config.Names, config.BaseURL, config.PasteUnlisted, listeners)), config.Credentials))
+	router.POST("/paste", Auth(writes.limit(Paste(datastore, config.Names, config.BaseURL, config.PasteUnlisted, listeners)), config.Credentials))
+	router.GET("/static/*filepath", static.handle)
+	router.HEAD("/static/*filepath", static.handle)
+	var handler http.Handler = router
+	if config.PrivateNotes {
+		handler = routeShared(handler)
+	}
+	return NewRateLimits(config.RateLimits, config.Credentials, realClock{}).middleware(announceBanner(settings, guardReadOnly(settings, protectContent(handler))))
+}
+
+// responds to requests for paths with no route
+// a note's page requested with a trailing slash, like /note/foo/, is redirected to the page;
+// api paths never are, so a write to /api/note/foo/ fails rather than touching the note foo
+func notFound(resp http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		name := strings.TrimPrefix(req.URL.Path, "/note/")
+		if name != req.URL.Path && strings.HasSuffix(name, "/") {
+			name = strings.TrimRight(name, "/")
+			if name != "" {
+				location := notePath(boardPrefix(req), name)
+				if req.URL.RawQuery != "" {
+					location += "?" + req.URL.RawQuery
+				}
+				http.Redirect(resp, req, location, http.StatusMovedPermanently)
+				return
+			}
+		}
+	}
+	errorResponse(resp, req, http.StatusNotFound)
+}
+
+// basic authentication middleware
+// disabled if credentials == ""
+func Auth(h httprouter.Handle, credentials map[string]bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// Get the Basic Authentication credentials
+		user, password, hasAuth := r.BasicAuth()
+
+		_, credsValid := credentials[user+":"+password]
+		if credentials == nil {
+			// authentication is turned off
+			h(w, r, ps)
+		} else if hasAuth && credsValid {
+			// Delegate request to the given handle
+			h(w, withUser(r, user), ps)
+		} else {
+			// Request Basic Authentication otherwise
+			w.Header().Set("WWW-Authenticate", "Basic realm=Restricted")
+			discardBody(w, r)
+			errorResponse(w, r, http.StatusUnauthorized)
+		}
+	}
+}
+
+// format for displaying when a note expires
+const expiryFormat = "2006-01-02 15:04 MST"
+
+// functions available to templates
+// note names must be path-escaped in links, since they may contain characters like % and ?
+var templateFuncs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	// escapes a note's name in a path, keeping its /s
+	"escapeNoteName": escapeNoteName,
+	"notePath":       notePath,
+	// how long ago a time was, e.g. "2 hours ago"
+	"age": func(t time.Time) string { return describeAge(time.Since(t)) },
+}
+
+// PageData is shared by the data passed to every page's template
+type PageData struct {
+	// the authenticated user viewing the page, or "" if authentication is off
+	User string
+	// whether to include the operator's -custom-css and -custom-js, after the built-in assets
+	CustomCSS bool
+	CustomJS  bool
+	// the operator's message shown at the top of the page, or "" if there isn't one
+	Banner string
+	// the path of the board the page belongs to, which links are under
+	Base string
+}
+
+// Pages provides the data every page's template is given
+type Pages struct {
+	static   *StaticAssets
+	settings *Settings
+}
+
+// the data shared by every page
+func (p Pages) data(req *http.Request) PageData {
+	return PageData{
+		User:      requestUser(req),
+		Base:      boardPrefix(req),
+		CustomCSS: p.static.hasCustom("custom.css"),
+		CustomJS:  p.static.hasCustom("custom.js"),
+		Banner:    p.settings.banner(),
+	}
+}
+
+// IndexData is passed to the index.html template
+type IndexData struct {
+	PageData
+	RecentNotes []NoteInfo
+	// how often each recent note has been viewed, or nil if views aren't recorded
+	Views     map[string]int64
+	Templates []string
+	// the other boards the user can open
+	Boards []string
+	// with -private-notes, whether the page lists the shared notes rather than the user's own,
+	// and a link to the other
+	Shared     bool
+	OtherNotes string
+	// whether the notes couldn't be listed
+	Unavailable bool
+	// the create form's one-time token; see claimForm
+	FormToken string
+}
+
+// NoteData is passed to the note.html template
+type NoteData struct {
+	PageData
+	Title string
+	// shown instead of Title: the note's own title, or its name if it has none
+	Heading string
+	// the note's own title, which may be ""
+	NoteTitle string
+	// the url the note links to, if its whole body is one
+	Link string
+	// from the note's meta, for highlighters
+	Language string
+	// a preview of the note, which links to it unfurl with; "" if it isn't text
+	Description string
+	Body        string
+	// the note's lines, if it's small enough to number them
+	Lines []NoteLine
+	// whether Body is only the beginning of the note, which is TotalSize bytes long
+	Truncated bool
+	TotalSize int64
+	// when the note was created, and when its contents last changed, or "" if they haven't
+	Created string
+	Edited  string
+	Expires string
+	// the prefix of the -retention rule the note is kept under, if any
+	Retention   string
+	Unlisted    bool
+	Locked      string
+	Attachments []Attachment
+	// how many of the note's links were broken when they were last checked
+	BrokenLinks int
+	// whether comments are displayed, since they may be disabled
+	ShowComments bool
+	Comments     []Comment
+	// why the note isn't shown to anyone but admins right now, or "" if it is
+	Hidden string
+	// the comment form's one-time token; see claimForm
+	FormToken string
+}
+
+// displays index page
+// settings gives the number of recent posts to display
+// if the notes can't be listed, the page is still shown with a banner saying so, unless strict is set
+func Index(templates *template.Template, pages Pages, datastore Datastore, settings *Settings, analytics *Analytics, boards []*Board, cache *IndexCache, strict bool) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		user := requestUser(req)
+		visibleBoards := accessibleBoards(boards, req)
+		// the boards listed depend on the credentials given, not just the user,
+		// and the shared notes are listed under another path than the user's own
+		cacheKey := user + "\x00" + boardPrefix(req) + "\x00" + strings.Join(visibleBoards, "/")
+		page, err := cache.get(datastore.now(), cacheKey, func() ([]byte, error) {
+			recentNotes, err := datastore.getLatestNotes(settings.recentNotes())
+			if err != nil {
+				return nil, fmt.Errorf("getting recent posts: %v", err)
+			}
+			noteTemplates, err := datastore.listTemplates()
+			if err != nil {
+				return nil, fmt.Errorf("getting templates: %v", err)
+			}
+			var views map[string]int64
+			if analytics != nil {
+				names := make([]string, len(recentNotes))
+				for i, note := range recentNotes {
+					names[i] = note.Name
+				}
+				views, err = datastore.getViewCounts(names)
+				if err != nil {
+					return nil, fmt.Errorf("getting view counts: %v", err)
+				}
+			}
+			data := IndexData{
+				PageData:    pages.data(req),
+				RecentNotes: recentNotes,
+				Templates:   noteTemplates,
+				Boards:      visibleBoards,
+				Views:       views,
+				FormToken:   formTokenPlaceholder,
+			}
+			if datastore.private {
+				data.Shared = sharedNotes(req)
+				if data.Shared {
+					data.OtherNotes = strings.TrimSuffix(data.Base, sharedPrefix) + "/"
+				} else {
+					data.OtherNotes = data.Base + sharedPrefix + "/"
+				}
+			}
+			buf := bytes.NewBuffer(nil)
+			err = templates.ExecuteTemplate(buf, "index.html", data)
+			if err != nil {
+				return nil, fmt.Errorf("rendering page: %v", err)
+			}
+			return buf.Bytes(), nil
+		})
+		if err != nil {
+			cache.logError(time.Now(), err)
+			if strict {
+				ErrorPage(resp, http.StatusInternalServerError)
+				return
+			}
+			buf := bytes.NewBuffer(nil)
+			err = templates.ExecuteTemplate(buf, "index.html", IndexData{PageData: pages.data(req), Unavailable: true, FormToken: formTokenPlaceholder})
+			if err != nil {
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("rendering page: %v", err)
+				return
+			}
+			page = buf.Bytes()
+		}
+		// every copy of the page gets its own token, though the page is cached
+		page = bytes.Replace(page, []byte(formTokenPlaceholder), []byte(newFormToken()), 1)
+		resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		resp.Write(page)
+	}
+}
+
+// removes a character cut in half from the end of a note
+func trimPartialRune(data []byte) []byte {
+	for i := 0; i < utf8.UTFMax-1 && len(data) > 0; i++ {
+		r, size := utf8.DecodeLastRune(data)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// displays a note on a pretty html page
+// the expiry in settings is used to tell the reader when the note will be deleted, and under which -retention rule
+// if comments is true, the note's comments are displayed below it
+// only the first maxSize bytes of a note are displayed, with links to the rest, unless maxSize is zero
+func Note(templates *template.Template, pages Pages, datastore Datastore, settings *Settings, analytics *Analytics, comments bool, maxSize int) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params[0].Value
+		if noteName == RANDOM_NOTE {
+			randomNote(resp, req, datastore)
+			return
+		}
+		if redirectToCanonical(resp, req, noteName, "") {
+			return
+		}
+		// browsers revalidate the page on every view, and it's only rendered again once something on it has changed
+		modified, ok, err := datastore.getPageTime(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		if notModifiedSince(req, modified) {
+			// it's still a view, so a note read from a cache doesn't expire
+			err = datastore.touchNote(noteName)
+			if err != nil {
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("accessing %s: %v", noteName, err)
+				return
+			}
+			analytics.recordView(req, datastore.key(noteName))
+			setPageCaching(resp, modified)
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		var data []byte
+		var size int64
+		if maxSize == 0 {
+			data, ok, err = datastore.getNote(noteName)
+			size = int64(len(data))
+		} else {
+			data, size, ok, err = datastore.getNotePrefix(noteName, maxSize)
+		}
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		truncated := int64(len(data)) < size
+		if truncated {
+			data = trimPartialRune(data)
+		}
+		link := ""
+		if !truncated {
+			link, _ = noteLink(data)
+		}
+		var lines []NoteLine
+		if len(data) <= maxNumberedSize {
+			lines = numberLines(string(data))
+		}
+		info, _, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		heading := info.Title
+		if heading == "" {
+			heading = noteName
+		}
+		edited := ""
+		if info.ModifyTime.After(info.CreateTime) {
+			edited = info.ModifyTime.Format(expiryFormat)
+		}
+		expiry := settings.expiry().noteExpiry(noteName, info)
+		expires := ""
+		if expiry.Expires != nil {
+			expires = expiry.Expires.Format(expiryFormat)
+		}
+		lock, locked, err := datastore.getNoteLock(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing lock on %s: %v", noteName, err)
+			return
+		}
+		lockedBy := ""
+		if locked {
+			lockedBy = fmt.Sprintf("Locked by %s %s", lock.Holder, describeAge(time.Since(lock.Acquired)))
+		}
+		attachments, err := datastore.listAttachments(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("listing attachments of %s: %v", noteName, err)
+			return
+		}
+		links, err := datastore.listLinkChecks(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("listing link checks of %s: %v", noteName, err)
+			return
+		}
+		brokenLinks := 0
+		for _, link := range links {
+			if link.Broken {
+				brokenLinks++
+			}
+		}
+		var thread []Comment
+		if comments {
+			thread, err = datastore.listComments(noteName)
+			if err != nil {
+				ErrorPage(resp, http.StatusInternalServerError)
+				log.Printf("listing comments on %s: %v", noteName, err)
+				return
+			}
+		}
+		description := ""
+		if text, ok := readableText(info, data, truncated); ok {
+			description = safePreview(text)
+		}
+		resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		setPageCaching(resp, modified)
+		err = templates.ExecuteTemplate(resp, "note.html", NoteData{
+			PageData:     pages.data(req),
+			Title:        noteName,
+			Heading:      heading,
+			NoteTitle:    info.Title,
+			Link:         link,
+			Language:     safeToken(noteLanguage(info)),
+			Description:  description,
+			Body:         string(data),
+			Lines:        lines,
+			Truncated:    truncated,
+			TotalSize:    size,
+			Created:      info.CreateTime.Format(expiryFormat),
+			Edited:       edited,
+			Expires:      expires,
+			Retention:    expiry.Rule,
+			Unlisted:     info.Unlisted,
+			BrokenLinks:  brokenLinks,
+			Locked:       lockedBy,
+			Hidden:       info.Visibility.describe(datastore.now()),
+			Attachments:  attachments,
+			ShowComments: comments,
+			Comments:     thread,
+			FormToken:    newFormToken(),
+		})
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("writing template: %v", err)
+			return
+		}
+	}
+}
+
+// displays a note entirely raw. good for binaries or curl
+func RawNote(datastore Datastore, analytics *Analytics) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params[0].Value
+		if noteName == LATEST_NOTE {
+			latest, ok, err := datastore.getLatestNote()
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("getting latest note: %v", err)
+				return
+			}
+			if !ok {
+				APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+				return
+			}
+			noteName = latest
+		}
+		hash, ok, err := datastore.getNoteHash(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		// the hash doubles as the etag, so clients can skip fetching notes which haven't changed
+		etag := `"` + hash + `"`
+		resp.Header().Set("ETag", etag)
+		resp.Header().Set(hashHeader, hash)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, ok, err := datastore.getNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		info, _, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		resp.Header().Set("Content-Type", servedContentType(info.ContentType))
+		// when the body last changed, unlike the note's page, whose Last-Modified counts its comments and the like too
+		resp.Header().Set("Last-Modified", info.ModifyTime.UTC().Format(http.TimeFormat))
+		sandboxContent(resp)
+		_, err = resp.Write(data)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("responding with raw file: %v", err)
+		}
+	}
+}
+
+// checks whether an If-None-Match or If-Match header matches an etag
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// lets browsers keep a note's page, as long as they check it hasn't changed since modified every time they show it
+// it's private, since the page differs between users
+func setPageCaching(resp http.ResponseWriter, modified time.Time) {
+	resp.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	resp.Header().Set("Cache-Control", "private, max-age=0, must-revalidate")
+}
+
+// whether a resource last modified at modified hasn't changed since an If-Modified-Since header
+// as in RFC 9110, the header is ignored if If-None-Match is given too, or if it isn't a valid date
+func notModifiedSince(req *http.Request, modified time.Time) bool {
+	if req.Header.Get("If-None-Match") != "" {
+		return false
+	}
+	since, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// http dates are only precise to the second
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// special note names, which can't be used by real notes
+const (
+	// GET /api/note/_latest returns the most recently created note
+	LATEST_NOTE = "_latest"
+	// GET /note/_random redirects to a random note
+	RANDOM_NOTE = "_random"
+)
+
+// longest name a new note may have, in bytes
+const maxNoteNameLength = 255
+
+// checks whether a name can be used for a new note, returning an error explaining why not
+// names beginning with an underscore are reserved for special names like LATEST_NOTE
+// a name may be a path, like "ci/build", so notes can be grouped into folders, but each part of it
+// must be a name which a url can hold, and none but the first may be one of the actions under a note's path,
+// like "print", or the name couldn't be told apart from the action; see noteActions
+func validateNoteName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("note names can't be empty")
+	case strings.HasPrefix(name, "_"):
+		return errors.New("note names beginning with _ are reserved")
+	case len(name) > maxNoteNameLength:
+		return fmt.Errorf("note names can't be longer than %d bytes", maxNoteNameLength)
+	case !utf8.ValidString(name):
+		return errors.New("note names must be valid UTF-8")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		return errors.New("note names can't contain control characters")
+	case strings.Contains(name, "\\"):
+		return errors.New("note names can't contain \\, since it separates folders on some systems")
+	case strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/"):
+		return errors.New("note names can't begin or end with /")
+	}
+	for i, part := range strings.Split(name, "/") {
+		switch {
+		case part == "":
+			return errors.New("note names can't contain //")
+		case part == "." || part == "..":
+			return errors.New("note names can't contain . or .. between /s")
+		case i > 0 && noteActions[part]:
+			return fmt.Errorf("%q can't follow a / in note names, since it's an action on a note, like /note/:note/%s", part, part)
+		}
+	}
+	return nil
+}
+
+// checks whether notes could have names beginning with prefix, which may end with a /, like "ci/"
+func validateNotePrefix(prefix string) error {
+	return validateNoteName(strings.TrimSuffix(prefix, "/"))
+}
+
+// longest title a note may have, in bytes
+const maxNoteTitleLength = 255
+
+// checks whether a title can be given to a note, returning an error explaining why not
+// an empty title is fine, and means the note is shown by its name
+func validateNoteTitle(title string) error {
+	switch {
+	case len(title) > maxNoteTitleLength:
+		return fmt.Errorf("note titles can't be longer than %d bytes", maxNoteTitleLength)
+	case !utf8.ValidString(title):
+		return errors.New("note titles must be valid UTF-8")
+	case strings.IndexFunc(title, unicode.IsControl) >= 0:
+		return errors.New("note titles can't contain control characters")
+	}
+	return nil
+}
+
+// gives a new note a title, as an alternative to the title query parameter
+const titleHeader = "X-Note-Title"
+
+// the title a request gives the note it creates, from the X-Note-Title header or the title query parameter
+func requestTitle(req *http.Request) (string, error) {
+	title := req.Header.Get(titleHeader)
+	if title == "" {
+		title = req.URL.Query().Get("title")
+	}
+	title = strings.TrimSpace(title)
+	return title, validateNoteTitle(title)
+}
+
+// redirects to a random note
+func randomNote(resp http.ResponseWriter, req *http.Request, datastore Datastore) {
+	noteName, ok, err := datastore.getRandomNote()
+	if err != nil {
+		ErrorPage(resp, http.StatusInternalServerError)
+		log.Printf("getting random note: %v", err)
+		return
+	}
+	if !ok {
+		ErrorPage(resp, http.StatusNotFound)
+		return
+	}
+	http.Redirect(resp, req, notePath(boardPrefix(req), noteName), http.StatusFound)
+}
+
+// header carrying the SHA-256 of a note's body, as hex
+// on uploads, the server rejects the note if the body doesn't match it
+const hashHeader = "X-Content-SHA256"
+
+// checks that a string is a hex-encoded SHA-256
+func validHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// WritePolicy decides which notes SetNote refuses
+type WritePolicy struct {
+	// whether a PUT with an empty body empties an existing note, rather than being refused
+	EmptyTruncates bool
+	// whether a note with the same body as another is refused, rather than just reported
+	RejectDuplicates bool
+	// how long a POST's idempotency key is remembered; if zero, forever
+	IdempotencyAge time.Duration
+	// the largest note a compressed upload may decompress to
+	MaxDecompressedSize int64
+	// the largest note which may be written, however it's written; if zero, there's no limit
+	// it's applied by a write hook; see Hooks
+	MaxNoteSize int64
+	// the most bytes of notes each owner may keep, with -private-notes, or that the shared notes, or every note, may;
+	// if zero, there's no limit
+	Quota int64
+}
+
+// DuplicateData is the response to a new note whose body is the same as another note's
+type DuplicateData struct {
+	DuplicateOf string `json:"duplicateOf"`
+}
+
+// posts a note
+// request body is the note, not a json
+// an empty body is refused unless the allow-empty query parameter is "true",
+// or if clobber and policy.emptyTruncates are set and the note exists, in which case it's emptied
+// if a new note has the same body as another listed note, the response names it as json
+// a PUT with if-unmodified-since is refused with 412 if the note has changed since then
+// a POST retried with the same idempotency key gets the response the first one did, rather than 409
+// a new note is only shown from the publish-at query parameter until the hide-after one, if they're given
+// a compressed body is decompressed, and its hash is that of the decompressed note, unless store-compressed is "true"
+func SetNote(datastore Datastore, clobber bool, policy WritePolicy, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params[0].Value
+		if err := datastore.validateNoteName(noteName); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+			return
+		}
+		expectedHash := strings.ToLower(req.Header.Get(hashHeader))
+		if expectedHash != "" && !validHash(expectedHash) {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		title, err := requestTitle(req)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		visibility, err := requestVisibility(req)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		var unmodifiedSince time.Time
+		var idempotencyKey string
+		if clobber {
+			unmodifiedSince, err = requestUnmodifiedSince(req)
+		} else {
+			idempotencyKey, err = requestIdempotencyKey(req)
+		}
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		err = decodeRequestBody(req, policy.MaxDecompressedSize)
+		if respondDecodeError(resp, err) {
+			return
+		}
+		body, contentType, hash, err := readNoteBody(req)
+		if err != nil && (errors.Is(err, io.ErrUnexpectedEOF) || clientGone(req)) {
+			// the body was cut short, so it mustn't be saved
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			log.Printf("upload of note %s was cut short: %v", noteName, err)
+			return
+		}
+		if respondDecodeError(resp, err) {
+			return
+		}
+		var formErr noteFormError
+		if errors.As(err, &formErr) {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, formErr.Error())
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error reading request body: %v", err)
+			return
+		}
+		if expectedHash != "" && expectedHash != hash {
+			APIError(resp, http.StatusUnprocessableEntity, ERR_HASH_MISMATCH)
+			return
+		}
+		resp.Header().Set(hashHeader, hash)
+		if emptyBody(body) && req.URL.Query().Get("allow-empty") != "true" {
+			truncate := false
+			if clobber && policy.EmptyTruncates {
+				truncate, err = datastore.noteExists(noteName)
+				if err != nil {
+					APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+					log.Printf("accessing %s: %v", noteName, err)
+					return
+				}
+			}
+			if !truncate {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_EMPTY_BODY, emptyBodyMessage)
+				return
+			}
+		}
+		if clientGone(req) {
+			log.Printf("abandoned writing note %s: client went away", noteName)
+			return
+		}
+		// replay a retried POST before looking for duplicates, since the note it created is one
+		if idempotencyKey != "" && replayIdempotentPost(resp, datastore, noteName, idempotencyKey, hash, policy.IdempotencyAge) {
+			return
+		}
+		if policy.RejectDuplicates {
+			duplicate, found, err := datastore.findDuplicate(hash, noteName)
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("looking for duplicates of %s: %v", noteName, err)
+				return
+			}
+			if found {
+				writeAPIError(resp, APIErrorDetail{
+					Code:        ERR_DUPLICATE,
+					Message:     "note has the same contents as " + duplicate,
+					Status:      http.StatusConflict,
+					DuplicateOf: duplicate,
+				})
+				return
+			}
+		}
+		if contentType == "" || contentType == "application/octet-stream" {
+			contentType = sniffContentType(noteName, body)
+		}
+		options := NoteOptions{
+			Unlisted:        req.URL.Query().Get("unlisted") == "true",
+			Title:           title,
+			ContentType:     contentType,
+			UnmodifiedSince: unmodifiedSince,
+			Visibility:      visibility,
+		}
+		status, err := datastore.setNoteWithHash(noteName, body, hash, clobber, options)
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		if status == MODIFIED {
+			APIErrorMessage(resp, http.StatusPreconditionFailed, ERR_PRECONDITION_FAILED, "the note has changed since if-unmodified-since")
+			return
+		}
+		if status == NO_CLOBBER {
+			// the first try may have been saved after its client gave up waiting, just before this one looked
+			if idempotencyKey != "" && replayIdempotentPost(resp, datastore, noteName, idempotencyKey, hash, policy.IdempotencyAge) {
+				return
+			}
+			APIError(resp, http.StatusConflict, ERR_NOTE_EXISTS)
+			return
+		}
+		if status == CREATED {
+			listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+			log.Printf("New note %s", noteName)
+			// the note is already saved, so failing to find a duplicate isn't worth failing the request
+			duplicate, found, err := datastore.findDuplicate(hash, noteName)
+			if err != nil {
+				log.Printf("looking for duplicates of %s: %v", noteName, err)
+			}
+			if idempotencyKey != "" {
+				err = datastore.saveIdempotencyKey(noteName, idempotencyKey, IdempotentPost{Hash: hash, DuplicateOf: duplicate})
+				if err != nil {
+					log.Printf("saving idempotency key for %s: %v", noteName, err)
+				}
+			}
+			if found {
+				respondDuplicate(resp, duplicate)
+				return
+			}
+			ErrorPage(resp, http.StatusCreated)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(body)))
+		log.Printf("Updated note %s", noteName)
+	}
+}
+
+// fields of a form whose value is the note, in order of preference, for clients which can only send forms
+// "f:1" is what sprunge's clients send
+var noteFormFields = []string{"body", "content", "f:1"}
+
+func isNoteFormField(name string) bool {
+	for _, field := range noteFormFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// a form which doesn't hold a note
+type noteFormError struct {
+	message string
+}
+
+func (e noteFormError) Error() string {
+	return e.message
+}
+
+// reads the note a request sets, and hashes it
+// a urlencoded form's note is the value of one of noteFormFields, and a multipart form's is its first file
+// or else one of noteFormFields; anything else, including a urlencoded body without any of those fields,
+// like one sent by curl --data-binary, is the note itself
+// returns the content type of an uploaded file, or "" if the note isn't one
+func readNoteBody(req *http.Request) (body []byte, contentType string, hash string, err error) {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		raw := bytes.NewBuffer(nil)
+		_, err = raw.ReadFrom(req.Body)
+		if err != nil {
+			return nil, "", "", err
+		}
+		form, err := url.ParseQuery(raw.String())
+		if err == nil {
+			for _, field := range noteFormFields {
+				if values, ok := form[field]; ok {
+					body = []byte(values[0])
+					return body, "", hashBody(body), nil
+				}
+			}
+		}
+		return raw.Bytes(), "", hashBody(raw.Bytes()), nil
+	case "multipart/form-data":
+		return readNoteMultipart(req)
+	}
+	// hash the body as it's read, rather than reading it again afterwards
+	buf := bytes.NewBuffer(nil)
+	hasher := sha256.New()
+	_, err = buf.ReadFrom(io.TeeReader(req.Body, hasher))
+	return buf.Bytes(), "", hex.EncodeToString(hasher.Sum(nil)), err
+}
+
+// reads the note from a multipart form, without buffering the rest of the form
+func readNoteMultipart(req *http.Request) ([]byte, string, string, error) {
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, "", "", noteFormError{"the body isn't a valid multipart form"}
+	}
+	fields := map[string][]byte{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		if part.FileName() == "" && !isNoteFormField(part.FormName()) {
+			continue
+		}
+		value := bytes.NewBuffer(nil)
+		_, err = value.ReadFrom(part)
+		if err != nil {
+			return nil, "", "", err
+		}
+		if part.FileName() != "" {
+			return value.Bytes(), storedContentType(part.Header.Get("Content-Type")), hashBody(value.Bytes()), nil
+		}
+		fields[part.FormName()] = value.Bytes()
+	}
+	for _, field := range noteFormFields {
+		if value, ok := fields[field]; ok {
+			return value, "", hashBody(value), nil
+		}
+	}
+	return nil, "", "", noteFormError{`the form has no file, and no "body", "content" or "f:1" field`}
+}
+
+// responds that a note was created, but its body is the same as another's
+func respondDuplicate(resp http.ResponseWriter, duplicate string) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(http.StatusCreated)
+	err := json.NewEncoder(resp).Encode(DuplicateData{DuplicateOf: duplicate})
+	if err != nil {
+		log.Printf("responding with duplicate: %v", err)
+	}
+}
+
+// atomically adds the by query parameter, or 1, to a note whose body is an integer
+// if the create query parameter is "true", a missing note is created as if it was 0
+// responds with the new value
+func IncrementNote(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		query := req.URL.Query()
+		by := int64(1)
+		if query.Get("by") != "" {
+			var err error
+			by, err = strconv.ParseInt(query.Get("by"), 10, 64)
+			if err != nil {
+				APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+				return
+			}
+		}
+		create := query.Get("create") == "true"
+		if create {
+			if err := datastore.validateNoteName(noteName); err != nil {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+				return
+			}
+		}
+		value, status, err := datastore.incrementNote(noteName, by, create)
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error incrementing note %s: %v", noteName, err)
+			return
+		}
+		switch status {
+		case NO_NOTE:
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		case NOT_A_NUMBER:
+			APIError(resp, http.StatusUnprocessableEntity, ERR_NOT_A_NUMBER)
+			return
+		}
+		body := strconv.FormatInt(value, 10)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		if status == CREATED {
+			listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+			log.Printf("New note %s", noteName)
+			resp.WriteHeader(http.StatusCreated)
+		} else {
+			listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(body)))
+			log.Printf("Updated note %s", noteName)
+		}
+		fmt.Fprintln(resp, body)
+	}
+}
+
+// atomically replaces a note's body with the set parameter, if its body is the expect parameter
+// the parameters may be in the query or a form body
+// responds 409 if the note's body isn't expect
+func CompareAndSwapNote(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		err := req.ParseForm()
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		if _, ok := req.Form["set"]; !ok {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		expect := []byte(req.Form.Get("expect"))
+		set := []byte(req.Form.Get("set"))
+		status, err := datastore.compareAndSwapNote(noteName, expect, set)
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		switch status {
+		case NO_NOTE:
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		case MISMATCH:
+			APIError(resp, http.StatusConflict, ERR_PRECONDITION_FAILED)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(set)))
+		log.Printf("Updated note %s", noteName)
+	}
+}
+
+// creates a note from a template
+// the template is named by the template query parameter, and the other query parameters
+// are substituted into its placeholders along with {{name}}, {{date}} and {{time}}
+func FromTemplate(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		if err := datastore.validateNoteName(noteName); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+			return
+		}
+		query := req.URL.Query()
+		templateName := query.Get("template")
+		if templateName == "" {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		title, err := requestTitle(req)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		templateBody, ok, err := datastore.peekNote(noteTemplatePrefix + templateName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing template %s: %v", templateName, err)
+			return
+		}
+		// a template the user can't read might as well not exist
+		if !ok || !datastore.permits(noteTemplatePrefix+templateName, ACCESS_READ) {
+			APIError(resp, http.StatusNotFound, ERR_TEMPLATE_NOT_FOUND)
+			return
+		}
+		values := map[string]string{}
+		for key := range query {
+			if key != "template" {
+				values[key] = query.Get(key)
+			}
+		}
+		values["name"] = noteName
+		body := fillNoteTemplate(templateBody, values, time.Now())
+		status, err := datastore.setNoteWithHash(noteName, body, hashBody(body), false, NoteOptions{Title: title, ContentType: sniffContentType(noteName, body)})
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		if status == NO_CLOBBER {
+			APIError(resp, http.StatusConflict, ERR_NOTE_EXISTS)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+		ErrorPage(resp, http.StatusCreated)
+		log.Printf("New note %s from template %s", noteName, templateName)
+	}
+}
+
+// longest comment accepted, in characters
+const maxCommentLength = 2000
+
+// adds a comment to a note, written by the authenticated user
+// the comment is either the request body, responding with the comment as json,
+// or the body field of a form, redirecting back to the note
+func AddComment(templates *template.Template, pages Pages, datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		fromForm := mediaType == "application/x-www-form-urlencoded"
+		var body, token string
+		if fromForm {
+			body = req.FormValue("body")
+			token = req.PostFormValue(formTokenField)
+		} else {
+			// read a little past the limit, to tell whether it was exceeded
+			data, err := io.ReadAll(io.LimitReader(req.Body, 4*maxCommentLength+1))
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("error reading request body: %v", err)
+				return
+			}
+			body = string(data)
+		}
+		body = strings.TrimSpace(body)
+		if body == "" {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		if len([]rune(body)) > maxCommentLength {
+			APIError(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE)
+			return
+		}
+		if !claimForm(resp, req, templates, pages, datastore, token, noteName, "#comments") {
+			return
+		}
+		comment, status, err := datastore.addComment(noteName, requestUser(req), body)
+		if err != nil {
+			releaseForm(datastore, token)
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error commenting on %s: %v", noteName, err)
+			return
+		}
+		if status == NO_NOTE {
+			releaseForm(datastore, token)
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		log.Printf("New comment on note %s", noteName)
+		if fromForm {
+			http.Redirect(resp, req, notePath(boardPrefix(req), noteName)+"#comments", http.StatusSeeOther)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(resp).Encode(comment)
+		if err != nil {
+			log.Printf("responding with comment: %v", err)
+		}
+	}
+}
+
+// lists the comments on a note as json, oldest first
+func ListComments(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		exists, err := datastore.noteExists(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		comments, err := datastore.listComments(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing comments on %s: %v", noteName, err)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(comments)
+		if err != nil {
+			log.Printf("responding with comment list: %v", err)
+		}
+	}
+}
+
+// deletes a comment, which must have been written by the authenticated user
+func DeleteComment(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		id, err := strconv.ParseInt(params.ByName("comment"), 10, 64)
+		if err != nil {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		status, err := datastore.deleteComment(noteName, id, requestUser(req))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting comment %d on %s: %v", id, noteName, err)
+			return
+		}
+		switch status {
+		case NO_NOTE:
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		case MISMATCH:
+			APIError(resp, http.StatusForbidden, ERR_FORBIDDEN)
+			return
+		}
+		log.Printf("Deleted comment %d on note %s", id, noteName)
+	}
+}
+
+// how long a lock lasts unless it's renewed
+const lockDuration = 5 * time.Minute
+
+// describes how long ago something happened, e.g. "2 minutes ago"
+func describeAge(age time.Duration) string {
+	minutes := int(age / time.Minute)
+	switch {
+	case minutes < 1:
+		return "just now"
+	case minutes == 1:
+		return "1 minute ago"
+	case minutes < 60:
+		return fmt.Sprintf("%d minutes ago", minutes)
+	case minutes < 120:
+		return "1 hour ago"
+	case minutes < 48*60:
+		return fmt.Sprintf("%d hours ago", minutes/60)
+	default:
+		return fmt.Sprintf("%d days ago", minutes/(24*60))
+	}
+}
+
+// who a request acquires locks as: the authenticated user, or the client's address if there isn't one
+func lockHolder(req *http.Request) string {
+	if user := requestUser(req); user != "" {
+		return user
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// writes a lock as json with the given status
+func writeLock(resp http.ResponseWriter, code int, lock NoteLock) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	err := json.NewEncoder(resp).Encode(lock)
+	if err != nil {
+		log.Printf("responding with lock: %v", err)
+	}
+}
+
+// responds with the lock on a note as json, or 404 if it isn't locked
+func GetLock(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		lock, ok, err := datastore.getNoteLock(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing lock on %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		writeLock(resp, http.StatusOK, lock)
+	}
+}
+
+// acquires or renews the requester's lock on a note, responding with the lock as json
+// responds 409 with the other lock if someone else holds it, unless the force query parameter is "true"
+func LockNote(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		exists, err := datastore.noteExists(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		holder := lockHolder(req)
+		force := req.URL.Query().Get("force") == "true"
+		lock, acquired, err := datastore.lockNote(noteName, holder, lockDuration, force)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error locking note %s: %v", noteName, err)
+			return
+		}
+		if !acquired {
+			writeLock(resp, http.StatusConflict, lock)
+			return
+		}
+		writeLock(resp, http.StatusOK, lock)
+	}
+}
+
+// releases the requester's lock on a note
+// responds 409 if someone else holds it, unless the force query parameter is "true"
+func UnlockNote(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		force := req.URL.Query().Get("force") == "true"
+		released, err := datastore.unlockNote(noteName, lockHolder(req), force)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error unlocking note %s: %v", noteName, err)
+			return
+		}
+		if !released {
+			APIError(resp, http.StatusConflict, ERR_LOCKED)
+			return
+		}
+	}
+}
+
+// ExportedNote is a note's body and metadata in one document,
+// for moving notes between corkboard instances
+type ExportedNote struct {
+	Name string `json:"name"`
+	Body string `json:"body"`
+	// "utf-8", or "base64" if the body isn't valid utf-8
+	Encoding    string    `json:"encoding"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	Hash        string    `json:"hash"`
+	Unlisted    bool      `json:"unlisted"`
+	Title       string    `json:"title,omitempty"`
+	CreateTime  time.Time `json:"create_time"`
+	LastViewed  time.Time `json:"last_viewed"`
+	ModifyTime  time.Time `json:"modify_time"`
+}
+
+// body encodings for exported notes
+const (
+	ENCODING_UTF8   = "utf-8"
+	ENCODING_BASE64 = "base64"
+)
+
+// responds with a note's body and metadata as json, without counting it as a view
+func ExportNote(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		info, ok, err := datastore.getNoteInfo(noteName)
+		var body []byte
+		var hash string
+		if err == nil && ok {
+			body, ok, err = datastore.peekNote(noteName)
+		}
+		if err == nil && ok {
+			hash, ok, err = datastore.getNoteHash(noteName)
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		exported := ExportedNote{
+			Name:        noteName,
+			Encoding:    ENCODING_UTF8,
+			ContentType: servedContentType(info.ContentType),
+			Size:        len(body),
+			Hash:        hash,
+			Unlisted:    info.Unlisted,
+			Title:       info.Title,
+			CreateTime:  info.CreateTime,
+			LastViewed:  info.LastViewed,
+			ModifyTime:  info.ModifyTime,
+		}
+		if utf8.Valid(body) {
+			exported.Body = string(body)
+		} else {
+			exported.Encoding = ENCODING_BASE64
+			if info.ContentType == "" {
+				exported.ContentType = "application/octet-stream"
+			}
+			exported.Body = base64.StdEncoding.EncodeToString(body)
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(exported)
+		if err != nil {
+			log.Printf("responding with exported note: %v", err)
+		}
+	}
+}
+
+// creates or overwrites a note from a document made by ExportNote, keeping its times
+// the note is named by the url, not the document
+// responds 422 if the body doesn't match the document's hash
+func ImportNote(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		if err := datastore.validateNoteName(noteName); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+			return
+		}
+		var exported ExportedNote
+		err := json.NewDecoder(req.Body).Decode(&exported)
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		var body []byte
+		switch exported.Encoding {
+		case ENCODING_UTF8, "":
+			body = []byte(exported.Body)
+		case ENCODING_BASE64:
+			body, err = base64.StdEncoding.DecodeString(exported.Body)
+			if err != nil {
+				APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+				return
+			}
+		default:
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		if exported.Hash != "" && strings.ToLower(exported.Hash) != hashBody(body) {
+			APIError(resp, http.StatusUnprocessableEntity, ERR_HASH_MISMATCH)
+			return
+		}
+		now := time.Now()
+		if exported.CreateTime.IsZero() {
+			exported.CreateTime = now
+		}
+		if exported.LastViewed.IsZero() {
+			exported.LastViewed = exported.CreateTime
+		}
+		// exported before notes had a modify_time
+		if exported.ModifyTime.IsZero() {
+			exported.ModifyTime = exported.CreateTime
+		}
+		if clientGone(req) {
+			log.Printf("abandoned importing note %s: client went away", noteName)
+			return
+		}
+		if err := validateNoteTitle(exported.Title); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		options := NoteOptions{Unlisted: exported.Unlisted, Title: exported.Title, ContentType: storedContentType(exported.ContentType)}
+		status, err := datastore.setNoteWithTimes(noteName, body, true, options, exported.CreateTime, exported.LastViewed, exported.ModifyTime)
+		if respondRejected(resp, err) {
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error importing note %s: %v", noteName, err)
+			return
+		}
+		if status == CREATED {
+			listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+			ErrorPage(resp, http.StatusCreated)
+			log.Printf("New note %s", noteName)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_UPDATED, noteName, len(body)))
+		log.Printf("Updated note %s", noteName)
+	}
+}
+
+// NoteMetadata is a note's metadata, with when it will expire
+type NoteMetadata struct {
+	NoteInfo
+	Expiry NoteExpiry `json:"expiry"`
+}
+
+// responds with a note's metadata as json, with when it will expire under expiry
+func GetMetadata(datastore Datastore, expiry func() ExpiryConfig) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		info, ok, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set(hashHeader, info.Hash)
+		err = json.NewEncoder(resp).Encode(NoteMetadata{NoteInfo: info, Expiry: expiry().noteExpiry(noteName, info)})
+		if err != nil {
+			log.Printf("responding with metadata: %v", err)
+		}
+	}
+}
+
+// MetadataUpdate is the body of a request to change a note's metadata
+// fields which are left out aren't changed
+type MetadataUpdate struct {
+	Unlisted *bool `json:"unlisted"`
+	// "" removes the title
+	Title *string `json:"title"`
+	// times like 2026-10-17T09:30:00Z, or "" to remove them; see Visibility
+	PublishAt *string `json:"publish_at"`
+	HideAfter *string `json:"hide_after"`
+}
+
+// changes a note's metadata from a json body
+func SetMetadata(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		var update MetadataUpdate
+		err := json.NewDecoder(req.Body).Decode(&update)
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		if update.Title != nil {
+			*update.Title = strings.TrimSpace(*update.Title)
+			if err := validateNoteTitle(*update.Title); err != nil {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+				return
+			}
+		}
+		// the changes are made together, or not at all, and the visibility can't change between being read and written
+		var exists bool
+		var invalid error
+		err = datastore.withTx(req.Context(), func(datastore Datastore) error {
+			visibility, ok, err := datastore.getNoteVisibility(noteName)
+			exists = ok
+			if err == nil && exists && (update.PublishAt != nil || update.HideAfter != nil) {
+				if update.PublishAt != nil {
+					visibility.PublishAt, invalid = parseVisibilityTime("publish_at", *update.PublishAt)
+				}
+				if invalid == nil && update.HideAfter != nil {
+					visibility.HideAfter, invalid = parseVisibilityTime("hide_after", *update.HideAfter)
+				}
+				if invalid == nil {
+					invalid = visibility.validate()
+				}
+				if invalid != nil {
+					return invalid
+				}
+				exists, err = datastore.setNoteVisibility(noteName, visibility)
+			}
+			if err == nil && exists && update.Unlisted != nil {
+				exists, err = datastore.setUnlisted(noteName, *update.Unlisted)
+			}
+			if err == nil && exists && update.Title != nil {
+				exists, err = datastore.setNoteTitle(noteName, *update.Title)
+			}
+			return err
+		})
+		if invalid != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, invalid.Error())
+			return
+		}
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error updating metadata of %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		log.Printf("Updated metadata of note %s", noteName)
+	}
+}
+
+// HealthData is the response to a health check
+type HealthData struct {
+	Status string `json:"status"`
+	// the most recent integrity check, if there's been one
+	IntegrityCheck *IntegrityCheck `json:"integrity_check,omitempty"`
+	// the most recent cleanup, if there's been one
+	LastCleanup *CleanupRun `json:"last_cleanup,omitempty"`
+}
+
+// responds with whether the server can reach its database, as json
+// this doesn't require authentication, so that load balancers and monitoring can use it
+func Health(datastore Datastore, maintenance *Maintenance) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		health := HealthData{Status: "ok"}
+		code := http.StatusOK
+		if check, ok := maintenance.lastIntegrityCheck(); ok {
+			health.IntegrityCheck = &check
+			if !check.OK {
+				health.Status = "corrupted"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		if run, ok := maintenance.lastCleanupRun(); ok {
+			health.LastCleanup = &run
+		}
+		err := datastore.ping(req.Context())
+		if err != nil {
+			log.Printf("health check: %v", err)
+			health.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(code)
+		err = json.NewEncoder(resp).Encode(health)
+		if err != nil {
+			log.Printf("responding with health: %v", err)
+		}
+	}
+}
+
+// vacuums the database, waiting for any other maintenance to finish
+// responds with the number of bytes reclaimed
+func Vacuum(maintenance *Maintenance) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		reclaimed, err := maintenance.vacuum()
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error vacuuming database: %v", err)
+			return
+		}
+		log.Printf("vacuumed database, reclaiming %d bytes", reclaimed)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		fmt.Fprintf(resp, "%d\n", reclaimed)
+	}
+}
+
+// lists the notes whose names begin with the prefix query parameter as json
+// if the q query parameter is given, only notes whose names or titles contain it, ignoring case, are listed
+// if the meta query parameter is given, only notes with it as one of their meta's values are listed
+func ListNotes(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		prefix := req.URL.Query().Get("prefix")
+		notes, err := datastore.listNotesWithPrefix(prefix)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing notes with prefix %s: %v", prefix, err)
+			return
+		}
+		if search := strings.ToLower(req.URL.Query().Get("q")); search != "" {
+			matching := make([]NoteInfo, 0)
+			for _, note := range notes {
+				if strings.Contains(strings.ToLower(note.Name), search) || strings.Contains(strings.ToLower(note.Title), search) {
+					matching = append(matching, note)
+				}
+			}
+			notes = matching
+		}
+		if query, ok := req.URL.Query()["meta"]; ok {
+			matching := make([]NoteInfo, 0)
+			for _, note := range notes {
+				if note.Meta.hasValue(query[0]) {
+					matching = append(matching, note)
+				}
+			}
+			notes = matching
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(notes)
+		if err != nil {
+			log.Printf("responding with notes: %v", err)
+		}
+	}
+}
+
+// deletes every note whose name begins with the prefix query parameter
+// to prevent accidents, the prefix can't be empty and must be repeated in the confirm query parameter
+// responds with the number of notes deleted
+func DeleteNotes(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		query := req.URL.Query()
+		prefix := query.Get("prefix")
+		if prefix == "" || query.Get("confirm") != prefix {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		names, err := datastore.deleteNotesWithPrefix(prefix)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting notes with prefix %s: %v", prefix, err)
+			return
+		}
+		for _, name := range names {
+			listeners.publish(newNoteEvent(req, NOTE_DELETED, name, 0))
+		}
+		log.Printf("Deleted %d notes with prefix %s", len(names), prefix)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		fmt.Fprintf(resp, "%d\n", len(names))
+	}
+}
+
+// handles note deletion
+// with the wipe query parameter "true", the note's contents are overwritten rather than only deleted,
+// and the response says whether they're gone from the database's files
+func DeleteNote(datastore Datastore, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params[0].Value
+		if req.URL.Query().Get("wipe") == "true" {
+			result, err := datastore.wipeNote(noteName)
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("error wiping note %s: %v", noteName, err)
+				return
+			}
+			if result.Deleted {
+				listeners.publish(newNoteEvent(req, NOTE_DELETED, noteName, 0))
+			}
+			log.Printf("Wiped note %s", noteName)
+			resp.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(resp).Encode(result)
+			if err != nil {
+				log.Printf("responding with wipe of %s: %v", noteName, err)
+			}
+			return
+		}
+		err := datastore.deleteNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error writing note %s: %v", noteName, err)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_DELETED, noteName, 0))
+		log.Printf("Deleted note %s", noteName)
+	}
+}
+
+// largest attachment upload kept in memory; the rest is buffered on disk
+const maxAttachmentMemory = 1 << 20
+
+// attaches the files in a multipart form to a note
+// each file is named after its filename
+// responds 409 if an attachment with that name exists, unless the clobber query parameter is "true"
+func AddAttachments(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		clobber := req.URL.Query().Get("clobber") == "true"
+		err := req.ParseMultipartForm(maxAttachmentMemory)
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		defer req.MultipartForm.RemoveAll()
+		attachments := []Attachment{}
+		for _, files := range req.MultipartForm.File {
+			for _, file := range files {
+				name := path.Base(file.Filename)
+				if name == "" || name == "." || name == "/" {
+					APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+					return
+				}
+				attachment, err := readAttachment(file)
+				if err != nil {
+					APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+					log.Printf("error reading attachment %s: %v", name, err)
+					return
+				}
+				attachment.Name = name
+				attachments = append(attachments, attachment)
+			}
+		}
+		if len(attachments) == 0 {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		if clientGone(req) {
+			log.Printf("abandoned attaching files to %s: client went away", noteName)
+			return
+		}
+		status, err := datastore.addAttachments(noteName, attachments, clobber)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error attaching files to %s: %v", noteName, err)
+			return
+		}
+		switch status {
+		case NO_NOTE:
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+		case NO_CLOBBER:
+			APIError(resp, http.StatusConflict, ERR_ATTACHMENT_EXISTS)
+		case CREATED:
+			log.Printf("Attached %d files to note %s", len(attachments), noteName)
+			ErrorPage(resp, http.StatusCreated)
+		default:
+			log.Printf("Attached %d files to note %s", len(attachments), noteName)
+		}
+	}
+}
+
+// reads an uploaded file into an attachment, working out its content type
+func readAttachment(file *multipart.FileHeader) (Attachment, error) {
+	f, err := file.Open()
+	if err != nil {
+		return Attachment{}, err
+	}
+	defer f.Close()
+	body, err := io.ReadAll(f)
+	if err != nil {
+		return Attachment{}, err
+	}
+	contentType := file.Header.Get("Content-Type")
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(body)
+	}
+	return Attachment{Size: len(body), Type: contentType, body: body}, nil
+}
+
+// lists a note's attachments as json
+func ListAttachments(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		exists, err := datastore.noteExists(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		attachments, err := datastore.listAttachments(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing attachments of %s: %v", noteName, err)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(attachments)
+		if err != nil {
+			log.Printf("responding with attachments: %v", err)
+		}
+	}
+}
+
+// responds with a single attachment
+func GetAttachment(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		name := params.ByName("attachment")
+		attachment, ok, err := datastore.getAttachment(noteName, name)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing attachment %s of %s: %v", name, noteName, err)
+			return
+		}
+		if !ok {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		resp.Header().Set("Content-Type", attachment.Type)
+		resp.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		sandboxContent(resp)
+		_, err = resp.Write(attachment.body)
+		if err != nil {
+			log.Printf("responding with attachment: %v", err)
+		}
+	}
+}
+
+// removes an attachment from a note. responds 200 even if it didn't exist
+func DeleteAttachment(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		name := params.ByName("attachment")
+		err := datastore.deleteAttachment(noteName, name)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting attachment %s of %s: %v", name, noteName, err)
+			return
+		}
+		log.Printf("Deleted attachment %s of note %s", name, noteName)
+	}
+}
+
+// form fields which sprunge-style clients put the paste in, in order of preference
+var pasteFields = []string{"sprunge", "f:1", "f", "paste"}
+
+// largest paste form kept in memory; the rest is buffered on disk
+const maxPasteMemory = 1 << 20
+
+// creates a note with a generated name from a form, like sprunge or ix.io
+// responds with the url of the new note followed by a newline
+// if unlisted is true, the note is left out of listings
+func Paste(datastore Datastore, names NameGenerator, baseURL string, unlisted bool, listeners Listeners) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		datastore := datastore.scoped(req)
+		err := req.ParseMultipartForm(maxPasteMemory)
+		if err != nil && err != http.ErrNotMultipart {
+			ErrorPage(resp, http.StatusBadRequest)
+			return
+		}
+		body, ok, err := readPasteForm(req)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("error reading paste: %v", err)
+			return
+		}
+		if !ok {
+			ErrorPage(resp, http.StatusBadRequest)
+			return
+		}
+		if emptyBody(body) && req.URL.Query().Get("allow-empty") != "true" {
+			ErrorMessage(resp, http.StatusBadRequest, emptyBodyMessage)
+			return
+		}
+		title, err := requestTitle(req)
+		if err != nil {
+			ErrorMessage(resp, http.StatusBadRequest, err.Error())
+			return
+		}
+		if clientGone(req) {
+			log.Print("abandoned paste: client went away")
+			return
+		}
+		noteName, err := createNoteWithRandomName(datastore, names, body, NoteOptions{Unlisted: unlisted, Title: title, ContentType: sniffContentType("", body)})
+		if err == errNotPermitted {
+			ErrorPage(resp, http.StatusForbidden)
+			return
+		}
+		if message, ok := rejectionMessage(err); ok {
+			ErrorMessage(resp, rejectionStatus(err), message)
+			return
+		}
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("error writing paste: %v", err)
+			return
+		}
+		listeners.publish(newNoteEvent(req, NOTE_CREATED, noteName, len(body)))
+		log.Printf("New note %s", noteName)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		fmt.Fprintf(resp, "%s\n", noteURL(requestBaseURL(baseURL, req)+boardPrefix(req), noteName))
+	}
+}
+
+// finds the paste in a parsed form, either as a plain value or as an uploaded file
+func readPasteForm(req *http.Request) ([]byte, bool, error) {
+	for _, field := range pasteFields {
+		if values, ok := req.Form[field]; ok && len(values) > 0 {
+			return []byte(values[0]), true, nil
+		}
+		if req.MultipartForm == nil {
+			continue
+		}
+		if files, ok := req.MultipartForm.File[field]; ok && len(files) > 0 {
+			file, err := files[0].Open()
+			if err != nil {
+				return nil, false, err
+			}
+			defer file.Close()
+			body := bytes.NewBuffer(nil)
+			_, err = body.ReadFrom(file)
+			return body.Bytes(), true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// explains why an empty note was refused
+const emptyBodyMessage = "the note is empty; to save it anyway, add ?allow-empty=true"
+
+// checks whether a note's body is empty, or only whitespace
+// such a note is almost always a mistake, like uploading a file which doesn't exist
+func emptyBody(body []byte) bool {
+	return len(bytes.TrimSpace(body)) == 0
+}
+
+// checks whether the client disconnected before its request was handled
+// a write is abandoned once its client has gone, since the client would never learn whether it happened
+func clientGone(req *http.Request) bool {
+	return req.Context().Err() != nil
+}
+
+// builds the public url of a note
+func noteURL(baseURL string, noteName string) string {
+	return notePath(strings.TrimSuffix(baseURL, "/"), noteName)
+}
+
+// the path of a note's page under a board's path, which is how every link to it is spelled;
+// Note redirects other spellings of it here, so browsers' histories and caches have one entry for each page
+// each part of the name is escaped as a path segment, and the names . and .. entirely, which old notes may have,
+// so they aren't taken for directories
+func notePath(base string, noteName string) string {
+	segment := escapeNoteName(noteName)
+	if noteName == "." || noteName == ".." {
+		segment = strings.Repeat("%2E", len(noteName))
+	}
+	return base + "/note/" + segment
+}
+
+// redirects a request for a note's page, or the page under it at suffix, to notePath's spelling of it,
+// like /note/%66oo to /note/foo, keeping the query
+// returns false if it was already spelled that way
+func redirectToCanonical(resp http.ResponseWriter, req *http.Request, noteName string, suffix string) bool {
+	canonical := notePath("", noteName) + suffix
+	if req.URL.EscapedPath() == canonical {
+		return false
+	}
+	location := boardPrefix(req) + canonical
+	if req.URL.RawQuery != "" {
+		location += "?" + req.URL.RawQuery
+	}
+	http.Redirect(resp, req, location, http.StatusMovedPermanently)
+	return true
+}
+
+// returns baseURL, or if it is empty, infers the base url from the request
+func requestBaseURL(baseURL string, req *http.Request) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
+
+func ErrorPage(resp http.ResponseWriter, code int) {
+	http.Error(resp, fmt.Sprintf("%d %s", code, http.StatusText(code)), code)
+}
+
+// like ErrorPage, but explains the error
+func ErrorMessage(resp http.ResponseWriter, code int, message string) {
+	http.Error(resp, fmt.Sprintf("%d %s: %s", code, http.StatusText(code), message), code)
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"flag"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// a clock which only moves when it's told to, for tests of anything that depends on the time
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(interval time.Duration) Ticker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ticker := &fakeTicker{c: make(chan time.Time, 1), interval: interval, next: c.now.Add(interval)}
+	c.tickers = append(c.tickers, ticker)
+	return ticker
+}
+
+// moves the clock forward, ticking each ticker whose next tick is due
+// like a time.Ticker, a ticker whose last tick hasn't been received drops the ones after it
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+	for _, ticker := range c.tickers {
+		ticker.advance(c.now)
+	}
+}
+
+// waits for n tickers to have been made, for code which makes its tickers on another goroutine
+func (c *fakeClock) waitForTickers(t testing.TB, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.lock.Lock()
+		made := len(c.tickers)
+		c.lock.Unlock()
+		if made >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("waited for %d tickers", n)
+}
+
+type fakeTicker struct {
+	c        chan time.Time
+	interval time.Duration
+	lock     sync.Mutex
+	next     time.Time
+	stopped  bool
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.stopped = true
+}
+
+func (t *fakeTicker) advance(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.stopped || now.Before(t.next) {
+		return
+	}
+	for !now.Before(t.next) {
+		t.next = t.next.Add(t.interval)
+	}
+	select {
+	case t.c <- now:
+	default:
+	}
+}
+
+// the time fake clocks start at
+var testEpoch = time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)
+
+// the default configuration, as if corkboard were run with args, but never with $CORKBOARD_CREDS
+func testConfig(t testing.TB, args ...string) Config {
+	t.Helper()
+	config, err := ParseFlags(flag.NewFlagSet("test", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Credentials = nil
+	return config
+}
+
+// a datastore with an up-to-date schema in a temporary directory, set up as NewApp would with config,
+// whose clock starts at testEpoch and only moves when it's advanced
+func testDatastore(t testing.TB, config Config) (Datastore, *fakeClock) {
+	t.Helper()
+	return testDatastoreThrough(t, config, "")
+}
+
+// like testDatastore, but with only the migrations up to and including the one in file applied,
+// or all of them if file is "", for tests of the migrations after it; see testMigrations
+func testDatastoreThrough(t testing.TB, config Config, file string) (Datastore, *fakeClock) {
+	t.Helper()
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	config.Hooks = config.Hooks.withDefaults(config)
+	datastore, err := openDatastore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { datastore.Close() })
+	clock := newFakeClock(testEpoch)
+	datastore.setClock(clock)
+	if err := datastore.RunMigrations(testMigrations(t, file)); err != nil {
+		t.Fatal(err)
+	}
+	return datastore, clock
+}
+
+// the embedded migrations up to and including the one in file, or all of them if file is ""
+func testMigrations(t testing.TB, file string) fs.FS {
+	t.Helper()
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file == "" {
+		return migrations
+	}
+	last, err := parseMigrationName(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := findMigrations(migrations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	through := fstest.MapFS{}
+	for _, f := range files {
+		if last.before(f.migration) {
+			continue
+		}
+		contents, err := fs.ReadFile(migrations, f.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		through[f.path] = &fstest.MapFile{Data: contents}
+	}
+	return through
+}
+
+// an app made from config serving on a local port, with a new database in a temporary directory
+// like servertest.New, for tests inside the package; its background work isn't started
+func testServer(t testing.TB, config Config) *httptest.Server {
+	t.Helper()
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	app, err := NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(app.Router())
+	t.Cleanup(func() {
+		server.Close()
+		app.Close()
+	})
+	return server
+}
+
+// an app made from config, with a new database in a temporary directory, whose clock starts at testEpoch
+func testApp(t testing.TB, config Config) (*App, *fakeClock) {
+	t.Helper()
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	app, err := NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { app.Close() })
+	clock := newFakeClock(testEpoch)
+	app.datastore.setClock(clock)
+	return app, clock
+}
+
+// the statuses of the responses a handler has given, in order
+type statusRecorder struct {
+	lock     sync.Mutex
+	statuses []int
+}
+
+func (r *statusRecorder) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req)
+		r.lock.Lock()
+		r.statuses = append(r.statuses, recorder.Code)
+		r.lock.Unlock()
+		for key, values := range recorder.Header() {
+			resp.Header()[key] = values
+		}
+		resp.WriteHeader(recorder.Code)
+		resp.Write(recorder.Body.Bytes())
+	})
+}
+
+func (r *statusRecorder) last() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(r.statuses) == 0 {
+		return 0
+	}
+	return r.statuses[len(r.statuses)-1]
+}
+
+// the path of a new database with n notes from the seed generator, created and viewed over the week before testEpoch,
+// for tests and benchmarks which need a board in use; the same n and sizes make the same notes
+func seededDatabase(t testing.TB, n int, sizes sizeRange) string {
+	t.Helper()
+	config := testConfig(t)
+	config.DatabasePath = filepath.Join(t.TempDir(), "seeded.db")
+	config.CreateDB = true
+	datastore, err := openDatastore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datastore.Close()
+	datastore.setClock(newFakeClock(testEpoch))
+	if err := datastore.RunMigrations(testMigrations(t, "")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = seedRandomNotes(datastore, rand.New(rand.NewSource(int64(n))), n, sizes, "demo-", 7*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config.DatabasePath
+}
+
+// serves a request without credentials, returning the response
+func serveRequest(t testing.TB, handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return resp
+}
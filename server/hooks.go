@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// WriteHook is asked before every note's body is written, however it's written: through the api, a form,
+// a tcp paste, an import or a digest
+// options are those the write sets, which for a compare-and-swap or increment are none
+// returning a WriteRejection refuses the note, and any other error fails the write
+type WriteHook interface {
+	BeforeWrite(ctx context.Context, name string, body []byte, options NoteOptions) error
+}
+
+// RetentionHook decides how long some notes are kept, instead of -note-expiry and -retention
+// it's asked each time a note is written or its metadata changes, and about every note at startup,
+// so what it decides may only depend on the note
+// returns false to leave the note to the other hooks and then the flags; a duration of zero keeps the note forever
+type RetentionHook interface {
+	RetentionOverride(ctx context.Context, note NoteInfo) (time.Duration, bool)
+}
+
+// WriteRejection is the error a WriteHook refuses a note with
+// the api responds with 422, the code "rejected" and the message, so it should say what to change
+type WriteRejection struct {
+	Message string
+}
+
+func (r WriteRejection) Error() string {
+	return r.Message
+}
+
+// Hooks are the policies a program embedding corkboard adds to its own
+// set Config.Hooks to them before NewApp, or register them with the App's OnWrite and OnRetention
+// the first retention hook to decide on a note wins
+// hooks see notes by the names they're stored under, which with -private-notes begin with their owner, like "alice/todo"
+// corkboard's own -max-note-size and -retention are hooks too: the size limit is asked before any other
+// write hook, and the -retention rules after every other retention hook, so notes follow them when no hook decides
+type Hooks struct {
+	writes    []WriteHook
+	retention []RetentionHook
+	// corkboard's own, set up by withDefaults
+	builtinWrites    []WriteHook
+	builtinRetention []RetentionHook
+}
+
+func (hooks *Hooks) OnWrite(hook WriteHook) {
+	hooks.writes = append(hooks.writes, hook)
+}
+
+func (hooks *Hooks) OnRetention(hook RetentionHook) {
+	hooks.retention = append(hooks.retention, hook)
+}
+
+// hooks with corkboard's own policies from config added to them, for an App to share between its datastores
+// hooks itself isn't changed, so one set can be given to more than one App
+func (hooks *Hooks) withDefaults(config Config) *Hooks {
+	all := &Hooks{}
+	if hooks != nil {
+		all.writes = append(all.writes, hooks.writes...)
+		all.retention = append(all.retention, hooks.retention...)
+	}
+	if config.WritePolicy.MaxNoteSize > 0 {
+		all.builtinWrites = append(all.builtinWrites, sizeLimit(config.WritePolicy.MaxNoteSize))
+	}
+	if len(config.Retention) > 0 {
+		all.builtinRetention = append(all.builtinRetention, prefixRetention{config.Retention, config.PrivateNotes})
+	}
+	return all
+}
+
+// asks every write hook about a note, stopping at the first which refuses it
+func (hooks *Hooks) beforeWrite(ctx context.Context, name string, body []byte, options NoteOptions) error {
+	if hooks == nil {
+		return nil
+	}
+	for _, list := range [][]WriteHook{hooks.builtinWrites, hooks.writes} {
+		for _, hook := range list {
+			if err := hook.BeforeWrite(ctx, name, body, options); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// whether any retention hooks are registered, so notes' retention_override needs to be kept up to date
+func (hooks *Hooks) retains() bool {
+	return hooks != nil && len(hooks.retention)+len(hooks.builtinRetention) > 0
+}
+
+// how long the first retention hook to decide on a note keeps it, and whether any did
+func (hooks *Hooks) retentionOverride(ctx context.Context, note NoteInfo) (time.Duration, bool) {
+	if age, ok := hooks.addedRetentionOverride(ctx, note); ok {
+		return age, true
+	}
+	if hooks == nil {
+		return 0, false
+	}
+	for _, hook := range hooks.builtinRetention {
+		if age, ok := hook.RetentionOverride(ctx, note); ok {
+			return age, true
+		}
+	}
+	return 0, false
+}
+
+// like retentionOverride, but asking only the hooks the embedding program added, not the -retention rules
+func (hooks *Hooks) addedRetentionOverride(ctx context.Context, note NoteInfo) (time.Duration, bool) {
+	if hooks == nil {
+		return 0, false
+	}
+	for _, hook := range hooks.retention {
+		if age, ok := hook.RetentionOverride(ctx, note); ok {
+			return age, true
+		}
+	}
+	return 0, false
+}
+
+// the -max-note-size write hook, refusing notes larger than this many bytes
+type sizeLimit int64
+
+func (max sizeLimit) BeforeWrite(_ context.Context, _ string, body []byte, _ NoteOptions) error {
+	if int64(len(body)) > int64(max) {
+		return WriteRejection{fmt.Sprintf("notes can't be larger than %d bytes", max)}
+	}
+	return nil
+}
+
+// the message a WriteHook or the quota refused a write with, and whether one did, for pages to show
+func rejectionMessage(err error) (string, bool) {
+	var exceeded QuotaExceeded
+	if errors.As(err, &exceeded) {
+		return exceeded.Error(), true
+	}
+	var rejection WriteRejection
+	if !errors.As(err, &rejection) {
+		return "", false
+	}
+	return rejection.Message, true
+}
+
+// the status a page responds to a refused write with: 507 for the quota, or 422 for a WriteHook
+func rejectionStatus(err error) int {
+	if errors.As(err, &QuotaExceeded{}) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusUnprocessableEntity
+}
+
+// responds to a write refused by a WriteHook or the quota, returning whether it was one
+func respondRejected(resp http.ResponseWriter, err error) bool {
+	if respondQuotaExceeded(resp, err) {
+		return true
+	}
+	message, ok := rejectionMessage(err)
+	if ok {
+		APIErrorMessage(resp, http.StatusUnprocessableEntity, ERR_REJECTED, message)
+	}
+	return ok
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keeps notes whose names begin with prefix for age
+type prefixHook struct {
+	prefix string
+	age    time.Duration
+}
+
+func (h prefixHook) RetentionOverride(_ context.Context, note NoteInfo) (time.Duration, bool) {
+	return h.age, strings.HasPrefix(note.Name, h.prefix)
+}
+
+// the -retention rules are a retention hook asked after the others, so a hook can override them,
+// and the expiry sweep follows whichever decided
+func TestRetentionRulesAreAHook(t *testing.T) {
+	config := testConfig(t, "-note-expiry", "1", "-retention", "tmp-=1h", "-retention", "keep-=never")
+	config.Hooks = &Hooks{}
+	config.Hooks.OnRetention(prefixHook{"tmp-special", 3 * time.Hour})
+	config.Hooks.OnRetention(prefixHook{"dept-", 0})
+	datastore, clock := testDatastore(t, config)
+	expiry := config.expiry()
+	expiry.hooks = datastore.hooks
+
+	cases := []struct {
+		name string
+		// what GET /api/note/:note/metadata says about it
+		rule    string
+		hook    bool
+		age     string
+		expired time.Duration
+	}{
+		{"tmp-scratch", "tmp-", false, "1h0m0s", time.Hour},
+		{"tmp-special", "", true, "3h0m0s", 3 * time.Hour},
+		{"keep-forever", "keep-", false, "never", 0},
+		{"dept-records", "", true, "never", 0},
+		{"plain", "", false, "24h0m0s", 24 * time.Hour},
+	}
+	for _, c := range cases {
+		if _, err := datastore.setNote(c.name, []byte("body"), false); err != nil {
+			t.Fatal(err)
+		}
+		info, _, err := datastore.getNoteInfo(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := expiry.noteExpiry(c.name, info)
+		if got.Rule != c.rule || got.Hook != c.hook || got.Age != c.age {
+			t.Errorf("%s: got rule %q, hook %v, age %s; want %q, %v, %s", c.name, got.Rule, got.Hook, got.Age, c.rule, c.hook, c.age)
+		}
+	}
+
+	// step through the day, checking each note expires when whatever decided on it says
+	for _, step := range []time.Duration{time.Hour, 3 * time.Hour, 24 * time.Hour} {
+		clock.Advance(step - clock.Now().Sub(testEpoch) + time.Second)
+		notes, err := datastore.listExpiringNotes(expiry.age, expiry.unviewedAge, expiry.policy, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expired := map[string]bool{}
+		for _, note := range notes {
+			expired[note.Name] = true
+		}
+		for _, c := range cases {
+			want := c.expired != 0 && c.expired <= step
+			if expired[c.name] != want {
+				t.Errorf("after %s: %s expired %v, want %v", step, c.name, expired[c.name], want)
+			}
+		}
+	}
+}
+
+// -max-note-size is a write hook, so it applies to every way of writing a note
+func TestMaxNoteSizeIsAWriteHook(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t, "-max-note-size", "5"))
+	if _, err := datastore.setNote("small", []byte("12345"), false); err != nil {
+		t.Fatalf("a note of the largest size was refused: %v", err)
+	}
+	writes := map[string]func() error{
+		"set": func() error {
+			_, err := datastore.setNote("large", []byte("123456"), false)
+			return err
+		},
+		"compare and swap": func() error {
+			_, err := datastore.swapNote("small", hashBody([]byte("12345")), []byte("123456"))
+			return err
+		},
+	}
+	for name, write := range writes {
+		err := write()
+		var rejection WriteRejection
+		if !errors.As(err, &rejection) {
+			t.Errorf("%s: got %v, want a WriteRejection", name, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lets a POST creating a note be retried safely: a retry with the same key gets the response the first POST did,
+// rather than 409
+const idempotencyHeader = "X-Idempotency-Key"
+
+// longest idempotency key accepted, in bytes
+const maxIdempotencyKeyLength = 255
+
+// the request's idempotency key, or "" if it has none
+func requestIdempotencyKey(req *http.Request) (string, error) {
+	key := strings.TrimSpace(req.Header.Get(idempotencyHeader))
+	if len(key) > maxIdempotencyKeyLength {
+		return "", noteFormError{idempotencyHeader + " is too long"}
+	}
+	return key, nil
+}
+
+// if a POST with the same idempotency key created the note within age, responds as it did
+// a retry whose body is different is a conflict, since it can't be the same POST
+// returns whether it responded
+func replayIdempotentPost(resp http.ResponseWriter, datastore Datastore, name string, key string, hash string, age time.Duration) bool {
+	post, ok, err := datastore.getIdempotencyKey(name, key, age)
+	if err != nil {
+		APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+		log.Printf("looking up idempotency key for %s: %v", name, err)
+		return true
+	}
+	if !ok {
+		return false
+	}
+	if post.Hash != hash {
+		APIErrorMessage(resp, http.StatusConflict, ERR_CONFLICT, idempotencyHeader+" was already used to create this note with other contents")
+		return true
+	}
+	log.Printf("Replayed creating note %s", name)
+	if post.DuplicateOf != "" {
+		respondDuplicate(resp, post.DuplicateOf)
+		return true
+	}
+	ErrorPage(resp, http.StatusCreated)
+	return true
+}
+
+// the time a request's note must not have changed since for it to be overwritten,
+// from the if-unmodified-since query parameter as an RFC 3339 time, or the If-Unmodified-Since header
+// returns the zero time if neither is given
+func requestUnmodifiedSince(req *http.Request) (time.Time, error) {
+	if query := req.URL.Query().Get("if-unmodified-since"); query != "" {
+		since, err := time.Parse(time.RFC3339, query)
+		if err != nil {
+			return since, noteFormError{"if-unmodified-since must be a time like 2026-10-17T09:30:00Z"}
+		}
+		return since, nil
+	}
+	if header := req.Header.Get("If-Unmodified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return since, noteFormError{"If-Unmodified-Since isn't a valid HTTP date"}
+		}
+		return since, nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a WriteHook made from a function
+type writeHookFunc func(ctx context.Context, name string, body []byte, options NoteOptions) error
+
+func (f writeHookFunc) BeforeWrite(ctx context.Context, name string, body []byte, options NoteOptions) error {
+	return f(ctx, name, body, options)
+}
+
+// POSTs body to a note with an idempotency key, or none if key is ""
+func postWithKey(t *testing.T, handler http.Handler, name string, key string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/note/"+name, strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(idempotencyHeader, key)
+	}
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	return resp
+}
+
+// a POST retried after its response was lost, as after a network timeout, gets the response the first one did,
+// as long as it's the same POST and it's retried within -idempotency-retention
+func TestIdempotentPostRetried(t *testing.T) {
+	app, clock := testApp(t, testConfig(t, "-idempotency-retention", "1h"))
+	handler := app.Router()
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		note       string
+		key        string
+		body       string
+		wantStatus int
+		wantCode   string
+		// part of the response's body, if it isn't an error
+		wantBody string
+	}{
+		{"first try", 0, "todo", "k1", "hello", http.StatusCreated, "", "201 Created"},
+		{"retried", time.Minute, "todo", "k1", "hello", http.StatusCreated, "", "201 Created"},
+		{"retried again", time.Minute, "todo", "k1", "hello", http.StatusCreated, "", "201 Created"},
+		{"the same key with other contents", 0, "todo", "k1", "goodbye", http.StatusConflict, ERR_CONFLICT, ""},
+		{"another key", 0, "todo", "k2", "hello", http.StatusConflict, ERR_NOTE_EXISTS, ""},
+		{"no key", 0, "todo", "", "hello", http.StatusConflict, ERR_NOTE_EXISTS, ""},
+		{"the same key for another note", 0, "other", "k1", "other", http.StatusCreated, "", "201 Created"},
+		// the duplicate the first try found is reported again
+		{"a duplicate", 0, "copy", "k3", "hello", http.StatusCreated, "", `"duplicateOf":"todo"`},
+		{"a duplicate retried", time.Minute, "copy", "k3", "hello", http.StatusCreated, "", `"duplicateOf":"todo"`},
+		{"retried too late", time.Hour, "todo", "k1", "hello", http.StatusConflict, ERR_NOTE_EXISTS, ""},
+	}
+	for _, step := range steps {
+		clock.Advance(step.advance)
+		resp := postWithKey(t, handler, step.note, step.key, step.body)
+		if resp.Code != step.wantStatus {
+			t.Fatalf("%s: got %d %q, want %d", step.name, resp.Code, resp.Body, step.wantStatus)
+		}
+		if step.wantCode != "" {
+			if code := errorCode(t, resp); code != step.wantCode {
+				t.Errorf("%s: got the code %q, want %q", step.name, code, step.wantCode)
+			}
+		} else if !strings.Contains(resp.Body.String(), step.wantBody) {
+			t.Errorf("%s: responded %q, want %q in it", step.name, resp.Body, step.wantBody)
+		}
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/todo", ""); resp.Body.String() != "hello" {
+		t.Errorf("todo says %q, want hello", resp.Body)
+	}
+}
+
+// a retry which arrives while the first try is still being saved finds the note created, then checks its key again,
+// so it gets the first try's response rather than 409, as long as it really is a retry
+func TestIdempotentPostRace(t *testing.T) {
+	cases := []struct {
+		name string
+		// the key and body the first try, which finishes while the retry is being written, was sent with
+		firstKey   string
+		firstBody  string
+		wantStatus int
+		wantCode   string
+	}{
+		{"the same post", "k1", "hello", http.StatusCreated, ""},
+		{"other contents", "k1", "goodbye", http.StatusConflict, ERR_CONFLICT},
+		{"another key", "k2", "hello", http.StatusConflict, ERR_NOTE_EXISTS},
+		{"no key", "", "hello", http.StatusConflict, ERR_NOTE_EXISTS},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := testConfig(t)
+			config.Hooks = &Hooks{}
+			var app *App
+			raced := false
+			// the retry has looked for its key and not found it, so the first try is saved before it's written
+			config.Hooks.OnWrite(writeHookFunc(func(_ context.Context, name string, _ []byte, _ NoteOptions) error {
+				if raced {
+					return nil
+				}
+				raced = true
+				if _, err := app.datastore.setNote(name, []byte(c.firstBody), false); err != nil {
+					return err
+				}
+				if c.firstKey == "" {
+					return nil
+				}
+				return app.datastore.saveIdempotencyKey(name, c.firstKey, IdempotentPost{Hash: hashBody([]byte(c.firstBody))})
+			}))
+			app, _ = testApp(t, config)
+			handler := app.Router()
+
+			resp := postWithKey(t, handler, "todo", "k1", "hello")
+			if !raced {
+				t.Fatal("the first try wasn't saved")
+			}
+			if resp.Code != c.wantStatus {
+				t.Fatalf("got %d %q, want %d", resp.Code, resp.Body, c.wantStatus)
+			}
+			if c.wantCode != "" {
+				if code := errorCode(t, resp); code != c.wantCode {
+					t.Errorf("got the code %q, want %q", code, c.wantCode)
+				}
+			}
+			if resp := serveRequest(t, handler, http.MethodGet, "/api/note/todo", ""); resp.Body.String() != c.firstBody {
+				t.Errorf("todo says %q, want the first try's %q", resp.Body, c.firstBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// a repeatable flag collecting glob patterns
+type globList []string
+
+func (g *globList) String() string {
+	return strings.Join(*g, ",")
+}
+
+func (g *globList) Set(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("bad pattern %q", pattern)
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+// checks whether a file matches any of the patterns, by either its base name or its relative path
+func (g globList) matches(relative string) bool {
+	for _, pattern := range g {
+		if matched, _ := path.Match(pattern, path.Base(relative)); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, relative); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// corkboard import-dir [flags] path
+// creates a note from each file under a directory, named after its path relative to the directory
+// each note is dated by its file's modification time
+func importDir(datastore Datastore, args []string) error {
+	flags := flag.NewFlagSet("import-dir", flag.ContinueOnError)
+	var exclude globList
+	flags.Var(&exclude, "exclude", "Skip files matching this glob, e.g. \"*.bak\". May be repeated.")
+	clobber := flags.Bool("clobber", false, "Overwrite notes which already exist, rather than skipping them.")
+	dryRun := flags.Bool("dry-run", false, "List what would be imported without changing anything.")
+	hidden := flags.Bool("hidden", false, "Import hidden files and the contents of hidden directories.")
+	maxSize := flags.Int64("max-size", 10<<20, "Skip files larger than this many bytes.")
+	separator := flags.String("separator", "-", "Replace the slashes in the paths of files in subdirectories with this,\nsince note names can't contain slashes.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] import-dir [import flags] path\nCreates a note from each file under path.\nImport flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one path")
+	}
+	if strings.Contains(*separator, "/") {
+		return fmt.Errorf("-separator can't contain a slash")
+	}
+	root := positional[0]
+
+	var created, updated, existing, tooLarge, excluded int
+	err = filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		relative = filepath.ToSlash(relative)
+		if relative == "." {
+			return nil
+		}
+		if !*hidden && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if exclude.matches(relative) {
+			excluded += 1
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		name := strings.ReplaceAll(relative, "/", *separator)
+		if err := validateNoteName(name); err != nil {
+			fmt.Printf("skipping %s: %v\n", relative, err)
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() > *maxSize {
+			fmt.Printf("skipping %s: larger than %d bytes\n", relative, *maxSize)
+			tooLarge += 1
+			return nil
+		}
+		exists, err := datastore.noteExists(name)
+		if err != nil {
+			return err
+		}
+		if exists && !*clobber {
+			fmt.Printf("skipping %s: note %s already exists\n", relative, name)
+			existing += 1
+			return nil
+		}
+		if *dryRun {
+			if exists {
+				fmt.Printf("would overwrite %s with %s\n", name, relative)
+				updated += 1
+			} else {
+				fmt.Printf("would create %s from %s\n", name, relative)
+				created += 1
+			}
+			return nil
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		status, err := datastore.setNoteWithTimes(name, body, *clobber, NoteOptions{}, info.ModTime(), info.ModTime(), info.ModTime())
+		if err != nil {
+			return fmt.Errorf("importing %s: %v", relative, err)
+		}
+		switch status {
+		case CREATED:
+			created += 1
+		case UPDATED:
+			updated += 1
+		case NO_CLOBBER:
+			// created since we checked
+			existing += 1
+		}
+		return nil
+	})
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d new notes, overwriting %d; skipped %d existing, %d too large and %d excluded\n",
+		verb, created, updated, existing, tooLarge, excluded)
+	return err
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// the index logs a failure to reach the database at most this often, rather than on every request
+const indexErrorLogInterval = time.Minute
+
+// longest a rendered index is served for
+// notes can change without an event, e.g. when they expire, so the index is eventually rebuilt anyway
+const indexCacheMaxAge = 10 * time.Second
+
+// IndexCache holds the rendered index page, so that it isn't rebuilt on every request
+// the page shows who's signed in and which boards they can open, so each user has their own copy
+// it's emptied whenever a note or the banner changes
+type IndexCache struct {
+	lock  sync.Mutex
+	pages map[string]cachedPage
+	// counts changes, so a page rendered while a note changed isn't kept
+	generation uint64
+	// when an error was last logged, and how many haven't been since
+	lastErrorLog   time.Time
+	unloggedErrors int
+}
+
+func (c *IndexCache) noteChanged(event NoteEvent) {
+	c.invalidate()
+}
+
+// empties the cache, so every page is rendered afresh
+func (c *IndexCache) invalidate() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation += 1
+	c.pages = nil
+}
+
+// logs an error rendering the index, unless one was logged recently
+func (c *IndexCache) logError(now time.Time, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if now.Sub(c.lastErrorLog) < indexErrorLogInterval {
+		c.unloggedErrors += 1
+		return
+	}
+	if c.unloggedErrors > 0 {
+		log.Printf("%v (and %d more errors rendering the index)", err, c.unloggedErrors)
+	} else {
+		log.Print(err)
+	}
+	c.lastErrorLog = now
+	c.unloggedErrors = 0
+}
+
+// a rendered page, and when it was rendered
+type cachedPage struct {
+	body     []byte
+	rendered time.Time
+}
+
+// returns the user's cached page if it's fresh, or else renders and caches it
+func (c *IndexCache) get(now time.Time, user string, render func() ([]byte, error)) ([]byte, error) {
+	c.lock.Lock()
+	if page, ok := c.pages[user]; ok && now.Sub(page.rendered) < indexCacheMaxAge {
+		c.lock.Unlock()
+		return page.body, nil
+	}
+	generation := c.generation
+	c.lock.Unlock()
+
+	page, err := render()
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation == generation {
+		if c.pages == nil {
+			c.pages = make(map[string]cachedPage)
+		}
+		c.pages[user] = cachedPage{body: page, rendered: now}
+	}
+	return page, nil
+}
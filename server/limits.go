@@ -0,0 +1,86 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// how long a write may wait for a slot before it's turned away
+const writeQueueTimeout = 5 * time.Second
+
+// number of writes currently being handled, exported at /debug/vars
+var writesInFlight = expvar.NewInt("writes_in_flight")
+
+// WriteLimiter limits how many write requests are handled at once,
+// so a burst of large uploads can't exhaust memory or starve the database
+type WriteLimiter struct {
+	// holds a token for each write in progress; nil if writes aren't limited
+	slots chan struct{}
+}
+
+// limits writes to max at once; if max is zero, writes aren't limited
+func NewWriteLimiter(max int) *WriteLimiter {
+	limiter := &WriteLimiter{}
+	if max > 0 {
+		limiter.slots = make(chan struct{}, max)
+	}
+	return limiter
+}
+
+// middleware which waits for a free slot before handling a request
+// if none frees up within writeQueueTimeout, it responds 503 and asks the client to retry
+// whatever the request's body the handler doesn't read is dealt with by drainBody
+func (l *WriteLimiter) limit(h httprouter.Handle) httprouter.Handle {
+	return drainBody(func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if l.slots != nil {
+			timeout := time.NewTimer(writeQueueTimeout)
+			select {
+			case l.slots <- struct{}{}:
+				timeout.Stop()
+				defer func() { <-l.slots }()
+			case <-timeout.C:
+				resp.Header().Set("Retry-After", strconv.Itoa(int(writeQueueTimeout/time.Second)))
+				errorResponse(resp, req, http.StatusServiceUnavailable)
+				return
+			case <-req.Context().Done():
+				timeout.Stop()
+				return
+			}
+		}
+		writesInFlight.Add(1)
+		defer writesInFlight.Add(-1)
+		h(resp, req, params)
+	})
+}
+
+// the variables corkboard publishes, which are all /debug/vars serves
+// the standard cmdline isn't one, since the command line may hold credentials and secrets
+var debugVars = []string{"writes_in_flight", "dropped_views", "last_cleanup"}
+
+// serves corkboard's exported variables, like the number of writes in flight, as json
+// in the same format as expvar.Handler
+func DebugVars() httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(resp, "{\n")
+		first := true
+		for _, name := range debugVars {
+			v := expvar.Get(name)
+			if v == nil {
+				// last_cleanup is only published once maintenance starts
+				continue
+			}
+			if !first {
+				fmt.Fprintf(resp, ",\n")
+			}
+			first = false
+			fmt.Fprintf(resp, "%q: %s", name, v)
+		}
+		fmt.Fprintf(resp, "\n}\n")
+	}
+}
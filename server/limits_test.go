@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// under a burst of writes, no more than the limit run at once, and every write waiting less than
+// writeQueueTimeout for a slot is handled rather than turned away
+func TestWriteLimiterUnderLoad(t *testing.T) {
+	const max = 4
+	const writes = 40
+	var running, peak int64
+	handler := func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		now := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			old := atomic.LoadInt64(&peak)
+			if now <= old || atomic.CompareAndSwapInt64(&peak, old, now) {
+				break
+			}
+		}
+		// long enough for the writes to pile up, short enough that none waits writeQueueTimeout
+		time.Sleep(20 * time.Millisecond)
+		resp.WriteHeader(http.StatusCreated)
+	}
+	router := httprouter.New()
+	router.PUT("/api/note/:note", NewWriteLimiter(max).limit(handler))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	statuses := make(chan int, writes)
+	var wg sync.WaitGroup
+	for i := 0; i < writes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, err := http.NewRequest(http.MethodPut, server.URL+"/api/note/load", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	handled := 0
+	for status := range statuses {
+		if status != http.StatusCreated {
+			t.Errorf("got %d, want every write handled", status)
+		}
+		handled++
+	}
+	if handled != writes {
+		t.Errorf("%d of %d writes got a response", handled, writes)
+	}
+	if peak > max {
+		t.Errorf("%d writes ran at once, want at most %d", peak, max)
+	}
+	if peak < max {
+		t.Errorf("at most %d writes ran at once, so the limit of %d was never reached", peak, max)
+	}
+	if n := writesInFlight.Value(); n != 0 {
+		t.Errorf("writes_in_flight is %d after every write finished", n)
+	}
+}
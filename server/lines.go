@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// notes up to this size have numbered lines on their page, which can be linked to like #L10-L20
+const maxNumberedSize = 256 << 10
+
+// NoteLine is a numbered line of a note, for the note.html template
+type NoteLine struct {
+	Number int
+	Text   string
+}
+
+// splits a note into numbered lines
+// a final newline doesn't start another line
+func numberLines(body string) []NoteLine {
+	texts := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	lines := make([]NoteLine, len(texts))
+	for i, text := range texts {
+		lines[i] = NoteLine{Number: i + 1, Text: text}
+	}
+	return lines
+}
+
+// copies lines from through to of r to w, counting from 1
+// lines past the end of r are ignored, so a range which starts past the end copies nothing
+// lines are read one at a time, so they may be any length
+func copyLines(w io.Writer, r io.Reader, from int, to int) error {
+	reader := bufio.NewReader(r)
+	for number := 1; number <= to; number++ {
+		line, err := reader.ReadBytes('\n')
+		if number >= from && len(line) > 0 {
+			if _, writeErr := w.Write(line); writeErr != nil {
+				return writeErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// responds with a range of a note's lines, from the from query parameter to the to query parameter
+// lines are counted from 1, and both ends are included
+// from defaults to the first line and to defaults to the last
+func NoteLines(datastore Datastore, analytics *Analytics) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		query := req.URL.Query()
+		from, to := 1, int(^uint(0)>>1)
+		var err error
+		if query.Get("from") != "" {
+			from, err = strconv.Atoi(query.Get("from"))
+			if err != nil || from < 1 {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "from must be a line number, counting from 1")
+				return
+			}
+		}
+		if query.Get("to") != "" {
+			to, err = strconv.Atoi(query.Get("to"))
+			if err != nil || to < 1 {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "to must be a line number, counting from 1")
+				return
+			}
+		}
+		if from > to {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "from must not be after to")
+			return
+		}
+		body, ok, err := datastore.getNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		sandboxContent(resp)
+		err = copyLines(resp, bytes.NewReader(body), from, to)
+		if err != nil {
+			log.Printf("writing lines of %s: %v", noteName, err)
+		}
+	}
+}
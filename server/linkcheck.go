@@ -0,0 +1,413 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most links checked in each note; any more are left out
+const maxLinksPerNote = 100
+
+// how much of a response to a GET is read, so the connection can be reused
+const maxLinkCheckRead = 4 << 10
+
+// what link checks identify themselves as
+const linkCheckUserAgent = "corkboard-linkcheck/" + corkboardVersion
+
+// http and https urls in text, up to whitespace or characters which usually end them, like quotes and brackets
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `{}|\\^\[\]]+`)
+
+// LinkCheckConfig says how, and how often, the links in notes are checked
+type LinkCheckConfig struct {
+	// if zero, links aren't checked
+	Interval time.Duration
+	// notes larger than this many bytes aren't checked
+	MaxSize int
+	// most hosts checked at once
+	Concurrency int
+	// how long to wait between checks of links on the same host
+	HostDelay time.Duration
+	// if any are given, only links on these hosts and their subdomains are checked
+	Allow []string
+	// links on these hosts and their subdomains are never checked
+	Deny []string
+}
+
+// whether links on a host may be checked
+func (config LinkCheckConfig) checks(host string) bool {
+	host = strings.ToLower(host)
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if host == pattern || strings.HasSuffix(host, "."+pattern) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(config.Deny) {
+		return false
+	}
+	return len(config.Allow) == 0 || matches(config.Allow)
+}
+
+// parses a comma-separated list of hosts, like "example.com,docs.example.org"
+func parseHostList(list string) ([]string, error) {
+	hosts := []string{}
+	for _, host := range strings.Split(list, ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if strings.ContainsAny(host, "/:@ ") {
+			return nil, fmt.Errorf("%q isn't a host name", host)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// whether a note with a content type may be text, so its links can be checked
+// notes without one are text if their bodies are
+func textContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || fetchableTypes[mediaType]
+}
+
+// the distinct http and https links in text, in the order they first appear
+// punctuation ending a sentence, or a bracket closing around a link, isn't part of it
+func extractLinks(text string) []string {
+	links := []string{}
+	seen := map[string]bool{}
+	for _, match := range linkPattern.FindAllString(text, -1) {
+		for {
+			trimmed := strings.TrimRight(match, ".,;:!?*")
+			if strings.HasSuffix(trimmed, ")") && strings.Count(trimmed, "(") < strings.Count(trimmed, ")") {
+				trimmed = trimmed[:len(trimmed)-1]
+			}
+			if trimmed == match {
+				break
+			}
+			match = trimmed
+		}
+		link, err := url.Parse(match)
+		if err != nil || link.Hostname() == "" || link.User != nil {
+			continue
+		}
+		// the fragment is never sent, so links differing only in it are the same
+		link.Fragment = ""
+		match = link.String()
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		links = append(links, match)
+		if len(links) == maxLinksPerNote {
+			break
+		}
+	}
+	return links
+}
+
+// whether a link check found the link broken
+// a link which is rate limited is still there, so it isn't
+func linkBroken(status int) bool {
+	return status == 0 || (status >= 400 && status != http.StatusTooManyRequests)
+}
+
+// LinkChecker checks the links in text notes every interval, in the background
+// each host is checked by one worker at a time, waiting between its links, so no site is hammered
+type LinkChecker struct {
+	datastore Datastore
+	config    LinkCheckConfig
+	client    *http.Client
+	// cancelled to abandon the checks in progress when shutting down
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// the last run, for diagnostics
+	mutex   sync.Mutex
+	lastRun LinkCheckRun
+}
+
+// LinkCheckRun is what a run of the link checker did
+type LinkCheckRun struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Notes  int       `json:"notes"`
+	Links  int       `json:"links"`
+	Broken int       `json:"broken"`
+}
+
+// creates a link checker for the notes in datastore
+// unless allowInternal is set, it doesn't check links to addresses which aren't on the public internet, like fetches
+func NewLinkChecker(datastore Datastore, config LinkCheckConfig, allowInternal bool) *LinkChecker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &LinkChecker{
+		datastore: datastore,
+		config:    config,
+		client:    NewFetcher(allowInternal).client,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// the last run, for diagnostics
+func (c *LinkChecker) diagnostics() interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastRun
+}
+
+// checks links every interval until stopped
+// the first check is an interval after the last one, even if it was before corkboard started,
+// so restarting doesn't check every link again
+func (c *LinkChecker) run() {
+	defer close(c.done)
+	last, err := c.datastore.lastLinkCheck()
+	if err != nil {
+		log.Printf("finding the last link check: %v", err)
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(time.Until(last.Add(c.config.Interval))):
+		}
+		last = time.Now()
+		c.checkAll()
+	}
+}
+
+// stops checking links, abandoning the checks in progress
+func (c *LinkChecker) shutdown() {
+	c.cancel()
+	<-c.done
+}
+
+// checks the links in every note which may have them, and saves the results
+func (c *LinkChecker) checkAll() {
+	run := LinkCheckRun{Start: time.Now().UTC()}
+	names, err := c.datastore.linkCheckNotes(c.config.MaxSize)
+	if err != nil {
+		log.Printf("listing notes to check links in: %v", err)
+		return
+	}
+	// every note's links, and the links on each host, which are only checked once however many notes have them
+	noteLinks := map[string][]string{}
+	hostLinks := map[string][]string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		// reading a note to check its links isn't a view of it
+		body, size, exists, err := c.datastore.peekNotePrefix(name, c.config.MaxSize)
+		if err != nil {
+			log.Printf("reading %s to check its links: %v", name, err)
+			continue
+		}
+		if !exists || size > int64(c.config.MaxSize) || !validUTF8Prefix(body, false) {
+			continue
+		}
+		links := []string{}
+		for _, link := range extractLinks(string(body)) {
+			parsed, _ := url.Parse(link)
+			host := strings.ToLower(parsed.Hostname())
+			if !c.config.checks(host) {
+				continue
+			}
+			links = append(links, link)
+			if !seen[link] {
+				seen[link] = true
+				hostLinks[host] = append(hostLinks[host], link)
+			}
+		}
+		noteLinks[name] = links
+	}
+	results := c.checkHosts(hostLinks)
+	if c.ctx.Err() != nil {
+		// the results are incomplete, so they aren't saved; they're checked again next time
+		return
+	}
+	for _, name := range names {
+		links, ok := noteLinks[name]
+		if !ok {
+			continue
+		}
+		checks := make([]LinkCheck, 0, len(links))
+		for _, link := range links {
+			check, ok := results[link]
+			if !ok {
+				continue
+			}
+			checks = append(checks, check)
+			if check.Broken {
+				run.Broken++
+			}
+		}
+		err := c.datastore.saveLinkChecks(name, checks)
+		if err != nil {
+			log.Printf("saving the link checks of %s: %v", name, err)
+			continue
+		}
+		run.Notes++
+		run.Links += len(checks)
+	}
+	run.End = time.Now().UTC()
+	c.mutex.Lock()
+	c.lastRun = run
+	c.mutex.Unlock()
+	log.Printf("Checked %d links in %d notes, %d broken", run.Links, run.Notes, run.Broken)
+}
+
+// checks the links on each host, with at most config.Concurrency hosts at once
+func (c *LinkChecker) checkHosts(hostLinks map[string][]string) map[string]LinkCheck {
+	results := map[string]LinkCheck{}
+	var mutex sync.Mutex
+	hosts := make(chan []string)
+	var workers sync.WaitGroup
+	for i := 0; i < c.config.Concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for links := range hosts {
+				for i, link := range links {
+					if i > 0 && !c.wait() {
+						return
+					}
+					check, ok := c.check(link)
+					if !ok {
+						continue
+					}
+					mutex.Lock()
+					results[link] = check
+					mutex.Unlock()
+				}
+			}
+		}()
+	}
+	for _, links := range hostLinks {
+		select {
+		case hosts <- links:
+		case <-c.ctx.Done():
+		}
+	}
+	close(hosts)
+	workers.Wait()
+	return results
+}
+
+// waits config.HostDelay between requests to the same host, returning false if shutting down instead
+func (c *LinkChecker) wait() bool {
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-time.After(c.config.HostDelay):
+		return true
+	}
+}
+
+// checks a link with HEAD, or with GET if the server won't answer HEAD properly
+// returns false if the link wasn't checked, because it leads somewhere fetches may not go, like the local network
+func (c *LinkChecker) check(link string) (LinkCheck, bool) {
+	check := LinkCheck{URL: link, CheckTime: time.Now().UTC().Truncate(time.Second)}
+	status, err := c.request(http.MethodHead, link)
+	// many servers refuse HEAD, or answer it differently from GET; the host is waited for again first
+	if err == nil && linkBroken(status) && c.wait() {
+		status, err = c.request(http.MethodGet, link)
+	}
+	check.Status = status
+	var refused fetchRefusedError
+	if errors.As(err, &refused) {
+		return check, false
+	}
+	if err != nil {
+		check.Status = 0
+		// the url is already in the check
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		check.Error = err.Error()
+	} else if linkBroken(status) {
+		check.Error = http.StatusText(status)
+	}
+	check.Broken = linkBroken(check.Status)
+	return check, true
+}
+
+// makes a request to a link, returning the status of the response
+func (c *LinkChecker) request(method string, link string) (int, error) {
+	req, err := http.NewRequestWithContext(c.ctx, method, link, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", linkCheckUserAgent)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxLinkCheckRead))
+	return resp.StatusCode, nil
+}
+
+// LinksData is the response to GET /api/note/:note/links
+type LinksData struct {
+	Broken int         `json:"broken"`
+	Links  []LinkCheck `json:"links"`
+}
+
+// lists the last checks of a note's links as json, with how many were broken
+// a note whose links haven't been checked, or aren't checked at all, has none
+func NoteLinks(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		exists, err := datastore.noteExists(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		checks, err := datastore.listLinkChecks(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing link checks of %s: %v", noteName, err)
+			return
+		}
+		data := LinksData{Links: checks}
+		for _, check := range checks {
+			if check.Broken {
+				data.Broken++
+			}
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(data)
+		if err != nil {
+			log.Printf("responding with links of %s: %v", noteName, err)
+		}
+	}
+}
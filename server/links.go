@@ -0,0 +1,85 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// longest note which can be a link, in bytes
+const maxLinkSize = 2048
+
+// schemes a link note may redirect to; anything else, like javascript:, is shown as text
+var linkSchemes = map[string]bool{"http": true, "https": true}
+
+// the url a note links to, if its whole body is a single http or https url
+func noteLink(body []byte) (string, bool) {
+	if len(body) > maxLinkSize {
+		return "", false
+	}
+	text := strings.TrimSpace(string(body))
+	if text == "" || strings.ContainsAny(text, " \t\r\n") {
+		return "", false
+	}
+	safe, ok := safeURL(text)
+	if !ok {
+		return "", false
+	}
+	target, err := url.Parse(safe)
+	if err != nil || !linkSchemes[strings.ToLower(target.Scheme)] || target.Host == "" || target.User != nil {
+		return "", false
+	}
+	return safe, true
+}
+
+// whether a link leads back to a /go/ link on this corkboard, which could redirect in a loop
+// baseURL is this corkboard's public url, as given to requestBaseURL
+func linkLoops(link string, baseURL string, req *http.Request) bool {
+	target, err := url.Parse(link)
+	if err != nil {
+		return true
+	}
+	hosts := map[string]bool{strings.ToLower(req.Host): true}
+	if base, err := url.Parse(requestBaseURL(baseURL, req)); err == nil {
+		hosts[strings.ToLower(base.Host)] = true
+	}
+	if !hosts[strings.ToLower(target.Host)] {
+		return false
+	}
+	// boards and shared notes have their own /go/ under their prefix
+	return strings.Contains(target.EscapedPath(), "/go/")
+}
+
+// redirects to the url a note links to, if its whole body is one, like a link shortener
+// a note which isn't a link redirects to its page
+// links to another /go/ link on this corkboard are refused, since they could redirect in a loop
+func GoNote(datastore Datastore, analytics *Analytics, baseURL string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		body, ok, err := datastore.getNote(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		link, ok := noteLink(body)
+		if !ok {
+			http.Redirect(resp, req, notePath(boardPrefix(req), noteName), http.StatusFound)
+			return
+		}
+		if linkLoops(link, baseURL, req) {
+			ErrorMessage(resp, http.StatusLoopDetected, "the note links to another /go/ link on this corkboard")
+			return
+		}
+		http.Redirect(resp, req, link, http.StatusFound)
+	}
+}
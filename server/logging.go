@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// LogConfig stores where logs are written
+type LogConfig struct {
+	File      string
+	MaxSize   int64
+	Keep      int
+	Syslog    bool
+	SyslogTag string
+}
+
+// a log file which is rotated once it grows past maxSize
+// the current file is at path, and older files are at path.1, path.2, etc
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// opens the file at f.path for appending
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		err := f.rotate()
+		if err != nil {
+			// keep logging to the old file rather than losing messages
+			fmt.Fprintf(os.Stderr, "rotating log file %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// shifts each old log file along by one, deleting the oldest, and starts a new file
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.keep))
+	for i := f.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if f.keep > 0 {
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+	if err != nil {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+// closes and reopens the file, for when it has been moved by an external tool like logrotate
+func (f *rotatingFile) reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.file.Close()
+	return f.open()
+}
+
+// points the standard logger at the configured destinations
+// falls back to stderr with a warning if a destination can't be opened
+func setupLogging(config LogConfig) {
+	writers := []io.Writer{}
+	if config.File != "" {
+		file, err := openRotatingFile(config.File, config.MaxSize, config.Keep)
+		if err != nil {
+			log.Printf("warning: unable to open log file %s, logging to stderr instead: %v", config.File, err)
+		} else {
+			writers = append(writers, file)
+			notifyReopen(func() {
+				err := file.reopen()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "reopening log file %s: %v\n", config.File, err)
+				}
+			})
+		}
+	}
+	if config.Syslog {
+		writer, err := openSyslog(config.SyslogTag)
+		if err != nil {
+			log.Printf("warning: unable to connect to syslog, logging to stderr instead: %v", err)
+		} else {
+			if len(writers) == 0 {
+				// syslog adds its own timestamps
+				log.SetFlags(0)
+			}
+			writers = append(writers, writer)
+		}
+	}
+	if len(writers) == 0 {
+		return
+	}
+	log.SetOutput(io.MultiWriter(writers...))
+}
@@ -0,0 +1,17 @@
+//go:build plan9
+// +build plan9
+
+package server
+
+import (
+	"errors"
+	"io"
+)
+
+// syslog isn't available on this platform
+func openSyslog(tag string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// there is no SIGUSR1 on this platform, so log files are never reopened
+func notifyReopen(reopen func()) {}
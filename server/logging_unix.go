@@ -0,0 +1,28 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package server
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// connects to the local syslog daemon
+func openSyslog(tag string) (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+}
+
+// calls reopen whenever the process receives SIGUSR1, like logrotate sends
+func notifyReopen(reopen func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			reopen()
+		}
+	}()
+}
@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// the event id logs are written with; corkboard only writes the one kind of event
+const eventID = 1
+
+// eventLogWriter writes each log line as an informational event
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	err := w.log.Info(eventID, strings.TrimSuffix(string(p), "\n"))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writes to the Windows event log, under the source -service install registers
+func openSyslog(tag string) (io.Writer, error) {
+	log, err := eventlog.Open(tag)
+	if err != nil {
+		return nil, err
+	}
+	return eventLogWriter{log}, nil
+}
+
+// there is no SIGUSR1 on Windows, so log files are never reopened
+func notifyReopen(reopen func()) {}
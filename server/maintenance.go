@@ -0,0 +1,407 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maintenance runs jobs which churn through the database, like expiry and vacuuming,
+// one at a time so that they never overlap
+type Maintenance struct {
+	datastore Datastore
+	lock      sync.Mutex
+	// the results of the most recent integrity check and cleanup, or nil if there hasn't been one
+	// guarded by their own lock, so they can be read while a job runs
+	lastCheck   *IntegrityCheck
+	lastCleanup *CleanupRun
+	resultsLock sync.Mutex
+	// told about notes which will soon expire, if ExpiryConfig.warningWindow asks for it; nil if there's no one to tell
+	warnExpiring func(notes []NoteInfo, within time.Duration)
+	// stop the cleanup loop, and are closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+// IntegrityCheck is the result of checking the database for corruption
+type IntegrityCheck struct {
+	Time     time.Time `json:"time"`
+	Full     bool      `json:"full"`
+	OK       bool      `json:"ok"`
+	Problems []string  `json:"problems,omitempty"`
+}
+
+func NewMaintenance(datastore Datastore) *Maintenance {
+	return &Maintenance{
+		datastore: datastore,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// runs a job once no other job is running
+func (m *Maintenance) do(job func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	job()
+}
+
+// ExpiryConfig describes which notes expire, see expiryClause, and how long changes are logged
+type ExpiryConfig struct {
+	age         time.Duration
+	unviewedAge time.Duration
+	policy      string
+	// the -retention rules, for notes which are kept for other than age
+	// they're applied as a hook, so these are only for describing when notes expire
+	retention RetentionRules
+	// the hooks deciding how long notes are kept, including the -retention rules; see Hooks
+	hooks *Hooks
+	// notes which will expire within this long are warned about once, before they're deleted; if zero, they aren't
+	warningWindow time.Duration
+	// if zero, the change log is never pruned
+	changeLogAge time.Duration
+	// if zero, views recorded for GET /api/note/:note/stats are never pruned
+	viewAge time.Duration
+	// if zero, idempotency keys of POSTs and the tokens of submitted forms are never pruned
+	idempotencyAge time.Duration
+}
+
+// CleanupRun is the outcome of a cleanup
+type CleanupRun struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// number of expired notes deleted
+	Deleted int64  `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// longest a cleanup may run before it gives up, leaving the rest for next time
+const cleanupTimeout = 10 * time.Minute
+
+// how long to wait before retrying a cleanup which found the database busy
+const cleanupRetryDelay = 5 * time.Second
+
+// deletes expired notes and locks, remembering the outcome
+// a panic is logged and recorded as the outcome, rather than taking the server down
+func (m *Maintenance) cleanup(expiry ExpiryConfig) {
+	m.do(func() {
+		start := time.Now()
+		run := CleanupRun{Time: m.datastore.now().UTC()}
+		log.Print("cleanup started")
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("cleanup panicked: %v\n%s", r, debug.Stack())
+				run.Error = fmt.Sprintf("panic: %v", r)
+			}
+			duration := time.Since(start)
+			run.DurationSeconds = duration.Seconds()
+			if run.Error != "" {
+				log.Printf("cleanup failed after %s, having deleted %d expired notes: %s", duration, run.Deleted, run.Error)
+			} else {
+				log.Printf("cleanup finished in %s, deleting %d expired notes", duration, run.Deleted)
+			}
+			m.resultsLock.Lock()
+			m.lastCleanup = &run
+			m.resultsLock.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		deleted, err := m.expire(ctx, expiry, nil)
+		run.Deleted = deleted
+		if err != nil {
+			run.Error = err.Error()
+		}
+		m.warn(expiry)
+		_, err = m.datastore.deleteExpiredLocks()
+		if err != nil {
+			log.Printf("deleting expired locks: %v", err)
+		}
+		if expiry.changeLogAge != 0 {
+			_, err = m.datastore.pruneChanges(expiry.changeLogAge)
+			if err != nil {
+				log.Printf("pruning change log: %v", err)
+			}
+		}
+		if expiry.viewAge != 0 {
+			_, err = m.datastore.pruneViews(expiry.viewAge)
+			if err != nil {
+				log.Printf("pruning views: %v", err)
+			}
+		}
+		if expiry.idempotencyAge != 0 {
+			_, err = m.datastore.pruneIdempotencyKeys(expiry.idempotencyAge)
+			if err != nil {
+				log.Printf("pruning idempotency keys: %v", err)
+			}
+			_, err = m.datastore.pruneFormTokens(expiry.idempotencyAge)
+			if err != nil {
+				log.Printf("pruning form tokens: %v", err)
+			}
+		}
+	})
+}
+
+// deletes expired notes, or only those of them named in only if it isn't nil,
+// trying once more if the database is busy
+func (m *Maintenance) expire(ctx context.Context, expiry ExpiryConfig, only []string) (int64, error) {
+	if expiry.age == 0 && expiry.unviewedAge == 0 && !m.datastore.hooks.retains() {
+		return 0, nil
+	}
+	// notes viewed since the last flush mustn't be taken to be unviewed
+	if _, err := m.datastore.flushViews(); err != nil {
+		return 0, fmt.Errorf("recording views of notes before expiring them: %w", err)
+	}
+	deleted, err := m.datastore.deleteOldNotes(ctx, expiry.age, expiry.unviewedAge, expiry.policy, only)
+	if !busyError(err) {
+		return deleted, err
+	}
+	log.Printf("deleting expired notes: %v; retrying in %s", err, cleanupRetryDelay)
+	select {
+	case <-ctx.Done():
+		return deleted, ctx.Err()
+	case <-time.After(cleanupRetryDelay):
+	}
+	more, err := m.datastore.deleteOldNotes(ctx, expiry.age, expiry.unviewedAge, expiry.policy, only)
+	return deleted + more, err
+}
+
+// tells warnExpiring about the notes which will expire within expiry.warningWindow
+// and haven't been warned about yet
+func (m *Maintenance) warn(expiry ExpiryConfig) {
+	if m.warnExpiring == nil || expiry.warningWindow == 0 {
+		return
+	}
+	notes, err := m.datastore.warnExpiringNotes(expiry.warningWindow, expiry.age, expiry.unviewedAge, expiry.policy)
+	if err != nil {
+		log.Printf("finding notes to warn about expiring: %v", err)
+		return
+	}
+	if len(notes) > 0 {
+		log.Printf("warning that %d notes will expire within %s", len(notes), expiry.warningWindow)
+		m.warnExpiring(notes, expiry.warningWindow)
+	}
+}
+
+// lists up to limit of the notes which a cleanup would delete now, least recently viewed first
+func (m *Maintenance) expiring(expiry ExpiryConfig, limit int) ([]NoteInfo, error) {
+	if _, err := m.datastore.flushViews(); err != nil {
+		return nil, err
+	}
+	return m.datastore.listExpiringNotes(expiry.age, expiry.unviewedAge, expiry.policy, limit)
+}
+
+// deletes expired notes now, or only those of them named in only if it isn't nil,
+// so that a list from expiring can be reviewed before deleting it
+// notes which were viewed since they were listed are no longer expired, so they're kept
+func (m *Maintenance) prune(expiry ExpiryConfig, only []string) (int64, error) {
+	var deleted int64
+	var err error
+	m.do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		deleted, err = m.expire(ctx, expiry, only)
+	})
+	return deleted, err
+}
+
+// the outcome of the most recent cleanup, if there has been one
+func (m *Maintenance) lastCleanupRun() (CleanupRun, bool) {
+	m.resultsLock.Lock()
+	defer m.resultsLock.Unlock()
+	if m.lastCleanup == nil {
+		return CleanupRun{}, false
+	}
+	return *m.lastCleanup, true
+}
+
+// the outcomes of the most recent cleanup and integrity check, for diagnostics
+func (m *Maintenance) diagnostics() interface{} {
+	report := make(map[string]interface{})
+	if run, ok := m.lastCleanupRun(); ok {
+		report["last_cleanup"] = run
+	}
+	if check, ok := m.lastIntegrityCheck(); ok {
+		report["last_integrity_check"] = check
+	}
+	return report
+}
+
+// publishes the outcome of the most recent cleanup as the last_cleanup expvar
+// expvars can't be replaced, so if a process runs more than one App, only the first publishes its cleanups
+func (m *Maintenance) publishStats() {
+	if expvar.Get("last_cleanup") != nil {
+		return
+	}
+	expvar.Publish("last_cleanup", expvar.Func(func() interface{} {
+		run, ok := m.lastCleanupRun()
+		if !ok {
+			return nil
+		}
+		return run
+	}))
+}
+
+// cleans up every interval, as measured by the datastore's clock, until shut down
+// expiry is asked which notes expire before each cleanup, since it can be changed at runtime
+// views of notes are written every viewFlushInterval, and once more when it's shut down
+func (m *Maintenance) runCleanup(interval time.Duration, expiry func() ExpiryConfig) {
+	defer close(m.done)
+	ticker := m.datastore.getClock().NewTicker(interval)
+	defer ticker.Stop()
+	flush := m.datastore.getClock().NewTicker(viewFlushInterval)
+	defer flush.Stop()
+	for {
+		select {
+		case <-m.stop:
+			m.flushViews()
+			return
+		case <-flush.C():
+			m.flushViews()
+		case <-ticker.C():
+			m.cleanup(expiry())
+		}
+	}
+}
+
+// writes the views of notes waiting in the datastore's batch, logging rather than returning an error,
+// since they're kept for the next flush
+func (m *Maintenance) flushViews() {
+	if _, err := m.datastore.flushViews(); err != nil {
+		log.Printf("recording views of notes: %v", err)
+	}
+}
+
+// stops the cleanup and vacuum loops, waiting for any cleanup or vacuum in progress to finish
+// runCleanup must have been started
+func (m *Maintenance) shutdown() {
+	close(m.stop)
+	<-m.done
+	m.do(func() {})
+}
+
+// vacuums the database, returning the number of bytes reclaimed
+func (m *Maintenance) vacuum() (int64, error) {
+	var reclaimed int64
+	var err error
+	m.do(func() {
+		reclaimed, err = m.datastore.vacuum()
+	})
+	return reclaimed, err
+}
+
+// checks the database for corruption, remembering the result
+func (m *Maintenance) check(full bool) (IntegrityCheck, error) {
+	var result IntegrityCheck
+	var err error
+	m.do(func() {
+		var problems []string
+		problems, err = m.datastore.integrityCheck(full)
+		if err != nil {
+			return
+		}
+		result = IntegrityCheck{Time: time.Now().UTC(), Full: full, OK: len(problems) == 0, Problems: problems}
+		m.resultsLock.Lock()
+		m.lastCheck = &result
+		m.resultsLock.Unlock()
+	})
+	return result, err
+}
+
+// the result of the most recent integrity check, if there has been one
+func (m *Maintenance) lastIntegrityCheck() (IntegrityCheck, bool) {
+	m.resultsLock.Lock()
+	defer m.resultsLock.Unlock()
+	if m.lastCheck == nil {
+		return IntegrityCheck{}, false
+	}
+	return *m.lastCheck, true
+}
+
+// vacuums the database every interval, waiting for the window if one is set, until shut down
+func (m *Maintenance) runVacuum(interval time.Duration, window VacuumWindow) {
+	for {
+		next := time.Now().Add(interval)
+		if window.set {
+			next = window.next(next)
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		reclaimed, err := m.vacuum()
+		if err != nil {
+			log.Printf("vacuuming database: %v", err)
+		} else {
+			log.Printf("vacuumed database, reclaiming %d bytes", reclaimed)
+		}
+	}
+}
+
+// VacuumWindow is a quiet time of day during which the database may be vacuumed
+// it may wrap around midnight, like 23:00-02:00
+type VacuumWindow struct {
+	set bool
+	// minutes after midnight, in local time
+	start, end int
+}
+
+// parses a window like "02:00-05:00"
+func parseVacuumWindow(window string) (VacuumWindow, error) {
+	if window == "" {
+		return VacuumWindow{}, nil
+	}
+	bounds := strings.Split(window, "-")
+	if len(bounds) != 2 {
+		return VacuumWindow{}, fmt.Errorf("window %q must be in the form \"HH:MM-HH:MM\"", window)
+	}
+	parsed := [2]int{}
+	for i, bound := range bounds {
+		parts := strings.Split(bound, ":")
+		if len(parts) != 2 {
+			return VacuumWindow{}, fmt.Errorf("window %q must be in the form \"HH:MM-HH:MM\"", window)
+		}
+		hour, err := strconv.Atoi(parts[0])
+		if err != nil || hour < 0 || hour > 23 {
+			return VacuumWindow{}, fmt.Errorf("bad hour in %q", bound)
+		}
+		minute, err := strconv.Atoi(parts[1])
+		if err != nil || minute < 0 || minute > 59 {
+			return VacuumWindow{}, fmt.Errorf("bad minute in %q", bound)
+		}
+		parsed[i] = hour*60 + minute
+	}
+	if parsed[0] == parsed[1] {
+		return VacuumWindow{}, fmt.Errorf("window %q is empty", window)
+	}
+	return VacuumWindow{set: true, start: parsed[0], end: parsed[1]}, nil
+}
+
+// checks whether t falls within the window
+func (w VacuumWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// the first time at or after t which falls within the window
+func (w VacuumWindow) next(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	start := midnight.Add(time.Duration(w.start) * time.Minute)
+	if start.Before(t) {
+		start = start.AddDate(0, 0, 1)
+	}
+	return start
+}
@@ -0,0 +1,476 @@
+package server
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// turning markdown into plain text, for printing notes and piping them into other tools
+// this isn't a full commonmark parser; it takes out the markup people usually write,
+// and leaves anything it doesn't understand as it was
+
+var (
+	atxHeading     = regexp.MustCompile(`^ {0,3}#{1,6}(?:[ \t]+|$)`)
+	atxClosing     = regexp.MustCompile(`(?:^|[ \t]+)#+[ \t]*$`)
+	setextHeading  = regexp.MustCompile(`^ {0,3}(?:=+|-+)[ \t]*$`)
+	thematicBreak  = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	codeFence      = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+	quoteMarker    = regexp.MustCompile(`^ {0,3}>[ ]?`)
+	bulletItem     = regexp.MustCompile(`^([ \t]*)[-*+][ \t]+`)
+	linkDefinition = regexp.MustCompile(`^ {0,3}\[([^\]^][^\]]*)\]:[ \t]*<?([^\s>]+)>?(?:[ \t]+.*)?$`)
+	tableDelimiter = regexp.MustCompile(`^ {0,3}\|?[ \t]*:?-+:?[ \t]*(?:\|[ \t]*:?-+:?[ \t]*)*\|?[ \t]*$`)
+	autolink       = regexp.MustCompile(`^<((?:https?|ftp|mailto):[^\s<>]+)>`)
+	emailAutolink  = regexp.MustCompile(`^<([^\s<>@]+@[^\s<>@]+)>`)
+	htmlTag        = regexp.MustCompile(`^(?:</?[A-Za-z][A-Za-z0-9-]*(?:\s[^<>]*)?/?>|<!--[\s\S]*?-->)`)
+	entity         = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+)
+
+// how far ahead the end of a code span, link or link's text is looked for, so a long line full of unclosed backticks
+// or brackets doesn't take quadratic time; one which ends further away is left as it was written
+const maxInlineSpan = 4 << 10
+
+// the part of text the end of a code span or link may be in
+func inlineSpan(text string) string {
+	if len(text) > maxInlineSpan {
+		return text[:maxInlineSpan]
+	}
+	return text
+}
+
+// the plain text of a markdown document: headings, emphasis, code fences, quotes and html are taken out,
+// links and images become "text (url)", list items keep their markers and code keeps its indentation
+func markdownText(source string) string {
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+	refs := linkDefinitions(lines)
+	out := make([]string, 0, len(lines))
+	// the fence the code block being read was opened with, or "" outside one
+	fence := ""
+	// whether the last line was part of a paragraph, which a line of = or - under it makes a heading,
+	// and the markdown of that line, in case a delimiter row under it makes it the head of a table
+	paragraph := false
+	lastLine := ""
+	// whether the lines being read are the rows of a table, which runs until a blank line
+	table := false
+	for _, line := range lines {
+		if fence != "" {
+			if closesFence(line, fence) {
+				fence = ""
+			} else {
+				out = append(out, line)
+			}
+			continue
+		}
+		for quoteMarker.MatchString(line) {
+			line = quoteMarker.ReplaceAllString(line, "")
+		}
+		wasParagraph := paragraph
+		paragraph = false
+		switch {
+		case strings.TrimSpace(line) == "":
+			table = false
+			out = append(out, "")
+		case codeFence.MatchString(line):
+			fence = codeFence.FindStringSubmatch(line)[1]
+		case wasParagraph && !table && setextHeading.MatchString(line):
+			// the underline of the heading above
+		case thematicBreak.MatchString(line):
+			out = append(out, "")
+		case atxHeading.MatchString(line):
+			heading := atxHeading.ReplaceAllString(line, "")
+			out = append(out, markdownInline(atxClosing.ReplaceAllString(heading, ""), refs))
+		case linkDefinition.MatchString(line):
+			// the links using it say where they go
+		case wasParagraph && strings.Contains(lastLine, "|") && tableDelimiter.MatchString(line):
+			table = true
+			paragraph = true
+			out[len(out)-1] = tableRow(lastLine, refs)
+		case table:
+			paragraph = true
+			out = append(out, tableRow(line, refs))
+		case bulletItem.MatchString(line):
+			item := bulletItem.FindStringSubmatch(line)
+			out = append(out, item[1]+"- "+markdownInline(line[len(item[0]):], refs))
+			paragraph = true
+		case !wasParagraph && (strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")):
+			// indented code
+			out = append(out, line)
+		default:
+			text := strings.TrimRight(line, " \t")
+			// a backslash ending a line breaks it
+			text = strings.TrimSuffix(text, "\\")
+			out = append(out, markdownInline(strings.TrimLeft(text, " \t"), refs))
+			paragraph = true
+		}
+		lastLine = line
+	}
+	return strings.TrimRight(strings.Join(out, "\n"), "\n") + "\n"
+}
+
+// whether a line ends the code block opened by fence, which it does with at least as many of the same character
+func closesFence(line string, fence string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= len(fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// the urls of the reference links defined outside code blocks, like "[docs]: https://example.com", by label
+func linkDefinitions(lines []string) map[string]string {
+	refs := map[string]string{}
+	fence := ""
+	for _, line := range lines {
+		if fence != "" {
+			if closesFence(line, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if match := codeFence.FindStringSubmatch(line); match != nil {
+			fence = match[1]
+			continue
+		}
+		if match := linkDefinition.FindStringSubmatch(line); match != nil {
+			label := linkLabel(match[1])
+			// the first definition of a label is the one used
+			if _, ok := refs[label]; !ok {
+				refs[label] = match[2]
+			}
+		}
+	}
+	return refs
+}
+
+// labels match regardless of case and spacing
+func linkLabel(label string) string {
+	return strings.ToLower(strings.Join(strings.Fields(label), " "))
+}
+
+// a row of a table, with its cells separated by " | " and no pipes around the outside
+func tableRow(line string, refs map[string]string) string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	cells := []string{}
+	start := 0
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		} else if line[i] == '|' {
+			cells = append(cells, line[start:i])
+			start = i + 1
+		}
+	}
+	cells = append(cells, line[start:])
+	for i, cell := range cells {
+		cells[i] = markdownInline(strings.TrimSpace(strings.ReplaceAll(cell, "\\|", "|")), refs)
+	}
+	return strings.Join(cells, " | ")
+}
+
+// a run of emphasis characters, which is taken out if it's matched by another, or text
+type inlinePiece struct {
+	text      string
+	delimiter byte
+	canOpen   bool
+	canClose  bool
+	matched   bool
+}
+
+// the plain text of a line of markdown
+func markdownInline(text string, refs map[string]string) string {
+	return plainInline(text, refs, 0)
+}
+
+// how deeply links are looked for inside the text of other links, like a linked image, [![alt](img)](url)
+const maxLinkNesting = 2
+
+// the plain text of markdown inside depth links
+func plainInline(text string, refs map[string]string, depth int) string {
+	pieces := []inlinePiece{}
+	closers := closingBrackets(text)
+	plain := strings.Builder{}
+	flush := func() {
+		if plain.Len() > 0 {
+			pieces = append(pieces, inlinePiece{text: plain.String()})
+			plain.Reset()
+		}
+	}
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && isASCIIPunct(text[i+1]):
+			plain.WriteByte(text[i+1])
+			i += 2
+		case c == '`':
+			code, n := codeSpan(text[i:])
+			if n == 0 {
+				// backticks which aren't closed are themselves
+				n = len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+				code = text[i : i+n]
+			}
+			plain.WriteString(code)
+			i += n
+		case c == '!' && strings.HasPrefix(text[i+1:], "[") && closers[i+1] != 0 && depth < maxLinkNesting:
+			link, n := inlineLink(text[i+1:], closers[i+1]-i-1, refs, depth)
+			if n == 0 {
+				plain.WriteByte(c)
+				i++
+				continue
+			}
+			plain.WriteString(link)
+			i += 1 + n
+		case c == '[' && closers[i] != 0 && depth < maxLinkNesting:
+			link, n := inlineLink(text[i:], closers[i]-i, refs, depth)
+			if n == 0 {
+				plain.WriteByte(c)
+				i++
+				continue
+			}
+			plain.WriteString(link)
+			i += n
+		case c == '<':
+			if match := autolink.FindStringSubmatch(text[i:]); match != nil {
+				plain.WriteString(match[1])
+				i += len(match[0])
+			} else if match := emailAutolink.FindStringSubmatch(text[i:]); match != nil {
+				plain.WriteString(match[1])
+				i += len(match[0])
+			} else if tag := htmlTag.FindString(text[i:]); tag != "" {
+				i += len(tag)
+			} else {
+				plain.WriteByte(c)
+				i++
+			}
+		case c == '&':
+			if match := entity.FindString(text[i:]); match != "" {
+				plain.WriteString(html.UnescapeString(match))
+				i += len(match)
+			} else {
+				plain.WriteByte(c)
+				i++
+			}
+		case c == '*' || c == '_' || c == '~':
+			n := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+			// only ~~ strikes through, so a lone ~ like in ~/notes is text
+			if c == '~' && n != 2 {
+				plain.WriteString(text[i : i+n])
+				i += n
+				continue
+			}
+			before, _ := utf8.DecodeLastRuneInString(text[:i])
+			after, _ := utf8.DecodeRuneInString(text[i+n:])
+			if i == 0 {
+				before = ' '
+			}
+			if i+n == len(text) {
+				after = ' '
+			}
+			leftFlanking := !unicode.IsSpace(after) && (!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+			rightFlanking := !unicode.IsSpace(before) && (!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+			piece := inlinePiece{text: text[i : i+n], delimiter: c, canOpen: leftFlanking, canClose: rightFlanking}
+			// underscores inside words, like in snake_case, aren't emphasis
+			if c == '_' {
+				piece.canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+				piece.canClose = rightFlanking && (!leftFlanking || isPunct(after))
+			}
+			flush()
+			pieces = append(pieces, piece)
+			i += n
+		default:
+			plain.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+	// pair each closing run with the nearest opening run of the same character before it
+	openers := map[byte][]int{}
+	for i, piece := range pieces {
+		if piece.delimiter == 0 {
+			continue
+		}
+		open := openers[piece.delimiter]
+		if piece.canClose && len(open) > 0 {
+			pieces[open[len(open)-1]].matched = true
+			pieces[i].matched = true
+			openers[piece.delimiter] = open[:len(open)-1]
+			continue
+		}
+		if piece.canOpen {
+			openers[piece.delimiter] = append(open, i)
+		}
+	}
+	result := strings.Builder{}
+	for _, piece := range pieces {
+		if !piece.matched {
+			result.WriteString(piece.text)
+		}
+	}
+	return result.String()
+}
+
+// a code span at the start of text, like `go vet`, returning its contents and its length,
+// or a length of zero if the backticks aren't closed
+func codeSpan(text string) (string, int) {
+	text = inlineSpan(text)
+	ticks := len(text) - len(strings.TrimLeft(text, "`"))
+	rest := text[ticks:]
+	for offset := 0; offset < len(rest); {
+		end := strings.Index(rest[offset:], text[:ticks])
+		if end == -1 {
+			return "", 0
+		}
+		end += offset
+		run := len(rest[end:]) - len(strings.TrimLeft(rest[end:], "`"))
+		if run == ticks {
+			code := rest[:end]
+			// one space inside each backtick is padding, so code starting with a backtick can be written
+			if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+				code = code[1 : len(code)-1]
+			}
+			return code, ticks + end + ticks
+		}
+		offset = end + run
+	}
+	return "", 0
+}
+
+// a link at the start of text, like [text](url), [text][label] or [label], as "text (url)",
+// returning its length too, or a length of zero if it isn't a link
+// end is the index of the ] closing the link's text
+func inlineLink(text string, end int, refs map[string]string, depth int) (string, int) {
+	label := text[1:end]
+	rest := text[end+1:]
+	destination := ""
+	n := end + 1
+	if strings.HasPrefix(rest, "(") {
+		var length int
+		destination, length = linkDestination(rest)
+		if length == 0 {
+			return "", 0
+		}
+		n += length
+	} else {
+		ref := label
+		if strings.HasPrefix(rest, "[") {
+			refEnd := strings.IndexByte(inlineSpan(rest), ']')
+			if refEnd != -1 {
+				if refEnd > 1 {
+					ref = rest[1:refEnd]
+				}
+				n += refEnd + 1
+			}
+		}
+		var ok bool
+		destination, ok = refs[linkLabel(ref)]
+		if !ok {
+			return "", 0
+		}
+	}
+	linkText := plainInline(label, refs, depth+1)
+	if linkText == "" {
+		return destination, n
+	}
+	if destination == "" || linkText == destination || "mailto:"+linkText == destination {
+		return linkText, n
+	}
+	return linkText + " (" + destination + ")", n
+}
+
+// the index of the ] closing each [ in text, found in one pass, so a line full of brackets doesn't take
+// quadratic time; a [ which isn't closed, or is closed too far away to be a link, isn't in it
+func closingBrackets(text string) map[int]int {
+	closers := map[int]int{}
+	open := []int{}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '[':
+			open = append(open, i)
+		case ']':
+			if len(open) > 0 {
+				start := open[len(open)-1]
+				open = open[:len(open)-1]
+				if i-start < maxInlineSpan {
+					closers[start] = i
+				}
+			}
+		}
+	}
+	return closers
+}
+
+// the url in a link's (url "title"), and the length of it all, or a length of zero if it isn't one
+func linkDestination(text string) (string, int) {
+	text = inlineSpan(text)
+	i := 1
+	skipSpace := func() {
+		for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+			i++
+		}
+	}
+	skipSpace()
+	destination := ""
+	if i < len(text) && text[i] == '<' {
+		end := strings.IndexByte(text[i:], '>')
+		if end == -1 {
+			return "", 0
+		}
+		destination = text[i+1 : i+end]
+		i += end + 1
+	} else {
+		// parentheses in a url are fine, as long as they're balanced
+		start := i
+		depth := 0
+	url:
+		for ; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case ' ', '\t':
+				break url
+			case '(':
+				depth++
+			case ')':
+				if depth == 0 {
+					break url
+				}
+				depth--
+			}
+		}
+		if i > len(text) {
+			i = len(text)
+		}
+		destination = text[start:i]
+	}
+	skipSpace()
+	if i < len(text) && (text[i] == '"' || text[i] == '\'' || text[i] == '(') {
+		closing := text[i]
+		if closing == '(' {
+			closing = ')'
+		}
+		end := strings.IndexByte(text[i+1:], closing)
+		if end == -1 {
+			return "", 0
+		}
+		i += end + 2
+		skipSpace()
+	}
+	if i >= len(text) || text[i] != ')' {
+		return "", 0
+	}
+	return destination, i + 1
+}
+
+// the characters a backslash escapes
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) != -1
+}
+
+// punctuation decides whether * and _ next to it start or end emphasis
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
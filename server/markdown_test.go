@@ -0,0 +1,60 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkdownText(t *testing.T) {
+	cases := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"atx headings", "# Pancakes\n\n## Serves 4 ##", "Pancakes\n\nServes 4"},
+		{"setext headings", "Pancakes\n========\nServes 4\n--------", "Pancakes\nServes 4"},
+		{"emphasis", "*very* **well** ___done___ ~~burnt~~", "very well done burnt"},
+		{"unmatched emphasis", "2 * 3 * 4 and a lone * or _", "2 * 3 * 4 and a lone * or _"},
+		{"underscores inside words", "set max_note_size and __init__", "set max_note_size and init"},
+		{"a lone tilde", "in ~/notes", "in ~/notes"},
+		{"code spans", "run `go vet` or ``a ` b``", "run go vet or a ` b"},
+		{"unclosed backticks", "a ` b", "a ` b"},
+		{"code fences keep their contents", "```go\n# not a heading\n*x*\n```\nafter", "# not a heading\n*x*\nafter"},
+		{"tilde fences", "~~~\n**kept**\n~~~", "**kept**"},
+		{"indented code", "para\n\n    *kept*", "para\n\n    *kept*"},
+		{"quotes", "> quoted *text*\n> > nested", "quoted text\nnested"},
+		{"lists", "- one\n* two\n  + nested _three_\n1. numbered", "- one\n- two\n  - nested three\n1. numbered"},
+		{"inline links", "see [the docs](https://example.com/docs \"Docs\")", "see the docs (https://example.com/docs)"},
+		{"links to themselves", "[https://example.com](https://example.com)", "https://example.com"},
+		{"parentheses in urls", "[wiki](https://en.wikipedia.org/wiki/Go_(language))", "wiki (https://en.wikipedia.org/wiki/Go_(language))"},
+		{"reference links", "[docs][d] and [D]\n\n[d]: https://example.com", "docs (https://example.com) and D (https://example.com)"},
+		{"undefined references", "[not a link] and [x][y]", "[not a link] and [x][y]"},
+		{"images", "![a cat](cat.png)", "a cat (cat.png)"},
+		{"linked images", "[![a cat](cat.png)](https://example.com)", "a cat (cat.png) (https://example.com)"},
+		{"autolinks", "<https://example.com> and <me@example.com>", "https://example.com and me@example.com"},
+		{"html", "<b>bold</b> <!-- hidden --> <br/>", "bold  "},
+		{"entities", "fish &amp; chips &lt;3 &#x263A;", "fish & chips <3 ☺"},
+		{"escapes", `\*not emphasis\* and \[not a link\]`, "*not emphasis* and [not a link]"},
+		{"thematic breaks", "above\n\n***\nbelow", "above\n\n\nbelow"},
+		{"hard line breaks", "first\\\nsecond  ", "first\nsecond"},
+		{"tables", "| a | *b* |\n|---|:-:|\n| 1 | 2 \\| 3 |", "a | b\n1 | 2 | 3"},
+		{"windows line endings", "# Title\r\n*body*", "Title\nbody"},
+	}
+	for _, c := range cases {
+		if got := markdownText(c.markdown); got != c.want+"\n" {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want+"\n")
+		}
+	}
+}
+
+// lines full of unclosed code spans and links don't take quadratic time
+func TestMarkdownTextLongLines(t *testing.T) {
+	for _, line := range []string{strings.Repeat("`", 1<<18), strings.Repeat("[", 1<<18), strings.Repeat("[a](", 1<<16), strings.Repeat("*a ", 1<<16)} {
+		start := time.Now()
+		markdownText(line)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("a line of %q took %s", line[:4], elapsed)
+		}
+	}
+}
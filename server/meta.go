@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most bytes of json in a note's meta
+const maxMetaSize = 4096
+
+// the key of a note's meta giving the language of its contents,
+// which its page gives to highlighters as the class language-<language>
+const META_LANGUAGE = "language"
+
+// a language is a single class name, like go, c++ or objective-c
+var languagePattern = regexp.MustCompile(`^[A-Za-z0-9_+#.-]{1,32}$`)
+
+// NoteMeta is small, free-form metadata attached to a note, like {"language": "go", "source": "https://example.com"}
+// keys are strings, and values are strings, numbers or booleans
+type NoteMeta map[string]interface{}
+
+// parses and validates a note's meta from json
+func parseNoteMeta(data []byte) (NoteMeta, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as they were written, rather than rounded to float64
+	decoder.UseNumber()
+	var meta NoteMeta
+	err := decoder.Decode(&meta)
+	if err != nil || meta == nil {
+		return nil, fmt.Errorf("meta must be a json object")
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("meta must be a single json object")
+	}
+	for key, value := range meta {
+		if key == "" {
+			return nil, fmt.Errorf("keys can't be empty")
+		}
+		switch value.(type) {
+		case string, json.Number, bool:
+		default:
+			return nil, fmt.Errorf("the value of %q must be a string, number or boolean", key)
+		}
+	}
+	if language, ok := meta[META_LANGUAGE]; ok {
+		if language, ok := language.(string); !ok || !languagePattern.MatchString(language) {
+			return nil, fmt.Errorf("the value of %q must be a name like \"go\" or \"c++\"", META_LANGUAGE)
+		}
+	}
+	return meta, nil
+}
+
+// a string value from a note's meta, or "" if it isn't there
+func (m NoteMeta) text(key string) string {
+	value, _ := m[key].(string)
+	return value
+}
+
+// whether any of a note's meta has the value, written as it would be in a query string
+func (m NoteMeta) hasValue(value string) bool {
+	for _, v := range m {
+		switch v := v.(type) {
+		case string:
+			if v == value {
+				return true
+			}
+		case json.Number:
+			if v.String() == value {
+				return true
+			}
+		case bool:
+			if strconv.FormatBool(v) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replaces a note's meta with a json object from the body
+// an empty object removes it
+func SetNoteMeta(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxMetaSize+1))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error reading request body: %v", err)
+			return
+		}
+		if len(data) > maxMetaSize {
+			APIError(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE)
+			return
+		}
+		meta, err := parseNoteMeta(data)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, err.Error())
+			return
+		}
+		exists, err := datastore.setNoteMeta(noteName, meta)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error setting meta of %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		log.Printf("Set meta of note %s", noteName)
+	}
+}
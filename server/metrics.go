@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// upper bounds of the buckets of datastore method durations, in seconds
+var durationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// a histogram of durations
+type histogram struct {
+	// counts[i] is the number of durations no longer than durationBuckets[i], but longer than the bucket before;
+	// the last count is of durations longer than every bucket
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	i := sort.SearchFloat64s(durationBuckets, seconds)
+	h.counts[i] += 1
+	h.sum += seconds
+	h.count += 1
+}
+
+// DatastoreMetrics collects how the database is doing: how long each datastore method takes,
+// and how often the database is too busy to use
+// the datastore reports into it, and /metrics exports it for prometheus
+// a nil *DatastoreMetrics collects nothing, for commands
+type DatastoreMetrics struct {
+	// errors which meant the database was busy or locked
+	// first, so it's aligned for atomic access on 32-bit platforms
+	busyErrors int64
+	// the database file, whose WAL is beside it
+	path string
+	lock sync.Mutex
+	// by datastore method
+	durations map[string]*histogram
+}
+
+// a busy error which has been counted, so the methods calling the one which returned it don't count it again
+type countedBusyError struct {
+	error
+}
+
+func (e countedBusyError) Unwrap() error {
+	return e.error
+}
+
+func NewDatastoreMetrics(path string) *DatastoreMetrics {
+	return &DatastoreMetrics{path: path, durations: make(map[string]*histogram)}
+}
+
+// records a call to a datastore method which began at start and returned *err
+// used as `defer ds.metrics.observe("getNote", time.Now(), &err)`
+func (m *DatastoreMetrics) observe(method string, start time.Time, err *error) {
+	if m == nil {
+		return
+	}
+	seconds := time.Since(start).Seconds()
+	var counted countedBusyError
+	if busyError(*err) && !errors.As(*err, &counted) {
+		atomic.AddInt64(&m.busyErrors, 1)
+		*err = countedBusyError{*err}
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, ok := m.durations[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets)+1)}
+		m.durations[method] = h
+	}
+	h.observe(seconds)
+}
+
+// writes the histograms in the prometheus text format
+func (m *DatastoreMetrics) writeDurations(w io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	methods := make([]string, 0, len(m.durations))
+	for method := range m.durations {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	fmt.Fprintln(w, "# HELP corkboard_datastore_duration_seconds Time taken by each datastore method.")
+	fmt.Fprintln(w, "# TYPE corkboard_datastore_duration_seconds histogram")
+	for _, method := range methods {
+		h := m.durations[method]
+		cumulative := uint64(0)
+		for i, bound := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "corkboard_datastore_duration_seconds_bucket{method=%q,le=%q} %d\n",
+				method, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "corkboard_datastore_duration_seconds_bucket{method=%q,le=\"+Inf\"} %d\n", method, h.count)
+		fmt.Fprintf(w, "corkboard_datastore_duration_seconds_sum{method=%q} %g\n", method, h.sum)
+		fmt.Fprintf(w, "corkboard_datastore_duration_seconds_count{method=%q} %d\n", method, h.count)
+	}
+}
+
+// writes a single gauge or counter in the prometheus text format
+func writeMetric(w io.Writer, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// serves the datastore's metrics, and those of requests if they're timed, in the prometheus text format
+// the sizes of the database and the number of notes are read when scraped
+func Metrics(datastore Datastore, requests *RequestStats) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		size, err := datastore.databaseSize()
+		if err != nil {
+			errorResponse(resp, req, http.StatusInternalServerError)
+			log.Printf("getting database size for metrics: %v", err)
+			return
+		}
+		notes, err := datastore.countNotes()
+		if err != nil {
+			errorResponse(resp, req, http.StatusInternalServerError)
+			log.Printf("counting notes for metrics: %v", err)
+			return
+		}
+		// there's no WAL while no one has the database open
+		walSize := int64(0)
+		if info, err := os.Stat(datastore.metrics.path + "-wal"); err == nil {
+			walSize = info.Size()
+		}
+		resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetric(resp, "corkboard_database_size_bytes", "gauge", "Size of the database file.", size)
+		writeMetric(resp, "corkboard_database_wal_size_bytes", "gauge", "Size of the database's write-ahead log.", walSize)
+		writeMetric(resp, "corkboard_notes", "gauge", "Number of notes, including unlisted notes.", notes)
+		writeMetric(resp, "corkboard_datastore_busy_errors_total", "counter",
+			"Datastore calls which failed because the database was busy or locked.", atomic.LoadInt64(&datastore.metrics.busyErrors))
+		datastore.metrics.writeDurations(resp)
+		requests.writeMetrics(resp)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requests made by a mirror carry this header, so that the receiving
+// instance doesn't push them back and two mirrors can't loop forever
+const replicatedHeader = "X-Corkboard-Replicated"
+
+// check the replication queue at least this often
+const mirrorPollInterval = 30 * time.Second
+
+// bounds on the delay between retries of a failed replication
+const (
+	mirrorMinBackoff = 5 * time.Second
+	mirrorMaxBackoff = time.Hour
+)
+
+// Mirror pushes every change to this instance's notes to another corkboard instance
+type Mirror struct {
+	datastore Datastore
+	url       string
+	user      string
+	password  string
+	client    *http.Client
+	wake      chan struct{}
+	// stop the replication loop, and are closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+// creates a mirror pushing to the instance at mirrorURL
+// creds are in the form "username:password", or empty if the mirror doesn't need them
+func NewMirror(datastore Datastore, mirrorURL string, creds string) *Mirror {
+	mirror := &Mirror{
+		datastore: datastore,
+		url:       strings.TrimSuffix(mirrorURL, "/"),
+		client:    &http.Client{Timeout: time.Minute},
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if creds != "" {
+		parts := strings.SplitN(creds, ":", 2)
+		mirror.user, mirror.password = parts[0], parts[1]
+	}
+	return mirror
+}
+
+// queues a change to be pushed to the mirror
+// changes which were themselves replicated from a mirror are not pushed on
+func (m *Mirror) noteChanged(event NoteEvent) {
+	if event.Replicated {
+		return
+	}
+	action := REPLICATE_PUT
+	if event.Action == NOTE_DELETED {
+		action = REPLICATE_DELETE
+	}
+	err := m.datastore.enqueueReplication(event.Name, action)
+	if err != nil {
+		log.Printf("queueing replication of %s: %v", event.Name, err)
+		return
+	}
+	// wake the worker without blocking if it's already awake
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// the changes waiting to be pushed, for diagnostics
+func (m *Mirror) diagnostics() interface{} {
+	queued, err := m.datastore.countReplications()
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return map[string]int64{"queued": queued}
+}
+
+// works through the replication queue until stopped
+func (m *Mirror) run() {
+	defer close(m.done)
+	for {
+		for {
+			task, ok, err := m.datastore.nextReplication()
+			if err != nil {
+				log.Printf("reading replication queue: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			m.attempt(task)
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-m.wake:
+		case <-time.After(mirrorPollInterval):
+		}
+	}
+}
+
+// stops replicating, waiting for any change being pushed to finish
+// changes still queued are pushed when corkboard next starts
+func (m *Mirror) shutdown() {
+	close(m.stop)
+	<-m.done
+}
+
+// tries to replicate a single task, rescheduling it with exponential backoff if it fails
+func (m *Mirror) attempt(task replicationTask) {
+	err := m.replicate(task)
+	if err == nil {
+		err = m.datastore.finishReplication(task.id)
+		if err != nil {
+			log.Printf("removing replication of %s from queue: %v", task.name, err)
+		}
+		return
+	}
+	delay := mirrorMinBackoff
+	for i := 0; i < task.attempts && delay < mirrorMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > mirrorMaxBackoff {
+		delay = mirrorMaxBackoff
+	}
+	log.Printf("replicating %s to mirror (attempt %d): %v; retrying in %s", task.name, task.attempts+1, err, delay)
+	err = m.datastore.retryReplication(task.id, delay)
+	if err != nil {
+		log.Printf("rescheduling replication of %s: %v", task.name, err)
+	}
+}
+
+// performs the equivalent of a change against the mirror
+func (m *Mirror) replicate(task replicationTask) error {
+	switch task.action {
+	case REPLICATE_PUT:
+		body, ok, err := m.datastore.peekNote(task.name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// the note has been deleted since, and a delete task will follow
+			return nil
+		}
+		return m.put(task.name, body)
+	case REPLICATE_DELETE:
+		_, err := m.request(http.MethodDelete, task.name, nil)
+		return err
+	default:
+		return fmt.Errorf("unknown replication action %q", task.action)
+	}
+}
+
+// creates or overwrites a note on the mirror
+func (m *Mirror) put(name string, body []byte) error {
+	_, err := m.request(http.MethodPut, name, body)
+	return err
+}
+
+// makes a request against a note on the mirror's api
+// returns the response body, or an error if the response was unsuccessful
+func (m *Mirror) request(method string, name string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, m.url+"/api/note/"+escapeNoteName(name), reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(replicatedHeader, "1")
+	if m.user != "" {
+		req.SetBasicAuth(m.user, m.password)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return respBody, &mirrorStatusError{resp.StatusCode}
+	}
+	return respBody, nil
+}
+
+// an unsuccessful response from the mirror
+type mirrorStatusError struct {
+	code int
+}
+
+func (e *mirrorStatusError) Error() string {
+	return fmt.Sprintf("mirror responded %d %s", e.code, http.StatusText(e.code))
+}
+
+// pushes every note which is missing or different on the mirror
+// used to seed a new mirror or repair drift
+func (m *Mirror) sync() error {
+	names, err := m.datastore.getAllNotes()
+	if err != nil {
+		return fmt.Errorf("listing notes: %s", err)
+	}
+	pushed := 0
+	for _, name := range names {
+		body, ok, err := m.datastore.peekNote(name)
+		if err != nil {
+			return fmt.Errorf("reading note %s: %s", name, err)
+		}
+		if !ok {
+			continue
+		}
+		remote, err := m.request(http.MethodGet, name, nil)
+		statusErr, isStatus := err.(*mirrorStatusError)
+		missing := isStatus && (statusErr.code == http.StatusNotFound || statusErr.code == http.StatusGone)
+		if err != nil && !missing {
+			return fmt.Errorf("fetching %s from mirror: %s", name, err)
+		}
+		if !missing && sha256.Sum256(remote) == sha256.Sum256(body) {
+			continue
+		}
+		err = m.put(name, body)
+		if err != nil {
+			return fmt.Errorf("pushing %s to mirror: %s", name, err)
+		}
+		pushed += 1
+	}
+	log.Printf("synced %d notes to mirror, %d were already up to date", pushed, len(names)-pushed)
+	return nil
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// viewing a note, in any of the ways it can be viewed, moves its last_viewed but never its modify_time,
+// which only a change to its contents moves
+func TestViewingKeepsModifyTime(t *testing.T) {
+	app, clock := testApp(t, testConfig(t))
+	handler := app.Router()
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "first"); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d %q", resp.Code, resp.Body)
+	}
+	views := []string{"/note/todo", "/note/todo/print", "/api/note/todo", "/api/note/todo/lines", "/api/note/todo/text",
+		"/api/note/todo/metadata", "/api/notes", "/"}
+	// views each way, then checks the note's times, as the database and every page showing them have them
+	viewAt := func(at time.Duration, wantModified time.Time) {
+		t.Helper()
+		clock.Advance(testEpoch.Add(at).Sub(clock.Now()))
+		for _, path := range views {
+			if resp := serveRequest(t, handler, http.MethodGet, path, ""); resp.Code != http.StatusOK {
+				t.Fatalf("GET %s: got %d", path, resp.Code)
+			}
+		}
+		if _, err := app.datastore.flushViews(); err != nil {
+			t.Fatal(err)
+		}
+		info, ok, err := app.datastore.getNoteInfo("todo")
+		if err != nil || !ok {
+			t.Fatalf("reading todo: %v, %v", ok, err)
+		}
+		if want := testEpoch.Add(at); !info.LastViewed.Equal(want) {
+			t.Errorf("viewed at %s: last_viewed is %v, want %v", at, info.LastViewed, want)
+		}
+		if !info.ModifyTime.Equal(wantModified) {
+			t.Errorf("viewed at %s: modify_time is %v, want %v", at, info.ModifyTime, wantModified)
+		}
+
+		var metadata struct {
+			ModifyTime time.Time `json:"modify_time"`
+		}
+		resp := serveRequest(t, handler, http.MethodGet, "/api/note/todo/metadata", "")
+		if err := json.Unmarshal(resp.Body.Bytes(), &metadata); err != nil || !metadata.ModifyTime.Equal(wantModified) {
+			t.Errorf("viewed at %s: the metadata says %q, want modify_time %v", at, resp.Body, wantModified)
+		}
+		resp = serveRequest(t, handler, http.MethodGet, "/api/note/todo", "")
+		if got, want := resp.Header().Get("Last-Modified"), wantModified.UTC().Format(http.TimeFormat); got != want {
+			t.Errorf("viewed at %s: Last-Modified is %q, want %q", at, got, want)
+		}
+		// the pages only mention an edit after the note was created, which one dated by the database's clock may not be
+		edited := wantModified.After(testEpoch)
+		page := serveRequest(t, handler, http.MethodGet, "/note/todo", "").Body.String()
+		if strings.Contains(page, ", edited ") != edited {
+			t.Errorf("viewed at %s: the page shows an edit: %v, want %v", at, !edited, edited)
+		}
+		index := serveRequest(t, handler, http.MethodGet, "/", "").Body.String()
+		if strings.Contains(index, `class="edited"`) != edited {
+			t.Errorf("viewed at %s: the main page shows an edit: %v, want %v", at, !edited, edited)
+		}
+	}
+	viewAt(time.Hour, testEpoch)
+	viewAt(48*time.Hour, testEpoch)
+
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "second"); resp.Code != http.StatusOK {
+		t.Fatalf("changing todo: got %d %q", resp.Code, resp.Body)
+	}
+	info, _, err := app.datastore.getNoteInfo("todo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the database dates changes by its own clock, not the fake one
+	if info.ModifyTime.Equal(testEpoch) {
+		t.Fatalf("after changing todo, modify_time is still %v", info.ModifyTime)
+	}
+	viewAt(72*time.Hour, info.ModifyTime)
+
+	// writing the same contents again isn't a change
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "second"); resp.Code != http.StatusOK {
+		t.Fatalf("rewriting todo: got %d %q", resp.Code, resp.Body)
+	}
+	viewAt(96*time.Hour, info.ModifyTime)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// styles of generated note names
+const (
+	// 8 hex digits, like "3fa9c0d1"
+	NAME_STYLE_HEX = "hex"
+	// an adjective, a noun and a number, like "amber-falcon-42"
+	NAME_STYLE_WORDS = "words"
+	// a random uuid, like "0b6c3f4e-2d1a-4c8e-9f7b-5a1d2e3c4b6a"
+	NAME_STYLE_UUID = "uuid"
+	// 6 letters and digits, like "x7GkQ2"
+	NAME_STYLE_NANOID = "nanoid"
+)
+
+// characters used in nanoid-style note names
+const nameAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// length of nanoid-style note names
+const generatedNameLength = 6
+
+// length of hex-style note names, in bytes of randomness
+const generatedHexBytes = 4
+
+// digits on the end of words-style note names
+const generatedNameDigits = 2
+
+// give up on generating a unique name after this many collisions
+const maxNameAttempts = 10
+
+// NameGenerator makes up names for notes created without one
+// generated names only use letters, digits and hyphens, so they're always valid note names
+type NameGenerator struct {
+	style string
+	// source of randomness, which can be seeded to generate the same names every time
+	random     io.Reader
+	adjectives []string
+	nouns      []string
+}
+
+// makes a generator for the given style, using random as its source of randomness
+func NewNameGenerator(style string, random io.Reader) (NameGenerator, error) {
+	generator := NameGenerator{style: style, random: random}
+	switch style {
+	case NAME_STYLE_HEX, NAME_STYLE_UUID, NAME_STYLE_NANOID:
+	case NAME_STYLE_WORDS:
+		var err error
+		generator.adjectives, err = readWordlist("wordlist/adjectives.txt")
+		if err != nil {
+			return NameGenerator{}, err
+		}
+		generator.nouns, err = readWordlist("wordlist/nouns.txt")
+		if err != nil {
+			return NameGenerator{}, err
+		}
+	default:
+		return NameGenerator{}, fmt.Errorf("unknown name style %q", style)
+	}
+	return generator, nil
+}
+
+// reads an embedded list of words, one per line
+func readWordlist(path string) ([]string, error) {
+	contents, err := wordlistFS.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	words := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("wordlist %s is empty", path)
+	}
+	return words, nil
+}
+
+// generates a random note name
+// each collision with an existing note is another attempt, which makes a longer, more random name
+func (g NameGenerator) generate(attempt int) (string, error) {
+	var name string
+	var err error
+	switch g.style {
+	case NAME_STYLE_HEX:
+		name, err = g.hexName(generatedHexBytes + attempt)
+	case NAME_STYLE_WORDS:
+		name, err = g.wordsName(attempt)
+	case NAME_STYLE_UUID:
+		name, err = g.uuidName()
+	default:
+		name, err = g.nanoidName(generatedNameLength + attempt)
+	}
+	if err != nil {
+		return "", err
+	}
+	// a broken wordlist shouldn't be able to make a note which can't be reached
+	if err := validateNoteName(name); err != nil {
+		return "", fmt.Errorf("generated bad name %q: %v", name, err)
+	}
+	return name, nil
+}
+
+// a random number from 0 up to but not including max
+func (g NameGenerator) randomInt(max int) (int, error) {
+	n, err := rand.Int(g.random, big.NewInt(int64(max)))
+	if err != nil {
+		return 0, err
+	}
+	return int(n.Int64()), nil
+}
+
+func (g NameGenerator) hexName(size int) (string, error) {
+	bytes := make([]byte, size)
+	_, err := io.ReadFull(g.random, bytes)
+	return hex.EncodeToString(bytes), err
+}
+
+// after the first collision, a second adjective is added; every collision adds a digit
+func (g NameGenerator) wordsName(attempt int) (string, error) {
+	numAdjectives := 1
+	if attempt > 0 {
+		numAdjectives = 2
+	}
+	words := []string{}
+	for i := 0; i < numAdjectives; i++ {
+		n, err := g.randomInt(len(g.adjectives))
+		if err != nil {
+			return "", err
+		}
+		words = append(words, g.adjectives[n])
+	}
+	n, err := g.randomInt(len(g.nouns))
+	if err != nil {
+		return "", err
+	}
+	words = append(words, g.nouns[n])
+	digits := generatedNameDigits + attempt
+	limit := 1
+	for i := 0; i < digits; i++ {
+		limit *= 10
+	}
+	number, err := g.randomInt(limit)
+	if err != nil {
+		return "", err
+	}
+	words = append(words, fmt.Sprintf("%0*d", digits, number))
+	return strings.Join(words, "-"), nil
+}
+
+// a version 4 uuid, which is random enough that collisions don't need longer names
+func (g NameGenerator) uuidName() (string, error) {
+	uuid := make([]byte, 16)
+	_, err := io.ReadFull(g.random, uuid)
+	if err != nil {
+		return "", err
+	}
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16]), nil
+}
+
+func (g NameGenerator) nanoidName(length int) (string, error) {
+	name := make([]byte, length)
+	for i := range name {
+		n, err := g.randomInt(len(nameAlphabet))
+		if err != nil {
+			return "", err
+		}
+		name[i] = nameAlphabet[n]
+	}
+	return string(name), nil
+}
+
+// creates a new note with a generated name, never clobbering an existing note
+// returns the name of the new note, or errNotPermitted if the acl doesn't let the datastore's user write it
+func createNoteWithRandomName(datastore Datastore, names NameGenerator, body []byte, options NoteOptions) (string, error) {
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		name, err := names.generate(attempt)
+		if err != nil {
+			return "", err
+		}
+		if !datastore.permits(name, ACCESS_WRITE) {
+			return "", errNotPermitted
+		}
+		status, err := datastore.setNoteWithHash(name, body, hashBody(body), false, options)
+		if err != nil {
+			return "", err
+		}
+		if status == CREATED {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find an unused note name after %d attempts", maxNameAttempts)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// a generator whose names are the same every time for the same seed
+func seededNames(t *testing.T, style string, seed int64) NameGenerator {
+	t.Helper()
+	names, err := NewNameGenerator(style, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// a version 4 uuid
+const uuidPattern = `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`
+
+func TestNameStyles(t *testing.T) {
+	cases := []struct {
+		style string
+		// what a name looks like at each of the first attempts; later ones are only checked to be valid
+		patterns []string
+	}{
+		{NAME_STYLE_HEX, []string{`^[0-9a-f]{8}$`, `^[0-9a-f]{10}$`, `^[0-9a-f]{12}$`}},
+		{NAME_STYLE_WORDS, []string{`^[a-z]+-[a-z]+-[0-9]{2}$`, `^[a-z]+-[a-z]+-[a-z]+-[0-9]{3}$`, `^[a-z]+-[a-z]+-[a-z]+-[0-9]{4}$`}},
+		// uuids are random enough not to need to grow
+		{NAME_STYLE_UUID, []string{uuidPattern, uuidPattern, uuidPattern}},
+		{NAME_STYLE_NANOID, []string{`^[A-Za-z0-9]{6}$`, `^[A-Za-z0-9]{7}$`, `^[A-Za-z0-9]{8}$`}},
+	}
+	for _, c := range cases {
+		names := seededNames(t, c.style, 1)
+		same := seededNames(t, c.style, 1)
+		other := seededNames(t, c.style, 2)
+		differs := false
+		for attempt := 0; attempt < maxNameAttempts; attempt++ {
+			for i := 0; i < 50; i++ {
+				name, err := names.generate(attempt)
+				if err != nil {
+					t.Fatalf("%s, attempt %d: %v", c.style, attempt, err)
+				}
+				if attempt < len(c.patterns) && !regexp.MustCompile(c.patterns[attempt]).MatchString(name) {
+					t.Errorf("%s, attempt %d: %q isn't like %s", c.style, attempt, name, c.patterns[attempt])
+				}
+				if err := validateNoteName(name); err != nil {
+					t.Errorf("%s, attempt %d: %q isn't a valid name: %v", c.style, attempt, name, err)
+				}
+				if again, _ := same.generate(attempt); again != name {
+					t.Errorf("%s, attempt %d: the same seed generated %q, then %q", c.style, attempt, name, again)
+				}
+				if another, _ := other.generate(attempt); another != name {
+					differs = true
+				}
+			}
+		}
+		if !differs {
+			t.Errorf("%s: another seed generated the same names", c.style)
+		}
+	}
+	if _, err := NewNameGenerator("emoji", rand.New(rand.NewSource(1))); err == nil {
+		t.Error("an unknown style made a generator")
+	}
+}
+
+// a reader which gives n bytes, then fails
+type exhaustedReader struct {
+	n int
+}
+
+func (r *exhaustedReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, errors.New("out of entropy")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = 0x5a
+	}
+	r.n -= len(p)
+	return len(p), nil
+}
+
+// a generated name which is taken is tried again with another, longer one, until there are no attempts left
+func TestRandomNameCollisions(t *testing.T) {
+	for _, style := range []string{NAME_STYLE_HEX, NAME_STYLE_WORDS, NAME_STYLE_UUID, NAME_STYLE_NANOID} {
+		for _, taken := range []int{0, 1, 3, maxNameAttempts} {
+			datastore, _ := testDatastore(t, testConfig(t))
+			// the names the generator will try, in order, since it's seeded the same
+			predicted := seededNames(t, style, 1)
+			var tries []string
+			for attempt := 0; attempt < maxNameAttempts; attempt++ {
+				name, err := predicted.generate(attempt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tries = append(tries, name)
+			}
+			for _, name := range tries[:taken] {
+				if _, err := datastore.setNote(name, []byte("taken"), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			name, err := createNoteWithRandomName(datastore, seededNames(t, style, 1), []byte("new"), NoteOptions{})
+			if taken == maxNameAttempts {
+				if err == nil || !strings.Contains(err.Error(), "after 10 attempts") {
+					t.Errorf("%s, all %d names taken: got %q, %v, want an error", style, taken, name, err)
+				}
+				continue
+			}
+			if err != nil || name != tries[taken] {
+				t.Errorf("%s, %d names taken: got %q, %v, want %q", style, taken, name, err, tries[taken])
+				continue
+			}
+			if body, _, err := datastore.peekNote(name); err != nil || string(body) != "new" {
+				t.Errorf("%s: %s says %q, %v", style, name, body, err)
+			}
+			for _, name := range tries[:taken] {
+				if body, _, _ := datastore.peekNote(name); string(body) != "taken" {
+					t.Errorf("%s: the taken note %s was overwritten with %q", style, name, body)
+				}
+			}
+		}
+	}
+}
+
+// running out of randomness fails rather than making up a name that isn't random
+func TestNamesOutOfEntropy(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t))
+	for _, style := range []string{NAME_STYLE_HEX, NAME_STYLE_WORDS, NAME_STYLE_UUID, NAME_STYLE_NANOID} {
+		for _, random := range []io.Reader{&exhaustedReader{0}, &exhaustedReader{3}, strings.NewReader("")} {
+			names, err := NewNameGenerator(style, random)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name, err := createNoteWithRandomName(datastore, names, []byte("new"), NoteOptions{}); err == nil {
+				t.Errorf("%s: created %q without enough randomness", style, name)
+			}
+		}
+	}
+	if notes, err := datastore.listNotesWithPrefix(""); err != nil || len(notes) != 0 {
+		t.Errorf("created %d notes, %v", len(notes), err)
+	}
+}
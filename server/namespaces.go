@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the path the shared notes are served under with -private-notes, like /shared/note/todo
+const sharedPrefix = "/shared"
+
+// the datastore as a request sees it
+// with -private-notes, each user's notes are stored under names like "alice/todo", and requests
+// see only their user's notes, except under /shared/, where they see the notes which belong to no one;
+// the shared notes' names can't contain '/', so the two can't collide; see validateNoteName
+func (ds Datastore) scoped(req *http.Request) Datastore {
+	if ds.private && !sharedNotes(req) {
+		ds.owner = requestUser(req)
+	}
+	if ds.acl != nil {
+		ds.user = requestUser(req)
+	}
+	ds.ctx = req.Context()
+	return ds
+}
+
+// serves the shared notes under /shared/, from the same router as each user's own notes
+// like a board, the router sees paths without the prefix, and boardPrefix recovers it for links
+func routeShared(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == sharedPrefix {
+			http.Redirect(resp, req, boardPrefix(req)+sharedPrefix+"/", http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(req.URL.Path, sharedPrefix+"/") {
+			ctx := context.WithValue(req.Context(), boardKey, boardPrefix(req)+sharedPrefix)
+			ctx = context.WithValue(ctx, sharedKey, true)
+			http.StripPrefix(sharedPrefix, next).ServeHTTP(resp, req.WithContext(ctx))
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// checks whether a name can be used for a new note the datastore sees, like validateNoteName
+// with -private-notes, a shared note's name can't contain '/', or the part before it would be taken for its owner
+func (ds *Datastore) validateNoteName(name string) error {
+	if err := validateNoteName(name); err != nil {
+		return err
+	}
+	if ds.private && ds.owner == "" && strings.Contains(name, "/") {
+		return errors.New("shared notes' names can't contain /, since it separates private notes' owners from their names")
+	}
+	return nil
+}
+
+// whether a request is for the shared notes
+func sharedNotes(req *http.Request) bool {
+	shared, _ := req.Context().Value(sharedKey).(bool)
+	return shared
+}
+
+// OwnedNote describes a note and who it belongs to, for GET /api/admin/notes
+type OwnedNote struct {
+	// "" for a shared note
+	Owner string `json:"owner"`
+	NoteInfo
+}
+
+// lists every note, including unlisted notes, with its owner as json
+// the owner query parameter lists just that user's notes
+func ListOwnedNotes(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		owner := req.URL.Query().Get("owner")
+		// a datastore which isn't private sees every note by the name it's stored under
+		datastore.private = owner != ""
+		datastore.owner = owner
+		notes, err := datastore.listEveryNote()
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing notes of every owner: %v", err)
+			return
+		}
+		owned := make([]OwnedNote, 0, len(notes))
+		for _, note := range notes {
+			if owner == "" {
+				if parts := strings.SplitN(note.Name, "/", 2); len(parts) == 2 {
+					note.Name = parts[1]
+					owned = append(owned, OwnedNote{Owner: parts[0], NoteInfo: note})
+					continue
+				}
+			}
+			owned = append(owned, OwnedNote{Owner: owner, NoteInfo: note})
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(owned)
+		if err != nil {
+			log.Printf("responding with notes: %v", err)
+		}
+	}
+}
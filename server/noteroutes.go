@@ -0,0 +1,171 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the actions on a note, which may follow its name in a path, like "print" in /note/:note/print
+// a name can't have one after a /, or /note/a/print couldn't be told from the page of the note "a/print";
+// see validateNoteName
+var noteActions = map[string]bool{
+	"attachments": true, "cas": true, "comments": true, "content-type": true, "export": true,
+	"fetch": true, "from-template": true, "increment": true, "lines": true, "links": true,
+	"lock": true, "meta": true, "metadata": true, "patch": true, "print": true,
+	"signature": true, "snapshot": true, "snapshots": true, "stats": true, "text": true, "watch": true,
+}
+
+// serves the routes whose paths have a note's name in them, like /api/note/:note/lock
+// names may contain '/', which httprouter's :note can't match, so each prefix, like /api/note,
+// is one catch-all route, and the path after it is split into the name, the action and its parameter here
+type noteRouter struct {
+	router labelledRouter
+	// by prefix, method and action, with a / after actions taking a parameter; the note itself is the action ""
+	routes map[string]map[string]map[string]noteRoute
+}
+
+type noteRoute struct {
+	// the route as it was registered, like "/api/note/:note/attachments/:attachment", for labelling requests
+	path   string
+	handle httprouter.Handle
+	// the name of the route's parameter after the action, like "attachment", or "" if it has none
+	param string
+}
+
+func newNoteRouter(router labelledRouter) *noteRouter {
+	return &noteRouter{router: router, routes: make(map[string]map[string]map[string]noteRoute)}
+}
+
+func (n *noteRouter) GET(path string, h httprouter.Handle) {
+	n.Handle(http.MethodGet, path, h)
+}
+
+func (n *noteRouter) POST(path string, h httprouter.Handle) {
+	n.Handle(http.MethodPost, path, h)
+}
+
+func (n *noteRouter) PUT(path string, h httprouter.Handle) {
+	n.Handle(http.MethodPut, path, h)
+}
+
+func (n *noteRouter) PATCH(path string, h httprouter.Handle) {
+	n.Handle(http.MethodPatch, path, h)
+}
+
+func (n *noteRouter) DELETE(path string, h httprouter.Handle) {
+	n.Handle(http.MethodDelete, path, h)
+}
+
+// registers a route like "/api/note/:note/attachments/:attachment", whose action must be one of noteActions
+func (n *noteRouter) Handle(method string, path string, h httprouter.Handle) {
+	i := strings.Index(path, "/:note")
+	if i < 0 {
+		panic("note route " + path + " has no :note")
+	}
+	prefix := path[:i]
+	route := noteRoute{path: path, handle: h}
+	action := ""
+	if rest := strings.TrimPrefix(path[i:], "/:note"); rest != "" {
+		parts := strings.SplitN(strings.TrimPrefix(rest, "/"), "/", 2)
+		action = parts[0]
+		if !noteActions[action] {
+			panic("note route " + path + " has an action missing from noteActions")
+		}
+		if len(parts) == 2 {
+			route.param = strings.TrimPrefix(parts[1], ":")
+			action += "/"
+		}
+	}
+	if n.routes[prefix] == nil {
+		n.routes[prefix] = make(map[string]map[string]noteRoute)
+	}
+	if n.routes[prefix][method] == nil {
+		n.routes[prefix][method] = make(map[string]noteRoute)
+		n.router.Router.Handle(method, prefix+"/*note", n.serve(prefix))
+	}
+	n.routes[prefix][method][action] = route
+}
+
+// finds the route for a request under prefix
+func (n *noteRouter) serve(prefix string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		path := strings.TrimPrefix(params.ByName("note"), "/")
+		parts := strings.Split(path, "/")
+		// the first part is always part of the name, so a note may be named after an action
+		end := 1
+		for end < len(parts) && !noteActions[parts[end]] {
+			end++
+		}
+		name := strings.Join(parts[:end], "/")
+		action, arg := "", ""
+		switch {
+		case end == len(parts):
+		case end+1 == len(parts):
+			action = parts[end]
+		case end+2 == len(parts) && parts[end+1] != "":
+			action, arg = parts[end]+"/", parts[end+1]
+		default:
+			n.noRoute(resp, req, path)
+			return
+		}
+		if name == "" || strings.HasSuffix(name, "/") {
+			notFound(resp, req)
+			return
+		}
+		route, ok := n.routes[prefix][req.Method][action]
+		if !ok {
+			if allowed := n.allowed(prefix, action); len(allowed) > 0 {
+				resp.Header().Set("Allow", strings.Join(allowed, ", "))
+				errorResponse(resp, req, http.StatusMethodNotAllowed)
+				return
+			}
+			n.noRoute(resp, req, path)
+			return
+		}
+		labelRequest(req, req.Method, route.path)
+		routeParams := httprouter.Params{{Key: "note", Value: name}}
+		if route.param != "" {
+			routeParams = append(routeParams, httprouter.Param{Key: route.param, Value: arg})
+		}
+		route.handle(resp, req, routeParams)
+	}
+}
+
+// responds to a request for path, the part after the prefix, which no route matched
+// a write to the api was most likely meant for the note named by the whole path, like ci/print
+// in PUT /api/note/ci/print, so it's refused with why that name can't be used rather than a bare 404;
+// a trailing slash is still a 404, as notFound explains
+func (n *noteRouter) noRoute(resp http.ResponseWriter, req *http.Request, path string) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead && isAPIRequest(req) && !strings.HasSuffix(path, "/") {
+		if err := validateNoteName(path); err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_INVALID_NAME, err.Error())
+			return
+		}
+	}
+	notFound(resp, req)
+}
+
+// the methods with a route for an action under prefix, for a 405's Allow header
+func (n *noteRouter) allowed(prefix string, action string) []string {
+	methods := []string{}
+	for method, routes := range n.routes[prefix] {
+		if _, ok := routes[action]; ok {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// the path of a note's name in a url, with each part of it escaped, like "ci/build%231" for "ci/build#1"
+func escapeNoteName(name string) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func TestNoteRouter(t *testing.T) {
+	router := labelledRouter{httprouter.New()}
+	router.NotFound = http.HandlerFunc(notFound)
+	notes := newNoteRouter(router)
+	// each route responds with what it was given
+	routes := []struct{ method, path string }{
+		{http.MethodGet, "/note/:note"},
+		{http.MethodGet, "/note/:note/print"},
+		{http.MethodGet, "/api/note/:note"},
+		{http.MethodPut, "/api/note/:note"},
+		{http.MethodGet, "/api/note/:note/attachments"},
+		{http.MethodGet, "/api/note/:note/attachments/:attachment"},
+		{http.MethodPost, "/api/note/:note/lock"},
+		{http.MethodDelete, "/api/note/:note/lock"},
+	}
+	for _, route := range routes {
+		path := route.path
+		notes.Handle(route.method, path, func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+			resp.Write([]byte(path + " " + params.ByName("note") + " " + params.ByName("attachment")))
+		})
+	}
+
+	cases := []struct {
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{http.MethodGet, "/note/todo", http.StatusOK, "/note/:note todo "},
+		{http.MethodGet, "/note/ci/build-1", http.StatusOK, "/note/:note ci/build-1 "},
+		{http.MethodGet, "/note/ci/build-1/print", http.StatusOK, "/note/:note/print ci/build-1 "},
+		// a name's first part may be an action's name
+		{http.MethodGet, "/note/print", http.StatusOK, "/note/:note print "},
+		{http.MethodGet, "/note/print/print", http.StatusOK, "/note/:note/print print "},
+		{http.MethodGet, "/note/a%2Fb", http.StatusOK, "/note/:note a/b "},
+		{http.MethodGet, "/api/note/ci/build-1/attachments", http.StatusOK, "/api/note/:note/attachments ci/build-1 "},
+		{http.MethodGet, "/api/note/ci/build-1/attachments/log.txt", http.StatusOK, "/api/note/:note/attachments/:attachment ci/build-1 log.txt"},
+		{http.MethodDelete, "/api/note/a/b/c/lock", http.StatusOK, "/api/note/:note/lock a/b/c "},
+		{http.MethodGet, "/api/note/ci/build-1/attachments/", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/note/ci/build-1/attachments/log.txt/more", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/note/ci/build-1/print", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/note/ci/", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/note/", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/note/ci/build-1/lock", http.StatusMethodNotAllowed, ""},
+		// a write to a name which can't be a note's is refused with why, rather than not found
+		{http.MethodPut, "/api/note/ci/print", http.StatusBadRequest, `\"print\" can't follow a / in note names`},
+		{http.MethodPost, "/api/note/ci/build-1/attachments/log.txt/more", http.StatusBadRequest, `\"attachments\" can't follow a / in note names`},
+		{http.MethodPut, "/api/note/ci/lock/", http.StatusNotFound, ""},
+		{http.MethodGet, "/note/ci/build-1/", http.StatusMovedPermanently, ""},
+	}
+	for _, c := range cases {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest(c.method, c.path, nil))
+		if resp.Code != c.wantStatus || (c.wantStatus == http.StatusOK && resp.Body.String() != c.wantBody) {
+			t.Errorf("%s %s: got %d %q, want %d %q", c.method, c.path, resp.Code, resp.Body.String(), c.wantStatus, c.wantBody)
+		} else if c.wantStatus == http.StatusBadRequest && (errorCode(t, resp) != ERR_INVALID_NAME || !strings.Contains(resp.Body.String(), c.wantBody)) {
+			t.Errorf("%s %s: got %q, want %s saying %s", c.method, c.path, resp.Body.String(), ERR_INVALID_NAME, c.wantBody)
+		}
+	}
+}
+
+func TestValidateNoteName(t *testing.T) {
+	cases := []struct {
+		name string
+		ok   bool
+	}{
+		{"todo", true},
+		{"ci/build-1234", true},
+		{"a/b/c", true},
+		{"print", true},
+		{"print/a", true},
+		{"", false},
+		{"_latest", false},
+		{"/todo", false},
+		{"todo/", false},
+		{"ci//build", false},
+		{"ci/./build", false},
+		{"ci/../build", false},
+		{"..", false},
+		{"ci/print", false},
+		{"ci/lock/x", false},
+		{`ci\build`, false},
+		{"tab\there", false},
+	}
+	for _, c := range cases {
+		if err := validateNoteName(c.name); (err == nil) != c.ok {
+			t.Errorf("validateNoteName(%q) = %v, want ok %v", c.name, err, c.ok)
+		}
+	}
+}
+
+func TestEscapeNoteName(t *testing.T) {
+	cases := map[string]string{
+		"todo":        "todo",
+		"ci/build #1": "ci/build%20%231",
+		"100%/a?b":    "100%25/a%3Fb",
+	}
+	for name, want := range cases {
+		if got := escapeNoteName(name); got != want {
+			t.Errorf("escapeNoteName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// prefixes given on the command line may be folders
+func TestFolderPrefixes(t *testing.T) {
+	var rules RetentionRules
+	if err := rules.Set("tmp/=24h"); err != nil {
+		t.Errorf("-retention tmp/=24h: %v", err)
+	}
+	if err := rules.Set("tmp//=24h"); err == nil {
+		t.Error("-retention tmp//=24h was accepted")
+	}
+	if _, err := parseACL(strings.NewReader("infra/ write=alice\n"), "test"); err != nil {
+		t.Errorf("an acl rule for infra/: %v", err)
+	}
+}
+
+// a trailing slash on a note's page redirects a GET to the page,
+// and on an api path is always a 404, so no method touches the note foo through /api/note/foo/
+func TestTrailingSlashes(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/foo", "original"); resp.Code != http.StatusCreated {
+		t.Fatalf("creating foo: got %d", resp.Code)
+	}
+	cases := []struct {
+		method       string
+		path         string
+		wantStatus   int
+		wantLocation string
+	}{
+		{http.MethodGet, "/note/foo/", http.StatusMovedPermanently, "/note/foo"},
+		{http.MethodGet, "/note/foo//", http.StatusMovedPermanently, "/note/foo"},
+		{http.MethodGet, "/note/foo/?from=link", http.StatusMovedPermanently, "/note/foo?from=link"},
+		{http.MethodGet, "/note/missing/", http.StatusMovedPermanently, "/note/missing"},
+		// pages are only read, so other methods are refused as they are without the slash
+		{http.MethodHead, "/note/foo/", http.StatusMethodNotAllowed, ""},
+		{http.MethodPost, "/note/foo/", http.StatusMethodNotAllowed, ""},
+		{http.MethodPut, "/note/foo/", http.StatusMethodNotAllowed, ""},
+		{http.MethodDelete, "/note/foo/", http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/api/note/foo/", http.StatusNotFound, ""},
+		{http.MethodPost, "/api/note/foo/", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/note/foo/", http.StatusNotFound, ""},
+		{http.MethodDelete, "/api/note/foo/", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/note/missing/", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/note/missing/", http.StatusNotFound, ""},
+	}
+	for _, c := range cases {
+		resp := serveRequest(t, handler, c.method, c.path, "changed")
+		if resp.Code != c.wantStatus {
+			t.Errorf("%s %s: got %d, want %d", c.method, c.path, resp.Code, c.wantStatus)
+		}
+		if location := resp.Header().Get("Location"); location != c.wantLocation {
+			t.Errorf("%s %s: redirected to %q, want %q", c.method, c.path, location, c.wantLocation)
+		}
+		if c.wantStatus == http.StatusMethodNotAllowed {
+			if resp := serveRequest(t, handler, c.method, "/note/foo", ""); resp.Code != c.wantStatus {
+				t.Errorf("%s /note/foo: got %d, unlike with the slash", c.method, resp.Code)
+			}
+		}
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/foo", ""); resp.Body.String() != "original" {
+		t.Errorf("foo says %q, want original", resp.Body)
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/api/note/missing", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("missing was created, saying %q", resp.Body)
+	}
+}
+
+// random names, full of characters which need escaping, are created through the api, then fetched at notePath's spelling of them;
+// any other spelling of a note's page is redirected there, but the api serves every spelling as it is
+func TestCanonicalNoteURLs(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	alphabet := []rune("aZ09 -_.~/%?#&+=;:@!$'\"()*,<>[]{}|^`éü字😀")
+	random := rand.New(rand.NewSource(1))
+	created := 0
+	for i := 0; i < 300; i++ {
+		runes := make([]rune, 1+random.Intn(12))
+		for j := range runes {
+			runes[j] = alphabet[random.Intn(len(alphabet))]
+		}
+		name := fmt.Sprintf("n%d%s", i, string(runes))
+		if validateNoteName(name) != nil {
+			continue
+		}
+		body := fmt.Sprintf("note %d", i)
+		canonical := notePath("", name)
+		if resp := serveRequest(t, handler, http.MethodPut, "/api/note/"+escapeNoteName(name), body); resp.Code != http.StatusCreated {
+			t.Errorf("creating %q: got %d %q", name, resp.Code, resp.Body)
+			continue
+		}
+		created++
+		if resp := serveRequest(t, handler, http.MethodGet, canonical, ""); resp.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want 200", canonical, resp.Code)
+		}
+		resp := serveRequest(t, handler, http.MethodGet, "/api/note/"+escapeNoteName(name), "")
+		if resp.Code != http.StatusOK || resp.Body.String() != body {
+			t.Errorf("reading %q: got %d %q, want %q", name, resp.Code, resp.Body, body)
+		}
+
+		// every byte escaped, in lower case, which is never how notePath spells it unless it's all escapes of digits
+		other := percentEncode(name)
+		if other == strings.TrimPrefix(canonical, "/note/") {
+			continue
+		}
+		resp = serveRequest(t, handler, http.MethodGet, "/note/"+other+"?from=link", "")
+		if resp.Code != http.StatusMovedPermanently || resp.Header().Get("Location") != canonical+"?from=link" {
+			t.Errorf("GET /note/%s?from=link: got %d to %q, want a redirect to %s?from=link", other, resp.Code, resp.Header().Get("Location"), canonical)
+		}
+		resp = serveRequest(t, handler, http.MethodGet, "/api/note/"+other, "")
+		if resp.Code != http.StatusOK || resp.Body.String() != body {
+			t.Errorf("GET /api/note/%s: got %d %q, want %q", other, resp.Code, resp.Body, body)
+		}
+		resp = serveRequest(t, handler, http.MethodPut, "/api/note/"+other, body+" changed")
+		if resp.Code != http.StatusOK {
+			t.Errorf("PUT /api/note/%s: got %d %q, want 200", other, resp.Code, resp.Body)
+		}
+		resp = serveRequest(t, handler, http.MethodGet, "/api/note/"+escapeNoteName(name), "")
+		if resp.Body.String() != body+" changed" {
+			t.Errorf("after writing to /api/note/%s, %q says %q", other, name, resp.Body)
+		}
+	}
+	if created < 100 {
+		t.Errorf("only %d of the names were valid", created)
+	}
+}
+
+// escapes every byte of name but the /s between its folders, like %6e%6f%74%65
+func percentEncode(name string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			escaped.WriteByte('/')
+		} else {
+			fmt.Fprintf(&escaped, "%%%02x", name[i])
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// notes whose names begin with this are templates for new notes
+// e.g. the note "template-standup" is the template "standup"
+const noteTemplatePrefix = "template-"
+
+// matches placeholders in note templates, like {{date}} or {{ name }}
+var placeholderPattern = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// replaces the placeholders in a template's body with their values
+// {{date}} and {{time}} are always available, as well as any values passed in
+// unknown placeholders are left as they are
+// this is plain text substitution, so the result is escaped like any other note when rendered
+func fillNoteTemplate(body []byte, values map[string]string, now time.Time) []byte {
+	builtins := map[string]string{
+		"date": now.Format("2006-01-02"),
+		"time": now.Format("15:04"),
+	}
+	return placeholderPattern.ReplaceAllFunc(body, func(placeholder []byte) []byte {
+		key := strings.TrimSpace(string(placeholder[2 : len(placeholder)-2]))
+		if value, ok := values[key]; ok {
+			return []byte(value)
+		}
+		if value, ok := builtins[key]; ok {
+			return []byte(value)
+		}
+		return placeholder
+	})
+}
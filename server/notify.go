@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// notifications are collected for this long before being sent
+const notifyFlushInterval = 5 * time.Second
+
+// if more than this many notifications are collected at once, they're collapsed into a summary
+const notifyBurstThreshold = 3
+
+// most notifications waiting to be sent; any more are dropped
+const notifyQueueSize = 1024
+
+// NotifyConfig stores the settings for chat notifications
+type NotifyConfig struct {
+	SlackWebhook string
+	MatrixServer string
+	MatrixRoom   string
+	MatrixToken  string
+	Events       map[string]bool
+}
+
+// Notifier posts a chat message when notes are changed
+type Notifier struct {
+	config  NotifyConfig
+	baseURL string
+	client  *http.Client
+	queue   chan NoteEvent
+	// stop the sending loop, and are closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewNotifier(config NotifyConfig, baseURL string) *Notifier {
+	return &Notifier{
+		config:  config,
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		queue:   make(chan NoteEvent, notifyQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// parses a comma-separated list of events which should trigger notifications
+func parseNotifyEvents(list string) (map[string]bool, error) {
+	events := make(map[string]bool)
+	for _, event := range strings.Split(list, ",") {
+		event = strings.TrimSpace(event)
+		switch event {
+		case "":
+		case NOTE_CREATED, NOTE_UPDATED, NOTE_DELETED:
+			events[event] = true
+		default:
+			return nil, fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return events, nil
+}
+
+// queues a notification for the change, if it's one we notify about
+func (n *Notifier) noteChanged(event NoteEvent) {
+	if !n.config.Events[event.Action] {
+		return
+	}
+	select {
+	case n.queue <- event:
+	default:
+		log.Printf("dropping notification for %s: queue is full", event.Name)
+	}
+}
+
+// the notifications waiting to be sent, for diagnostics
+func (n *Notifier) diagnostics() interface{} {
+	return map[string]int{"queued": len(n.queue)}
+}
+
+// sends queued notifications until stopped
+// notifications are batched, so that a flood of changes becomes a single summary message
+func (n *Notifier) run() {
+	defer close(n.done)
+	for {
+		var event NoteEvent
+		select {
+		case <-n.stop:
+			return
+		case event = <-n.queue:
+		}
+		batch := []NoteEvent{event}
+		timeout := time.After(notifyFlushInterval)
+	collect:
+		for {
+			select {
+			case event := <-n.queue:
+				batch = append(batch, event)
+			case <-timeout:
+				break collect
+			case <-n.stop:
+				break collect
+			}
+		}
+		if len(batch) > notifyBurstThreshold {
+			n.send(summarizeEvents(batch))
+		} else {
+			for _, event := range batch {
+				n.send(n.describeEvent(event))
+			}
+		}
+	}
+}
+
+// stops sending notifications, waiting for any being sent to finish
+// a batch still being collected is sent straight away, but notifications queued after it are dropped
+func (n *Notifier) shutdown() {
+	close(n.stop)
+	<-n.done
+}
+
+// past tense of each kind of change, for messages
+var eventVerbs = map[string]string{
+	NOTE_CREATED: "created",
+	NOTE_UPDATED: "updated",
+	NOTE_DELETED: "deleted",
+}
+
+// describes a single change to a note
+func (n *Notifier) describeEvent(event NoteEvent) string {
+	message := fmt.Sprintf("Note %s was %s", event.Name, eventVerbs[event.Action])
+	if event.User != "" {
+		message += " by " + event.User
+	}
+	if event.Action != NOTE_DELETED {
+		message += fmt.Sprintf(" (%d bytes)", event.Size)
+		if n.baseURL != "" {
+			message += ": " + noteURL(n.baseURL, event.Name)
+		}
+	}
+	return message
+}
+
+// describes many changes in a single message, e.g. "12 notes created, 3 notes deleted"
+func summarizeEvents(events []NoteEvent) string {
+	counts := make(map[string]int)
+	for _, event := range events {
+		counts[event.Action] += 1
+	}
+	parts := []string{}
+	for _, action := range []string{NOTE_CREATED, NOTE_UPDATED, NOTE_DELETED} {
+		if counts[action] > 0 {
+			parts = append(parts, fmt.Sprintf("%d notes %s", counts[action], eventVerbs[action]))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// posts a single message listing notes which will expire within the next `within`
+// it's sent straight away, rather than queued, since it's sent from a cleanup and not a request
+func (n *Notifier) warnExpiring(notes []NoteInfo, within time.Duration) {
+	lines := []string{fmt.Sprintf("%d notes will expire within %s:", len(notes), within)}
+	for _, note := range notes {
+		line := note.Name
+		if n.baseURL != "" {
+			line += ": " + noteURL(n.baseURL, note.Name)
+		}
+		lines = append(lines, line)
+	}
+	n.send(strings.Join(lines, "\n"))
+}
+
+// posts a message to every configured chat service, logging any failures
+func (n *Notifier) send(message string) {
+	if n.config.SlackWebhook != "" {
+		err := n.sendSlack(message)
+		if err != nil {
+			log.Printf("sending slack notification: %v", err)
+		}
+	}
+	if n.config.MatrixServer != "" {
+		err := n.sendMatrix(message)
+		if err != nil {
+			log.Printf("sending matrix notification: %v", err)
+		}
+	}
+}
+
+func (n *Notifier) sendSlack(message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, n.config.SlackWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return n.do(req)
+}
+
+func (n *Notifier) sendMatrix(message string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": message})
+	if err != nil {
+		return err
+	}
+	// the transaction id makes retries of the same message idempotent
+	txnID := fmt.Sprintf("corkboard-%d", time.Now().UnixNano())
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(n.config.MatrixServer, "/"), url.PathEscape(n.config.MatrixRoom), txnID)
+	req, err := http.NewRequest(http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+n.config.MatrixToken)
+	return n.do(req)
+}
+
+// makes a request, returning an error if it was unsuccessful
+func (n *Notifier) do(req *http.Request) error {
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("responded %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PasteDocument is a paste as other pastebins export it, one json document per paste, like
+// {"id": "a1b2", "content": "...", "created": "2026-10-17T09:30:00Z", "expires": 1792230600, "syntax": "python"}
+// read by import-pastes and written by export-pastes
+type PasteDocument struct {
+	// the paste's name; pastebins which don't name pastes give an id instead
+	Name string `json:"name,omitempty"`
+	ID   string `json:"id,omitempty"`
+	// the paste's contents, base64-encoded if encoding is "base64"
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"`
+	// when the paste was created, and when it's to be taken down, if ever
+	Created *pasteTime `json:"created,omitempty"`
+	Expires *pasteTime `json:"expires,omitempty"`
+	// the language the paste is highlighted as, like "python"
+	Syntax string `json:"syntax,omitempty"`
+}
+
+// a time in a paste document, which other pastebins give either like 2026-10-17T09:30:00Z or in unix seconds
+// it's always written in the first form
+type pasteTime struct {
+	time.Time
+}
+
+func (t *pasteTime) UnmarshalJSON(data []byte) error {
+	var seconds int64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		t.Time = time.Unix(seconds, 0).UTC()
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.New("times must be like 2026-10-17T09:30:00Z or in unix seconds")
+	}
+	if seconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		t.Time = time.Unix(seconds, 0).UTC()
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339, text)
+	if err != nil {
+		return errors.New("times must be like 2026-10-17T09:30:00Z or in unix seconds")
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
+
+func (t pasteTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(formatTime(t.Time))
+}
+
+// syntaxes which mean a paste isn't highlighted at all, so aren't kept as its language
+var plainSyntaxes = map[string]bool{"": true, "text": true, "plaintext": true, "plain": true, "none": true}
+
+// the note a paste document becomes: its name, body, and options
+// fails if the document can't be made into a note
+func (doc PasteDocument) note() (string, []byte, NoteOptions, error) {
+	name := doc.Name
+	if name == "" {
+		name = doc.ID
+	}
+	if err := validateNoteName(name); err != nil {
+		return "", nil, NoteOptions{}, err
+	}
+	var body []byte
+	switch doc.Encoding {
+	case ENCODING_UTF8, "":
+		body = []byte(doc.Content)
+	case ENCODING_BASE64:
+		var err error
+		body, err = base64.StdEncoding.DecodeString(doc.Content)
+		if err != nil {
+			return "", nil, NoteOptions{}, fmt.Errorf("the content isn't valid base64: %v", err)
+		}
+	default:
+		return "", nil, NoteOptions{}, fmt.Errorf("unknown encoding %q", doc.Encoding)
+	}
+	options := NoteOptions{ContentType: syntaxContentType(doc.Syntax)}
+	if options.ContentType == "" {
+		options.ContentType = sniffContentType(name, body)
+	}
+	// some pastebins say a paste never expires with 0
+	if doc.Expires != nil && doc.Expires.Unix() > 0 {
+		hideAfter := doc.Expires.Time
+		options.Visibility.HideAfter = &hideAfter
+	}
+	return name, body, options, nil
+}
+
+// the content type of a paste highlighted as syntax, as it would be stored, or "" if it isn't known
+func syntaxContentType(syntax string) string {
+	syntax = strings.ToLower(syntax)
+	for mediaType, language := range contentTypeLanguages {
+		if language != syntax {
+			continue
+		}
+		if strings.HasPrefix(mediaType, "text/") {
+			return mediaType + "; charset=UTF-8"
+		}
+		return mediaType
+	}
+	return ""
+}
+
+// the language a paste's syntax is kept as in its note's meta, if it needs to be
+// a syntax which is implied by the note's content type isn't kept, so exporting the note gives the same syntax
+func syntaxLanguage(syntax string, contentType string) (string, bool) {
+	syntax = strings.ToLower(syntax)
+	if plainSyntaxes[syntax] || !languagePattern.MatchString(syntax) {
+		return "", false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); contentTypeLanguages[mediaType] == syntax {
+		return "", false
+	}
+	return syntax, true
+}
+
+// a note as a paste document
+func notePasteDocument(info NoteInfo, body []byte) PasteDocument {
+	doc := PasteDocument{Name: info.Name, Created: &pasteTime{info.CreateTime.UTC()}, Syntax: noteLanguage(info)}
+	if utf8.Valid(body) {
+		doc.Content = string(body)
+	} else {
+		doc.Encoding = ENCODING_BASE64
+		doc.Content = base64.StdEncoding.EncodeToString(body)
+	}
+	if info.HideAfter != nil {
+		doc.Expires = &pasteTime{info.HideAfter.UTC()}
+	}
+	return doc
+}
+
+// corkboard import-pastes [flags] dir
+// creates a note from each paste document in a directory's .json files, dated by when the paste was created
+// a paste which can't be imported is reported and skipped, without stopping the rest
+func importPastes(datastore Datastore, args []string) error {
+	flags := flag.NewFlagSet("import-pastes", flag.ContinueOnError)
+	clobber := flags.Bool("clobber", false, "Overwrite notes which already exist, rather than skipping them.")
+	dryRun := flags.Bool("dry-run", false, "List what would be imported without changing anything.")
+	maxSize := flags.Int("max-size", 10<<20, "Skip pastes larger than this many bytes.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] import-pastes [import flags] dir\n"+
+			"Creates a note from each paste exported as a .json file in dir, like\n"+
+			"{\"name\": \"todo\", \"content\": \"...\", \"created\": \"2026-10-17T09:30:00Z\", \"expires\": null, \"syntax\": \"python\"}\n"+
+			"Import flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one directory")
+	}
+	files, err := filepath.Glob(filepath.Join(positional[0], "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	var created, updated, existing, tooLarge, invalid int
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var doc PasteDocument
+		err = json.Unmarshal(data, &doc)
+		if err != nil {
+			fmt.Printf("skipping %s: %v\n", file, err)
+			invalid += 1
+			continue
+		}
+		name, body, options, err := doc.note()
+		if err == nil {
+			err = datastore.validateNoteName(name)
+		}
+		if err != nil {
+			fmt.Printf("skipping %s: %v\n", file, err)
+			invalid += 1
+			continue
+		}
+		if len(body) > *maxSize {
+			fmt.Printf("skipping %s: larger than %d bytes\n", file, *maxSize)
+			tooLarge += 1
+			continue
+		}
+		exists, err := datastore.noteExists(name)
+		if err != nil {
+			return err
+		}
+		if exists && !*clobber {
+			fmt.Printf("skipping %s: note %s already exists\n", file, name)
+			existing += 1
+			continue
+		}
+		if *dryRun {
+			if exists {
+				fmt.Printf("would overwrite %s with %s\n", name, file)
+				updated += 1
+			} else {
+				fmt.Printf("would create %s from %s\n", name, file)
+				created += 1
+			}
+			continue
+		}
+		createTime := time.Now()
+		if doc.Created != nil && doc.Created.Unix() > 0 {
+			createTime = doc.Created.Time
+		}
+		status, err := datastore.setNoteWithTimes(name, body, *clobber, options, createTime, createTime, createTime)
+		if err != nil {
+			return fmt.Errorf("importing %s: %v", file, err)
+		}
+		if status == NO_CLOBBER {
+			// created since we checked
+			fmt.Printf("skipping %s: note %s already exists\n", file, name)
+			existing += 1
+			continue
+		}
+		if language, ok := syntaxLanguage(doc.Syntax, options.ContentType); ok {
+			_, err = datastore.setNoteMeta(name, NoteMeta{META_LANGUAGE: language})
+			if err != nil {
+				return fmt.Errorf("importing %s: %v", file, err)
+			}
+		}
+		if status == CREATED {
+			created += 1
+		} else {
+			updated += 1
+		}
+	}
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d new notes, overwriting %d; skipped %d existing, %d too large and %d invalid\n",
+		verb, created, updated, existing, tooLarge, invalid)
+	return nil
+}
+
+// corkboard export-pastes dir
+// writes every note into dir as a paste document, which import-pastes reads back
+func exportPastes(datastore Datastore, args []string) error {
+	flags := flag.NewFlagSet("export-pastes", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] export-pastes dir\n"+
+			"Writes each note into dir as a .json file, in the form import-pastes reads.")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one directory")
+	}
+	dir := positional[0]
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	notes, err := datastore.listEveryNote()
+	if err != nil {
+		return err
+	}
+	exported := 0
+	for _, info := range notes {
+		body, ok, err := datastore.peekNote(info.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// deleted since it was listed
+			continue
+		}
+		data, err := json.MarshalIndent(notePasteDocument(info, body), "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(dir, archiveFileName(info.Name)+".json"), append(data, '\n'), 0644)
+		if err != nil {
+			return err
+		}
+		exported += 1
+	}
+	fmt.Printf("exported %d notes\n", exported)
+	return nil
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runs f, returning what it printed to standard output
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = write
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(read)
+		output <- string(data)
+	}()
+	defer func() {
+		os.Stdout = saved
+	}()
+	f()
+	write.Close()
+	return <-output
+}
+
+// writes each of files into a new directory, returning it
+func pasteDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// the paste documents in a directory written by export-pastes, by file name
+func readPasteDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+// pastes in the forms other pastebins export them in are imported, then exported in corkboard's own form,
+// which imports and exports again to the very same files
+func TestPastesRoundTrip(t *testing.T) {
+	expires := testEpoch.Add(30 * 24 * time.Hour)
+	dir := pasteDir(t, map[string]string{
+		"1.json": `{"name": "todo", "content": "milk\neggs\n", "created": "2026-10-01T08:00:00Z"}`,
+		// pastebins which number their pastes, and give times in unix seconds
+		"2.json": `{"id": "a1b2", "content": "print('hi')\n", "created": 1790000000, "expires": "` +
+			expires.Format(time.RFC3339) + `", "syntax": "Python"}`,
+		"3.json": `{"name": "config", "content": "{\"milk\": 2}", "created": "1790000000", "expires": 0, "syntax": "json"}`,
+		"4.json": `{"name": "blob", "content": "AP/+YmluAA==", "encoding": "base64", "syntax": "text"}`,
+	})
+	datastore, _ := testDatastore(t, testConfig(t))
+	captureStdout(t, func() {
+		if err := importPastes(datastore, []string{dir}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	exported := filepath.Join(t.TempDir(), "exported")
+	captureStdout(t, func() {
+		if err := exportPastes(datastore, []string{exported}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	files := readPasteDir(t, exported)
+
+	want := map[string]PasteDocument{
+		"todo.json": {Name: "todo", Content: "milk\neggs\n", Created: &pasteTime{time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)}},
+		"a1b2.json": {Name: "a1b2", Content: "print('hi')\n", Created: &pasteTime{time.Unix(1790000000, 0).UTC()},
+			Expires: &pasteTime{expires}, Syntax: "python"},
+		"config.json": {Name: "config", Content: `{"milk": 2}`, Created: &pasteTime{time.Unix(1790000000, 0).UTC()}, Syntax: "json"},
+		// a paste with no created time was created when it was imported
+		"blob.json": {Name: "blob", Content: "AP/+YmluAA==", Encoding: ENCODING_BASE64},
+	}
+	if len(files) != len(want) {
+		t.Errorf("exported %d files, want %d", len(files), len(want))
+	}
+	for file, wantDoc := range want {
+		var doc PasteDocument
+		if err := json.Unmarshal([]byte(files[file]), &doc); err != nil {
+			t.Errorf("%s: %q isn't a paste document: %v", file, files[file], err)
+			continue
+		}
+		if wantDoc.Created == nil {
+			wantDoc.Created = doc.Created
+		}
+		got, _ := json.Marshal(doc)
+		wantJSON, _ := json.Marshal(wantDoc)
+		if !bytes.Equal(got, wantJSON) {
+			t.Errorf("%s: exported\n%s\nwant\n%s", file, got, wantJSON)
+		}
+	}
+
+	again, _ := testDatastore(t, testConfig(t))
+	captureStdout(t, func() {
+		if err := importPastes(again, []string{exported}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	reexported := filepath.Join(t.TempDir(), "reexported")
+	captureStdout(t, func() {
+		if err := exportPastes(again, []string{reexported}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for file, contents := range readPasteDir(t, reexported) {
+		if contents != files[file] {
+			t.Errorf("%s: exported\n%s\nthen after importing it\n%s", file, files[file], contents)
+		}
+	}
+}
+
+// each paste which isn't imported is reported with why, and the rest are imported anyway
+func TestImportPastesSummary(t *testing.T) {
+	dir := pasteDir(t, map[string]string{
+		"a-new.json":       `{"name": "new", "content": "milk"}`,
+		"b-taken.json":     `{"name": "taken", "content": "from the pastebin"}`,
+		"c-large.json":     `{"name": "large", "content": "` + strings.Repeat("x", 101) + `"}`,
+		"d-broken.json":    `{"name": "broken", "content": `,
+		"e-base64.json":    `{"name": "encoded", "content": "not base64!", "encoding": "base64"}`,
+		"f-encoding.json":  `{"name": "encoded", "content": "milk", "encoding": "rot13"}`,
+		"g-name.json":      `{"name": "_reserved", "content": "milk"}`,
+		"h-no-name.json":   `{"content": "milk"}`,
+		"i-bad-time.json":  `{"name": "dated", "content": "milk", "created": "yesterday"}`,
+		"not-a-paste.txt":  "ignored",
+		"j-overwrite.json": `{"name": "also-taken", "content": "from the pastebin"}`,
+	})
+	cases := []struct {
+		name  string
+		flags []string
+		// lines the output should have, in order, each of which begins with the file it's about, if any
+		want []string
+		// what taken says afterwards, and whether new was created
+		wantTaken string
+		wantNew   bool
+	}{
+		{"dry run", []string{"-max-size", "100", "-dry-run"}, []string{
+			"would create new from a-new.json",
+			"skipping b-taken.json: note taken already exists",
+			"skipping c-large.json: larger than 100 bytes",
+			"skipping d-broken.json: unexpected end of JSON input",
+			"skipping e-base64.json: the content isn't valid base64",
+			`skipping f-encoding.json: unknown encoding "rot13"`,
+			"skipping g-name.json: note names beginning with _ are reserved",
+			"skipping h-no-name.json: note names can't be empty",
+			"skipping i-bad-time.json: times must be like",
+			"skipping j-overwrite.json: note also-taken already exists",
+			"would import 1 new notes, overwriting 0; skipped 2 existing, 1 too large and 6 invalid",
+		}, "original", false},
+		{"import", []string{"-max-size", "100"}, []string{
+			"skipping b-taken.json: note taken already exists",
+			"skipping c-large.json: larger than 100 bytes",
+			"imported 1 new notes, overwriting 0; skipped 2 existing, 1 too large and 6 invalid",
+		}, "original", true},
+		{"clobber", []string{"-clobber"}, []string{
+			"skipping d-broken.json",
+			"imported 2 new notes, overwriting 2; skipped 0 existing, 0 too large and 6 invalid",
+		}, "from the pastebin", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			datastore, _ := testDatastore(t, testConfig(t))
+			for _, name := range []string{"taken", "also-taken"} {
+				if _, err := datastore.setNote(name, []byte("original"), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			output := captureStdout(t, func() {
+				if err := importPastes(datastore, append(c.flags, dir)); err != nil {
+					t.Fatal(err)
+				}
+			})
+			output = strings.ReplaceAll(output, dir+string(filepath.Separator), "")
+			lines := strings.Split(strings.TrimSpace(output), "\n")
+			next := 0
+			for _, want := range c.want {
+				for next < len(lines) && !strings.HasPrefix(lines[next], want) {
+					next++
+				}
+				if next == len(lines) {
+					t.Errorf("no line beginning %q, in order, in\n%s", want, output)
+					break
+				}
+			}
+			if body, _, err := datastore.peekNote("taken"); err != nil || string(body) != c.wantTaken {
+				t.Errorf("taken says %q, %v, want %q", body, err, c.wantTaken)
+			}
+			if exists, err := datastore.noteExists("new"); err != nil || exists != c.wantNew {
+				t.Errorf("new exists: %v, %v, want %v", exists, err, c.wantNew)
+			}
+		})
+	}
+}
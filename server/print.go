@@ -0,0 +1,141 @@
+package server
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// the ERR_NOT_TEXT message
+const notTextMessage = "the note isn't text, so it can't be printed or read as plain text"
+
+// the text of a note as it's read, which the print view and GET /api/note/:note/text both show:
+// markdown notes have their markup taken out, and other text notes are as they were written
+// returns false if the note isn't text; truncated says body is only the beginning of the note
+func readableText(info NoteInfo, body []byte, truncated bool) (string, bool) {
+	if !textContentType(info.ContentType) || !validUTF8Prefix(body, truncated) {
+		return "", false
+	}
+	if noteLanguage(info) == "markdown" {
+		return markdownText(string(body)), true
+	}
+	return string(body), true
+}
+
+// PrintData is passed to the print.html template
+type PrintData struct {
+	PageData
+	Title string
+	// the note's own title, or its name if it has none
+	Heading string
+	Body    string
+	// whether Body is prose converted from markdown, rather than text shown as it was written
+	Prose bool
+	// whether Body is only the beginning of the note, which is TotalSize bytes long
+	Truncated bool
+	TotalSize int64
+}
+
+// displays a note on its own, with nothing around it, for printing
+// like the note's page, only its first maxSize bytes are displayed, unless maxSize is zero
+func PrintNote(templates *template.Template, pages Pages, datastore Datastore, analytics *Analytics, maxSize int) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		if redirectToCanonical(resp, req, noteName, "/print") {
+			return
+		}
+		var data []byte
+		var size int64
+		var ok bool
+		var err error
+		if maxSize == 0 {
+			data, ok, err = datastore.getNote(noteName)
+			size = int64(len(data))
+		} else {
+			data, size, ok, err = datastore.getNotePrefix(noteName, maxSize)
+		}
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		info, _, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		truncated := int64(len(data)) < size
+		if truncated {
+			data = trimPartialRune(data)
+		}
+		text, ok := readableText(info, data, truncated)
+		if !ok {
+			ErrorMessage(resp, http.StatusUnsupportedMediaType, notTextMessage)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		heading := info.Title
+		if heading == "" {
+			heading = noteName
+		}
+		resp.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		err = templates.ExecuteTemplate(resp, "print.html", PrintData{
+			PageData:  pages.data(req),
+			Title:     noteName,
+			Heading:   heading,
+			Body:      text,
+			Prose:     noteLanguage(info) == "markdown",
+			Truncated: truncated,
+			TotalSize: size,
+		})
+		if err != nil {
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("writing template: %v", err)
+		}
+	}
+}
+
+// responds with a note as plain text, for piping into other tools
+// markdown notes have their markup taken out, with links written as "text (url)"
+func NoteText(datastore Datastore, analytics *Analytics) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		data, ok, err := datastore.getNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !ok {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		info, _, err := datastore.getNoteInfo(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		text, ok := readableText(info, data, false)
+		if !ok {
+			APIError(resp, http.StatusUnsupportedMediaType, ERR_NOT_TEXT)
+			return
+		}
+		analytics.recordView(req, datastore.key(noteName))
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		sandboxContent(resp)
+		_, err = resp.Write([]byte(text))
+		if err != nil {
+			log.Printf("responding with text of %s: %v", noteName, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPrintAndText(t *testing.T) {
+	handler := testServer(t, testConfig(t, "-html-max-size", "200")).Config.Handler
+	recipe := "# Pancakes\n\nMix **flour** and [milk](https://example.com/milk).\n\n<script>alert(1)</script>\n"
+	// each note's type is sniffed from its name and contents
+	notes := map[string]string{
+		"recipe.md": recipe,
+		"script":    "if a < b && c {\n\t*x*\n}\n",
+		"long":      strings.Repeat("0123456789", 30),
+		"image":     "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+	}
+	for name, body := range notes {
+		if resp := serveRequest(t, handler, http.MethodPut, "/api/note/"+name, body); resp.Code != http.StatusCreated {
+			t.Fatalf("creating %s: got %d %q", name, resp.Code, resp.Body)
+		}
+	}
+
+	cases := []struct {
+		path       string
+		wantStatus int
+		// what the response should and shouldn't contain
+		want    []string
+		notWant []string
+	}{
+		{"/api/note/recipe.md/text", http.StatusOK, []string{"Pancakes\n\nMix flour and milk (https://example.com/milk).\n"}, []string{"#", "**", "<script>"}},
+		{"/note/recipe.md/print", http.StatusOK,
+			[]string{"<h1>recipe.md</h1>", `class="prose"`, "Mix flour and milk (https://example.com/milk).", "print.css"},
+			[]string{"<script>", "<nav", "form-token", "**"}},
+		// other text is as it was written, and escaped on the page
+		{"/api/note/script/text", http.StatusOK, []string{"if a < b && c {\n\t*x*\n}\n"}, nil},
+		{"/note/script/print", http.StatusOK, []string{`class="code"`, "if a &lt; b &amp;&amp; c {\n\t*x*\n}"}, []string{"a < b"}},
+		// the page only shows -html-max-size bytes, but the text is all of it
+		{"/note/long/print", http.StatusOK, []string{"Only the first 200 of this note's 300 bytes are shown."}, nil},
+		{"/api/note/long/text", http.StatusOK, []string{strings.Repeat("0123456789", 30)}, nil},
+		{"/note/image/print", http.StatusUnsupportedMediaType, []string{notTextMessage}, nil},
+		{"/api/note/image/text", http.StatusUnsupportedMediaType, nil, nil},
+		{"/note/missing/print", http.StatusNotFound, nil, nil},
+		{"/api/note/missing/text", http.StatusNotFound, nil, nil},
+	}
+	for _, c := range cases {
+		resp := serveRequest(t, handler, http.MethodGet, c.path, "")
+		body := resp.Body.String()
+		if resp.Code != c.wantStatus {
+			t.Errorf("%s: got %d %q, want %d", c.path, resp.Code, body, c.wantStatus)
+			continue
+		}
+		for _, want := range c.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s: %q doesn't contain %q", c.path, body, want)
+			}
+		}
+		for _, notWant := range c.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("%s: %q contains %q", c.path, body, notWant)
+			}
+		}
+	}
+
+	resp := serveRequest(t, handler, http.MethodGet, "/api/note/image/text", "")
+	var envelope struct {
+		Error APIErrorDetail `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &envelope); err != nil || envelope.Error.Code != ERR_NOT_TEXT {
+		t.Errorf("the text of an image: got %q, want the code %s", resp.Body, ERR_NOT_TEXT)
+	}
+	if got := resp.Header().Get("Content-Type"); got != "application/json" && !strings.HasPrefix(got, "application/json") {
+		t.Errorf("the text of an image is %s, want json", got)
+	}
+	if got := serveRequest(t, handler, http.MethodGet, "/api/note/recipe.md/text", "").Header().Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+		t.Errorf("the text of a note is %s, want text/plain", got)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most expiring notes listed by default
+const defaultExpiringLimit = 1000
+
+// a repeatable flag collecting note names
+type nameList []string
+
+func (n *nameList) String() string {
+	return strings.Join(*n, ",")
+}
+
+func (n *nameList) Set(name string) error {
+	*n = append(*n, name)
+	return nil
+}
+
+// corkboard prune [flags]
+// deletes expired notes now, or with -dry-run, lists the notes which would be deleted
+func pruneNotes(maintenance *Maintenance, expiry ExpiryConfig, args []string) error {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "List the notes which would be deleted, least recently viewed first,\nwithout deleting them.")
+	limit := flags.Int("limit", defaultExpiringLimit, "With -dry-run, list at most this many notes.")
+	var only nameList
+	flags.Var(&only, "only", "Delete only this expired note. May be repeated, to delete notes reviewed with -dry-run.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] prune [prune flags]\nDeletes the notes which have expired under -note-expiry, -unviewed-expiry\nand -expiry-policy.\nPrune flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected argument %q", positional[0])
+	}
+	if *limit < 1 {
+		return fmt.Errorf("-limit must be positive")
+	}
+
+	if *dryRun {
+		notes, err := maintenance.expiring(expiry, *limit)
+		if err != nil {
+			return err
+		}
+		count := 0
+		for _, note := range notes {
+			if only != nil && !containsName(only, note.Name) {
+				continue
+			}
+			fmt.Printf("%s  %10d  %s\n", note.LastViewed.UTC().Format(timeFormat), note.Size, note.Name)
+			count += 1
+		}
+		fmt.Printf("would delete %d notes\n", count)
+		return nil
+	}
+	var names []string
+	if only != nil {
+		names = only
+	}
+	deleted, err := maintenance.prune(expiry, names)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d notes\n", deleted)
+	return nil
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lists the notes a cleanup would delete now as json, least recently viewed first
+// the dry-run query parameter must be "true"; POST deletes them
+func CleanupPreview(maintenance *Maintenance, expiry func() ExpiryConfig) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		query := req.URL.Query()
+		if query.Get("dry-run") != "true" {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "add ?dry-run=true, or POST to delete expired notes")
+			return
+		}
+		limit := defaultExpiringLimit
+		if query.Get("limit") != "" {
+			var err error
+			limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || limit < 1 {
+				APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "limit must be positive")
+				return
+			}
+		}
+		notes, err := maintenance.expiring(expiry(), limit)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing expiring notes: %v", err)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(notes)
+		if err != nil {
+			log.Printf("responding with expiring notes: %v", err)
+		}
+	}
+}
+
+// deletes expired notes now, responding with how many were deleted
+// if the body is a json list of names, only the expired notes in it are deleted
+func Cleanup(maintenance *Maintenance, expiry func() ExpiryConfig) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		var only []string
+		err := json.NewDecoder(req.Body).Decode(&only)
+		if err != nil && err != io.EOF {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "the body must be empty or a json list of note names")
+			return
+		}
+		if err == nil && only == nil {
+			// a json null deletes nothing, rather than everything
+			only = []string{}
+		}
+		deleted, err := maintenance.prune(expiry(), only)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting expired notes: %v", err)
+			return
+		}
+		log.Printf("deleted %d expired notes", deleted)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		fmt.Fprintf(resp, "%d\n", deleted)
+	}
+}
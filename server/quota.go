@@ -0,0 +1,83 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// QuotaExceeded is the error a write is refused with when it would take its owner's notes over -quota
+// the api responds with 507, the code "quota_exceeded" and these numbers
+type QuotaExceeded struct {
+	// the bytes the owner's notes take up now
+	Used int64 `json:"used"`
+	// the bytes the write would add to them
+	Needed int64 `json:"needed"`
+	// the most they may take up
+	Quota int64 `json:"quota"`
+}
+
+func (q QuotaExceeded) Error() string {
+	return fmt.Sprintf("the note would take up %d more bytes, but %d of the %d byte quota are used", q.Needed, q.Used, q.Quota)
+}
+
+// the notes a note's size counts against: with -private-notes, its owner's, or the shared notes; otherwise, every note
+func (ds *Datastore) quotaScope() (string, []interface{}) {
+	if !ds.private {
+		return "1", nil
+	}
+	if ds.owner == "" {
+		return "instr(name, '/') = 0", nil
+	}
+	return prefixClause(ds.key(""))
+}
+
+// the size of a note, or 0 if it doesn't exist
+func (ds *Datastore) noteSize(q queryer, name string) (int64, error) {
+	var size int64
+	err := q.QueryRow(`select coalesce(sum(size), 0) from "note" where name = ?`, ds.key(name)).Scan(&size)
+	return size, err
+}
+
+// refuses a write of size bytes to a note which would take its owner's notes over the quota
+// it's asked through q, the write's transaction, before the write, so two writes can't both fit in the last of the quota
+func (ds *Datastore) checkQuota(q queryer, name string, size int64) error {
+	if ds.quota == 0 {
+		return nil
+	}
+	oldSize, err := ds.noteSize(q, name)
+	if err != nil {
+		return err
+	}
+	return ds.checkGrowth(q, name, oldSize, size)
+}
+
+// like checkQuota, for a write which has already been made in q, and changed the note from oldSize bytes
+// writes which don't make a note larger are let through, so notes can be trimmed under a lowered quota
+func (ds *Datastore) checkGrowth(q queryer, name string, oldSize int64, size int64) error {
+	if ds.quota == 0 || size <= oldSize {
+		return nil
+	}
+	clause, args := ds.quotaScope()
+	var others int64
+	err := q.QueryRow(`select coalesce(sum(size), 0) from "note" where `+clause+` and name <> ?`,
+		append(args, ds.key(name))...).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others+size > ds.quota {
+		return QuotaExceeded{Used: others + oldSize, Needed: size - oldSize, Quota: ds.quota}
+	}
+	return nil
+}
+
+// responds to a write refused for the quota, returning whether it was
+func respondQuotaExceeded(resp http.ResponseWriter, err error) bool {
+	var exceeded QuotaExceeded
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	writeAPIError(resp, APIErrorDetail{Code: ERR_QUOTA_EXCEEDED, Message: exceeded.Error(), Status: http.StatusInsufficientStorage,
+		Quota: &exceeded})
+	return true
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+// each case starts with a quota of 10 bytes and a note "full" of 6, and makes one write
+func TestQuota(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(ds Datastore) error
+		// the quota's numbers the write is refused with, or nil if it isn't
+		want *QuotaExceeded
+	}{
+		{"create within", func(ds Datastore) error {
+			_, err := ds.setNote("new", []byte("1234"), false)
+			return err
+		}, nil},
+		{"create over", func(ds Datastore) error {
+			_, err := ds.setNote("new", []byte("12345"), false)
+			return err
+		}, &QuotaExceeded{Used: 6, Needed: 5, Quota: 10}},
+		{"overwrite within, not counting the old body", func(ds Datastore) error {
+			_, err := ds.setNote("full", []byte("1234567890"), true)
+			return err
+		}, nil},
+		{"overwrite over", func(ds Datastore) error {
+			_, err := ds.setNote("full", []byte("12345678901"), true)
+			return err
+		}, &QuotaExceeded{Used: 6, Needed: 5, Quota: 10}},
+		{"no clobber", func(ds Datastore) error {
+			_, err := ds.setNote("full", []byte("12345678901"), false)
+			return err
+		}, nil},
+		{"compare and swap over", func(ds Datastore) error {
+			_, err := ds.swapNote("full", hashBody([]byte("123456")), []byte("12345678901"))
+			return err
+		}, &QuotaExceeded{Used: 6, Needed: 5, Quota: 10}},
+		{"increment over", func(ds Datastore) error {
+			if _, err := ds.setNote("count", []byte("9999"), false); err != nil {
+				return err
+			}
+			_, _, err := ds.incrementNote("count", 1, false)
+			return err
+		}, &QuotaExceeded{Used: 10, Needed: 1, Quota: 10}},
+	}
+	for _, dedupe := range []bool{false, true} {
+		for _, c := range cases {
+			config := testConfig(t, "-quota", "10")
+			config.Dedupe = dedupe
+			datastore, _ := testDatastore(t, config)
+			if _, err := datastore.setNote("full", []byte("123456"), false); err != nil {
+				t.Fatal(err)
+			}
+			err := c.write(datastore)
+			var exceeded QuotaExceeded
+			switch {
+			case c.want == nil && err != nil:
+				t.Errorf("%s (dedupe %v): got %v, want no error", c.name, dedupe, err)
+			case c.want != nil && !errors.As(err, &exceeded):
+				t.Errorf("%s (dedupe %v): got %v, want %v", c.name, dedupe, err, *c.want)
+			case c.want != nil && exceeded != *c.want:
+				t.Errorf("%s (dedupe %v): got %v, want %v", c.name, dedupe, exceeded, *c.want)
+			}
+		}
+	}
+}
+
+// a refused write leaves the note as it was
+func TestQuotaRollsBack(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t, "-quota", "4"))
+	if _, err := datastore.setNote("count", []byte("9999"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := datastore.incrementNote("count", 1, false); !errors.As(err, &QuotaExceeded{}) {
+		t.Fatalf("incrementing over the quota: got %v", err)
+	}
+	if _, err := datastore.setNote("more", []byte("1"), false); !errors.As(err, &QuotaExceeded{}) {
+		t.Fatalf("creating over the quota: got %v", err)
+	}
+	body, _, err := datastore.getNote("count")
+	if err != nil || string(body) != "9999" {
+		t.Errorf("count: got %q, %v, want 9999", body, err)
+	}
+	if exists, err := datastore.noteExists("more"); err != nil || exists {
+		t.Errorf("more: exists %v, %v", exists, err)
+	}
+}
+
+// with -private-notes, each owner and the shared notes have a quota of their own
+func TestQuotaPerOwner(t *testing.T) {
+	config := testConfig(t, "-quota", "5")
+	config.PrivateNotes = true
+	shared, _ := testDatastore(t, config)
+	alice, bob := shared, shared
+	alice.owner, bob.owner = "alice", "bob"
+	for _, ds := range []Datastore{shared, alice, bob} {
+		if _, err := ds.setNote("todo", []byte("12345"), false); err != nil {
+			t.Fatalf("filling %q's quota: %v", ds.owner, err)
+		}
+	}
+	for _, ds := range []Datastore{shared, alice, bob} {
+		if _, err := ds.setNote("more", []byte("1"), false); !errors.As(err, &QuotaExceeded{}) {
+			t.Errorf("writing past %q's quota: got %v", ds.owner, err)
+		}
+	}
+	// trimming a note is let through, even over a quota lowered since
+	alice.quota = 1
+	if _, err := alice.setNote("todo", []byte("1234"), true); err != nil {
+		t.Errorf("trimming a note: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often idle buckets are dropped, so the limiter doesn't grow without bound
+const rateLimitPruneInterval = 10 * time.Minute
+
+// RateLimitConfig stores the settings for rate limiting
+type RateLimitConfig struct {
+	// requests per second, and how many may be made at once; a zero rate disables the limit
+	ReadRate, WriteRate   float64
+	ReadBurst, WriteBurst int
+	// authenticated users are limited by username rather than address, at this multiple of the rates;
+	// if zero, they're limited by address like everyone else
+	UserMultiplier float64
+	// proxies whose X-Forwarded-For headers are believed
+	TrustedProxies []*net.IPNet
+}
+
+// a token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket rate limiter keyed by client
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	clock     Clock
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+func NewRateLimiter(rate float64, burst int, clock Clock) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		clock:     clock,
+		buckets:   make(map[string]*bucket),
+		lastPrune: clock.Now(),
+	}
+}
+
+// takes a token from a client's bucket
+// if there isn't one, returns false and how long until there will be
+func (l *RateLimiter) allow(key string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.clock.Now()
+	if now.Sub(l.lastPrune) > rateLimitPruneInterval {
+		l.prune(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens -= 1
+	return true, 0
+}
+
+// drops buckets which would have refilled by now, since they're the same as no bucket
+// must be called with the lock held
+func (l *RateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// RateLimits limits the rate of reads and writes from each client
+type RateLimits struct {
+	config      RateLimitConfig
+	credentials map[string]bool
+	// nil if that kind of request isn't limited, or users aren't limited separately
+	read, write, userRead, userWrite *RateLimiter
+}
+
+func NewRateLimits(config RateLimitConfig, credentials map[string]bool, clock Clock) *RateLimits {
+	limits := &RateLimits{config: config, credentials: credentials}
+	if config.ReadRate > 0 {
+		limits.read = NewRateLimiter(config.ReadRate, config.ReadBurst, clock)
+		if config.UserMultiplier > 0 {
+			limits.userRead = NewRateLimiter(config.ReadRate*config.UserMultiplier,
+				int(float64(config.ReadBurst)*config.UserMultiplier), clock)
+		}
+	}
+	if config.WriteRate > 0 {
+		limits.write = NewRateLimiter(config.WriteRate, config.WriteBurst, clock)
+		if config.UserMultiplier > 0 {
+			limits.userWrite = NewRateLimiter(config.WriteRate*config.UserMultiplier,
+				int(float64(config.WriteBurst)*config.UserMultiplier), clock)
+		}
+	}
+	return limits
+}
+
+// middleware which responds 429 to clients making requests too quickly
+func (l *RateLimits) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		limiter, userLimiter := l.write, l.userWrite
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			limiter, userLimiter = l.read, l.userRead
+		}
+		key := clientIP(req, l.config.TrustedProxies)
+		if user, password, ok := req.BasicAuth(); ok && userLimiter != nil && l.credentials[user+":"+password] {
+			limiter = userLimiter
+			key = user
+		}
+		if limiter != nil {
+			if ok, wait := limiter.allow(key); !ok {
+				resp.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				discardBody(resp, req)
+				errorResponse(resp, req, http.StatusTooManyRequests)
+				return
+			}
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// the address of the client which made a request
+// if the request came through trusted proxies, the client is the last address in X-Forwarded-For
+// which isn't a trusted proxy
+func clientIP(req *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	if !ipTrusted(host, trustedProxies) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		host = hop
+		if !ipTrusted(hop, trustedProxies) {
+			break
+		}
+	}
+	return host
+}
+
+// checks whether an address is one of the trusted proxies
+func ipTrusted(addr string, trustedProxies []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
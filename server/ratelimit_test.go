@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := newFakeClock(testEpoch)
+	limiter := NewRateLimiter(1, 2, clock)
+	steps := []struct {
+		advance  time.Duration
+		key      string
+		want     bool
+		wantWait time.Duration
+	}{
+		{0, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+		// each client has its own bucket
+		{0, "b", true, 0},
+		{500 * time.Millisecond, "a", false, 500 * time.Millisecond},
+		{500 * time.Millisecond, "a", true, 0},
+		{0, "a", false, time.Second},
+		// a bucket never holds more than the burst
+		{time.Hour, "a", true, 0},
+		{0, "a", true, 0},
+		{0, "a", false, time.Second},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		ok, wait := limiter.allow(step.key)
+		if ok != step.want || wait != step.wantWait {
+			t.Errorf("step %d: got %v and a wait of %s, want %v and %s", i, ok, wait, step.want, step.wantWait)
+		}
+	}
+}
+
+// buckets which have refilled are dropped, and those which haven't are kept
+func TestRateLimiterPrunes(t *testing.T) {
+	clock := newFakeClock(testEpoch)
+	limiter := NewRateLimiter(0.001, 1, clock)
+	limiter.allow("idle")
+	clock.Advance(rateLimitPruneInterval / 2)
+	limiter.allow("busy")
+	// the idle bucket has had 1000s to refill a token, the busy one only half of that
+	clock.Advance(1000*time.Second - rateLimitPruneInterval/2)
+	limiter.allow("other")
+	if _, ok := limiter.buckets["idle"]; ok {
+		t.Error("the idle bucket was kept")
+	}
+	if _, ok := limiter.buckets["busy"]; !ok {
+		t.Error("the busy bucket was dropped")
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	_, proxy, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := RateLimitConfig{ReadRate: 1, ReadBurst: 1, WriteRate: 1, WriteBurst: 1, UserMultiplier: 2,
+		TrustedProxies: []*net.IPNet{proxy}}
+	clock := newFakeClock(testEpoch)
+	limits := NewRateLimits(config, map[string]bool{"alice:secret": true}, clock)
+	handler := limits.middleware(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+	do := func(method string, remote string, forwarded string, creds string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/note/todo", nil)
+		req.RemoteAddr = remote + ":1234"
+		if forwarded != "" {
+			req.Header.Set("X-Forwarded-For", forwarded)
+		}
+		if user, password, ok := strings.Cut(creds, ":"); ok {
+			req.SetBasicAuth(user, password)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	steps := []struct {
+		name      string
+		method    string
+		remote    string
+		forwarded string
+		creds     string
+		want      int
+	}{
+		{"first read", http.MethodGet, "192.0.2.1", "", "", http.StatusOK},
+		{"second read", http.MethodGet, "192.0.2.1", "", "", http.StatusTooManyRequests},
+		{"head counts as a read", http.MethodHead, "192.0.2.1", "", "", http.StatusTooManyRequests},
+		{"writes are limited apart from reads", http.MethodPut, "192.0.2.1", "", "", http.StatusOK},
+		{"second write", http.MethodDelete, "192.0.2.1", "", "", http.StatusTooManyRequests},
+		{"another address", http.MethodGet, "192.0.2.2", "", "", http.StatusOK},
+		{"through a trusted proxy", http.MethodGet, "10.0.0.1", "192.0.2.3", "", http.StatusOK},
+		{"the same client through the proxy", http.MethodGet, "10.0.0.2", "192.0.2.3", "", http.StatusTooManyRequests},
+		{"a forged header from an untrusted address", http.MethodGet, "192.0.2.1", "192.0.2.9", "", http.StatusTooManyRequests},
+		{"a user is limited by name, at twice the burst", http.MethodGet, "192.0.2.1", "", "alice:secret", http.StatusOK},
+		{"a user's second read", http.MethodGet, "192.0.2.2", "", "alice:secret", http.StatusOK},
+		{"a user's third read", http.MethodGet, "192.0.2.3", "", "alice:secret", http.StatusTooManyRequests},
+		{"wrong credentials are limited by address", http.MethodGet, "192.0.2.1", "", "alice:wrong", http.StatusTooManyRequests},
+	}
+	for _, step := range steps {
+		resp := do(step.method, step.remote, step.forwarded, step.creds)
+		if resp.Code != step.want {
+			t.Errorf("%s: got %d, want %d", step.name, resp.Code, step.want)
+		}
+		if resp.Code == http.StatusTooManyRequests && resp.Header().Get("Retry-After") != "1" {
+			t.Errorf("%s: Retry-After is %q, want 1", step.name, resp.Header().Get("Retry-After"))
+		}
+	}
+	clock.Advance(time.Second)
+	if resp := do(http.MethodGet, "192.0.2.1", "", ""); resp.Code != http.StatusOK {
+		t.Errorf("a second later: got %d, want 200", resp.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []*net.IPNet{proxies}
+	cases := []struct {
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"192.0.2.1:80", nil, "192.0.2.1"},
+		{"192.0.2.1:80", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"10.0.0.1:80", nil, "10.0.0.1"},
+		{"10.0.0.1:80", []string{"198.51.100.1"}, "198.51.100.1"},
+		// the client can put anything at the start, so the last untrusted hop is the client
+		{"10.0.0.1:80", []string{"203.0.113.7, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1:80", []string{"203.0.113.7", "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1:80", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"[2001:db8::1]:80", nil, "2001:db8::1"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = c.remote
+		for _, value := range c.forwarded {
+			req.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(req, trusted); got != c.want {
+			t.Errorf("%s forwarded for %v: got %s, want %s", c.remote, c.forwarded, got, c.want)
+		}
+	}
+}
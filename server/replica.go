@@ -0,0 +1,240 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// generations are named like generation-20261017T093000Z.db, so they sort by time
+const (
+	generationPrefix     = "generation-"
+	generationSuffix     = ".db"
+	generationTimeFormat = "20060102T150405Z"
+)
+
+// Replicator keeps snapshots of the database in a directory, as a warm standby
+// each snapshot is a complete database, called a generation, and old generations are pruned
+type Replicator struct {
+	datastore Datastore
+	dir       string
+	// how many generations to keep
+	keep int
+	// stop the replication loop, and are closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewReplicator(datastore Datastore, dir string, keep int) *Replicator {
+	return &Replicator{
+		datastore: datastore,
+		dir:       dir,
+		keep:      keep,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// snapshots the database now and every interval, as measured by the datastore's clock,
+// and once more when shut down
+func (r *Replicator) run(interval time.Duration) {
+	defer close(r.done)
+	ticker := r.datastore.getClock().NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.replicate()
+		select {
+		case <-r.stop:
+			r.replicate()
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// stops replicating, waiting for the final snapshot to be written
+func (r *Replicator) shutdown() {
+	close(r.stop)
+	<-r.done
+}
+
+// writes a new generation and prunes old ones, logging any failure
+func (r *Replicator) replicate() {
+	_, err := r.snapshot()
+	if err != nil {
+		log.Printf("replicating database: %v", err)
+		return
+	}
+	err = r.prune()
+	if err != nil {
+		log.Printf("pruning replicas: %v", err)
+	}
+}
+
+// writes a snapshot of the database as a new generation, returning its path
+// the snapshot is written under a temporary name and synced before it's renamed,
+// so a generation is never seen half written
+func (r *Replicator) snapshot() (string, error) {
+	err := os.MkdirAll(r.dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	name := generationPrefix + r.datastore.now().UTC().Format(generationTimeFormat) + generationSuffix
+	path := filepath.Join(r.dir, name)
+	temp := filepath.Join(r.dir, ".tmp-"+name)
+	os.Remove(temp)
+	defer os.Remove(temp)
+	err = r.datastore.snapshot(temp)
+	if err != nil {
+		return "", err
+	}
+	err = syncFile(temp)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(temp, path)
+	if err != nil {
+		return "", err
+	}
+	return path, syncDir(r.dir)
+}
+
+// removes all but the newest generations
+func (r *Replicator) prune() error {
+	generations, err := listGenerations(r.dir)
+	if err != nil {
+		return err
+	}
+	for len(generations) > r.keep {
+		err = os.Remove(filepath.Join(r.dir, generations[0]))
+		if err != nil {
+			return err
+		}
+		generations = generations[1:]
+	}
+	return nil
+}
+
+// lists the generations in a directory, oldest first
+func listGenerations(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	generations := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, generationPrefix) && strings.HasSuffix(name, generationSuffix) {
+			generations = append(generations, name)
+		}
+	}
+	sort.Strings(generations)
+	return generations, nil
+}
+
+// syncs a file's contents to disk
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// corkboard [flags] restore -from dir
+// rebuilds the database at databasePath from the newest generation in a replica directory
+// corkboard mustn't be running on the database while it's restored
+func restoreReplica(databasePath string, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	from := flags.String("from", "", "Directory written by -replica-dir to restore from.")
+	force := flags.Bool("force", false, "Replace the database if it already exists. The old database is kept\nbeside it, with \".before-restore\" appended to its name.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] restore -from dir\nRebuilds the database from the newest snapshot in dir.\nRestore flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 || *from == "" {
+		flags.Usage()
+		return flag.ErrHelp
+	}
+
+	generations, err := listGenerations(*from)
+	if err != nil {
+		return err
+	}
+	if len(generations) == 0 {
+		return fmt.Errorf("no snapshots in %s", *from)
+	}
+	newest := filepath.Join(*from, generations[len(generations)-1])
+
+	if _, err := os.Stat(databasePath); err == nil {
+		if !*force {
+			return fmt.Errorf("%s already exists; use restore -force to replace it", databasePath)
+		}
+		// keep the old database, and its WAL, which belongs with it
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err = os.Rename(databasePath+suffix, databasePath+".before-restore"+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	// a WAL left behind by another database would be applied to this one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Remove(databasePath + suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err = copyFileSynced(newest, databasePath)
+	if err != nil {
+		return err
+	}
+	log.Printf("restored %s from %s", databasePath, newest)
+	return nil
+}
+
+// copies a file through a temporary file, so it's never seen half written, and syncs it to disk
+func copyFileSynced(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	temp, err := os.CreateTemp(filepath.Dir(to), ".restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	_, err = io.Copy(temp, source)
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp.Name(), to)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(to))
+}
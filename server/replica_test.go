@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snapshots past -replica-keep prune the oldest, and restore -from rebuilds a database from the newest,
+// with every note written before it and none written after
+func TestReplicateAndRestore(t *testing.T) {
+	config := testConfig(t, "-replica-keep", "3")
+	datastore, clock := testDatastore(t, config)
+	dir := filepath.Join(t.TempDir(), "replicas")
+	replicator := NewReplicator(datastore, dir, config.ReplicaKeep)
+	var taken []string
+	for i := 0; i < 6; i++ {
+		if _, err := datastore.setNote(fmt.Sprintf("note-%d", i), []byte(fmt.Sprintf("written before snapshot %d", i)), false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := datastore.setNote("todo", []byte(fmt.Sprintf("version %d", i)), true); err != nil {
+			t.Fatal(err)
+		}
+		replicator.replicate()
+		taken = append(taken, generationPrefix+clock.Now().UTC().Format(generationTimeFormat)+generationSuffix)
+		clock.Advance(5 * time.Minute)
+	}
+	if _, err := datastore.setNote("late", []byte("written after the last snapshot"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	generations, err := listGenerations(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(generations, " ") != strings.Join(taken[3:], " ") {
+		t.Errorf("kept %v, want the newest 3 of %v", generations, taken)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(generations) {
+		t.Errorf("%d files in %s, want only the %d generations", len(entries), dir, len(generations))
+	}
+
+	restored := filepath.Join(t.TempDir(), "restored.db")
+	if err := restoreReplica(restored, []string{"-from", dir}); err != nil {
+		t.Fatal(err)
+	}
+	config.DatabasePath = restored
+	config.Hooks = config.Hooks.withDefaults(config)
+	restoredStore, err := openDatastore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restoredStore.Close()
+	for i := 0; i < 6; i++ {
+		name, want := fmt.Sprintf("note-%d", i), fmt.Sprintf("written before snapshot %d", i)
+		if body, _, err := restoredStore.peekNote(name); err != nil || string(body) != want {
+			t.Errorf("%s says %q, %v, want %q", name, body, err, want)
+		}
+	}
+	if body, _, err := restoredStore.peekNote("todo"); err != nil || string(body) != "version 5" {
+		t.Errorf("todo says %q, %v, want the newest snapshot's version 5", body, err)
+	}
+	if exists, err := restoredStore.noteExists("late"); err != nil || exists {
+		t.Errorf("a note written after the last snapshot was restored: %v, %v", exists, err)
+	}
+}
+
+// restore won't replace a database without -force, and keeps the one it replaces
+func TestRestoreErrors(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t))
+	dir := filepath.Join(t.TempDir(), "replicas")
+	if _, err := datastore.setNote("todo", []byte("milk"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReplicator(datastore, dir, 1).snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreReplica(filepath.Join(t.TempDir(), "notes.db"), []string{"-from", t.TempDir()}); err == nil || !strings.Contains(err.Error(), "no snapshots") {
+		t.Errorf("restoring from an empty directory: got %v", err)
+	}
+	existing := filepath.Join(t.TempDir(), "notes.db")
+	if err := os.WriteFile(existing, []byte("the old database"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreReplica(existing, []string{"-from", dir}); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("restoring over a database without -force: got %v", err)
+	}
+	if data, _ := os.ReadFile(existing); string(data) != "the old database" {
+		t.Errorf("the database was changed without -force")
+	}
+	if err := restoreReplica(existing, []string{"-from", dir, "-force"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(existing + ".before-restore"); string(data) != "the old database" {
+		t.Errorf("the old database wasn't kept, found %q", data)
+	}
+	if data, _ := os.ReadFile(existing); !strings.HasPrefix(string(data), "SQLite format 3") {
+		t.Errorf("the restored database isn't a database")
+	}
+}
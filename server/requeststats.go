@@ -0,0 +1,338 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most requests RequestStats keeps, however many were made within its window,
+// so a busy corkboard's stats are of only its latest requests
+const maxRequestSamples = 10000
+
+// the longest path kept of each request, so a request for a long path can't use much memory
+const maxSamplePath = 256
+
+// how many of the slowest requests GET /api/stats lists
+const slowestRequests = 10
+
+// the route of requests which matched no route, like those answered 404
+const unmatchedRoute = "unmatched"
+
+// the quantiles of durations reported for each route
+var requestQuantiles = []float64{0.5, 0.95, 0.99}
+
+// a request which was answered
+type requestSample struct {
+	time     time.Time
+	route    string
+	path     string
+	status   int
+	duration time.Duration
+}
+
+// the requests to a route since startup, for prometheus
+type routeTotal struct {
+	count uint64
+	sum   float64
+}
+
+// RequestStats collects how long corkboard takes to answer requests to each route, and how it answers them,
+// for GET /api/stats and /metrics
+// the latest requests are kept in a ring buffer, and those older than the window are left out of what it reports
+// one is shared by every board; a nil *RequestStats collects nothing, when -stats-window is zero
+type RequestStats struct {
+	window time.Duration
+	lock   sync.Mutex
+	// the latest requests, oldest first from next once it's full
+	samples []requestSample
+	next    int
+	// since startup, by route and by status class, like "2xx"
+	totals   map[string]*routeTotal
+	statuses map[string]uint64
+}
+
+func NewRequestStats(window time.Duration) *RequestStats {
+	if window == 0 {
+		return nil
+	}
+	return &RequestStats{
+		window:   window,
+		samples:  make([]requestSample, 0, maxRequestSamples),
+		totals:   make(map[string]*routeTotal),
+		statuses: make(map[string]uint64),
+	}
+}
+
+// the route a request matched, which the route's handler fills in; see labelledRouter
+type routeLabel struct {
+	route string
+}
+
+// middleware which times every request and records how it was answered
+func (s *RequestStats) middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		label := &routeLabel{route: unmatchedRoute}
+		writer := &statusWriter{ResponseWriter: resp}
+		next.ServeHTTP(writer, req.WithContext(context.WithValue(req.Context(), routeKey, label)))
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.observe(requestSample{time: start, route: label.route, path: req.URL.Path, status: status, duration: time.Since(start)})
+	})
+}
+
+func (s *RequestStats) observe(sample requestSample) {
+	if len(sample.path) > maxSamplePath {
+		sample.path = sample.path[:maxSamplePath]
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.samples) < maxRequestSamples {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+		s.next = (s.next + 1) % maxRequestSamples
+	}
+	total, ok := s.totals[sample.route]
+	if !ok {
+		total = &routeTotal{}
+		s.totals[sample.route] = total
+	}
+	total.count += 1
+	total.sum += sample.duration.Seconds()
+	s.statuses[statusClass(sample.status)] += 1
+}
+
+// like "2xx"
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// a response which remembers the status it was sent with
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// an httprouter which labels each request with the route it matched, like "GET /note/:note", for RequestStats
+type labelledRouter struct {
+	*httprouter.Router
+}
+
+func labelRequest(req *http.Request, method string, path string) {
+	if label, ok := req.Context().Value(routeKey).(*routeLabel); ok {
+		label.route = method + " " + path
+	}
+}
+
+func (r labelledRouter) Handle(method string, path string, h httprouter.Handle) {
+	r.Router.Handle(method, path, func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		labelRequest(req, method, path)
+		h(resp, req, params)
+	})
+}
+
+func (r labelledRouter) GET(path string, h httprouter.Handle) {
+	r.Handle(http.MethodGet, path, h)
+}
+
+func (r labelledRouter) HEAD(path string, h httprouter.Handle) {
+	r.Handle(http.MethodHead, path, h)
+}
+
+func (r labelledRouter) POST(path string, h httprouter.Handle) {
+	r.Handle(http.MethodPost, path, h)
+}
+
+func (r labelledRouter) PUT(path string, h httprouter.Handle) {
+	r.Handle(http.MethodPut, path, h)
+}
+
+func (r labelledRouter) PATCH(path string, h httprouter.Handle) {
+	r.Handle(http.MethodPatch, path, h)
+}
+
+func (r labelledRouter) DELETE(path string, h httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, h)
+}
+
+// RequestStatsReport is the response to GET /api/stats
+type RequestStatsReport struct {
+	WindowSeconds float64 `json:"window_seconds"`
+	// when the oldest request counted was made, which is later than the window's start
+	// if more than maxRequestSamples requests were made in it; nil if there were none
+	Since    *time.Time     `json:"since"`
+	Requests int            `json:"requests"`
+	Statuses map[string]int `json:"statuses"`
+	// by route, in order
+	Routes []RouteStats `json:"routes"`
+	// slowest first
+	Slowest []SlowRequest `json:"slowest"`
+}
+
+type RouteStats struct {
+	Route      string  `json:"route"`
+	Requests   int     `json:"requests"`
+	P50Seconds float64 `json:"p50_seconds"`
+	P95Seconds float64 `json:"p95_seconds"`
+	P99Seconds float64 `json:"p99_seconds"`
+}
+
+type SlowRequest struct {
+	Time            time.Time `json:"time"`
+	Route           string    `json:"route"`
+	Path            string    `json:"path"`
+	Status          int       `json:"status"`
+	DurationSeconds float64   `json:"duration_seconds"`
+}
+
+// the requests made within the window before now
+func (s *RequestStats) recent(now time.Time) []requestSample {
+	start := now.Add(-s.window)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	recent := make([]requestSample, 0, len(s.samples))
+	for _, sample := range s.samples {
+		if !sample.time.Before(start) {
+			recent = append(recent, sample)
+		}
+	}
+	return recent
+}
+
+// summarises the requests made within the window before now
+func (s *RequestStats) report(now time.Time) RequestStatsReport {
+	recent := s.recent(now)
+	report := RequestStatsReport{
+		WindowSeconds: s.window.Seconds(),
+		Requests:      len(recent),
+		Statuses:      make(map[string]int),
+		Routes:        []RouteStats{},
+		Slowest:       []SlowRequest{},
+	}
+	// slowest first, so each route's durations are in order too
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].duration > recent[j].duration
+	})
+	byRoute := make(map[string][]time.Duration)
+	for _, sample := range recent {
+		if report.Since == nil || sample.time.Before(*report.Since) {
+			since := sample.time
+			report.Since = &since
+		}
+		report.Statuses[statusClass(sample.status)] += 1
+		byRoute[sample.route] = append(byRoute[sample.route], sample.duration)
+		if len(report.Slowest) < slowestRequests {
+			report.Slowest = append(report.Slowest, SlowRequest{
+				Time:            sample.time,
+				Route:           sample.route,
+				Path:            sample.path,
+				Status:          sample.status,
+				DurationSeconds: sample.duration.Seconds(),
+			})
+		}
+	}
+	for route, durations := range byRoute {
+		report.Routes = append(report.Routes, RouteStats{
+			Route:      route,
+			Requests:   len(durations),
+			P50Seconds: quantile(durations, 0.5),
+			P95Seconds: quantile(durations, 0.95),
+			P99Seconds: quantile(durations, 0.99),
+		})
+	}
+	sort.Slice(report.Routes, func(i, j int) bool {
+		return report.Routes[i].Route < report.Routes[j].Route
+	})
+	return report
+}
+
+// the duration which a fraction q of durations, sorted longest first, are no longer than, in seconds
+func quantile(durations []time.Duration, q float64) float64 {
+	rank := int(math.Ceil(q * float64(len(durations))))
+	return durations[len(durations)-rank].Seconds()
+}
+
+// serves the durations of recent requests to each route, the slowest of them and how they were answered
+func GetRequestStats(stats *RequestStats) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		resp.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(resp).Encode(stats.report(time.Now()))
+		if err != nil {
+			log.Printf("responding with request stats: %v", err)
+		}
+	}
+}
+
+// writes the quantiles of each route's durations over the window as a summary, and the responses
+// by status class since startup, in the prometheus text format
+func (s *RequestStats) writeMetrics(w io.Writer) {
+	if s == nil {
+		return
+	}
+	report := s.report(time.Now())
+	quantiles := make(map[string]RouteStats)
+	for _, route := range report.Routes {
+		quantiles[route.Route] = route
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	routes := make([]string, 0, len(s.totals))
+	for route := range s.totals {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	fmt.Fprintln(w, "# HELP corkboard_http_request_duration_seconds Time taken to answer requests to each route, with quantiles over -stats-window.")
+	fmt.Fprintln(w, "# TYPE corkboard_http_request_duration_seconds summary")
+	for _, route := range routes {
+		// a route with no requests within the window has no quantiles, but still its sum and count
+		if stats, ok := quantiles[route]; ok {
+			for i, value := range []float64{stats.P50Seconds, stats.P95Seconds, stats.P99Seconds} {
+				fmt.Fprintf(w, "corkboard_http_request_duration_seconds{route=%q,quantile=%q} %g\n",
+					route, strconv.FormatFloat(requestQuantiles[i], 'g', -1, 64), value)
+			}
+		}
+		fmt.Fprintf(w, "corkboard_http_request_duration_seconds_sum{route=%q} %g\n", route, s.totals[route].sum)
+		fmt.Fprintf(w, "corkboard_http_request_duration_seconds_count{route=%q} %d\n", route, s.totals[route].count)
+	}
+	classes := make([]string, 0, len(s.statuses))
+	for class := range s.statuses {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	fmt.Fprintln(w, "# HELP corkboard_http_responses_total Responses by status class, like 2xx.")
+	fmt.Fprintln(w, "# TYPE corkboard_http_responses_total counter")
+	for _, class := range classes {
+		fmt.Fprintf(w, "corkboard_http_responses_total{class=%q} %d\n", class, s.statuses[class])
+	}
+}
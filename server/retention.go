@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RetentionRule keeps the notes whose names begin with prefix for age, rather than -note-expiry
+// an age of zero keeps them forever
+// with -private-notes, the prefix is matched against the names users see, without their owner
+type RetentionRule struct {
+	prefix string
+	age    time.Duration
+}
+
+func (rule RetentionRule) String() string {
+	if rule.age == 0 {
+		return rule.prefix + "=never"
+	}
+	return rule.prefix + "=" + rule.age.String()
+}
+
+// RetentionRules are the -retention flags, kept with the longest prefixes first,
+// so the first rule a note's name matches is the most specific
+type RetentionRules []RetentionRule
+
+func (rules *RetentionRules) String() string {
+	parts := []string{}
+	for _, rule := range *rules {
+		parts = append(parts, rule.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// parses a rule like "tmp-=24h", or "keep-=never"
+func (rules *RetentionRules) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return errors.New(`must be like "prefix=24h" or "prefix=never"`)
+	}
+	rule := RetentionRule{prefix: value[:i]}
+	if err := validateNotePrefix(rule.prefix); err != nil {
+		return fmt.Errorf("prefix %q: %v", rule.prefix, err)
+	}
+	if age := value[i+1:]; age != "never" {
+		var err error
+		rule.age, err = time.ParseDuration(age)
+		if err != nil || rule.age <= 0 {
+			return fmt.Errorf("%q must be a positive duration like \"24h\", or \"never\"", age)
+		}
+	}
+	for _, other := range *rules {
+		if other.prefix == rule.prefix {
+			return fmt.Errorf("there's already a rule for %q", rule.prefix)
+		}
+	}
+	*rules = append(*rules, rule)
+	sort.SliceStable(*rules, func(i, j int) bool { return len((*rules)[i].prefix) > len((*rules)[j].prefix) })
+	return nil
+}
+
+// the rule for a note, and whether there is one; if there isn't, -note-expiry applies
+func (rules RetentionRules) find(name string) (RetentionRule, bool) {
+	for _, rule := range rules {
+		if strings.HasPrefix(name, rule.prefix) {
+			return rule, true
+		}
+	}
+	return RetentionRule{}, false
+}
+
+// the -retention rules as a retention hook, which corkboard asks after any others; see Hooks
+type prefixRetention struct {
+	rules RetentionRules
+	// whether notes' stored names begin with their owner, which the rules' prefixes don't
+	private bool
+}
+
+func (r prefixRetention) RetentionOverride(_ context.Context, note NoteInfo) (time.Duration, bool) {
+	name := note.Name
+	if r.private {
+		name = name[strings.Index(name, "/")+1:]
+	}
+	rule, ok := r.rules.find(name)
+	return rule.age, ok
+}
+
+// NoteExpiry says when a note will expire, and why, for GET /api/note/:note/metadata
+type NoteExpiry struct {
+	// the prefix of the -retention rule for the note, or "" if -note-expiry or a retention hook applies
+	Rule string `json:"rule"`
+	// whether a retention hook decided how long the note is kept; see Hooks
+	Hook bool `json:"hook,omitempty"`
+	// how long the note is kept after it's last viewed, or created, under the expiry policy; "never" if it's kept forever
+	Age string `json:"age"`
+	// null if the note won't expire
+	Expires *time.Time `json:"expires"`
+}
+
+// when a note will expire, as expiryClause would have it
+func (expiry ExpiryConfig) noteExpiry(name string, info NoteInfo) NoteExpiry {
+	age := expiry.age
+	result := NoteExpiry{}
+	if info.retentionOverride != nil {
+		age = *info.retentionOverride
+		// the -retention rules are a hook too, but asked last, so a rule only applies if no other hook decided
+		rule, ok := expiry.retention.find(name)
+		if _, added := expiry.hooks.addedRetentionOverride(context.Background(), info); ok && !added {
+			result.Rule = rule.prefix
+		} else {
+			result.Hook = true
+		}
+	}
+	result.Age = "never"
+	if age != 0 {
+		result.Age = age.String()
+	}
+	published := func(from time.Time) time.Time {
+		if info.PublishAt != nil && info.PublishAt.After(from) {
+			return *info.PublishAt
+		}
+		return from
+	}
+	var expires time.Time
+	if age != 0 {
+		from := info.LastViewed
+		if expiry.policy == EXPIRE_CREATED {
+			from = info.CreateTime
+		}
+		expires = published(from).Add(age)
+	}
+	if expiry.unviewedAge != 0 && info.LastViewed.Equal(info.CreateTime) {
+		unviewed := published(info.CreateTime).Add(expiry.unviewedAge)
+		if expires.IsZero() || unviewed.Before(expires) {
+			expires = unviewed
+		}
+	}
+	if !expires.IsZero() {
+		expires = expires.UTC()
+		result.Expires = &expires
+	}
+	return result
+}
@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// on startup, look back this far for a scheduled note which was missed while corkboard was down
+const scheduleCatchUpWindow = 7 * 24 * time.Hour
+
+// a field of a cron expression, as the set of values it matches
+type cronField map[int]bool
+
+// CronSchedule is a parsed five-field cron expression
+type CronSchedule struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	// cron matches either day field when both are restricted
+	dayOfMonthAny, dayOfWeekAny bool
+}
+
+// parses a standard cron expression like "0 9 * * 1-5"
+// supports *, numbers, ranges, lists and steps in each field
+func parseCron(expression string) (CronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return CronSchedule{}, fmt.Errorf("cron expression %q must have 5 fields", expression)
+	}
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := [5]cronField{}
+	for i, field := range fields {
+		values, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return CronSchedule{}, fmt.Errorf("cron expression %q: %s", expression, err)
+		}
+		parsed[i] = values
+	}
+	// sunday is both 0 and 7
+	if parsed[4][7] {
+		parsed[4][0] = true
+	}
+	return CronSchedule{
+		minute:        parsed[0],
+		hour:          parsed[1],
+		dayOfMonth:    parsed[2],
+		month:         parsed[3],
+		dayOfWeek:     parsed[4],
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}, nil
+}
+
+// parses one field of a cron expression, whose values must be between min and max
+func parseCronField(field string, min int, max int) (cronField, error) {
+	values := cronField{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("bad value %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("bad value %q", part)
+				}
+			} else if step != 1 {
+				// "5/10" means every 10 starting from 5
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for value := low; value <= high; value += step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// checks whether the schedule fires during the minute containing t
+func (c CronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+	domMatch := c.dayOfMonth[t.Day()]
+	dowMatch := c.dayOfWeek[int(t.Weekday())]
+	if c.dayOfMonthAny || c.dayOfWeekAny {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// the last minute before t at which the schedule fires, looking back no further than within
+// returns false if it doesn't fire in that time
+func (c CronSchedule) previous(t time.Time, within time.Duration) (time.Time, bool) {
+	start := t.Truncate(time.Minute)
+	if start.Equal(t) {
+		start = start.Add(-time.Minute)
+	}
+	for slot := start; !slot.Before(t.Add(-within)); slot = slot.Add(-time.Minute) {
+		if c.matches(slot) {
+			return slot, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// the first minute after t at which the schedule fires, looking ahead no further than within
+// returns false if it doesn't fire in that time
+func (c CronSchedule) next(t time.Time, within time.Duration) (time.Time, bool) {
+	for slot := t.Truncate(time.Minute).Add(time.Minute); !slot.After(t.Add(within)); slot = slot.Add(time.Minute) {
+		if c.matches(slot) {
+			return slot, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// whether the schedule fires at most once a day, at one hour and minute
+func (c CronSchedule) daily() bool {
+	return len(c.minute) == 1 && len(c.hour) == 1
+}
+
+// ScheduleEntry describes a note which is created on a schedule
+type ScheduleEntry struct {
+	schedule CronSchedule
+	// name of the note, which may contain placeholders like {{date}}
+	namePattern string
+	// name of the template the note is created from, or "" to use body
+	template string
+	body     string
+}
+
+// parses a schedule file
+// each line is a cron expression, a name pattern, and optionally the note's body,
+// or "template:name" to create it from a template, e.g.
+//
+//	0 9 * * 1  standup-{{date}}  template:standup
+//
+// blank lines and lines beginning with '#' are ignored
+func parseSchedule(r io.Reader, source string) ([]ScheduleEntry, error) {
+	entries := []ScheduleEntry{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("%s line %d: expected a cron expression and a note name", source, lineNumber)
+		}
+		schedule, err := parseCron(strings.Join(fields[:5], " "))
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %s", source, lineNumber, err)
+		}
+		entry := ScheduleEntry{schedule: schedule, namePattern: fields[5]}
+		rest := strings.Join(fields[6:], " ")
+		if strings.HasPrefix(rest, "template:") {
+			entry.template = strings.TrimPrefix(rest, "template:")
+		} else {
+			entry.body = strings.ReplaceAll(rest, `\n`, "\n")
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", source, err)
+	}
+	return entries, nil
+}
+
+// Scheduler creates notes according to a schedule
+type Scheduler struct {
+	datastore Datastore
+	entries   []ScheduleEntry
+	listeners Listeners
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func NewScheduler(datastore Datastore, entries []ScheduleEntry, listeners Listeners) *Scheduler {
+	return &Scheduler{
+		datastore: datastore,
+		entries:   entries,
+		listeners: listeners,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// runs the schedule until stopped
+// on startup, each entry's most recent slot is run in case it was missed while corkboard was down;
+// since notes which already exist are skipped, this never creates a note twice
+func (s *Scheduler) run() {
+	defer close(s.done)
+	now := time.Now().Truncate(time.Minute)
+	for _, entry := range s.entries {
+		for slot := now; slot.After(now.Add(-scheduleCatchUpWindow)); slot = slot.Add(-time.Minute) {
+			if entry.schedule.matches(slot) {
+				s.create(entry, slot)
+				break
+			}
+		}
+	}
+	last := now
+	for {
+		next := last.Add(time.Minute)
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		for _, entry := range s.entries {
+			if entry.schedule.matches(next) {
+				s.create(entry, next)
+			}
+		}
+		last = next
+	}
+}
+
+// stops the scheduler, waiting for any note being created to finish
+func (s *Scheduler) shutdown() {
+	close(s.stop)
+	<-s.done
+}
+
+// creates the note for an entry's slot, unless it already exists
+func (s *Scheduler) create(entry ScheduleEntry, slot time.Time) {
+	name := string(fillNoteTemplate([]byte(entry.namePattern), map[string]string{}, slot))
+	if err := validateNoteName(name); err != nil {
+		log.Printf("scheduling note %s: %v", name, err)
+		return
+	}
+	body := []byte(entry.body)
+	if entry.template != "" {
+		templateBody, ok, err := s.datastore.peekNote(noteTemplatePrefix + entry.template)
+		if err != nil {
+			log.Printf("scheduling note %s: accessing template %s: %v", name, entry.template, err)
+			return
+		}
+		if !ok {
+			log.Printf("scheduling note %s: template %s doesn't exist", name, entry.template)
+			return
+		}
+		body = templateBody
+	}
+	body = fillNoteTemplate(body, map[string]string{"name": name}, slot)
+	status, err := s.datastore.setNote(name, body, false)
+	if err != nil {
+		log.Printf("scheduling note %s: %v", name, err)
+		return
+	}
+	if status == CREATED {
+		s.listeners.publish(NoteEvent{Action: NOTE_CREATED, Name: name, Size: len(body)})
+		log.Printf("New scheduled note %s", name)
+	}
+}
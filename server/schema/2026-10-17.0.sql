@@ -0,0 +1,10 @@
+-- Queue of changes waiting to be pushed to a mirror
+
+create table "replication" (
+    id            integer primary key autoincrement,
+    name          text not null,
+    action        text not null,
+    attempts      integer not null default 0,
+    next_attempt  datetime default current_timestamp,
+    create_time   datetime default current_timestamp
+);
@@ -0,0 +1,4 @@
+-- SHA-256 of each note's body, as lowercase hex
+-- Existing notes are hashed by corkboard at startup
+
+alter table "note" add column hash text;
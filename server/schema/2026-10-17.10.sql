@@ -0,0 +1,31 @@
+-- A log of every change to a note, for GET /api/changes
+-- The triggers append to it in the same transaction as the change, so no change is missed.
+-- Ids are the cursor clients resume from; autoincrement means they're never reused.
+-- Times are written by sqlite, in the same format as corkboard's.
+
+create table "change_log" (
+    id           integer primary key autoincrement,
+    name         text not null,
+    action       text not null,
+    hash         text,
+    change_time  datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+create index change_log_time on "change_log" (change_time);
+
+create trigger note_change_insert after insert on "note"
+begin
+    insert into "change_log" (name, action, hash) values (new.name, 'create', new.hash);
+end;
+
+-- notes hashed for the first time at startup haven't changed
+create trigger note_change_update after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    insert into "change_log" (name, action, hash) values (new.name, 'update', new.hash);
+end;
+
+create trigger note_change_delete after delete on "note"
+begin
+    insert into "change_log" (name, action) values (old.name, 'delete');
+end;
@@ -0,0 +1,6 @@
+-- Settings which can be changed while corkboard is running, like the banner shown on every page
+
+create table "setting" (
+    name   text primary key,
+    value  text not null
+);
@@ -0,0 +1,15 @@
+-- Views of notes, for GET /api/note/:note/stats. Each view is recorded with the client's
+-- address truncated to its network, like 203.0.113.0/24, so viewers can't be told apart.
+-- Views older than -analytics-retention are pruned; "views" on the note keeps counting them.
+
+alter table "note" add column views integer not null default 0;
+
+create table "view_event" (
+    id         integer primary key autoincrement,
+    note       text not null references "note" (name) on delete cascade on update cascade,
+    client     text not null,
+    view_time  datetime not null
+);
+
+create index view_event_note on "view_event" (note, view_time);
+create index view_event_time on "view_event" (view_time);
@@ -0,0 +1,4 @@
+-- Free-form metadata of notes, set with PUT /api/note/:note/meta: a json object of
+-- strings, numbers and booleans, like {"language": "go"}.
+
+alter table "note" add column meta text not null default '{}';
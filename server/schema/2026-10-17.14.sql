@@ -0,0 +1,3 @@
+-- Titles of notes, shown instead of their names. '' means the note has none.
+
+alter table "note" add column title text not null default '';
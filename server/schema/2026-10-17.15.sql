@@ -0,0 +1,4 @@
+-- The type of notes uploaded as files in multipart forms, which they're served as.
+-- '' means plain text.
+
+alter table "note" add column content_type text not null default '';
@@ -0,0 +1,14 @@
+-- When notes' contents last changed, for PUT /api/note/:note?if-unmodified-since.
+-- Null until a note is first changed, since until then it's when the note was created.
+-- Written by a trigger, like the change log, so every way of changing a note sets it.
+
+alter table "note" add column modify_time datetime;
+
+update "note" set modify_time = (select max(change_time) from "change_log"
+    where "change_log".name = "note".name and action = 'update');
+
+create trigger note_modified after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    update "note" set modify_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = new.name;
+end;
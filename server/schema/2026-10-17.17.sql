@@ -0,0 +1,13 @@
+-- Notes created by POSTs with an X-Idempotency-Key, so that a retried POST gets the
+-- same response rather than 409. Keys older than -idempotency-retention are pruned.
+
+create table "idempotency_key" (
+    note          text not null references "note" (name) on delete cascade on update cascade,
+    key           text not null,
+    hash          text not null,
+    duplicate_of  text not null default '',
+    create_time   datetime not null,
+    primary key (note, key)
+);
+
+create index idempotency_key_time on "idempotency_key" (create_time);
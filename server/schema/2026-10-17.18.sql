@@ -0,0 +1,3 @@
+-- Finds a note's latest change, so a request for a deleted note can get 410 rather than 404.
+
+create index change_log_name on "change_log" (name, id);
@@ -0,0 +1,19 @@
+-- Frozen copies of notes' contents, served at /snap/:hash, for POST /api/note/:note/snapshot.
+-- Snapshots outlive their notes, so "note_snapshot" has no foreign key to "note";
+-- those whose notes are gone are only deleted by DELETE /api/admin/snapshots?orphaned=true.
+
+create table "snapshot" (
+    hash          text not null primary key,
+    body          blob not null,
+    content_type  text not null default '',
+    create_time   datetime not null
+);
+
+create table "note_snapshot" (
+    note         text not null,
+    hash         text not null references "snapshot" (hash) on delete cascade,
+    create_time  datetime not null,
+    primary key (note, hash)
+);
+
+create index note_snapshot_hash on "note_snapshot" (hash);
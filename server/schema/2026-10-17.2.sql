@@ -0,0 +1,32 @@
+-- Deduplicated note bodies
+-- With -dedupe, a note's body lives in "blob" and the note references it by hash.
+-- The triggers keep each blob's refcount up to date and delete blobs nothing references.
+
+create table "blob" (
+    hash      text not null primary key,
+    body      blob not null,
+    refcount  integer not null default 0
+);
+
+alter table "note" add column blob_hash text references "blob" (hash);
+
+create trigger note_blob_insert after insert on "note"
+when new.blob_hash is not null
+begin
+    update "blob" set refcount = refcount + 1 where hash = new.blob_hash;
+end;
+
+create trigger note_blob_update after update of blob_hash on "note"
+when old.blob_hash is not new.blob_hash
+begin
+    update "blob" set refcount = refcount + 1 where hash = new.blob_hash;
+    update "blob" set refcount = refcount - 1 where hash = old.blob_hash;
+    delete from "blob" where hash = old.blob_hash and refcount <= 0;
+end;
+
+create trigger note_blob_delete after delete on "note"
+when old.blob_hash is not null
+begin
+    update "blob" set refcount = refcount - 1 where hash = old.blob_hash;
+    delete from "blob" where hash = old.blob_hash and refcount <= 0;
+end;
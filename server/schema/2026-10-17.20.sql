@@ -0,0 +1,5 @@
+-- When notes are shown, for notes prepared ahead of time or taken down later without being deleted.
+-- Outside the window, a note is left out of listings and only admins can read it; null means no bound.
+
+alter table "note" add column publish_at datetime;
+alter table "note" add column hide_after datetime;
@@ -0,0 +1,8 @@
+-- Notes which were announced with -expiry-warning as about to expire, so they're only announced once.
+-- last_viewed is the note's when it was announced; if it's since been viewed, it may be announced again.
+
+create table "expiry_warning" (
+    note         text not null primary key references "note" (name) on delete cascade on update cascade,
+    last_viewed  datetime not null,
+    warn_time    datetime not null
+);
@@ -0,0 +1,10 @@
+-- The one-time tokens of web forms which have been submitted, and the note each created or commented on,
+-- so submitting a form again is answered with a link to that note rather than acting twice.
+
+create table "form_submission" (
+    token        text not null primary key,
+    note         text not null,
+    submit_time  datetime not null
+);
+
+create index form_submission_time on "form_submission" (submit_time);
@@ -0,0 +1,45 @@
+-- Callbacks registered with POST /api/note/:note/watch, to be told when that note is updated or deleted.
+-- Deliveries are queued by triggers in the same transaction as the change, so a deleted note's are queued
+-- before its watches are deleted along with it; they copy what they need from the watch for that reason.
+-- A watch is disabled, rather than deleted, once deliveries to it keep failing.
+
+create table "note_watch" (
+    id           integer primary key autoincrement,
+    note         text not null references "note" (name) on delete cascade on update cascade,
+    url          text not null,
+    secret       text not null default '',
+    created_by   text not null default '',
+    create_time  datetime not null,
+    disabled     boolean not null default 0
+);
+
+create index note_watch_note on "note_watch" (note);
+
+create table "watch_delivery" (
+    id            integer primary key autoincrement,
+    watch         integer not null,
+    note          text not null,
+    url           text not null,
+    secret        text not null,
+    action        text not null,
+    hash          text,
+    event_time    datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now')),
+    attempts      integer not null default 0,
+    next_attempt  datetime not null default (strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+);
+
+create index watch_delivery_watch on "watch_delivery" (watch, id);
+
+-- notes hashed for the first time at startup haven't changed
+create trigger note_watch_update after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    insert into "watch_delivery" (watch, note, url, secret, action, hash)
+        select id, note, url, secret, 'update', new.hash from "note_watch" where note = new.name and not disabled;
+end;
+
+create trigger note_watch_delete before delete on "note"
+begin
+    insert into "watch_delivery" (watch, note, url, secret, action)
+        select id, note, url, secret, 'delete' from "note_watch" where note = old.name and not disabled;
+end;
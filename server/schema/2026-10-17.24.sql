@@ -0,0 +1,29 @@
+-- Each note's size, and indexes covering everything listings read, so listing notes never reads their bodies.
+-- Columns added since the table was created are stored after the body, so reading them from the table
+-- means reading through every page of the body, which is slow for large notes.
+-- The size is written by triggers, like modify_time, so every way of changing a note keeps it up to date.
+
+alter table "note" add column size integer not null default 0;
+
+update "note" set size = length(coalesce((select body from "blob" where hash = "note".blob_hash), body));
+
+create trigger note_size_insert after insert on "note"
+begin
+    update "note" set size = length(coalesce((select body from "blob" where hash = new.blob_hash), new.body))
+        where name = new.name;
+end;
+
+create trigger note_size_update after update of body, blob_hash on "note"
+begin
+    update "note" set size = length(coalesce((select body from "blob" where hash = new.blob_hash), new.body))
+        where name = new.name;
+end;
+
+-- for listings by age and by name; they have the same columns, which are every one selectNoteInfo reads
+drop index note_create_time;
+
+create index note_listing_time on "note" (create_time, name, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time);
+
+create index note_listing_name on "note" (name, create_time, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time);
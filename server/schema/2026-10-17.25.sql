@@ -0,0 +1,13 @@
+-- The last check of each http and https link in each note, made in the background with -linkcheck-interval.
+-- A note's links are replaced whenever its links are checked, so links it no longer has are forgotten.
+
+create table "link_check" (
+    note        text not null references "note" (name) on delete cascade on update cascade,
+    url         text not null,
+    status      integer not null,
+    error       text not null default '',
+    check_time  datetime not null,
+    primary key (note, url)
+);
+
+create index link_check_time on "link_check" (check_time);
@@ -0,0 +1,43 @@
+-- When anything shown on a note's page last changed, for the Last-Modified of GET /note/:note:
+-- its contents, title, type, meta or visibility, or its comments or attachments.
+-- Viewing a note never changes it, so a page revalidated with If-Modified-Since stays 304 until the note is written to.
+-- Written by triggers, like modify_time, so every way of changing a note sets it; null until then,
+-- since until then it's when the note was created.
+
+alter table "note" add column page_time datetime;
+
+update "note" set page_time = (select max(time) from (
+    select "note".modify_time as time
+    union all select max(create_time) from "comment" where note = "note".name
+    union all select max(create_time) from "attachment" where note = "note".name));
+
+create trigger note_page_changed after update of hash, title, content_type, meta, unlisted, publish_at, hide_after on "note"
+when (old.hash is not null and old.hash is not new.hash) or old.title is not new.title
+    or old.content_type is not new.content_type or old.meta is not new.meta or old.unlisted is not new.unlisted
+    or old.publish_at is not new.publish_at or old.hide_after is not new.hide_after
+begin
+    update "note" set page_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = new.name;
+end;
+
+create trigger comment_page_insert after insert on "comment"
+begin
+    update "note" set page_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = new.note;
+end;
+
+create trigger comment_page_delete after delete on "comment"
+begin
+    update "note" set page_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = old.note;
+end;
+
+create trigger attachment_page_insert after insert on "attachment"
+begin
+    update "note" set page_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = new.note;
+end;
+
+create trigger attachment_page_delete after delete on "attachment"
+begin
+    update "note" set page_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') where name = old.note;
+end;
+
+-- like the listing indexes, so reading it doesn't read through the note's body
+create index note_page_time on "note" (name, page_time, create_time);
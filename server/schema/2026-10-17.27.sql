@@ -0,0 +1,16 @@
+-- How long each note is kept, in seconds, as decided by the retention hooks of a program embedding corkboard;
+-- see Hooks. 0 keeps the note forever, and null leaves it to -note-expiry and -retention.
+-- Written by corkboard after each change to a note, since hooks are go code sqlite can't call,
+-- so the sweep, listings and warnings all apply it in sql, like the -retention rules.
+
+alter table "note" add column retention_override integer;
+
+-- read by selectNoteInfo, so the listing indexes have to cover it too
+drop index note_listing_time;
+drop index note_listing_name;
+
+create index note_listing_time on "note" (create_time, name, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override);
+
+create index note_listing_name on "note" (name, create_time, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override);
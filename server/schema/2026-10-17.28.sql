@@ -0,0 +1,45 @@
+-- Change times are written by corkboard rather than sqlite: the triggers stamp them with corkboard_now(),
+-- which corkboard registers on its connection to answer with its own clock, so they agree with every other
+-- time it writes. The table is rebuilt without its default, so a row can't be stamped any other way.
+
+drop trigger note_change_insert;
+drop trigger note_change_update;
+drop trigger note_change_delete;
+
+create table "change_log_new" (
+    id           integer primary key autoincrement,
+    name         text not null,
+    action       text not null,
+    hash         text,
+    change_time  datetime not null
+);
+
+insert into "change_log_new" (id, name, action, hash, change_time)
+    select id, name, action, hash, change_time from "change_log";
+
+-- ids carry on from the last one handed out, even if the changes after it have been trimmed
+delete from sqlite_sequence where name = 'change_log_new';
+insert into sqlite_sequence (name, seq) select 'change_log_new', seq from sqlite_sequence where name = 'change_log';
+
+drop table "change_log";
+alter table "change_log_new" rename to "change_log";
+
+create index change_log_time on "change_log" (change_time);
+create index change_log_name on "change_log" (name, id);
+
+create trigger note_change_insert after insert on "note"
+begin
+    insert into "change_log" (name, action, hash, change_time) values (new.name, 'create', new.hash, corkboard_now());
+end;
+
+-- notes hashed for the first time at startup haven't changed
+create trigger note_change_update after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    insert into "change_log" (name, action, hash, change_time) values (new.name, 'update', new.hash, corkboard_now());
+end;
+
+create trigger note_change_delete after delete on "note"
+begin
+    insert into "change_log" (name, action, change_time) values (old.name, 'delete', corkboard_now());
+end;
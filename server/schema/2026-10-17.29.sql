@@ -0,0 +1,10 @@
+-- Notes' modify_time is stamped with corkboard_now(), corkboard's clock, like the change log's times,
+-- rather than sqlite's.
+
+drop trigger note_modified;
+
+create trigger note_modified after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    update "note" set modify_time = corkboard_now() where name = new.name;
+end;
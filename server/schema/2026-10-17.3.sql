@@ -0,0 +1,10 @@
+-- Files attached to notes
+
+create table "attachment" (
+    note          text not null references "note" (name) on delete cascade on update cascade,
+    name          text not null,
+    content_type  text not null,
+    body          blob not null,
+    create_time   datetime default current_timestamp,
+    primary key (note, name)
+);
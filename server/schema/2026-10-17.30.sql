@@ -0,0 +1,43 @@
+-- Watch deliveries are stamped with corkboard_now(), corkboard's clock, like the change log's times,
+-- so a delivery's first attempt is due by the same clock its retries are scheduled with.
+-- The table is rebuilt without its defaults, so a delivery can't be stamped any other way.
+
+drop trigger note_watch_update;
+drop trigger note_watch_delete;
+
+create table "watch_delivery_new" (
+    id            integer primary key autoincrement,
+    watch         integer not null,
+    note          text not null,
+    url           text not null,
+    secret        text not null,
+    action        text not null,
+    hash          text,
+    event_time    datetime not null,
+    attempts      integer not null default 0,
+    next_attempt  datetime not null
+);
+
+insert into "watch_delivery_new" (id, watch, note, url, secret, action, hash, event_time, attempts, next_attempt)
+    select id, watch, note, url, secret, action, hash, event_time, attempts, next_attempt from "watch_delivery";
+
+drop table "watch_delivery";
+alter table "watch_delivery_new" rename to "watch_delivery";
+
+create index watch_delivery_watch on "watch_delivery" (watch, id);
+
+-- notes hashed for the first time at startup haven't changed
+create trigger note_watch_update after update of hash on "note"
+when old.hash is not null and old.hash is not new.hash
+begin
+    insert into "watch_delivery" (watch, note, url, secret, action, hash, event_time, next_attempt)
+        select id, note, url, secret, 'update', new.hash, corkboard_now(), corkboard_now()
+        from "note_watch" where note = new.name and not disabled;
+end;
+
+create trigger note_watch_delete before delete on "note"
+begin
+    insert into "watch_delivery" (watch, note, url, secret, action, event_time, next_attempt)
+        select id, note, url, secret, 'delete', corkboard_now(), corkboard_now()
+        from "note_watch" where note = old.name and not disabled;
+end;
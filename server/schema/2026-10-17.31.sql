@@ -0,0 +1,36 @@
+-- Notes' page_time is stamped with corkboard_now(), corkboard's clock, like modify_time,
+-- so the Last-Modified of a note's page agrees with the times shown on it.
+
+drop trigger note_page_changed;
+drop trigger comment_page_insert;
+drop trigger comment_page_delete;
+drop trigger attachment_page_insert;
+drop trigger attachment_page_delete;
+
+create trigger note_page_changed after update of hash, title, content_type, meta, unlisted, publish_at, hide_after on "note"
+when (old.hash is not null and old.hash is not new.hash) or old.title is not new.title
+    or old.content_type is not new.content_type or old.meta is not new.meta or old.unlisted is not new.unlisted
+    or old.publish_at is not new.publish_at or old.hide_after is not new.hide_after
+begin
+    update "note" set page_time = corkboard_now() where name = new.name;
+end;
+
+create trigger comment_page_insert after insert on "comment"
+begin
+    update "note" set page_time = corkboard_now() where name = new.note;
+end;
+
+create trigger comment_page_delete after delete on "comment"
+begin
+    update "note" set page_time = corkboard_now() where name = old.note;
+end;
+
+create trigger attachment_page_insert after insert on "attachment"
+begin
+    update "note" set page_time = corkboard_now() where name = new.note;
+end;
+
+create trigger attachment_page_delete after delete on "attachment"
+begin
+    update "note" set page_time = corkboard_now() where name = old.note;
+end;
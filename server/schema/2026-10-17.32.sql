@@ -0,0 +1,10 @@
+-- Notes' hashes are part of their metadata, so selectNoteInfo reads them, and the listing indexes have to cover them too.
+
+drop index note_listing_time;
+drop index note_listing_name;
+
+create index note_listing_time on "note" (create_time, name, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override, hash);
+
+create index note_listing_name on "note" (name, create_time, unlisted, publish_at, hide_after,
+    last_viewed, size, title, content_type, meta, modify_time, retention_override, hash);
@@ -0,0 +1,3 @@
+-- Unlisted notes are reachable by name but left out of listings
+
+alter table "note" add column unlisted boolean not null default 0;
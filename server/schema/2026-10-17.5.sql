@@ -0,0 +1,9 @@
+-- Advisory locks which stop people editing a note at the same time
+-- A lock is ignored, and eventually deleted, once it has expired.
+
+create table "note_lock" (
+    note      text not null primary key references "note" (name) on delete cascade on update cascade,
+    holder    text not null,
+    acquired  datetime default current_timestamp,
+    expires   datetime not null
+);
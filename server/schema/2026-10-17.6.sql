@@ -0,0 +1,11 @@
+-- Comments left on notes
+
+create table "comment" (
+    id           integer primary key autoincrement,
+    note         text not null references "note" (name) on delete cascade on update cascade,
+    author       text not null,
+    body         text not null,
+    create_time  datetime default current_timestamp
+);
+
+create index comment_note on "comment" (note, id);
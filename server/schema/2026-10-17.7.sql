@@ -0,0 +1,18 @@
+-- Times are now written by corkboard as RFC 3339 strings in UTC, like 2026-10-17T09:30:00Z,
+-- rather than by sqlite. Convert the times written before, so they still compare correctly.
+
+update "note" set
+    create_time = strftime('%Y-%m-%dT%H:%M:%SZ', create_time),
+    last_viewed = strftime('%Y-%m-%dT%H:%M:%SZ', last_viewed);
+
+update "replication" set
+    next_attempt = strftime('%Y-%m-%dT%H:%M:%SZ', next_attempt),
+    create_time = strftime('%Y-%m-%dT%H:%M:%SZ', create_time);
+
+update "attachment" set create_time = strftime('%Y-%m-%dT%H:%M:%SZ', create_time);
+
+update "note_lock" set
+    acquired = strftime('%Y-%m-%dT%H:%M:%SZ', acquired),
+    expires = strftime('%Y-%m-%dT%H:%M:%SZ', expires);
+
+update "comment" set create_time = strftime('%Y-%m-%dT%H:%M:%SZ', create_time);
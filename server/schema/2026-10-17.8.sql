@@ -0,0 +1,5 @@
+-- Index the times used to list recent notes and to expire old ones,
+-- so neither has to scan the whole table on a large board
+
+create index note_create_time on "note" (create_time);
+create index note_last_viewed on "note" (last_viewed);
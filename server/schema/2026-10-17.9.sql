@@ -0,0 +1,3 @@
+-- Index note hashes, to find notes with the same body as a new one
+
+create index note_hash on "note" (hash);
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"strings"
+)
+
+// SchemaConfig decides what happens at startup when the database's schema isn't the expected one
+type SchemaConfig struct {
+	// apply pending migrations, rather than refusing to start
+	AutoMigrate bool
+	// start even if the database has migrations this binary doesn't know about
+	AllowNewer bool
+	// don't compare the schema at all
+	SkipCheck bool
+}
+
+// makes sure the database's schema is the one this binary expects before it's used,
+// so that a mismatch fails clearly at startup rather than with sql errors on some later request
+// a new database is always created
+func checkSchema(datastore Datastore, migrations fs.FS, config SchemaConfig) error {
+	if config.SkipCheck {
+		return nil
+	}
+	status, err := datastore.schemaStatus(migrations)
+	if err != nil {
+		return fmt.Errorf("error checking schema: %s", err)
+	}
+	if len(status.Unknown) > 0 {
+		if !config.AllowNewer {
+			return fmt.Errorf("the database has migrations this corkboard doesn't know about (%s), "+
+				"so a newer corkboard has probably used it. Upgrade corkboard, or to start anyway, use -allow-newer-schema.",
+				joinMigrations(status.Unknown))
+		}
+		log.Printf("WARNING: the database has migrations this corkboard doesn't know about (%s). "+
+			"Starting anyway because of -allow-newer-schema, but requests may fail.", joinMigrations(status.Unknown))
+	}
+	if len(status.Pending) == 0 {
+		return nil
+	}
+	if !status.New && !config.AutoMigrate {
+		return fmt.Errorf("the database's schema is out of date: migrations %s haven't been applied. "+
+			"Back up the database and run \"corkboard migrate\", or start with -auto-migrate.",
+			joinMigrations(status.Pending))
+	}
+	err = datastore.RunMigrations(migrations)
+	if err != nil {
+		return fmt.Errorf("error running schema: %s", err)
+	}
+	return nil
+}
+
+func joinMigrations(migrations []migration) string {
+	names := []string{}
+	for _, m := range migrations {
+		names = append(names, m.String())
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// policy for pages corkboard renders itself: only its own scripts, styles and images,
+// so that even if a note's contents were ever interpreted as html, nothing they load would run
+const pageSecurityPolicy = "default-src 'self'; object-src 'none'; base-uri 'none'; frame-ancestors 'none'; form-action 'self'"
+
+// policy for notes and attachments served as they were uploaded
+// if a browser does render one, it's sandboxed, with no scripts and no access to this origin
+const contentSecurityPolicy = "sandbox; default-src 'none'"
+
+// the one place which decides what pasted content can do in a browser
+// note bodies and names only reach pages through html/template, which escapes them;
+// nothing may mark user content as template.HTML, since nothing sanitizes it
+// what html/template can't judge goes through the policy below first: links through safeURL,
+// names used as classes or attributes through safeToken, and previews of notes through safePreview
+// every response is also kept from being sniffed as another type and held to pageSecurityPolicy
+func protectContent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Header().Set("X-Content-Type-Options", "nosniff")
+		resp.Header().Set("Content-Security-Policy", pageSecurityPolicy)
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// marks a response as carrying user content verbatim, like a raw note or an attachment
+// the content isn't changed, but a browser opening it directly gets no scripts or origin
+func sandboxContent(resp http.ResponseWriter) {
+	resp.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+}
+
+// schemes a link from user content may be rendered with; anything else, like javascript: or data:, isn't a link
+var safeSchemes = map[string]bool{"http": true, "https": true, "mailto": true}
+
+// the url a link from user content may be rendered with, and whether it may be at all:
+// it must have a scheme in safeSchemes, or be a path with none
+// browsers skip some characters in urls which url.Parse doesn't, like tabs in "java\tscript:",
+// so a url with control characters or spaces is refused rather than guessed at
+func safeURL(raw string) (string, bool) {
+	if raw == "" || strings.IndexFunc(raw, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0 {
+		return "", false
+	}
+	target, err := url.Parse(raw)
+	if err != nil || target.Opaque != "" && target.Scheme != "mailto" {
+		return "", false
+	}
+	if target.Scheme != "" && !safeSchemes[strings.ToLower(target.Scheme)] {
+		return "", false
+	}
+	if target.Scheme == "" {
+		// a path whose first part has a colon, like "jav&#x61;script:", is a scheme to anything which decodes it
+		first := raw
+		if end := strings.IndexAny(raw, "/?"); end >= 0 {
+			first = raw[:end]
+		}
+		if strings.Contains(first, ":") {
+			return "", false
+		}
+	}
+	return target.String(), true
+}
+
+// longest token safeToken keeps, in bytes
+const maxTokenSize = 64
+
+// a name from user content which a page uses as a class or an attribute's value, like a note's language,
+// with everything but letters, digits and "-_+#." taken out, so it's one token which can't say anything else
+func safeToken(name string) string {
+	token := strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_+#.", r)) {
+			return r
+		}
+		return -1
+	}, name)
+	if len(token) > maxTokenSize {
+		token = token[:maxTokenSize]
+	}
+	return token
+}
+
+// longest preview safePreview makes, in characters
+const maxPreviewLength = 200
+
+// a note's text shortened to a preview, like the description a link to it unfurls with:
+// one line, without control characters, ending with "…" if it was cut short
+// the preview is still text, and is escaped wherever it's shown
+func safePreview(text string) string {
+	preview := strings.Join(strings.FieldsFunc(text, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }), " ")
+	if utf8.RuneCountInString(preview) <= maxPreviewLength {
+		return preview
+	}
+	return string([]rune(preview)[:maxPreviewLength-1]) + "…"
+}
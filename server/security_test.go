@@ -0,0 +1,68 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSafeURL(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"https://example.com/a?b=c#d", "https://example.com/a?b=c#d", true},
+		{"HTTP://example.com", "http://example.com", true},
+		{"mailto:alice@example.com", "mailto:alice@example.com", true},
+		{"/note/todo", "/note/todo", true},
+		{"todo#L3", "todo#L3", true},
+		{"javascript:alert(1)", "", false},
+		{"JaVaScRiPt:alert(1)", "", false},
+		{"java\tscript:alert(1)", "", false},
+		{" javascript:alert(1)", "", false},
+		{"\x01javascript:alert(1)", "", false},
+		{"jav&#x61;script:alert(1)", "", false},
+		{"data:text/html,<script>alert(1)</script>", "", false},
+		{"data:image/svg+xml;base64,PHN2Zz4=", "", false},
+		{"vbscript:msgbox(1)", "", false},
+		{"http:alert(1)", "", false},
+		{"", "", false},
+	}
+	for _, c := range cases {
+		got, ok := safeURL(c.raw)
+		if got != c.want || ok != c.ok {
+			t.Errorf("safeURL(%q) = %q, %v; want %q, %v", c.raw, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestSafeToken(t *testing.T) {
+	cases := map[string]string{
+		"python":                            "python",
+		"c++":                               "c++",
+		"objective-c":                       "objective-c",
+		`go" onmouseover="alert(1)`:         "goonmouseoveralert1",
+		"x><script>alert(1)</script>":       "xscriptalert1script",
+		"café":                              "caf",
+		strings.Repeat("a", 2*maxTokenSize): strings.Repeat("a", maxTokenSize),
+	}
+	for name, want := range cases {
+		if got := safeToken(name); got != want {
+			t.Errorf("safeToken(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSafePreview(t *testing.T) {
+	cases := map[string]string{
+		"hello":                      "hello",
+		"  line one\n\tline two\r\n": "line one line two",
+		"bell\x07ed":                 "bell ed",
+		strings.Repeat("é", 300):     strings.Repeat("é", maxPreviewLength-1) + "…",
+	}
+	for text, want := range cases {
+		if got := safePreview(text); got != want {
+			t.Errorf("safePreview(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
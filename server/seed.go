@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// if notes never expire, seeded notes are spread over this long instead
+const defaultSeedWindow = 7 * 24 * time.Hour
+
+// an inclusive range of note sizes, parsed from a flag like "100:10000"
+type sizeRange struct {
+	min, max int
+}
+
+func (r *sizeRange) String() string {
+	return fmt.Sprintf("%d:%d", r.min, r.max)
+}
+
+func (r *sizeRange) Set(value string) error {
+	bounds := strings.SplitN(value, ":", 2)
+	if len(bounds) != 2 {
+		return fmt.Errorf("must be in the form min:max")
+	}
+	min, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return fmt.Errorf("bad minimum %q", bounds[0])
+	}
+	max, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return fmt.Errorf("bad maximum %q", bounds[1])
+	}
+	if min < 1 || max < min {
+		return fmt.Errorf("must have 1 <= min <= max")
+	}
+	r.min, r.max = min, max
+	return nil
+}
+
+// corkboard seed [flags]
+// fills the database with pseudo-random notes, for trying out the ui or benchmarking
+// notes are dated across the expiry window, so they look like a board which has been in use
+func seedNotes(datastore Datastore, expiry time.Duration, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	count := flags.Int("n", 100, "Create this many notes.")
+	sizes := sizeRange{100, 10000}
+	flags.Var(&sizes, "size-range", "Make each note between min and max bytes long, in the form min:max.")
+	prefix := flags.String("prefix", "demo-", "Begin the name of every note with this, so they can be removed with \"corkboard wipe\".")
+	seed := flags.Int64("seed", 0, "Seed for the random generator. The same seed creates the same notes.\nIf set to zero, one is picked and printed.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] seed [seed flags]\nCreates notes full of random words.\nSeed flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		flags.Usage()
+		return fmt.Errorf("unexpected argument %q", positional[0])
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+		fmt.Printf("using -seed %d\n", *seed)
+	}
+	bytes, err := seedWithOptions(datastore, SeedOptions{
+		Count:   *count,
+		MinSize: sizes.min,
+		MaxSize: sizes.max,
+		Prefix:  *prefix,
+		Seed:    *seed,
+		Window:  expiry,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created %d notes totalling %d bytes\n", *count, bytes)
+	return nil
+}
+
+// SeedOptions describes the notes App.Seed creates, as the flags of "corkboard seed" do
+type SeedOptions struct {
+	// how many notes to create
+	Count int
+	// the range of each note's size in bytes, inclusive
+	MinSize, MaxSize int
+	// the beginning of every note's name, so they can be removed with App.Wipe
+	Prefix string
+	// the same seed creates the same notes
+	Seed int64
+	// how long before now the notes were created; if zero, defaultSeedWindow
+	Window time.Duration
+}
+
+// Seed creates notes full of random words, like "corkboard seed",
+// and returns how many bytes they total
+func (app *App) Seed(options SeedOptions) (int, error) {
+	return seedWithOptions(app.datastore, options)
+}
+
+// Wipe removes every note whose name begins with prefix, like "corkboard wipe",
+// and returns how many there were
+func (app *App) Wipe(prefix string) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("the prefix can't be empty")
+	}
+	removed, err := app.datastore.deleteNotesWithPrefix(prefix)
+	return len(removed), err
+}
+
+func seedWithOptions(datastore Datastore, options SeedOptions) (int, error) {
+	if options.Count < 1 {
+		return 0, fmt.Errorf("-n must be positive")
+	}
+	if options.MinSize < 1 || options.MaxSize < options.MinSize {
+		return 0, fmt.Errorf("-size-range must have 1 <= min <= max")
+	}
+	if options.Prefix == "" {
+		return 0, fmt.Errorf("-prefix can't be empty, or the notes couldn't be told apart from real ones")
+	}
+	if err := validateNotePrefix(options.Prefix); err != nil {
+		return 0, fmt.Errorf("-prefix: %v", err)
+	}
+	window := options.Window
+	if window == 0 {
+		window = defaultSeedWindow
+	}
+	sizes := sizeRange{options.MinSize, options.MaxSize}
+	return seedRandomNotes(datastore, rand.New(rand.NewSource(options.Seed)), options.Count, sizes, options.Prefix, window)
+}
+
+// most seeded notes created in one transaction
+const seedBatchSize = 1000
+
+// creates count notes of random words, each with a size in sizes and a name beginning with prefix,
+// created and viewed at random times over the window before now, and returns how many bytes they total
+// the same random source creates the same notes
+func seedRandomNotes(datastore Datastore, random *rand.Rand, count int, sizes sizeRange, prefix string, window time.Duration) (int, error) {
+	// the same wordlist as generated names, for names and bodies
+	names, err := NewNameGenerator(NAME_STYLE_WORDS, random)
+	if err != nil {
+		return 0, err
+	}
+	now := datastore.now()
+	var created, bytes int
+	for created < count {
+		// in batches, since a transaction for each note is slow, and one for all of them would keep others from writing
+		err := datastore.withTx(context.Background(), func(tx Datastore) error {
+			for batch := 0; batch < seedBatchSize && created < count; batch++ {
+				body := seedBody(random, names, sizes.min+random.Intn(sizes.max-sizes.min+1))
+				createTime := now.Add(-time.Duration(random.Int63n(int64(window))))
+				viewTime := createTime.Add(time.Duration(random.Int63n(int64(now.Sub(createTime)) + 1)))
+				status, err := createSeedNote(tx, names, prefix, body, createTime, viewTime)
+				if err != nil {
+					return err
+				}
+				if status == CREATED {
+					created += 1
+					bytes += len(body)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return bytes, err
+		}
+	}
+	return bytes, nil
+}
+
+// creates a note with a generated name beginning with prefix, retrying with longer names on collisions
+func createSeedNote(datastore Datastore, names NameGenerator, prefix string, body []byte, created time.Time, viewed time.Time) (int, error) {
+	for attempt := 0; attempt < maxNameAttempts; attempt++ {
+		name, err := names.generate(attempt)
+		if err != nil {
+			return NO_CLOBBER, err
+		}
+		status, err := datastore.setNoteWithTimes(prefix+name, body, false, NoteOptions{}, created, viewed, created)
+		if err != nil || status == CREATED {
+			return status, err
+		}
+	}
+	return NO_CLOBBER, fmt.Errorf("unable to find an unused note name after %d attempts", maxNameAttempts)
+}
+
+// makes a body of exactly size bytes, from lines of random words
+func seedBody(random *rand.Rand, names NameGenerator, size int) []byte {
+	var body strings.Builder
+	for body.Len() < size {
+		words := 3 + random.Intn(10)
+		for i := 0; i < words; i++ {
+			if i > 0 {
+				body.WriteByte(' ')
+			}
+			if random.Intn(2) == 0 {
+				body.WriteString(names.adjectives[random.Intn(len(names.adjectives))])
+			} else {
+				body.WriteString(names.nouns[random.Intn(len(names.nouns))])
+			}
+		}
+		body.WriteByte('\n')
+	}
+	return []byte(body.String()[:size])
+}
+
+// corkboard wipe -prefix prefix -confirm prefix
+// removes notes made by seed, or any other notes sharing a prefix
+func wipeNotes(datastore Datastore, args []string) error {
+	flags := flag.NewFlagSet("wipe", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "Remove every note whose name begins with this.")
+	confirm := flags.String("confirm", "", "Must repeat -prefix exactly, to prevent accidents.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: corkboard [flags] wipe -prefix prefix -confirm prefix\nRemoves every note whose name begins with prefix.\nWipe flags:")
+		flags.PrintDefaults()
+	}
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 || *prefix == "" {
+		flags.Usage()
+		return fmt.Errorf("-prefix is required")
+	}
+	if *confirm != *prefix {
+		return fmt.Errorf("-confirm must repeat -prefix exactly")
+	}
+	removed, err := datastore.deleteNotesWithPrefix(*prefix)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("removed %d notes\n", len(removed))
+	return nil
+}
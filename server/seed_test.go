@@ -0,0 +1,112 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FBemf/corkboard/server"
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// a seeded note, as the api lists it, along with its contents
+type seededNote struct {
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`
+	CreateTime time.Time `json:"create_time"`
+	Body       string
+}
+
+// lists the notes beginning with prefix, with their contents
+func seededNotes(t *testing.T, s *servertest.Server, prefix string) []seededNote {
+	t.Helper()
+	status, body := s.Do(t, http.MethodGet, "/api/notes?prefix="+prefix, "")
+	if status != http.StatusOK {
+		t.Fatalf("listing notes: got %d %q", status, body)
+	}
+	var notes []seededNote
+	if err := json.Unmarshal([]byte(body), &notes); err != nil {
+		t.Fatal(err)
+	}
+	for i := range notes {
+		status, notes[i].Body = s.Do(t, http.MethodGet, "/api/note/"+notes[i].Name, "")
+		if status != http.StatusOK {
+			t.Fatalf("getting %s: got %d", notes[i].Name, status)
+		}
+	}
+	return notes
+}
+
+func TestSeed(t *testing.T) {
+	options := server.SeedOptions{Count: 50, MinSize: 10, MaxSize: 200, Prefix: "demo-", Seed: 1, Window: time.Hour}
+	first := servertest.New(t, servertest.Config(t))
+	first.Seed(t, options)
+	notes := seededNotes(t, first, "demo-")
+	if len(notes) != options.Count {
+		t.Fatalf("seeded %d notes, want %d", len(notes), options.Count)
+	}
+	for _, note := range notes {
+		if len(note.Body) < options.MinSize || len(note.Body) > options.MaxSize || note.Size != len(note.Body) {
+			t.Errorf("%s is %d bytes, listed as %d, want between %d and %d", note.Name, len(note.Body), note.Size, options.MinSize, options.MaxSize)
+		}
+		if age := time.Since(note.CreateTime); age < 0 || age > options.Window+time.Minute {
+			t.Errorf("%s was created %s ago, want within the window", note.Name, age)
+		}
+	}
+
+	// the same seed makes the same notes, on another database
+	second := servertest.New(t, servertest.Config(t))
+	second.Seed(t, options)
+	again := seededNotes(t, second, "demo-")
+	for i := range notes {
+		if i >= len(again) || again[i].Name != notes[i].Name || again[i].Body != notes[i].Body {
+			t.Fatalf("the same seed made different notes, starting with %s", notes[i].Name)
+		}
+	}
+	options.Seed = 2
+	third := servertest.New(t, servertest.Config(t))
+	third.Seed(t, options)
+	if other := seededNotes(t, third, "demo-"); other[0].Name == notes[0].Name && other[0].Body == notes[0].Body {
+		t.Error("another seed made the same notes")
+	}
+}
+
+// wipe removes the seeded notes, and only them
+func TestWipe(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/todo", "real"); status != http.StatusCreated {
+		t.Fatalf("creating todo: got %d %q", status, body)
+	}
+	s.Seed(t, server.SeedOptions{Count: 20, MinSize: 10, MaxSize: 20, Prefix: "demo-", Seed: 1})
+	removed, err := s.App.Wipe("demo-")
+	if err != nil || removed != 20 {
+		t.Fatalf("wiped %d notes, %v, want 20", removed, err)
+	}
+	if notes := seededNotes(t, s, "demo-"); len(notes) != 0 {
+		t.Errorf("%d seeded notes are left", len(notes))
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/todo", ""); status != http.StatusOK || body != "real" {
+		t.Errorf("todo: got %d %q after wiping, want it kept", status, body)
+	}
+	if _, err := s.App.Wipe(""); err == nil {
+		t.Error("wiped with an empty prefix")
+	}
+}
+
+// the options are checked like the seed command's flags
+func TestSeedOptions(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	cases := []server.SeedOptions{
+		{Count: 0, MinSize: 1, MaxSize: 1, Prefix: "demo-"},
+		{Count: 1, MinSize: 0, MaxSize: 1, Prefix: "demo-"},
+		{Count: 1, MinSize: 2, MaxSize: 1, Prefix: "demo-"},
+		{Count: 1, MinSize: 1, MaxSize: 1, Prefix: ""},
+		{Count: 1, MinSize: 1, MaxSize: 1, Prefix: "_demo-"},
+	}
+	for _, options := range cases {
+		if _, err := s.App.Seed(options); err == nil {
+			t.Errorf("seeded with %+v", options)
+		}
+	}
+}
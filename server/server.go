@@ -0,0 +1,985 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const corkboardVersion = "v0.1.0"
+
+//go:embed templates
+var templateFS embed.FS
+
+//go:embed static
+var staticFS embed.FS
+
+//go:embed schema
+var schemaFS embed.FS
+
+//go:embed wordlist
+var wordlistFS embed.FS
+
+// environment variable holding credentials, in the same form as -creds
+const credentialsEnvVar = "CORKBOARD_CREDS"
+
+// how long to wait for requests to finish when shutting down
+const shutdownTimeout = 10 * time.Second
+
+// delete expired notes every hour
+const cleanupInterval = time.Hour
+
+// Config is how a corkboard is set up, as its flags say
+// ParseFlags builds one from the command line; a program embedding corkboard can start from what it gives
+// for no args, change the fields it needs, and pass it to NewApp
+// fields like ACL, Boards and Schedule are parsed from files, and are simplest to set with their flags
+type Config struct {
+	DatabasePath        string
+	CreateDB            bool
+	DBWait              time.Duration
+	Credentials         map[string]bool
+	Port                int
+	BaseURL             string
+	NoteExpiryTime      time.Duration
+	Retention           RetentionRules
+	UnviewedExpiryTime  time.Duration
+	ExpiryPolicy        string
+	ExpiryWarning       time.Duration
+	ChangeLogAge        time.Duration
+	NumRecentNotes      int
+	TCPPaste            TCPPasteConfig
+	MirrorURL           string
+	MirrorCredentials   string
+	Dedupe              bool
+	Logging             LogConfig
+	Notify              NotifyConfig
+	ScheduleFile        string
+	Boards              []BoardConfig
+	PasteUnlisted       bool
+	DisableComments     bool
+	ArchiveDir          string
+	VacuumInterval      time.Duration
+	VacuumWindow        VacuumWindow
+	StatsWindow         time.Duration
+	SkipIntegrityCheck  bool
+	Schema              SchemaConfig
+	MaxConcurrentWrites int
+	RateLimits          RateLimitConfig
+	WritePolicy         WritePolicy
+	StrictIndex         bool
+	HTMLMaxSize         int
+	ReplicaDir          string
+	ReplicaInterval     time.Duration
+	ReplicaKeep         int
+	Names               NameGenerator
+	Banner              string
+	FeedTitle           string
+	ReadOnly            bool
+	Admins              map[string]bool
+	ACL                 *ACL
+	PrivateNotes        bool
+	Analytics           bool
+	DiagDir             string
+	AnalyticsRetention  time.Duration
+	CustomCSS           string
+	CustomJS            string
+	TemplatesDir        string
+	AllowInternalFetch  bool
+	Dev                 bool
+	Schedule            []ScheduleEntry
+	LinkCheck           LinkCheckConfig
+	Digest              DigestConfig
+	// the policies of a program embedding corkboard; nil if there are none
+	Hooks *Hooks
+
+	// set by ParseFlags for the command line itself
+	command           string
+	commandArgs       []string
+	printVersion      bool
+	validateTemplates bool
+	service           string
+	// settings given as flags, which can't be changed at runtime
+	pinnedSettings map[string]bool
+	// shared by every board; nil with -stats-window 0
+	requestStats *RequestStats
+}
+
+// Main runs corkboard's command line with args, which are parsed into flags: a client command like "push",
+// a command like "migrate" run on the database, or otherwise the server, until SIGINT or SIGTERM
+// the main package is only this; programs embedding corkboard use NewApp instead
+func Main(flags *flag.FlagSet, args []string) error {
+	// client commands have their own flags, and don't need a database
+	if len(args) > 0 {
+		if command, ok := clientCommands[args[0]]; ok {
+			os.Exit(command(args[1:]))
+		}
+	}
+
+	// this has to come before the flags are parsed, since it changes the directory relative paths are from
+	asService, err := startedAsService()
+	if err != nil {
+		return fmt.Errorf("error checking whether running as a service: %s", err)
+	}
+
+	config, err := ParseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if config.service != "" {
+		err = serviceCommand(config.service, withoutFlag(flags, args, "service"))
+		if err != nil {
+			return fmt.Errorf("error with -service %s: %s", config.service, err)
+		}
+		return nil
+	}
+
+	if config.printVersion {
+		fmt.Printf("corkboard %s\n", corkboardVersion)
+		return nil
+	}
+
+	if config.validateTemplates {
+		_, err := loadTemplates(config.TemplatesDir, true)
+		if err == nil {
+			_, err = loadDigestTemplate(config.TemplatesDir, true)
+		}
+		if err != nil {
+			return fmt.Errorf("error loading templates: %s", err)
+		}
+		fmt.Println("templates are valid")
+		return nil
+	}
+
+	if config.command == "doctor" {
+		return runDoctor(config)
+	}
+
+	// services have no console to log to
+	if asService && config.Logging.File == "" {
+		config.Logging.Syslog = true
+	}
+	setupLogging(config.Logging)
+
+	if config.command == "gen-name" {
+		name, err := config.Names.generate(0)
+		if err != nil {
+			return fmt.Errorf("error generating name: %s", err)
+		}
+		fmt.Println(name)
+		return nil
+	}
+
+	if config.command == "restore" {
+		err = restoreReplica(config.DatabasePath, config.commandArgs)
+		if err == flag.ErrHelp {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error restoring database: %s", err)
+		}
+		return nil
+	}
+
+	if config.command == "check" || config.command == "migrate" {
+		return runDatabaseCommand(config)
+	}
+
+	app, err := NewApp(config)
+	if err != nil {
+		return err
+	}
+	defer app.Close()
+
+	if config.command != "" {
+		err = runCommand(app)
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	notifyDiagnostics(func() {
+		app.diagnostics.dump(config.DiagDir)
+	})
+
+	if asService {
+		// the service manager says when to shut down, rather than signals
+		return runService(app.Run)
+	}
+	// shut down gracefully on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return app.Run(ctx)
+}
+
+// args without the flag called name, and its value, where flags are those args were parsed with
+func withoutFlag(flags *flag.FlagSet, args []string, name string) []string {
+	result := []string{}
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			// flags end at the first argument which isn't one
+			return append(result, args[i:]...)
+		}
+		flagName := strings.TrimLeft(args[i], "-")
+		hasValue := strings.Contains(flagName, "=")
+		if hasValue {
+			flagName = flagName[:strings.Index(flagName, "=")]
+		}
+		if flagName != name {
+			result = append(result, args[i])
+		}
+		// the flag's value may be the next argument, unless it's a boolean
+		if f := flags.Lookup(flagName); !hasValue && f != nil {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+				i++
+				if flagName != name && i < len(args) {
+					result = append(result, args[i])
+				}
+			}
+		}
+	}
+	return result
+}
+
+// checks the database for corruption, quickly, unless config says not to
+func checkIntegrity(maintenance *Maintenance, config Config) error {
+	if config.SkipIntegrityCheck {
+		return nil
+	}
+	// the quick check skips checking indexes, so it stays fast on large databases
+	result, err := maintenance.check(false)
+	if err != nil {
+		return fmt.Errorf("error checking database: %s", err)
+	}
+	if !result.OK {
+		for _, problem := range result.Problems {
+			log.Printf("integrity check: %s", problem)
+		}
+		return fmt.Errorf("database %s is corrupted. Restore it from a backup, or try recovering it with "+
+			"\"sqlite3 %s .recover\". To start anyway, use -skip-integrity-check.",
+			config.DatabasePath, config.DatabasePath)
+	}
+	return nil
+}
+
+// runs the commands for databases an App can't open: check, for one which may be corrupted,
+// and migrate, for one whose schema is out of date
+func runDatabaseCommand(config Config) error {
+	migrations, err := fs.Sub(schemaFS, "schema")
+	if err != nil {
+		return err
+	}
+	// migrating a database which doesn't exist yet creates it, as it would be at startup
+	config.CreateDB = config.CreateDB || config.command == "migrate"
+	datastore, err := openDatastore(config)
+	if err != nil {
+		return fmt.Errorf("error opening db %s: %s", config.DatabasePath, err)
+	}
+	defer datastore.Close()
+	maintenance := NewMaintenance(datastore)
+
+	if config.command == "check" {
+		result, err := maintenance.check(true)
+		if err != nil {
+			return fmt.Errorf("error checking database: %s", err)
+		}
+		for _, problem := range result.Problems {
+			fmt.Println(problem)
+		}
+		if !result.OK {
+			return fmt.Errorf("database %s is corrupted", config.DatabasePath)
+		}
+		log.Printf("database %s is ok", config.DatabasePath)
+		return nil
+	}
+
+	err = checkIntegrity(maintenance, config)
+	if err != nil {
+		return err
+	}
+	err = datastore.RunMigrations(migrations)
+	if err != nil {
+		return fmt.Errorf("error running schema: %s", err)
+	}
+	return nil
+}
+
+// runs one of the commands which work on the main board's notes
+// returns flag.ErrHelp if the command was only asked for its usage
+func runCommand(app *App) error {
+	config := app.config
+	datastore := app.datastore
+	switch config.command {
+	case "dedupe":
+		converted, saved, err := datastore.dedupeNotes()
+		if err != nil {
+			return fmt.Errorf("error deduplicating notes: %s", err)
+		}
+		log.Printf("deduplicated %d notes, reclaiming %d bytes", converted, saved)
+
+	case "assign-owner":
+		owner := config.commandArgs[0]
+		moved, left, err := datastore.assignOwner(owner)
+		if err != nil {
+			return fmt.Errorf("error assigning notes to %s: %s", owner, err)
+		}
+		log.Printf("moved %d notes into %s's private notes", moved, owner)
+		if left > 0 {
+			log.Printf("left %d notes shared, since %s already has notes with the same names", left, owner)
+		}
+
+	case "vacuum":
+		reclaimed, err := app.maintenance.vacuum()
+		if err != nil {
+			return fmt.Errorf("error vacuuming database: %s", err)
+		}
+		log.Printf("vacuumed database, reclaiming %d bytes", reclaimed)
+
+	case "import-dir":
+		err := importDir(datastore, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error importing directory: %s", err)
+		}
+		return err
+
+	case "import-pastes":
+		err := importPastes(datastore, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error importing pastes: %s", err)
+		}
+		return err
+
+	case "export-pastes":
+		err := exportPastes(datastore, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error exporting pastes: %s", err)
+		}
+		return err
+
+	case "config":
+		err := configCommand(app, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error exporting or importing configuration: %s", err)
+		}
+		return err
+
+	case "prune":
+		err := pruneNotes(app.maintenance, app.settings.expiry(), config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error pruning notes: %s", err)
+		}
+		return err
+
+	case "seed":
+		err := seedNotes(datastore, config.NoteExpiryTime, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error seeding notes: %s", err)
+		}
+		return err
+
+	case "wipe":
+		err := wipeNotes(datastore, config.commandArgs)
+		if err != nil && err != flag.ErrHelp {
+			return fmt.Errorf("error wiping notes: %s", err)
+		}
+		return err
+
+	case "restore-archived":
+		note, body, err := readArchivedNote(config.commandArgs[0])
+		if err != nil {
+			return fmt.Errorf("error reading archived note: %s", err)
+		}
+		status, err := datastore.setNoteWithHash(note.Name, body, note.Hash, false, NoteOptions{Unlisted: note.Unlisted, Title: note.Title})
+		if err != nil {
+			return fmt.Errorf("error restoring note %s: %s", note.Name, err)
+		}
+		if status == NO_CLOBBER {
+			return fmt.Errorf("error restoring note %s: a note with that name already exists", note.Name)
+		}
+		log.Printf("restored note %s", note.Name)
+
+	case "sync":
+		if app.mirror == nil {
+			return errors.New("bad arguments: sync requires -mirror-url")
+		}
+		err := app.mirror.sync()
+		if err != nil {
+			return fmt.Errorf("error syncing to mirror: %s", err)
+		}
+
+	default:
+		return fmt.Errorf("bad arguments: unknown command %q", config.command)
+	}
+	return nil
+}
+
+// loads the templates and static files pages are rendered with, including any customisations from config
+func loadAssets(config Config) (*template.Template, *StaticAssets, error) {
+	templates, err := loadTemplates(config.TemplatesDir, config.Dev)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading templates: %s", err)
+	}
+	staticFiles, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		return nil, nil, err
+	}
+	static, err := loadStaticAssets(staticFiles)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading static files: %s", err)
+	}
+	static.reload = config.Dev
+	if config.CustomCSS != "" {
+		if err := static.addCustom("custom.css", config.CustomCSS); err != nil {
+			return nil, nil, fmt.Errorf("error loading -custom-css: %s", err)
+		}
+	}
+	if config.CustomJS != "" {
+		if err := static.addCustom("custom.js", config.CustomJS); err != nil {
+			return nil, nil, fmt.Errorf("error loading -custom-js: %s", err)
+		}
+	}
+	return templates, static, nil
+}
+
+// which notes expire, and how long changes are logged
+func (config Config) expiry() ExpiryConfig {
+	return ExpiryConfig{
+		age:            config.NoteExpiryTime,
+		retention:      config.Retention,
+		hooks:          config.Hooks,
+		unviewedAge:    config.UnviewedExpiryTime,
+		policy:         config.ExpiryPolicy,
+		warningWindow:  config.ExpiryWarning,
+		changeLogAge:   config.ChangeLogAge,
+		viewAge:        config.AnalyticsRetention,
+		idempotencyAge: config.WritePolicy.IdempotencyAge,
+	}
+}
+
+// the values of the flags behind settings, in the form they're given on the command line
+func (config Config) settingFlags() map[string]string {
+	return map[string]string{
+		SETTING_NOTE_EXPIRY:     strconv.Itoa(int(config.NoteExpiryTime / (24 * time.Hour))),
+		SETTING_UNVIEWED_EXPIRY: config.UnviewedExpiryTime.String(),
+		SETTING_RECENT_NOTES:    strconv.Itoa(config.NumRecentNotes),
+		SETTING_READ_ONLY:       strconv.FormatBool(config.ReadOnly),
+		SETTING_BANNER:          config.Banner,
+	}
+}
+
+// commands which can be given after the flags, instead of serving the application
+var commands = []struct {
+	name string
+	// the command's arguments, for usage
+	args        string
+	description string
+	// whether the command parses its own flags from its arguments, so they can't be counted here
+	ownFlags bool
+}{
+	{"sync", "", "push notes to -mirror-url and exit", false},
+	{"dedupe", "", "deduplicate all existing note bodies and exit", false},
+	{"check", "", "check the whole database for corruption and exit", false},
+	{"vacuum", "", "return free space in the database to the filesystem and exit", false},
+	{"prune", "", "delete expired notes now and exit; see -h for -dry-run", true},
+	{"restore-archived", "<file>", "restore a note archived by -archive-dir and exit", false},
+	{"import-dir", "<path>", "create notes from the files in a directory and exit; see -h", true},
+	{"import-pastes", "<dir>", "create notes from pastes exported by another pastebin and exit; see -h", true},
+	{"export-pastes", "<dir>", "write every note into a directory as a paste, for import-pastes, and exit", true},
+	{"restore", "-from <dir>", "rebuild the database from a -replica-dir and exit; see -h", true},
+	{"seed", "-n <count>", "create notes full of random words and exit; see -h", true},
+	{"wipe", "-prefix <prefix>", "remove the notes made by seed and exit; see -h", true},
+	{"migrate", "", "bring the database's schema up to date and exit", false},
+	{"doctor", "", "summarize the configuration, check the database, directories and hosts it uses, and exit", false},
+	{"assign-owner", "<user>", "move the shared notes into the user's -private-notes and exit", false},
+	{"gen-name", "", "print a name in the -name-style, without creating a note, and exit", false},
+	{"config", "export|import", "write the configuration to a file, or check and restore it from one, and exit; see -h", true},
+}
+
+// ParseFlags parses the command line, without the program's name, into a Config
+// flags are defined on the given set, so a program embedding corkboard can use a set of its own;
+// parsing no args gives the defaults, with any credentials in $CORKBOARD_CREDS
+func ParseFlags(flags *flag.FlagSet, args []string) (Config, error) {
+	config := Config{}
+	flags.StringVar(&config.DatabasePath, "db-path", "./notes.db", "Path to the sqlite db.")
+	flags.BoolVar(&config.CreateDB, "create-db", false, "Create the database at -db-path if it doesn't exist, rather than refusing to start.\nIt's also created with -auto-migrate, or by \"corkboard migrate\".")
+	flags.DurationVar(&config.DBWait, "db-wait", 0, "If the database can't be opened at startup, keep trying for this long, e.g. \"30s\",\nin case it's on a network filesystem which isn't mounted yet.")
+	credentialFile := flags.String("creds-file", "", "Path to a file holding login credentials in the form\n\"username:password\". Each line holds a valid set of credentials.\nBlank lines and lines beginning with '#' are ignored.")
+	credentials := flags.String("creds", "", "Access credentials in the form\n\"username:password\".\nPrefer $CORKBOARD_CREDS or -creds-stdin, which keep passwords out of ps.")
+	credentialStdin := flags.Bool("creds-stdin", false, "Read login credentials from standard input at startup,\nin the same form as -creds-file.")
+	flags.IntVar(&config.Port, "port", 8080, "Port to serve the application on.")
+	flags.StringVar(&config.BaseURL, "base-url", "", "Public URL corkboard is served from, e.g. \"https://corkboard.example.com\".\nUsed to build links to notes. If unset, it is inferred from each request.")
+	noteExpiryTime := flags.Int("note-expiry", 7, "Notes which have not been viewed in this many days will be deleted.\nIf set to zero, notes never expire.")
+	flags.Var(&config.Retention, "retention", "Keep notes whose names begin with a prefix for another time than -note-expiry,\ne.g. \"tmp-=24h\", or forever, e.g. \"keep-=never\". May be repeated; the longest matching\nprefix wins.")
+	flags.DurationVar(&config.UnviewedExpiryTime, "unviewed-expiry", 0, "Notes which have never been viewed since they were created are deleted\nafter this long, e.g. \"36h\". If set to zero, this is disabled.")
+	flags.StringVar(&config.ExpiryPolicy, "expiry-policy", EXPIRE_VIEWED, "Whether -note-expiry counts from when a note was last \"viewed\"\nor from when it was \"created\".")
+	flags.DurationVar(&config.ExpiryWarning, "expiry-warning", 0, "Post a message once about notes which will expire within this long, e.g. \"24h\",\nto -notify-slack-webhook or -notify-matrix-server. If set to zero, this is disabled.")
+	flags.DurationVar(&config.ChangeLogAge, "change-log-retention", 30*24*time.Hour, "Keep changes in the log behind GET /api/changes for this long.\nIf set to zero, they're kept forever.")
+	flags.DurationVar(&config.LinkCheck.Interval, "linkcheck-interval", 0, "Check the http and https links in text notes this often, e.g. \"168h\", for\nGET /api/note/:note/links and a count of broken links on each note's page.\nIf set to zero, links aren't checked.")
+	flags.IntVar(&config.LinkCheck.MaxSize, "linkcheck-max-size", 256<<10, "Only check the links in notes no larger than this many bytes.")
+	flags.IntVar(&config.LinkCheck.Concurrency, "linkcheck-concurrency", 4, "Most hosts whose links are checked at once.")
+	flags.DurationVar(&config.LinkCheck.HostDelay, "linkcheck-host-delay", 2*time.Second, "Wait this long between checks of links on the same host.")
+	linkCheckAllow := flags.String("linkcheck-allow", "", "Comma-separated hosts whose links are checked, with their subdomains.\nIf unset, every host's are, except those of -linkcheck-deny.")
+	linkCheckDeny := flags.String("linkcheck-deny", "", "Comma-separated hosts whose links are never checked, with their subdomains.")
+	digestSchedule := flags.String("digest-schedule", "", "Write a digest note of the notes created, updated and deleted since the last one,\nand of those about to expire, on this cron schedule, e.g. \"0 7 * * *\".\nIf unset, no digests are written.")
+	flags.StringVar(&config.Digest.Prefix, "digest-prefix", "digest-", "Name digests this followed by their date, e.g. digest-2026-10-17.\nNotes beginning with it are left out of digests.")
+	flags.BoolVar(&config.Digest.Notify, "digest-notify", false, "Post a link to each digest to -notify-slack-webhook or -notify-matrix-server.")
+	flags.StringVar(&config.FeedTitle, "feed-title", "corkboard", "Title of the feed of the newest notes at /feed.json.")
+	flags.StringVar(&config.Banner, "banner", "", "Message shown at the top of every page and in the X-Corkboard-Banner header,\ne.g. to warn of maintenance. While given, the banner can't be changed at runtime.")
+	flags.BoolVar(&config.ReadOnly, "read-only", false, "Refuse every change to notes with 503, e.g. while the database is being moved.\nCan be changed at runtime with PUT /api/admin/settings.")
+	flags.BoolVar(&config.PrivateNotes, "private-notes", false, "Give each user their own notes, which no one else can see. Notes anyone can\nsee and change are under /shared/. Requires credentials.")
+	admins := flags.String("admins", "", "Comma-separated users who may use the /api/admin endpoints.\nIf unset, everyone who can sign in may.")
+	aclFile := flags.String("acl-file", "", "Path to a file of rules for who may read and write notes, by the beginnings of\ntheir names. Each line is a prefix and the users allowed, e.g.\n\"infra- read=* write=alice,bob\". The first matching rule wins. Requires credentials.")
+	aclDefault := flags.String("acl-default", "allow", "Whether notes no -acl-file rule matches may be read and written by everyone,\n\"allow\", or by no one, \"deny\".")
+	flags.StringVar(&config.CustomCSS, "custom-css", "", "Path to a stylesheet included in every page after the built-in one,\nserved at /static/custom.css.")
+	flags.StringVar(&config.CustomJS, "custom-js", "", "Path to a script included in every page after the built-in ones,\nserved at /static/custom.js.")
+	flags.BoolVar(&config.AllowInternalFetch, "allow-internal-fetch", false, "Let POST /api/note/:note/fetch fetch from, and watches post to, loopback,\nlink-local and private addresses. Only enable this if everyone who can sign\nin may reach them.")
+	flags.StringVar(&config.TemplatesDir, "templates-dir", "", "Directory of templates used instead of the built-in ones with the same names,\ne.g. index.html. Templates it doesn't have are built-in.")
+	flags.BoolVar(&config.validateTemplates, "validate-templates", false, "Check that the templates, including those in -templates-dir, are all there\nand can be rendered, then exit. As with -dev, a broken -templates-dir template\nis an error, rather than replaced with the built-in one.")
+	flags.BoolVar(&config.Dev, "dev", false, "Reread -custom-css and -custom-js on every request,\nso changes to them show up without restarting. Refuse to start if a\n-templates-dir template can't be used, rather than using the built-in one.")
+	flags.BoolVar(&config.Analytics, "analytics", true, "Record views of notes for GET /api/note/:note/stats, with each viewer's address\ntruncated to its /24 or /48 network. If false, nothing about views is recorded.")
+	flags.DurationVar(&config.AnalyticsRetention, "analytics-retention", 30*24*time.Hour, "Keep the views recorded by -analytics for this long.\nIf set to zero, they're kept forever.")
+	flags.IntVar(&config.HTMLMaxSize, "html-max-size", 1<<20, "Show only this many bytes of larger notes on their page, with a link to\nthe whole note. If set to zero, notes are always shown in full.")
+	flags.DurationVar(&config.WritePolicy.IdempotencyAge, "idempotency-retention", 24*time.Hour, "Remember the X-Idempotency-Key of each POST creating a note for this long, so\nretrying it gets the same response, and the token of each submitted web form,\nso it isn't submitted twice. If set to zero, they're kept forever.")
+	flags.Int64Var(&config.WritePolicy.MaxDecompressedSize, "decompressed-max-size", 32<<20, "Largest note an upload with a Content-Encoding like gzip may decompress to,\nin bytes. Larger ones are refused with 413, so a small upload can't fill the disk.")
+	flags.Int64Var(&config.WritePolicy.MaxNoteSize, "max-note-size", 0, "Refuse notes larger than this many bytes with 422, however they're written.\nIf set to zero, notes may be any size.")
+	flags.Int64Var(&config.WritePolicy.Quota, "quota", 0, "Refuse writes with 507 once the notes they'd be stored among would take up more\nthan this many bytes: with -private-notes, each user's notes, or the shared\nnotes; otherwise, every note. If set to zero, there's no limit.")
+	flags.BoolVar(&config.WritePolicy.RejectDuplicates, "reject-duplicates", false, "Refuse notes with the same contents as another note with 409, rather than\nsaving them and naming the other note in the response.")
+	flags.BoolVar(&config.StrictIndex, "strict-index", false, "Respond 500 if the main page can't list recent notes,\nrather than showing the page with a banner saying so.")
+	flags.IntVar(&config.NumRecentNotes, "recent-notes", 8, "Display this many recent notes on the main page.\n")
+	flags.IntVar(&config.TCPPaste.Port, "tcp-paste-port", 0, "Accept pastes over plain TCP on this port, like termbin.\nRequires -base-url. If set to zero, this is disabled.")
+	flags.DurationVar(&config.TCPPaste.IdleTimeout, "tcp-paste-timeout", 5*time.Second, "A TCP paste ends once its connection has been idle this long.")
+	flags.IntVar(&config.TCPPaste.MaxSize, "tcp-paste-max-size", 1<<20, "Largest TCP paste accepted, in bytes.")
+	flags.IntVar(&config.TCPPaste.MaxConns, "tcp-paste-max-conns", 16, "Most TCP paste connections handled at once.")
+	flags.StringVar(&config.TCPPaste.Token, "tcp-paste-token", "", "If set, the first line of each TCP paste must be this token.")
+	tcpPasteAllow := flags.String("tcp-paste-allow", "", "Comma-separated IP addresses and CIDR ranges allowed to make TCP pastes.\nIf unset, any address may.")
+	flags.StringVar(&config.MirrorURL, "mirror-url", "", "URL of another corkboard instance to push every change to.\nRun \"corkboard sync\" to push notes which are missing or different on it.")
+	flags.StringVar(&config.MirrorCredentials, "mirror-creds", "", "Credentials for the -mirror-url instance in the form\n\"username:password\".")
+	flags.StringVar(&config.Logging.File, "log-file", "", "Write logs to this file instead of stderr.\nThe file is reopened on SIGUSR1, for logrotate.")
+	flags.Int64Var(&config.Logging.MaxSize, "log-max-size", 10<<20, "Rotate the -log-file once it grows past this many bytes.\nIf set to zero, it is never rotated.")
+	flags.IntVar(&config.Logging.Keep, "log-keep", 5, "Keep this many rotated log files.")
+	flags.BoolVar(&config.Logging.Syslog, "log-syslog", false, "Write logs to the local syslog, or to the event log on Windows.")
+	flags.StringVar(&config.Logging.SyslogTag, "log-syslog-tag", "corkboard", "Tag for messages written to syslog.")
+	flags.StringVar(&config.Notify.SlackWebhook, "notify-slack-webhook", "", "Post a message to this Slack incoming webhook URL when notes change.")
+	flags.StringVar(&config.Notify.MatrixServer, "notify-matrix-server", "", "Post a message to a Matrix room on this homeserver when notes change.\nRequires -notify-matrix-room and -notify-matrix-token.")
+	flags.StringVar(&config.Notify.MatrixRoom, "notify-matrix-room", "", "ID of the Matrix room to post to, e.g. \"!abc123:example.com\".")
+	flags.StringVar(&config.Notify.MatrixToken, "notify-matrix-token", "", "Access token of the Matrix user to post as.")
+	notifyEvents := flags.String("notify-events", NOTE_CREATED, "Comma-separated list of changes to notify about,\nout of \"create\", \"update\" and \"delete\".")
+	flags.StringVar(&config.DiagDir, "diag-dir", "", "Write the diagnostics dumped on SIGUSR2 to a timestamped file in this directory,\nrather than to the log.")
+	flags.BoolVar(&config.Dedupe, "dedupe", false, "Store identical note bodies only once.\nRun \"corkboard dedupe\" to deduplicate notes created before this was set.")
+	boardsFile := flags.String("boards-file", "", "Path to a file of extra boards, each served under /b/name/ from its own database.\nEach line is a board's name and options overriding flags for it, e.g.\n\"work db=./work.db creds-file=./work.creds note-expiry=30\".")
+	flags.StringVar(&config.ScheduleFile, "schedule-file", "", "Path to a file of notes to create on a schedule. Each line is a cron\nexpression, a note name, and the note's body or \"template:name\", e.g.\n\"0 9 * * 1 standup-{{date}} template:standup\".")
+	nameStyle := flags.String("name-style", NAME_STYLE_NANOID, "Style of the names given to notes created by POST /, POST /paste and TCP\npastes, out of \"hex\", \"words\" like amber-falcon-42, \"uuid\" and \"nanoid\".")
+	flags.BoolVar(&config.PasteUnlisted, "paste-unlisted", false, "Leave notes created by POST /, POST /paste and TCP pastes out of listings.")
+	flags.BoolVar(&config.WritePolicy.EmptyTruncates, "empty-put-truncates", false, "Let a PUT with an empty body empty an existing note. Otherwise, empty notes\nare refused unless ?allow-empty=true is given.")
+	flags.BoolVar(&config.DisableComments, "disable-comments", false, "Turn off comments on notes.")
+	flags.StringVar(&config.ArchiveDir, "archive-dir", "", "Write expired notes to this directory before deleting them.\nRun \"corkboard restore-archived <file>\" to restore one.")
+	flags.DurationVar(&config.StatsWindow, "stats-window", time.Hour, "Report how long requests took over this long, e.g. \"24h\", in GET /api/stats\nand /metrics. Only the latest 10000 requests are kept. If set to zero,\nrequests aren't timed.")
+	flags.DurationVar(&config.VacuumInterval, "vacuum-interval", 0, "Return free space in the database to the filesystem this often, e.g. \"168h\".\nIf set to zero, this is disabled.")
+	vacuumWindow := flags.String("vacuum-window", "", "Only vacuum between these local times, e.g. \"02:00-05:00\".")
+	flags.BoolVar(&config.SkipIntegrityCheck, "skip-integrity-check", false, "Start even without checking the database for corruption.")
+	flags.BoolVar(&config.Schema.AutoMigrate, "auto-migrate", false, "Bring the database's schema up to date at startup, rather than refusing to\nstart until \"corkboard migrate\" is run.")
+	flags.BoolVar(&config.Schema.AllowNewer, "allow-newer-schema", false, "Start even if a newer corkboard has changed the database's schema.")
+	flags.BoolVar(&config.Schema.SkipCheck, "skip-schema-check", false, "Start without checking the database's schema is the one this corkboard expects.")
+	flags.IntVar(&config.MaxConcurrentWrites, "max-concurrent-writes", 8, "Most requests which change notes handled at once. Others wait up to 5s for\na turn, then are told to retry. If set to zero, writes aren't limited.")
+	flags.Float64Var(&config.RateLimits.ReadRate, "rate-read", 0, "Most reads each client may make per second, on average.\nIf set to zero, reads aren't limited.")
+	flags.IntVar(&config.RateLimits.ReadBurst, "rate-read-burst", 20, "Most reads each client may make at once.")
+	flags.Float64Var(&config.RateLimits.WriteRate, "rate-write", 0, "Most writes each client may make per second, on average.\nIf set to zero, writes aren't limited.")
+	flags.IntVar(&config.RateLimits.WriteBurst, "rate-write-burst", 5, "Most writes each client may make at once.")
+	flags.Float64Var(&config.RateLimits.UserMultiplier, "rate-user-multiplier", 0, "Limit logged-in users by username rather than address,\nat this multiple of the -rate-* limits. If set to zero, they're limited by address.")
+	trustedProxies := flags.String("trusted-proxies", "", "Comma-separated IP addresses and CIDR ranges of reverse proxies whose\nX-Forwarded-For headers are believed when identifying clients.")
+	flags.StringVar(&config.ReplicaDir, "replica-dir", "", "Copy a snapshot of the database into this directory every -replica-interval.\nRun \"corkboard restore -from <dir>\" to rebuild the database from the newest one.")
+	flags.DurationVar(&config.ReplicaInterval, "replica-interval", 5*time.Minute, "How often to snapshot the database into -replica-dir.")
+	flags.IntVar(&config.ReplicaKeep, "replica-keep", 24, "Keep this many snapshots in -replica-dir.")
+	flags.StringVar(&config.service, "service", "", "On Windows, \"install\", \"uninstall\", \"start\" or \"stop\" the corkboard service, then exit.\nIt's installed to run with the other flags given, and logs to the event log\nunless -log-file is given.")
+	flags.BoolVar(&config.printVersion, "version", false, "Print the version number and exit")
+	flags.Usage = func() {
+		out := flags.Output()
+		fmt.Fprintf(out, "Usage of corkboard:\n  corkboard [flags]                          serve the application\n")
+		for _, command := range commands {
+			fmt.Fprintf(out, "  corkboard [flags] %-24s %s\n", strings.TrimSpace(command.name+" "+command.args), command.description)
+		}
+		fmt.Fprintf(out, "  corkboard push|pull|watch -h               talk to a corkboard server; see each command's help\n")
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	if err != nil {
+		return config, err
+	}
+
+	// flags given on the command line win over settings stored at runtime
+	config.pinnedSettings = make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		if _, ok := settingValidators[f.Name]; ok {
+			config.pinnedSettings[f.Name] = true
+		}
+	})
+
+	if flags.NArg() > 0 {
+		config.command = flags.Arg(0)
+		config.commandArgs = flags.Args()[1:]
+		known := false
+		for _, command := range commands {
+			if command.name == config.command {
+				known = true
+				if !command.ownFlags && len(config.commandArgs) != len(strings.Fields(command.args)) {
+					return config, fmt.Errorf("bad arguments: usage: corkboard [flags] %s %s", command.name, command.args)
+				}
+			}
+		}
+		if !known {
+			return config, fmt.Errorf("bad arguments: unknown command %q", config.command)
+		}
+	}
+
+	if *noteExpiryTime < 0 {
+		return config, errors.New("bad arguments: -note-expiry must be non-negative")
+	} else {
+		// convert from number of hours into time.Duration
+		config.NoteExpiryTime = time.Duration(*noteExpiryTime*24) * time.Hour
+	}
+
+	if config.ChangeLogAge < 0 {
+		return config, errors.New("bad arguments: -change-log-retention must be non-negative")
+	}
+
+	if config.AnalyticsRetention < 0 {
+		return config, errors.New("bad arguments: -analytics-retention must be non-negative")
+	}
+
+	if config.WritePolicy.MaxNoteSize < 0 {
+		return config, errors.New("bad arguments: -max-note-size must be non-negative")
+	}
+	if config.WritePolicy.Quota < 0 {
+		return config, errors.New("bad arguments: -quota must be non-negative")
+	}
+	if config.WritePolicy.MaxDecompressedSize <= 0 {
+		return config, errors.New("bad arguments: -decompressed-max-size must be positive")
+	}
+
+	if config.WritePolicy.IdempotencyAge < 0 {
+		return config, errors.New("bad arguments: -idempotency-retention must be non-negative")
+	}
+
+	if config.UnviewedExpiryTime < 0 {
+		return config, errors.New("bad arguments: -unviewed-expiry must be non-negative")
+	}
+
+	if config.DBWait < 0 {
+		return config, errors.New("bad arguments: -db-wait must be non-negative")
+	}
+
+	if config.LinkCheck.Interval < 0 || config.LinkCheck.HostDelay < 0 {
+		return config, errors.New("bad arguments: -linkcheck-interval and -linkcheck-host-delay must be non-negative")
+	}
+	if config.LinkCheck.MaxSize <= 0 || config.LinkCheck.Concurrency <= 0 {
+		return config, errors.New("bad arguments: -linkcheck-max-size and -linkcheck-concurrency must be positive")
+	}
+	config.LinkCheck.Allow, err = parseHostList(*linkCheckAllow)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -linkcheck-allow: %v", err)
+	}
+	config.LinkCheck.Deny, err = parseHostList(*linkCheckDeny)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -linkcheck-deny: %v", err)
+	}
+
+	if *digestSchedule != "" {
+		schedule, err := parseCron(*digestSchedule)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: -digest-schedule: %v", err)
+		}
+		config.Digest.schedule = &schedule
+	}
+	if err := validateNoteName(config.Digest.Prefix + "2006-01-02"); err != nil {
+		return config, fmt.Errorf("bad arguments: -digest-prefix: %v", err)
+	}
+	if config.Digest.Notify && config.Digest.schedule == nil {
+		return config, errors.New("bad arguments: -digest-notify requires -digest-schedule")
+	}
+	if config.Digest.Notify && config.Notify.SlackWebhook == "" && config.Notify.MatrixServer == "" {
+		return config, errors.New("bad arguments: -digest-notify requires -notify-slack-webhook or -notify-matrix-server")
+	}
+
+	if config.ExpiryPolicy != EXPIRE_VIEWED && config.ExpiryPolicy != EXPIRE_CREATED {
+		return config, fmt.Errorf("bad arguments: -expiry-policy must be %q or %q", EXPIRE_VIEWED, EXPIRE_CREATED)
+	}
+
+	if config.ExpiryWarning < 0 {
+		return config, errors.New("bad arguments: -expiry-warning must be non-negative")
+	}
+	if config.ExpiryWarning != 0 && config.Notify.SlackWebhook == "" && config.Notify.MatrixServer == "" {
+		return config, errors.New("bad arguments: -expiry-warning requires -notify-slack-webhook or -notify-matrix-server")
+	}
+
+	if config.NumRecentNotes < 0 {
+		return config, errors.New("bad arguments: -recent-notes must be non-negative")
+	}
+
+	if config.Notify.MatrixServer != "" && (config.Notify.MatrixRoom == "" || config.Notify.MatrixToken == "") {
+		return config, errors.New("bad arguments: -notify-matrix-server requires -notify-matrix-room and -notify-matrix-token")
+	}
+	events, err := parseNotifyEvents(*notifyEvents)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -notify-events: %v", err)
+	}
+	config.Notify.Events = events
+
+	if config.ScheduleFile != "" {
+		file, err := os.Open(config.ScheduleFile)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: unable to open schedule file %s: %v", config.ScheduleFile, err)
+		}
+		config.Schedule, err = parseSchedule(file, "schedule file "+config.ScheduleFile)
+		file.Close()
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: %v", err)
+		}
+	}
+
+	if config.RateLimits.ReadRate < 0 || config.RateLimits.WriteRate < 0 || config.RateLimits.UserMultiplier < 0 {
+		return config, errors.New("bad arguments: -rate-read, -rate-write and -rate-user-multiplier must be non-negative")
+	}
+	config.RateLimits.TrustedProxies, err = parseAllowList(*trustedProxies)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -trusted-proxies: %v", err)
+	}
+
+	if config.HTMLMaxSize < 0 {
+		return config, errors.New("bad arguments: -html-max-size must be non-negative")
+	}
+
+	config.Banner = strings.TrimSpace(config.Banner)
+	if err := validateBanner(config.Banner); err != nil {
+		return config, fmt.Errorf("bad arguments: -banner %v", err)
+	}
+
+	if config.MaxConcurrentWrites < 0 {
+		return config, errors.New("bad arguments: -max-concurrent-writes must be non-negative")
+	}
+
+	if config.ReplicaInterval <= 0 || config.ReplicaKeep < 1 {
+		return config, errors.New("bad arguments: -replica-interval and -replica-keep must be positive")
+	}
+
+	if config.StatsWindow < 0 {
+		return config, errors.New("bad arguments: -stats-window must be non-negative")
+	}
+
+	if config.VacuumInterval < 0 {
+		return config, errors.New("bad arguments: -vacuum-interval must be non-negative")
+	}
+	config.VacuumWindow, err = parseVacuumWindow(*vacuumWindow)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -vacuum-window: %v", err)
+	}
+
+	if config.DiagDir != "" {
+		if info, err := os.Stat(config.DiagDir); err != nil || !info.IsDir() {
+			return config, fmt.Errorf("bad arguments: -diag-dir %s isn't a directory", config.DiagDir)
+		}
+	}
+
+	switch config.service {
+	case "", "install", "uninstall", "start", "stop":
+	default:
+		return config, errors.New("bad arguments: -service must be \"install\", \"uninstall\", \"start\" or \"stop\"")
+	}
+	if config.service != "" && config.command != "" {
+		return config, errors.New("bad arguments: -service can't be given with a command")
+	}
+
+	// the working directory may change, e.g. when run as a service, and absolute paths
+	// let Windows use directories whose paths are longer than 260 characters
+	if config.ArchiveDir != "" {
+		config.ArchiveDir, err = filepath.Abs(config.ArchiveDir)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: -archive-dir: %v", err)
+		}
+	}
+
+	if config.Logging.MaxSize < 0 || config.Logging.Keep < 0 {
+		return config, errors.New("bad arguments: -log-max-size and -log-keep must be non-negative")
+	}
+
+	if config.MirrorCredentials != "" {
+		if _, err := validateCredential(config.MirrorCredentials); err != nil {
+			return config, fmt.Errorf("bad arguments: -mirror-creds: %v", err)
+		}
+	}
+
+	config.Names, err = NewNameGenerator(*nameStyle, rand.Reader)
+	if err != nil {
+		return config, fmt.Errorf("bad arguments: -name-style: %v", err)
+	}
+
+	config.TCPPaste.Unlisted = config.PasteUnlisted
+
+	if config.TCPPaste.Port < 0 {
+		return config, errors.New("bad arguments: -tcp-paste-port must be non-negative")
+	}
+	if config.TCPPaste.Port != 0 {
+		if config.BaseURL == "" {
+			return config, errors.New("bad arguments: -tcp-paste-port requires -base-url")
+		}
+		if config.TCPPaste.IdleTimeout <= 0 || config.TCPPaste.MaxSize <= 0 || config.TCPPaste.MaxConns <= 0 {
+			return config, errors.New("bad arguments: -tcp-paste-timeout, -tcp-paste-max-size and -tcp-paste-max-conns must be positive")
+		}
+		allowed, err := parseAllowList(*tcpPasteAllow)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: -tcp-paste-allow: %v", err)
+		}
+		config.TCPPaste.Allowed = allowed
+	}
+
+	// config.Credentials is a map of all valid user:password strings
+	// credentials from every source are merged together
+	credentialEnv := os.Getenv(credentialsEnvVar)
+	if *credentialFile == "" && *credentials == "" && credentialEnv == "" && !*credentialStdin {
+		// if config.Credentials is nil, authentication is turned off
+		config.Credentials = nil
+	} else {
+		config.Credentials = make(map[string]bool)
+		if *credentialFile != "" {
+			// if a file was provided, each line is a valid set of creds
+			file, err := os.Open(*credentialFile)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: unable to open credentials file %s: %v", *credentialFile, err)
+			}
+			defer file.Close()
+
+			err = parseCredentials(file, "credentials file "+*credentialFile, config.Credentials)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: %v", err)
+			}
+		}
+		if credentialEnv != "" {
+			err := parseCredentials(strings.NewReader(splitCredentialsEnv(credentialEnv)), "$"+credentialsEnvVar, config.Credentials)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: %v", err)
+			}
+		}
+		if *credentialStdin {
+			err := parseCredentials(os.Stdin, "standard input", config.Credentials)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: %v", err)
+			}
+		}
+		if *credentials != "" {
+			// go can't portably rewrite its own argv, so the password stays visible
+			log.Printf("warning: -creds exposes the password to other users via ps; use $%s or -creds-stdin instead", credentialsEnvVar)
+			err := parseCredentials(strings.NewReader(*credentials), "-creds", config.Credentials)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: %v", err)
+			}
+		}
+		if len(config.Credentials) == 0 {
+			return config, errors.New("bad arguments: credentials were supplied but none are valid")
+		}
+	}
+
+	if *admins != "" {
+		if config.Credentials == nil {
+			return config, errors.New("bad arguments: -admins requires credentials")
+		}
+		config.Admins = make(map[string]bool)
+		for _, admin := range strings.Split(*admins, ",") {
+			admin = strings.TrimSpace(admin)
+			if !hasUser(config.Credentials, admin) {
+				return config, fmt.Errorf("bad arguments: -admins: there are no credentials for %q", admin)
+			}
+			config.Admins[admin] = true
+		}
+	}
+
+	if *aclDefault != "allow" && *aclDefault != "deny" {
+		return config, errors.New(`bad arguments: -acl-default must be "allow" or "deny"`)
+	}
+	if *aclFile != "" {
+		if config.Credentials == nil {
+			return config, errors.New("bad arguments: -acl-file requires credentials")
+		}
+		file, err := os.Open(*aclFile)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: unable to open acl file %s: %v", *aclFile, err)
+		}
+		rules, err := parseACL(file, "acl file "+*aclFile)
+		file.Close()
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: %v", err)
+		}
+		for _, rule := range rules {
+			if user, ok := rule.unknownUser(config.Credentials); ok {
+				return config, fmt.Errorf("bad arguments: acl file %s line %d: there are no credentials for %q", *aclFile, rule.line, user)
+			}
+			if rule.namesAdmins() && len(config.Admins) == 0 {
+				return config, fmt.Errorf("bad arguments: acl file %s line %d: %s requires -admins", *aclFile, rule.line, aclAdmins)
+			}
+		}
+		config.ACL = &ACL{rules: rules, defaultAllow: *aclDefault == "allow", admins: config.Admins}
+	}
+
+	if config.PrivateNotes {
+		if config.Credentials == nil {
+			return config, errors.New("bad arguments: -private-notes requires credentials")
+		}
+		if user, ok := unownableUser(config.Credentials); ok {
+			return config, fmt.Errorf("bad arguments: -private-notes: user %q can't own notes, since their name contains '/'", user)
+		}
+		if config.MirrorURL != "" {
+			return config, errors.New("bad arguments: -private-notes can't be used with -mirror-url")
+		}
+	}
+
+	if config.command == "assign-owner" {
+		if owner := config.commandArgs[0]; owner == "" || strings.Contains(owner, "/") {
+			return config, errors.New("bad arguments: assign-owner: users who own notes can't have '/' in their names")
+		}
+	}
+
+	if *boardsFile != "" {
+		file, err := os.Open(*boardsFile)
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: unable to open boards file %s: %v", *boardsFile, err)
+		}
+		config.Boards, err = parseBoards(file, "boards file "+*boardsFile)
+		file.Close()
+		if err != nil {
+			return config, fmt.Errorf("bad arguments: %v", err)
+		}
+		databases := map[string]bool{config.DatabasePath: true}
+		for _, board := range config.Boards {
+			boardConfig, err := board.apply(config)
+			if err != nil {
+				return config, fmt.Errorf("bad arguments: %v", err)
+			}
+			if databases[boardConfig.DatabasePath] {
+				return config, fmt.Errorf("bad arguments: board %s shares the db %s with another board", board.name, boardConfig.DatabasePath)
+			}
+			databases[boardConfig.DatabasePath] = true
+		}
+	}
+
+	if config.TCPPaste.Port != 0 && config.Credentials != nil &&
+		config.TCPPaste.Token == "" && len(config.TCPPaste.Allowed) == 0 {
+		// tcp pastes can't use basic auth, so they need some other protection
+		return config, errors.New("bad arguments: -tcp-paste-port requires -tcp-paste-token or -tcp-paste-allow when credentials are set")
+	}
+
+	return config, nil
+}
@@ -0,0 +1,92 @@
+// Package servertest runs corkboard against a throwaway database,
+// for tests of the server and of programs that embed it
+package servertest
+
+import (
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/FBemf/corkboard/server"
+)
+
+// Config is the configuration corkboard would get from args on the command line,
+// but with a new database in a temporary directory and authentication off
+func Config(t testing.TB, args ...string) server.Config {
+	t.Helper()
+	config, err := server.ParseFlags(flag.NewFlagSet("servertest", flag.ContinueOnError), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	// $CORKBOARD_CREDS mustn't leak into tests
+	config.Credentials = nil
+	return config
+}
+
+// Server is an App serving http on a local port
+// it's closed, along with its database, when the test finishes
+type Server struct {
+	*httptest.Server
+	App *server.App
+}
+
+// New starts serving an App made from config
+// only requests are served; the background work Run would do isn't started
+func New(t testing.TB, config server.Config) *Server {
+	t.Helper()
+	app, err := server.NewApp(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{httptest.NewServer(app.Router()), app}
+	t.Cleanup(func() {
+		s.Close()
+		app.Close()
+	})
+	return s
+}
+
+// Do sends a request without credentials, and returns the response's status and body
+func (s *Server) Do(t testing.TB, method string, path string, body string) (int, string) {
+	t.Helper()
+	return s.DoAs(t, "", method, path, body)
+}
+
+// DoAs sends a request with credentials in the form "user:password", or none if creds is "",
+// and returns the response's status and body
+func (s *Server) DoAs(t testing.TB, creds string, method string, path string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds != "" {
+		parts := strings.SplitN(creds, ":", 2)
+		req.SetBasicAuth(parts[0], parts[len(parts)-1])
+	}
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(respBody)
+}
+
+// Seed fills the server's database with notes of random words, like "corkboard seed"
+// it's the same generator the seed command uses, so the same options make the same notes
+func (s *Server) Seed(t testing.TB, options server.SeedOptions) {
+	t.Helper()
+	if _, err := s.App.Seed(options); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"errors"
+)
+
+// only Windows has services; elsewhere, use an init system like systemd
+func startedAsService() (bool, error) {
+	return false, nil
+}
+
+func runService(run func(context.Context) error) error {
+	return errors.New("services are only supported on Windows")
+}
+
+func serviceCommand(action string, args []string) error {
+	return errors.New("-service is only supported on Windows")
+}
@@ -0,0 +1,157 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// the name corkboard is installed as a service under, and logs to the event log as
+const serviceName = "corkboard"
+
+// how long -service stop waits for corkboard to finish the requests it's handling
+const serviceStopTimeout = 30 * time.Second
+
+// whether corkboard was started by the service manager, rather than from a console
+// if it was, the working directory is changed to the executable's, since the service manager
+// starts services in the system directory, where a relative -db-path like ./notes.db doesn't belong
+func startedAsService() (bool, error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false, err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return true, err
+	}
+	return true, os.Chdir(filepath.Dir(exe))
+}
+
+// runs the application until the service manager stops it
+func runService(run func(context.Context) error) error {
+	handler := &serviceHandler{run: run}
+	err := svc.Run(serviceName, handler)
+	if err != nil {
+		return err
+	}
+	return handler.err
+}
+
+// serviceHandler answers the service manager on corkboard's behalf
+type serviceHandler struct {
+	run func(context.Context) error
+	// why run failed, if it did
+	err error
+}
+
+func (h *serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- h.run(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				h.err = err
+				// a service-specific exit code, so the service manager reports that it failed
+				return true, 1
+			}
+			return false, 0
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
+
+// installs, uninstalls, starts or stops the corkboard service
+// it's installed to run this executable with args, and to start when Windows does
+func serviceCommand(action string, args []string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager: %v", err)
+	}
+	defer manager.Disconnect()
+	if action == "install" {
+		return installService(manager, args)
+	}
+	service, err := manager.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s isn't installed: %v", serviceName, err)
+	}
+	defer service.Close()
+	switch action {
+	case "uninstall":
+		err = service.Delete()
+		if err != nil {
+			return err
+		}
+		return eventlog.Remove(serviceName)
+	case "start":
+		return service.Start()
+	case "stop":
+		current, err := service.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(serviceStopTimeout)
+		for current.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("service %s didn't stop within %s", serviceName, serviceStopTimeout)
+			}
+			time.Sleep(300 * time.Millisecond)
+			current, err = service.Query()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action %q", action)
+}
+
+func installService(manager *mgr.Mgr, args []string) error {
+	service, err := manager.OpenService(serviceName)
+	if err == nil {
+		service.Close()
+		return fmt.Errorf("service %s is already installed", serviceName)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	service, err = manager.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Corkboard",
+		Description: "A little pastebin-style service.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		service.Delete()
+		return fmt.Errorf("registering with the event log: %v", err)
+	}
+	return nil
+}
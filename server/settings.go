@@ -0,0 +1,305 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// settings which can be changed while corkboard is running, named after the flags they override
+const (
+	SETTING_NOTE_EXPIRY     = "note-expiry"
+	SETTING_UNVIEWED_EXPIRY = "unviewed-expiry"
+	SETTING_RECENT_NOTES    = "recent-notes"
+	SETTING_READ_ONLY       = "read-only"
+	SETTING_BANNER          = "banner"
+)
+
+// checks a value of each setting, which is given in the same form as its flag
+var settingValidators = map[string]func(string) error{
+	SETTING_NOTE_EXPIRY:     validateNonNegativeInt,
+	SETTING_UNVIEWED_EXPIRY: validateNonNegativeDuration,
+	SETTING_RECENT_NOTES:    validateNonNegativeInt,
+	SETTING_READ_ONLY:       validateBool,
+	SETTING_BANNER:          validateBanner,
+}
+
+// where a setting's value comes from
+const (
+	SETTING_FROM_DEFAULT = "default"
+	SETTING_FROM_FLAG    = "flag"
+	SETTING_FROM_STORED  = "stored"
+)
+
+func validateNonNegativeInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return fmt.Errorf("must be a non-negative integer")
+	}
+	return nil
+}
+
+func validateNonNegativeDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return fmt.Errorf("must be a non-negative duration, e.g. \"36h\"")
+	}
+	return nil
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("must be \"true\" or \"false\"")
+	}
+	return nil
+}
+
+// Settings overlays settings stored in the database over the flags' values, so they can be changed
+// without restarting; flags given on the command line win over stored settings
+// it's read on every request, so stored settings are kept in memory
+type Settings struct {
+	datastore Datastore
+	index     *IndexCache
+	// each setting's value from its flag
+	flags map[string]string
+	// settings whose flags were given on the command line
+	pinned map[string]bool
+	// the parts of expiry which can't be changed at runtime
+	expiryDefaults ExpiryConfig
+	lock           sync.RWMutex
+	stored         map[string]string
+}
+
+// loads the stored settings
+// stored values which aren't valid, say because an older corkboard doesn't understand them, are ignored
+func loadSettings(datastore Datastore, index *IndexCache, config Config) (*Settings, error) {
+	stored, err := datastore.listSettings()
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range stored {
+		validate, ok := settingValidators[name]
+		if !ok {
+			delete(stored, name)
+		} else if err := validate(value); err != nil {
+			log.Printf("ignoring stored setting %s: %v", name, err)
+			delete(stored, name)
+		}
+	}
+	return &Settings{
+		datastore:      datastore,
+		index:          index,
+		flags:          config.settingFlags(),
+		pinned:         config.pinnedSettings,
+		expiryDefaults: config.expiry(),
+		stored:         stored,
+	}, nil
+}
+
+// a setting's current value, and where it comes from
+func (s *Settings) lookup(name string) (string, string) {
+	if s.pinned[name] {
+		return s.flags[name], SETTING_FROM_FLAG
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if value, ok := s.stored[name]; ok {
+		return value, SETTING_FROM_STORED
+	}
+	return s.flags[name], SETTING_FROM_DEFAULT
+}
+
+func (s *Settings) get(name string) string {
+	value, _ := s.lookup(name)
+	return value
+}
+
+// values are validated before they're stored, so they always parse
+
+func (s *Settings) noteExpiry() time.Duration {
+	days, _ := strconv.Atoi(s.get(SETTING_NOTE_EXPIRY))
+	return time.Duration(days*24) * time.Hour
+}
+
+func (s *Settings) unviewedExpiry() time.Duration {
+	age, _ := time.ParseDuration(s.get(SETTING_UNVIEWED_EXPIRY))
+	return age
+}
+
+func (s *Settings) recentNotes() int {
+	n, _ := strconv.Atoi(s.get(SETTING_RECENT_NOTES))
+	return n
+}
+
+func (s *Settings) readOnly() bool {
+	readOnly, _ := strconv.ParseBool(s.get(SETTING_READ_ONLY))
+	return readOnly
+}
+
+func (s *Settings) banner() string {
+	return s.get(SETTING_BANNER)
+}
+
+// which notes expire, as currently set
+func (s *Settings) expiry() ExpiryConfig {
+	expiry := s.expiryDefaults
+	expiry.age = s.noteExpiry()
+	expiry.unviewedAge = s.unviewedExpiry()
+	return expiry
+}
+
+// checks a change to settings could be made
+// returns the status and api error code to respond with, and a message, if it couldn't
+func (s *Settings) check(changes map[string]*string) (int, string, string) {
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		validate, ok := settingValidators[name]
+		if !ok {
+			return http.StatusBadRequest, ERR_BAD_REQUEST, fmt.Sprintf("there's no setting %q", name)
+		}
+		if s.pinned[name] {
+			return http.StatusConflict, ERR_CONFLICT, fmt.Sprintf("%s was given with -%s on the command line, which wins over stored settings", name, name)
+		}
+		if value := changes[name]; value != nil {
+			if err := validate(*value); err != nil {
+				return http.StatusBadRequest, ERR_BAD_REQUEST, fmt.Sprintf("%s %v", name, err)
+			}
+		}
+	}
+	return 0, "", ""
+}
+
+// changes settings everywhere at once, storing them so they survive restarts
+// a nil value removes the stored setting, so its flag's value applies again
+func (s *Settings) update(changes map[string]*string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.datastore.setSettings(changes)
+	if err != nil {
+		return err
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(s.stored, name)
+		} else {
+			s.stored[name] = *value
+		}
+	}
+	// the cached index may show the old banner or number of recent notes
+	s.index.invalidate()
+	return nil
+}
+
+// SettingData describes a setting for GET /api/admin/settings
+type SettingData struct {
+	Value string `json:"value"`
+	// "default" if it comes from its flag's default, "flag" if the flag was given, or "stored"
+	Source string `json:"source"`
+}
+
+// every setting's current value
+func (s *Settings) list() map[string]SettingData {
+	settings := make(map[string]SettingData)
+	for name := range settingValidators {
+		value, source := s.lookup(name)
+		settings[name] = SettingData{Value: value, Source: source}
+	}
+	return settings
+}
+
+// responds with every setting as json
+func GetSettings(settings *Settings) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		respondSettings(resp, settings)
+	}
+}
+
+// changes the settings in a json object of names and values, all or nothing
+// a null value removes the stored setting, so its flag's value applies again
+func SetSettings(settings *Settings) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		var changes map[string]*string
+		err := json.NewDecoder(req.Body).Decode(&changes)
+		if err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "the body must be a json object of setting names and values")
+			return
+		}
+		if status, code, message := settings.check(changes); status != 0 {
+			APIErrorMessage(resp, status, code, message)
+			return
+		}
+		err = settings.update(changes)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error changing settings: %v", err)
+			return
+		}
+		for name, value := range changes {
+			if value == nil {
+				log.Printf("Reset setting %s", name)
+			} else {
+				log.Printf("Set setting %s to %q", name, *value)
+			}
+		}
+		respondSettings(resp, settings)
+	}
+}
+
+func respondSettings(resp http.ResponseWriter, settings *Settings) {
+	resp.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(resp).Encode(settings.list())
+	if err != nil {
+		log.Printf("responding with settings: %v", err)
+	}
+}
+
+// refuses requests which would change notes while corkboard is read-only
+// the admin api is left alone, so read-only mode can be turned off again
+func guardReadOnly(settings *Settings, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if settings.readOnly() && !strings.HasPrefix(req.URL.Path, "/api/admin/") {
+				discardBody(resp, req)
+				if isAPIRequest(req) {
+					APIError(resp, http.StatusServiceUnavailable, ERR_READ_ONLY)
+				} else {
+					ErrorMessage(resp, http.StatusServiceUnavailable, errorMessages[ERR_READ_ONLY])
+				}
+				return
+			}
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// whether a request is from an admin
+// if there are no admins, everyone who can sign in is one
+func isAdmin(req *http.Request, admins map[string]bool) bool {
+	return len(admins) == 0 || admins[requestUser(req)]
+}
+
+// restricts a handler to admins; see isAdmin
+func AdminOnly(h httprouter.Handle, admins map[string]bool) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if !isAdmin(req, admins) {
+			discardBody(resp, req)
+			APIError(resp, http.StatusForbidden, ERR_FORBIDDEN)
+			return
+		}
+		h(resp, req, params)
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// GET /api/admin/settings, as a map of names to values and sources
+func getSettings(t *testing.T, handler http.Handler) map[string]SettingData {
+	t.Helper()
+	resp := serveRequest(t, handler, http.MethodGet, "/api/admin/settings", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("getting settings: got %d %q", resp.Code, resp.Body)
+	}
+	var settings map[string]SettingData
+	if err := json.Unmarshal(resp.Body.Bytes(), &settings); err != nil {
+		t.Fatalf("the settings %q aren't json: %v", resp.Body, err)
+	}
+	return settings
+}
+
+// settings are changed all or nothing, and removing a stored one brings back its flag's value
+func TestSetSettings(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	for name, setting := range getSettings(t, handler) {
+		if setting.Source != SETTING_FROM_DEFAULT {
+			t.Errorf("%s comes from %q at first, want %q", name, setting.Source, SETTING_FROM_DEFAULT)
+		}
+	}
+	defaultExpiry := getSettings(t, handler)[SETTING_NOTE_EXPIRY].Value
+	steps := []struct {
+		name       string
+		changes    string
+		wantStatus int
+		wantCode   string
+		// the note-expiry setting after the change
+		want SettingData
+	}{
+		{"set", `{"note-expiry":"3"}`, http.StatusOK, "", SettingData{"3", SETTING_FROM_STORED}},
+		{"invalid", `{"note-expiry":"-1"}`, http.StatusBadRequest, ERR_BAD_REQUEST, SettingData{"3", SETTING_FROM_STORED}},
+		{"unknown", `{"colour":"blue"}`, http.StatusBadRequest, ERR_BAD_REQUEST, SettingData{"3", SETTING_FROM_STORED}},
+		{"not json", `note-expiry=4`, http.StatusBadRequest, ERR_BAD_REQUEST, SettingData{"3", SETTING_FROM_STORED}},
+		// the valid change isn't made either
+		{"one of two invalid", `{"note-expiry":"4","recent-notes":"many"}`, http.StatusBadRequest, ERR_BAD_REQUEST, SettingData{"3", SETTING_FROM_STORED}},
+		{"two at once", `{"note-expiry":"5","recent-notes":"2"}`, http.StatusOK, "", SettingData{"5", SETTING_FROM_STORED}},
+		{"reset", `{"note-expiry":null}`, http.StatusOK, "", SettingData{defaultExpiry, SETTING_FROM_DEFAULT}},
+	}
+	for _, step := range steps {
+		resp := serveRequest(t, handler, http.MethodPut, "/api/admin/settings", step.changes)
+		if resp.Code != step.wantStatus {
+			t.Errorf("%s: got %d %q, want %d", step.name, resp.Code, resp.Body, step.wantStatus)
+		} else if step.wantCode != "" && errorCode(t, resp) != step.wantCode {
+			t.Errorf("%s: got %q, want the code %s", step.name, resp.Body, step.wantCode)
+		}
+		if got := getSettings(t, handler)[SETTING_NOTE_EXPIRY]; got != step.want {
+			t.Errorf("%s: note-expiry is %+v, want %+v", step.name, got, step.want)
+		}
+	}
+	if got := getSettings(t, handler)[SETTING_RECENT_NOTES]; got != (SettingData{"2", SETTING_FROM_STORED}) {
+		t.Errorf("recent-notes is %+v, want 2, stored", got)
+	}
+}
+
+// a flag given on the command line wins over a stored setting, which is kept but not used,
+// and can't be changed at runtime
+func TestPinnedSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.db")
+	start := func(args ...string) *App {
+		t.Helper()
+		config := testConfig(t, args...)
+		config.DatabasePath = path
+		config.CreateDB = true
+		app, err := NewApp(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return app
+	}
+	app := start()
+	three, two := "3", "2"
+	if err := app.settings.update(map[string]*string{SETTING_NOTE_EXPIRY: &three, SETTING_RECENT_NOTES: &two}); err != nil {
+		t.Fatal(err)
+	}
+	app.Close()
+
+	app = start("-note-expiry", "9")
+	defer app.Close()
+	handler := app.Router()
+	settings := getSettings(t, handler)
+	if got := settings[SETTING_NOTE_EXPIRY]; got != (SettingData{"9", SETTING_FROM_FLAG}) {
+		t.Errorf("note-expiry is %+v, want the flag's 9", got)
+	}
+	if got := settings[SETTING_RECENT_NOTES]; got != (SettingData{"2", SETTING_FROM_STORED}) {
+		t.Errorf("recent-notes is %+v, want the stored 2", got)
+	}
+	if got := app.settings.noteExpiry(); got != 9*24*time.Hour {
+		t.Errorf("notes expire after %s, want the flag's 9 days", got)
+	}
+	for _, changes := range []string{`{"note-expiry":"1"}`, `{"note-expiry":null}`, `{"note-expiry":"1","recent-notes":"4"}`} {
+		resp := serveRequest(t, handler, http.MethodPut, "/api/admin/settings", changes)
+		if resp.Code != http.StatusConflict || errorCode(t, resp) != ERR_CONFLICT {
+			t.Errorf("%s: got %d %q, want 409", changes, resp.Code, resp.Body)
+		}
+	}
+	if got := app.settings.recentNotes(); got != 2 {
+		t.Errorf("recent-notes was changed to %d along with the pinned note-expiry", got)
+	}
+}
+
+// a change takes effect at once, through the accessors everything reads settings with
+func TestSettingsTakeEffect(t *testing.T) {
+	app, _ := testApp(t, testConfig(t))
+	handler := app.Router()
+	put := func(changes string) {
+		t.Helper()
+		if resp := serveRequest(t, handler, http.MethodPut, "/api/admin/settings", changes); resp.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %q", changes, resp.Code, resp.Body)
+		}
+	}
+	put(`{"note-expiry":"2","unviewed-expiry":"90m","banner":"maintenance tonight"}`)
+	if got := app.settings.expiry(); got.age != 48*time.Hour || got.unviewedAge != 90*time.Minute {
+		t.Errorf("expiry is %s and %s unviewed, want 48h and 90m", got.age, got.unviewedAge)
+	}
+	if got := app.settings.banner(); got != "maintenance tonight" {
+		t.Errorf("the banner is %q", got)
+	}
+
+	put(`{"read-only":"true"}`)
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "milk"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("writing while read-only: got %d, want 503", resp.Code)
+	}
+	// the admin api isn't read-only, so it can be turned off again
+	put(`{"read-only":null}`)
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "milk"); resp.Code != http.StatusCreated {
+		t.Errorf("writing after read-only was reset: got %d, want 201", resp.Code)
+	}
+}
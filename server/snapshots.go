@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// snapshots never change, so they can be cached for as long as browsers and proxies will
+const snapshotCacheControl = "public, max-age=31536000, immutable"
+
+// SnapshotData is a snapshot as listed by the api, with where it's served
+type SnapshotData struct {
+	Snapshot
+	URL string `json:"url"`
+}
+
+// where a snapshot is served, on this corkboard
+func snapshotURL(baseURL string, req *http.Request, hash string) string {
+	return strings.TrimSuffix(requestBaseURL(baseURL, req), "/") + boardPrefix(req) + "/snap/" + hash
+}
+
+// freezes a note's current contents at /snap/:hash, responding with the snapshot as json
+// responds 201 if the snapshot is new, and 200 if the note was already snapshotted with the same contents
+func SnapshotNote(datastore Datastore, baseURL string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		snapshot, status, err := datastore.snapshotNote(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error snapshotting %s: %v", noteName, err)
+			return
+		}
+		if status == NO_NOTE {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		if status == CREATED {
+			log.Printf("Snapshotted note %s as %s", noteName, snapshot.Hash)
+			resp.WriteHeader(http.StatusCreated)
+		}
+		err = json.NewEncoder(resp).Encode(SnapshotData{Snapshot: snapshot, URL: snapshotURL(baseURL, req, snapshot.Hash)})
+		if err != nil {
+			log.Printf("responding with snapshot: %v", err)
+		}
+	}
+}
+
+// lists a note's snapshots as json, newest first, even if the note has since been deleted
+func ListSnapshots(datastore Datastore, baseURL string) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		snapshots, err := datastore.listSnapshots(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing snapshots of %s: %v", noteName, err)
+			return
+		}
+		if len(snapshots) == 0 {
+			exists, err := datastore.noteExists(noteName)
+			if err != nil {
+				APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+				log.Printf("accessing %s: %v", noteName, err)
+				return
+			}
+			if !exists {
+				noteNotFound(resp, req, datastore, noteName)
+				return
+			}
+		}
+		listed := make([]SnapshotData, len(snapshots))
+		for i, snapshot := range snapshots {
+			listed[i] = SnapshotData{Snapshot: snapshot, URL: snapshotURL(baseURL, req, snapshot.Hash)}
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(listed)
+		if err != nil {
+			log.Printf("responding with snapshots: %v", err)
+		}
+	}
+}
+
+// serves a snapshot's contents exactly as they were, for good
+// it's served only for GET and HEAD, so writing to it is refused with 405
+func GetSnapshot(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		hash := strings.ToLower(params.ByName("hash"))
+		if !validHash(hash) {
+			ErrorPage(resp, http.StatusNotFound)
+			return
+		}
+		// the hash is the snapshot, so a client with it cached already has the right contents
+		etag := `"` + hash + `"`
+		resp.Header().Set("ETag", etag)
+		resp.Header().Set("Cache-Control", snapshotCacheControl)
+		if etagMatches(req.Header.Get("If-None-Match"), etag) {
+			resp.WriteHeader(http.StatusNotModified)
+			return
+		}
+		snapshot, ok, err := datastore.getSnapshot(hash)
+		if err != nil {
+			resp.Header().Del("Cache-Control")
+			ErrorPage(resp, http.StatusInternalServerError)
+			log.Printf("accessing snapshot %s: %v", hash, err)
+			return
+		}
+		if !ok {
+			// it may yet be created, or have been purged
+			resp.Header().Del("Cache-Control")
+			resp.Header().Del("ETag")
+			ErrorPage(resp, http.StatusNotFound)
+			return
+		}
+		resp.Header().Set("Content-Type", servedContentType(snapshot.ContentType))
+		resp.Header().Set(hashHeader, hash)
+		sandboxContent(resp)
+		_, err = resp.Write(snapshot.body)
+		if err != nil {
+			log.Printf("responding with snapshot: %v", err)
+		}
+	}
+}
+
+// deletes a snapshot for good, whichever notes it was taken of
+func DeleteSnapshot(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		hash := strings.ToLower(params.ByName("hash"))
+		deleted, err := datastore.deleteSnapshot(hash)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting snapshot %s: %v", hash, err)
+			return
+		}
+		if !deleted {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		log.Printf("Deleted snapshot %s", hash)
+	}
+}
+
+// deletes the snapshots of notes which have been deleted, responding with how many were deleted
+// snapshots outlive their notes until this is done, so it needs ?orphaned=true to be sure it's deliberate
+func PruneSnapshots(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		if req.URL.Query().Get("orphaned") != "true" {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "only snapshots of deleted notes can be pruned; pass ?orphaned=true")
+			return
+		}
+		deleted, err := datastore.pruneOrphanedSnapshots()
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error pruning snapshots: %v", err)
+			return
+		}
+		log.Printf("deleted %d snapshots of deleted notes", deleted)
+		resp.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		fmt.Fprintf(resp, "%d\n", deleted)
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// most of a note's body looked at to guess its content type, in bytes
+const maxSniffSize = 8 << 10
+
+// longest media type a note's content type can be set to
+const maxContentTypeSize = 255
+
+// the byte order mark some editors begin utf-8 files with
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// content types given by the extension at the end of a note's name, like notes.md
+// http.DetectContentType calls all of these text/plain
+var extensionTypes = map[string]string{
+	".json":     "application/json",
+	".yaml":     "application/yaml",
+	".yml":      "application/yaml",
+	".toml":     "application/toml",
+	".csv":      "text/csv; charset=UTF-8",
+	".tsv":      "text/tab-separated-values; charset=UTF-8",
+	".md":       "text/markdown; charset=UTF-8",
+	".markdown": "text/markdown; charset=UTF-8",
+	".xml":      "application/xml",
+	".diff":     "text/x-diff; charset=UTF-8",
+	".patch":    "text/x-diff; charset=UTF-8",
+	".sh":       "application/x-sh",
+	".js":       "application/javascript",
+	".css":      "text/css; charset=UTF-8",
+	".txt":      defaultNoteContentType,
+}
+
+// languages given to highlighters for notes of each media type, unless their meta gives one
+var contentTypeLanguages = map[string]string{
+	"application/json":          "json",
+	"application/yaml":          "yaml",
+	"application/toml":          "toml",
+	"text/csv":                  "csv",
+	"text/tab-separated-values": "tsv",
+	"text/markdown":             "markdown",
+	"application/xml":           "xml",
+	"text/x-diff":               "diff",
+	"application/x-sh":          "bash",
+	"application/javascript":    "javascript",
+	"text/css":                  "css",
+}
+
+// a top-level yaml mapping key, like "name:" or "- name:"
+var yamlKeyPattern = regexp.MustCompile(`^(- )?[A-Za-z_][A-Za-z0-9_.-]*:( |$)`)
+
+// guesses the content type of a note which wasn't uploaded as a file of a known type,
+// returning it as it would be stored, so "" for plain text
+// an extension at the end of the note's name wins; otherwise only the first maxSniffSize bytes of the body are looked at
+func sniffContentType(name string, body []byte) string {
+	if contentType, ok := extensionTypes[strings.ToLower(path.Ext(name))]; ok {
+		return storedContentType(contentType)
+	}
+	prefix := body
+	truncated := len(prefix) > maxSniffSize
+	if truncated {
+		prefix = prefix[:maxSniffSize]
+	}
+	// a bom says the note is utf-8 text, and would trip up the rest
+	text := bytes.TrimPrefix(prefix, utf8BOM)
+	isText := len(text) < len(prefix) || validUTF8Prefix(text, truncated)
+	if !isText {
+		detected := http.DetectContentType(prefix)
+		// html and xml would run in the browser, so notes are never guessed to be them,
+		// and binaries of unknown types are served as they always have been
+		if strings.HasPrefix(detected, "text/") || detected == "application/octet-stream" {
+			return ""
+		}
+		return storedContentType(detected)
+	}
+	switch {
+	case sniffJSON(text, truncated):
+		return "application/json"
+	case sniffYAML(text, truncated):
+		return "application/yaml"
+	case sniffDelimited(text, truncated, ','):
+		return "text/csv; charset=UTF-8"
+	case sniffDelimited(text, truncated, '\t'):
+		return "text/tab-separated-values; charset=UTF-8"
+	}
+	return ""
+}
+
+// whether text is utf-8, allowing for a character cut in half at the end if it was truncated
+func validUTF8Prefix(text []byte, truncated bool) bool {
+	if truncated {
+		for i := 0; i < utf8.UTFMax-1 && len(text) > 0 && !utf8.Valid(text); i++ {
+			text = text[:len(text)-1]
+		}
+	}
+	return utf8.Valid(text) && bytes.IndexByte(text, 0) == -1
+}
+
+// the complete lines of text, leaving out one cut short at the end if it was truncated
+func completeLines(text []byte, truncated bool) []string {
+	if truncated {
+		if end := bytes.LastIndexByte(text, '\n'); end != -1 {
+			text = text[:end]
+		}
+	}
+	return strings.Split(strings.TrimRight(string(text), "\r\n"), "\n")
+}
+
+// whether text is a json object or array, or the start of one if it was truncated
+func sniffJSON(text []byte, truncated bool) bool {
+	trimmed := bytes.TrimSpace(text)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return false
+	}
+	if !truncated {
+		return json.Valid(trimmed)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// whether text looks like a yaml document: it begins with ---,
+// or its unindented lines are all mapping keys, list items or comments, with at least two keys
+func sniffYAML(text []byte, truncated bool) bool {
+	lines := completeLines(text, truncated)
+	keys := 0
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if i == 0 && line == "---" {
+			return len(lines) > 1
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, " ") {
+			continue
+		}
+		if !yamlKeyPattern.MatchString(line) {
+			return false
+		}
+		keys++
+	}
+	return keys >= 2
+}
+
+// whether text is a table of at least two rows and two columns separated by delimiter,
+// with the same number of columns in every row
+func sniffDelimited(text []byte, truncated bool, delimiter rune) bool {
+	lines := completeLines(text, truncated)
+	if len(lines) < 2 {
+		return false
+	}
+	reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+	reader.Comma = delimiter
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil || len(record) < 2 {
+			return false
+		}
+		rows++
+	}
+	return rows >= 2
+}
+
+// the language given to highlighters for a note, from its meta or else its content type
+func noteLanguage(info NoteInfo) string {
+	if language := info.Meta.text(META_LANGUAGE); language != "" {
+		return language
+	}
+	mediaType, _, _ := mime.ParseMediaType(info.ContentType)
+	return contentTypeLanguages[mediaType]
+}
+
+// corrects the content type a note is served as, to the media type in the request body
+// the type is kept until the note's contents are next written, when it's guessed again
+func SetContentType(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		data, err := io.ReadAll(io.LimitReader(req.Body, maxContentTypeSize+1))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error reading request body: %v", err)
+			return
+		}
+		if len(data) > maxContentTypeSize {
+			APIError(resp, http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE)
+			return
+		}
+		contentType := strings.TrimSpace(string(data))
+		mediaType, parameters, err := mime.ParseMediaType(contentType)
+		slash := strings.Index(mediaType, "/")
+		if err != nil || slash <= 0 || slash == len(mediaType)-1 {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, fmt.Sprintf("%q isn't a media type like text/csv", contentType))
+			return
+		}
+		exists, err := datastore.setContentType(noteName, storedContentType(mime.FormatMediaType(mediaType, parameters)))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error setting content type of %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		log.Printf("Set content type of note %s to %s", noteName, mediaType)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSniffContentType(t *testing.T) {
+	bom := string(utf8BOM)
+	// a json array whose first maxSniffSize bytes end halfway through an é
+	cutJSON := "[    " + strings.Repeat(`"é",`, 2000) + `"é"]`
+	if utf8.ValidString(cutJSON[:maxSniffSize]) {
+		t.Fatal("the json isn't cut in the middle of a character")
+	}
+	cases := []struct {
+		name string
+		note string
+		body string
+		want string
+	}{
+		{"plain text", "todo", "milk\neggs\n", ""},
+		{"empty", "todo", "", ""},
+		{"a bom", "todo", bom + "milk\neggs\n", ""},
+		{"json after a bom", "todo", bom + `{"milk": 2}`, "application/json"},
+		{"a json object", "todo", `{"milk": 2, "eggs": [1, 2]}`, "application/json"},
+		{"a json array", "todo", "  [1, 2, 3]\n", "application/json"},
+		{"broken json", "todo", `{"milk": 2,`, ""},
+		{"a json string", "todo", `"milk"`, ""},
+		{"yaml", "todo", "name: corkboard\nversion: 2\n# a comment\nitems:\n  - milk\n", "application/yaml"},
+		{"a yaml document", "todo", "---\nmilk\n", "application/yaml"},
+		{"yaml with one key", "todo", "name: corkboard\n", ""},
+		{"a sentence with a colon", "todo", "Note: buy milk\nand eggs\n", ""},
+		{"csv", "todo", "item,count\nmilk,2\neggs,12\n", "text/csv; charset=UTF-8"},
+		{"quoted csv", "todo", "item,note\nmilk,\"semi-skimmed, 2l\"\n", "text/csv; charset=UTF-8"},
+		{"ragged csv", "todo", "item,count\nmilk,2,extra\n", ""},
+		{"one row of csv", "todo", "milk,eggs\n", ""},
+		{"tsv", "todo", "item\tcount\nmilk\t2\n", "text/tab-separated-values; charset=UTF-8"},
+		{"a png", "todo", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+		{"a pdf", "todo", "%PDF-1.7\n\x00\xff", "application/pdf"},
+		// html is never guessed, since it would run in the browser
+		{"html", "todo", "<!DOCTYPE html><html><script>alert(1)</script></html>", ""},
+		{"unknown binary", "todo", "\x00\x01\x02\xff\xfe", ""},
+		// an extension wins over the body
+		{"markdown by name", "readme.md", `{"milk": 2}`, "text/markdown; charset=UTF-8"},
+		{"json by name", "config.JSON", "not json at all", "application/json"},
+		{"text by name", "list.txt", "item,count\nmilk,2\n", ""},
+		{"an extension in a folder", "docs/notes.yml", "milk", "application/yaml"},
+		{"an unknown extension", "photo.heic", "item,count\nmilk,2\n", "text/csv; charset=UTF-8"},
+		// only the first maxSniffSize bytes are looked at
+		{"json cut short", "todo", cutJSON, "application/json"},
+		{"csv cut short", "todo", "item,count\n" + strings.Repeat("milk,2\n", maxSniffSize/7+100), "text/csv; charset=UTF-8"},
+		{"a png past the prefix", "todo", strings.Repeat("milk\n", maxSniffSize/5+1) + "\x89PNG\r\n\x1a\n\x00", ""},
+		{"yaml past the prefix", "todo", strings.Repeat("milk and eggs\n", maxSniffSize/14+1) + "name: x\nversion: 2\n", ""},
+		{"binary past the prefix", "todo", strings.Repeat("a,b\n", maxSniffSize/4+1) + "\x00\xff", "text/csv; charset=UTF-8"},
+	}
+	for _, c := range cases {
+		if got := sniffContentType(c.note, []byte(c.body)); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// PUT /api/note/:note/content-type corrects a guess until the note is next written
+func TestSetContentType(t *testing.T) {
+	handler := testServer(t, testConfig(t)).Config.Handler
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "item,count\nmilk,2\n"); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", resp.Code)
+	}
+	servedAs := func() string {
+		return serveRequest(t, handler, http.MethodGet, "/api/note/todo", "").Header().Get("Content-Type")
+	}
+	if got := servedAs(); got != "text/csv; charset=UTF-8" {
+		t.Fatalf("todo is served as %q, want it guessed to be csv", got)
+	}
+	cases := []struct {
+		name        string
+		contentType string
+		wantStatus  int
+		wantCode    string
+		// what the note is then served as
+		want string
+	}{
+		{"a media type", "application/yaml", http.StatusOK, "", "application/yaml"},
+		{"with parameters", "text/csv; charset=utf-8; header=present", http.StatusOK, "", "text/csv; charset=utf-8; header=present"},
+		{"surrounded by space", " text/x-diff \n", http.StatusOK, "", "text/x-diff"},
+		{"no subtype", "text/", http.StatusBadRequest, ERR_BAD_REQUEST, "text/x-diff"},
+		{"no slash", "csv", http.StatusBadRequest, ERR_BAD_REQUEST, "text/x-diff"},
+		{"a bad parameter", "text/csv; charset", http.StatusBadRequest, ERR_BAD_REQUEST, "text/x-diff"},
+		{"empty", "", http.StatusBadRequest, ERR_BAD_REQUEST, "text/x-diff"},
+		{"too long", "text/" + strings.Repeat("x", maxContentTypeSize), http.StatusRequestEntityTooLarge, ERR_PAYLOAD_TOO_LARGE, "text/x-diff"},
+		{"plain text", "text/plain; charset=UTF-8", http.StatusOK, "", defaultNoteContentType},
+	}
+	for _, c := range cases {
+		resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo/content-type", c.contentType)
+		if resp.Code != c.wantStatus {
+			t.Errorf("%s: got %d %q, want %d", c.name, resp.Code, resp.Body, c.wantStatus)
+		} else if c.wantCode != "" && errorCode(t, resp) != c.wantCode {
+			t.Errorf("%s: got %q, want the code %s", c.name, resp.Body, c.wantCode)
+		}
+		if got := servedAs(); got != c.want {
+			t.Errorf("%s: todo is served as %q, want %q", c.name, got, c.want)
+		}
+	}
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/missing/content-type", "text/csv"); resp.Code != http.StatusNotFound {
+		t.Errorf("a missing note: got %d, want 404", resp.Code)
+	}
+
+	// writing the note guesses again
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo/content-type", "application/yaml"); resp.Code != http.StatusOK {
+		t.Fatalf("setting the content type: got %d", resp.Code)
+	}
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", `{"milk": 2}`); resp.Code != http.StatusOK {
+		t.Fatalf("changing todo: got %d", resp.Code)
+	}
+	if got := servedAs(); got != "application/json" {
+		t.Errorf("after changing todo, it's served as %q, want it guessed again", got)
+	}
+}
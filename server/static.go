@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/julienschmidt/httprouter"
+)
+
+// content encodings for static assets, from most to least preferred
+var staticEncodings = []string{"br", "gzip"}
+
+// a static file, compressed ahead of time
+type staticAsset struct {
+	contentType string
+	// hash of the uncompressed file
+	hash string
+	// the file in each encoding which made it smaller, and "identity", uncompressed
+	encodings map[string][]byte
+}
+
+// StaticAssets serves static files compressed once at startup,
+// so requests don't spend any time compressing them
+type StaticAssets struct {
+	assets map[string]*staticAsset
+	// files provided by the operator to restyle pages, by the path they're served at
+	custom map[string]string
+	// whether custom files are reread on every request, so edits show up without restarting
+	reload bool
+}
+
+// reads and compresses every file in a filesystem
+func loadStaticAssets(static fs.FS) (*StaticAssets, error) {
+	assets := make(map[string]*staticAsset)
+	err := fs.WalkDir(static, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		body, err := fs.ReadFile(static, name)
+		if err != nil {
+			return err
+		}
+		asset, err := newStaticAsset(name, body)
+		if err != nil {
+			return err
+		}
+		assets["/"+name] = asset
+		return nil
+	})
+	return &StaticAssets{assets: assets, custom: make(map[string]string)}, err
+}
+
+// compresses a file ahead of time, guessing its content type from its name
+func newStaticAsset(name string, body []byte) (*staticAsset, error) {
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	sum := sha256.Sum256(body)
+	asset := &staticAsset{
+		contentType: contentType,
+		hash:        hex.EncodeToString(sum[:8]),
+		encodings:   map[string][]byte{"identity": body},
+	}
+	for _, encoding := range staticEncodings {
+		compressed, err := compressAsset(encoding, body)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(body) {
+			asset.encodings[encoding] = compressed
+		}
+	}
+	return asset, nil
+}
+
+// serves a file from disk at /static/name, alongside the built-in files
+func (s *StaticAssets) addCustom(name string, file string) error {
+	asset, err := loadCustomAsset(name, file)
+	if err != nil {
+		return err
+	}
+	s.assets["/"+name] = asset
+	s.custom["/"+name] = file
+	return nil
+}
+
+func loadCustomAsset(name string, file string) (*staticAsset, error) {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return newStaticAsset(name, body)
+}
+
+// whether a custom file is served at /static/name
+func (s *StaticAssets) hasCustom(name string) bool {
+	_, ok := s.custom["/"+name]
+	return ok
+}
+
+// compresses a file with the given content encoding
+func compressAsset(encoding string, body []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	var err error
+	switch encoding {
+	case "br":
+		writer := brotli.NewWriterLevel(buf, brotli.BestCompression)
+		_, err = writer.Write(body)
+		if err == nil {
+			err = writer.Close()
+		}
+	case "gzip":
+		var writer *gzip.Writer
+		writer, err = gzip.NewWriterLevel(buf, gzip.BestCompression)
+		if err == nil {
+			_, err = writer.Write(body)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+	}
+	return buf.Bytes(), err
+}
+
+// picks the most preferred encoding which the client accepts and the asset has
+// falls back to no encoding, which every client understands
+func (a *staticAsset) chooseEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		refused := false
+		for _, param := range fields[1:] {
+			param = strings.ReplaceAll(param, " ", "")
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				refused = true
+			}
+		}
+		accepted[coding] = !refused
+	}
+	for _, encoding := range staticEncodings {
+		ok, listed := accepted[encoding]
+		if !listed {
+			ok = accepted["*"]
+		}
+		if _, has := a.encodings[encoding]; has && ok {
+			return encoding
+		}
+	}
+	return "identity"
+}
+
+// serves a static file in the best encoding the client accepts
+func (s *StaticAssets) handle(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	name := path.Clean(params.ByName("filepath"))
+	asset, ok := s.assets[name]
+	if !ok {
+		ErrorPage(resp, http.StatusNotFound)
+		return
+	}
+	file, custom := s.custom[name]
+	if custom && s.reload {
+		// if the file can't be read, say because an editor is midway through saving it, keep serving the version read at startup
+		fresh, err := loadCustomAsset(name, file)
+		if err != nil {
+			log.Printf("reloading %s: %v", file, err)
+		} else {
+			asset = fresh
+		}
+	}
+	encoding := asset.chooseEncoding(req.Header.Get("Accept-Encoding"))
+	body := asset.encodings[encoding]
+	// each encoding is a different representation, so needs its own etag
+	etag := `"` + asset.hash + `-` + encoding + `"`
+	header := resp.Header()
+	header.Set("Vary", "Accept-Encoding")
+	header.Set("ETag", etag)
+	if custom {
+		// the file can change on disk under the same url, so browsers must check it's still current
+		header.Set("Cache-Control", "no-cache")
+	}
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		resp.WriteHeader(http.StatusNotModified)
+		return
+	}
+	header.Set("Content-Type", asset.contentType)
+	if encoding != "identity" {
+		header.Set("Content-Encoding", encoding)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	if req.Method == http.MethodHead {
+		return
+	}
+	resp.Write(body)
+}
@@ -0,0 +1,12 @@
+// the operator's banner can be dismissed; it stays hidden until its text changes
+(() => {
+    let announcement = document.getElementById("announcement");
+    let text = announcement.firstChild.textContent.trim();
+    if (sessionStorage.getItem("dismissed-banner") == text) {
+        announcement.hidden = true;
+    }
+    document.getElementById("dismiss-announcement").addEventListener("click", () => {
+        sessionStorage.setItem("dismissed-banner", text);
+        announcement.hidden = true;
+    });
+})();
@@ -0,0 +1,99 @@
+document.addEventListener("DOMContentLoaded", () => {
+    // the path of the board this page belongs to, which every link is under
+    let base = document.body.dataset.base;
+    let titleArea = document.getElementById("title");
+    let noteTitleArea = document.getElementById("noteTitle");
+    let bodyArea = document.getElementById("body");
+    let submitButton = document.getElementById("submit");
+    let statusArea = document.getElementById("status");
+    let templateSelect = document.getElementById("template");
+    statusArea.textContent = "";
+
+    submitButton.addEventListener("click", event => {
+        event.preventDefault();
+        let title = titleArea.value;
+        let body = bodyArea.value;
+        let url = `${base}/api/note/${encodeURIComponent(title)}`;
+        let query = [];
+        if (templateSelect && templateSelect.value) {
+            // the template provides the body
+            url = `${base}/api/note/${encodeURIComponent(title)}/from-template`;
+            query.push(`template=${encodeURIComponent(templateSelect.value)}`);
+            body = "";
+        }
+        if (noteTitleArea.value) {
+            // as a query parameter, since headers can't hold every character
+            query.push(`title=${encodeURIComponent(noteTitleArea.value)}`);
+        }
+        if (query.length > 0) {
+            url += "?" + query.join("&");
+        }
+        fetch(url, {
+            method: "POST",
+            cache: "no-cache",
+            headers: {
+                "Content-Type": "application/octet-stream",
+            },
+            redirect: "follow",
+            body: body,
+        }).then(resp => {
+            if (resp.status == 201 && resp.headers.get("Content-Type") == "application/json") {
+                // the note has the same contents as another
+                resp.json().then(data => showDuplicate(true, data.duplicateOf));
+            } else if (resp.ok) {
+                statusArea.textContent = "";
+                titleArea.value = "";
+                noteTitleArea.value = "";
+                bodyArea.value = "";
+                window.location.reload(true);
+            } else if (resp.headers.get("Content-Type") == "application/json") {
+                resp.json().then(data => showError(data.error));
+            } else {
+                statusArea.textContent = "Unknown server error";
+            }
+        })
+    });
+
+    function showError(error) {
+        if (error.code == "note_exists") {
+            statusArea.textContent = "That note already exists!";
+        } else if (error.code == "duplicate") {
+            showDuplicate(false, error.duplicateOf);
+        } else if (error.code == "template_not_found") {
+            statusArea.textContent = "That template doesn't exist!";
+        } else if (error.code == "unauthorized") {
+            statusArea.textContent = "Authorization error. Try reloading the page.";
+        } else if (error.status == 400) {
+            // the server explains what was wrong
+            statusArea.textContent = error.message;
+        } else {
+            statusArea.textContent = "Unknown server error";
+        }
+    }
+
+    // the path of a note's page, escaped as the server escapes it, so the link isn't redirected
+    function notePath(name) {
+        if (name == "." || name == "..") {
+            return `${base}/note/${"%2E".repeat(name.length)}`;
+        }
+        // each part of a name like "ci/build" is escaped on its own, keeping the /s
+        let path = name.split("/").map(part => encodeURIComponent(part)
+            .replace(/[!'()*]/g, c => "%" + c.charCodeAt(0).toString(16).toUpperCase())
+            .replace(/%(24|26|2B|3A|3D|40)/g, (_, hex) => String.fromCharCode(parseInt(hex, 16)))).join("/");
+        return `${base}/note/${path}`;
+    }
+
+    function showDuplicate(created, duplicateOf) {
+        let link = document.createElement("a");
+        link.href = notePath(duplicateOf);
+        link.textContent = duplicateOf;
+        if (created) {
+            statusArea.replaceChildren("Note created, but it's the same as ", link, ".");
+            titleArea.value = "";
+            noteTitleArea.value = "";
+            bodyArea.value = "";
+        } else {
+            statusArea.replaceChildren("That's the same as ", link, "!");
+        }
+    }
+});
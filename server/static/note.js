@@ -0,0 +1,177 @@
+function copyToClipboard(str) {
+    const el = document.createElement('textarea');
+    el.value = str;
+    document.body.appendChild(el);
+    el.select();
+    document.execCommand('copy');
+    document.body.removeChild(el);
+};
+
+// highlights the lines named by a fragment like #L10 or #L10-L20, and scrolls to them
+function highlightLines() {
+    document.querySelectorAll(".line.highlight").forEach(line => line.classList.remove("highlight"));
+    let match = window.location.hash.match(/^#L(\d+)(?:-L(\d+))?$/);
+    if (!match) {
+        return;
+    }
+    let from = parseInt(match[1]);
+    let to = match[2] ? parseInt(match[2]) : from;
+    for (let number = from; number <= to; number++) {
+        let line = document.getElementById(`L${number}`);
+        if (line) {
+            line.classList.add("highlight");
+        }
+    }
+    let first = document.getElementById(`L${from}`);
+    if (first) {
+        first.scrollIntoView();
+    }
+}
+
+window.addEventListener("hashchange", highlightLines);
+
+document.addEventListener("DOMContentLoaded", () => {
+    highlightLines();
+    // the path of the board this page belongs to, which every link is under
+    let base = document.body.dataset.base;
+    let deleteButton = document.getElementById("delete");
+    let copyButton = document.getElementById("copy");
+    let noteArea = document.getElementById("note");
+
+    deleteButton.addEventListener("click", event => {
+        event.preventDefault();
+        let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+        if (window.confirm("Are you sure you want to delete this note?")) {
+            fetch(`${base}/api/note/${noteName}`, {
+                method: "DELETE",
+                cache: "no-cache",
+                redirect: "follow",
+            }).then(resp => {
+                if (resp.ok) {
+                    window.location = `${base}/`
+                }
+            });
+        }
+    });
+
+    let editButton = document.getElementById("edit");
+    let editor = document.getElementById("editor");
+    let editBody = document.getElementById("editBody");
+    let editTitle = document.getElementById("editTitle");
+    let statusArea = document.getElementById("status");
+    let renewal = null;
+
+    // takes the note's lock, asking before overriding someone else's
+    let lock = force => {
+        let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+        return fetch(`${base}/api/note/${noteName}/lock${force ? "?force=true" : ""}`, {
+            method: "POST",
+            cache: "no-cache",
+        }).then(resp => {
+            if (resp.status == 409) {
+                return resp.json().then(held => {
+                    let minutes = Math.floor((Date.now() - Date.parse(held.acquired)) / 60000);
+                    return window.confirm(`Locked by ${held.holder} ${minutes} minutes ago. Edit anyway?`)
+                        && lock(true);
+                });
+            }
+            return resp.ok;
+        });
+    };
+
+    let unlock = () => {
+        let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+        clearInterval(renewal);
+        return fetch(`${base}/api/note/${noteName}/lock`, {
+            method: "DELETE",
+            cache: "no-cache",
+        });
+    };
+
+    editButton.addEventListener("click", event => {
+        event.preventDefault();
+        let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+        lock(false).then(locked => {
+            if (!locked) {
+                return;
+            }
+            // keep the lock while the editor is open
+            renewal = setInterval(() => lock(false), 60000);
+            return fetch(`${base}/api/note/${noteName}`, { cache: "no-cache" })
+                .then(resp => resp.text())
+                .then(body => {
+                    editBody.value = body;
+                    noteArea.hidden = true;
+                    editor.hidden = false;
+                });
+        });
+    });
+
+    document.getElementById("save").addEventListener("click", event => {
+        event.preventDefault();
+        let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+        // emptying a note in the editor is deliberate
+        fetch(`${base}/api/note/${noteName}?allow-empty=true`, {
+            method: "PUT",
+            cache: "no-cache",
+            headers: {
+                "Content-Type": "application/octet-stream",
+            },
+            body: editBody.value,
+        }).then(resp => {
+            if (resp.ok && editTitle.value != editTitle.defaultValue) {
+                return fetch(`${base}/api/note/${noteName}/metadata`, {
+                    method: "PATCH",
+                    cache: "no-cache",
+                    headers: {
+                        "Content-Type": "application/json",
+                    },
+                    body: JSON.stringify({ title: editTitle.value }),
+                });
+            }
+            return resp;
+        }).then(resp => {
+            if (resp.ok) {
+                unlock().then(() => window.location.reload(true));
+            } else if (resp.headers.get("Content-Type") == "application/json") {
+                // the server explains what was wrong
+                resp.json().then(data => statusArea.textContent = data.error.message);
+            } else {
+                statusArea.textContent = "Unknown server error";
+            }
+        });
+    });
+
+    document.getElementById("cancel").addEventListener("click", event => {
+        event.preventDefault();
+        unlock().then(() => window.location.reload(true));
+    });
+
+    for (let button of document.getElementsByClassName("deleteComment")) {
+        button.addEventListener("click", event => {
+            event.preventDefault();
+            let noteName = encodeURIComponent(document.getElementById("noteName").dataset.name);
+            if (!window.confirm("Are you sure you want to delete this comment?")) {
+                return;
+            }
+            fetch(`${base}/api/note/${noteName}/comments/${button.dataset.id}`, {
+                method: "DELETE",
+                cache: "no-cache",
+            }).then(resp => {
+                if (resp.ok) {
+                    window.location.reload(true);
+                } else if (resp.status == 403) {
+                    window.alert("You can only delete your own comments.");
+                }
+            });
+        });
+    }
+
+    copyButton.addEventListener("click", event => {
+        event.preventDefault();
+        copyToClipboard(noteArea.textContent);
+        let previousContent = copyButton.textContent;
+        copyButton.textContent = "Copied!";
+        setTimeout(() => copyButton.textContent = previousContent, 1500);
+    });
+});
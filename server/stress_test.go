@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// hundreds of clients reading and writing at once never see the database locked
+// every write goes through one connection, so none of them should fail, and reads don't wait for writes
+func TestConcurrentRequests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the stress test is slow")
+	}
+	s := servertest.New(t, servertest.Config(t))
+	const clients = 300
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/api/note/shared", "overwritten"},
+		{http.MethodGet, "/api/note/shared", ""},
+		{http.MethodPost, "/api/note/own-%d", "created"},
+		{http.MethodGet, "/api/note/own-%d", ""},
+		{http.MethodPost, "/api/note/counter/increment", ""},
+		{http.MethodGet, "/api/note/shared/metadata", ""},
+		{http.MethodDelete, "/api/note/own-%d", ""},
+		{http.MethodGet, "/", ""},
+		{http.MethodGet, "/api/notes", ""},
+	}
+	if status, body := s.Do(t, http.MethodPut, "/api/note/counter", "0"); status != http.StatusCreated {
+		t.Fatalf("creating the counter: got %d %q", status, body)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	failures := []string{}
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, r := range requests {
+				path := r.path
+				if path == "/api/note/own-%d" {
+					path = fmt.Sprintf(path, i)
+				}
+				// not s.Do, which can't fail the test from another goroutine
+				failure := ""
+				req, err := http.NewRequest(r.method, s.URL+path, strings.NewReader(r.body))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp, err := s.Client().Do(req)
+				if err != nil {
+					failure = fmt.Sprintf("%s %s: %v", r.method, path, err)
+				} else {
+					body, _ := io.ReadAll(resp.Body)
+					resp.Body.Close()
+					if resp.StatusCode >= 500 {
+						failure = fmt.Sprintf("%s %s: %d %q", r.method, path, resp.StatusCode, body)
+					}
+				}
+				if failure != "" {
+					lock.Lock()
+					failures = append(failures, failure)
+					lock.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, failure := range failures {
+		t.Error(failure)
+	}
+	if status, body := s.Do(t, http.MethodGet, "/api/note/counter", ""); body != fmt.Sprint(clients) {
+		t.Errorf("the counter: got %d %q, want %d, one for each client", status, body, clients)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the lines of a summary of what a corkboard has enabled, logged at startup and printed by "corkboard doctor"
+// schema is the last migration applied to the database, or "" if it isn't known
+// secrets are never in it: users are only counted, and only the hosts of urls which may hold tokens are given
+func configSummary(config Config, expiry ExpiryConfig, schema string) []string {
+	lines := []string{}
+	add := func(topic string, format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf("%-13s ", topic+":")+fmt.Sprintf(format, args...))
+	}
+
+	listen := fmt.Sprintf(":%d, plain http", config.Port)
+	if config.TCPPaste.Port != 0 {
+		listen += fmt.Sprintf("; tcp pastes on :%d", config.TCPPaste.Port)
+	}
+	add("listen", "%s", listen)
+	// corkboard doesn't terminate tls itself
+	tls := "none; serve https from a reverse proxy"
+	if strings.HasPrefix(config.BaseURL, "https://") {
+		tls = "none here; -base-url is https, so presumably a reverse proxy serves it"
+	}
+	add("tls", "%s", tls)
+	if config.BaseURL != "" {
+		add("base url", "%s", redactURL(config.BaseURL))
+	}
+
+	if config.Credentials == nil {
+		add("auth", "off; anyone who can reach corkboard may read and write every note")
+	} else {
+		auth := []string{fmt.Sprintf("basic, %s", plural(countUsers(config.Credentials), "user"))}
+		if config.Admins != nil {
+			auth = append(auth, fmt.Sprintf("%s with -admins", plural(len(config.Admins), "admin")))
+		}
+		if config.ACL != nil {
+			auth = append(auth, fmt.Sprintf("-acl-file of %s", plural(len(config.ACL.rules), "rule")))
+		}
+		if config.PrivateNotes {
+			auth = append(auth, "-private-notes")
+		}
+		add("auth", "%s", strings.Join(auth, ", "))
+	}
+	read := "no; reading notes requires signing in"
+	if config.Credentials == nil {
+		read = "yes"
+	}
+	if config.ReadOnly {
+		read += "; -read-only refuses every change"
+	}
+	add("public read", "%s", read)
+
+	path, err := filepath.Abs(config.DatabasePath)
+	if err != nil {
+		path = config.DatabasePath
+	}
+	storage := "sqlite at " + path
+	if config.Dedupe {
+		storage += ", deduplicated"
+	}
+	if schema != "" {
+		storage += ", schema " + schema
+	}
+	add("storage", "%s", storage)
+	if len(config.Boards) > 0 {
+		names := []string{}
+		for _, board := range config.Boards {
+			names = append(names, board.name)
+		}
+		add("boards", "%s, each with its own database", strings.Join(names, ", "))
+	}
+
+	add("expiry", "%s; cleanup every %s", describeExpiry(expiry), shortDuration(cleanupInterval))
+
+	limits := []string{fmt.Sprintf("%s decompressed per upload", byteSize(config.WritePolicy.MaxDecompressedSize))}
+	if config.WritePolicy.MaxNoteSize != 0 {
+		limits = append(limits, fmt.Sprintf("%s per note", byteSize(config.WritePolicy.MaxNoteSize)))
+	}
+	if config.WritePolicy.Quota != 0 {
+		limits = append(limits, fmt.Sprintf("%s quota", byteSize(config.WritePolicy.Quota)))
+	}
+	if config.HTMLMaxSize != 0 {
+		limits = append(limits, fmt.Sprintf("%s shown per page", byteSize(int64(config.HTMLMaxSize))))
+	}
+	if config.MaxConcurrentWrites != 0 {
+		limits = append(limits, fmt.Sprintf("%d writes at once", config.MaxConcurrentWrites))
+	}
+	if config.RateLimits.ReadRate != 0 {
+		limits = append(limits, fmt.Sprintf("%g reads/s", config.RateLimits.ReadRate))
+	}
+	if config.RateLimits.WriteRate != 0 {
+		limits = append(limits, fmt.Sprintf("%g writes/s", config.RateLimits.WriteRate))
+	}
+	if config.TCPPaste.Port != 0 {
+		limits = append(limits, fmt.Sprintf("%s per tcp paste", byteSize(int64(config.TCPPaste.MaxSize))))
+	}
+	add("limits", "%s", strings.Join(limits, ", "))
+
+	integrations := []string{"metrics at /metrics", "feed at /feed.json", "watches"}
+	if config.StatsWindow != 0 {
+		integrations = append(integrations, "request stats over "+shortDuration(config.StatsWindow)+" at /api/stats")
+	}
+	if config.Notify.SlackWebhook != "" {
+		integrations = append(integrations, "slack notifications")
+	}
+	if config.Notify.MatrixServer != "" {
+		integrations = append(integrations, "matrix notifications to "+redactURL(config.Notify.MatrixServer))
+	}
+	if config.MirrorURL != "" {
+		integrations = append(integrations, "mirror to "+redactURL(config.MirrorURL))
+	}
+	if config.Analytics {
+		integrations = append(integrations, "analytics")
+	}
+	if config.LinkCheck.Interval != 0 {
+		integrations = append(integrations, "link checks every "+shortDuration(config.LinkCheck.Interval))
+	}
+	if config.Digest.schedule != nil {
+		integrations = append(integrations, "digests")
+	}
+	if config.ScheduleFile != "" {
+		integrations = append(integrations, plural(len(config.Schedule), "scheduled note"))
+	}
+	if config.ReplicaDir != "" {
+		integrations = append(integrations, "replicas every "+shortDuration(config.ReplicaInterval))
+	}
+	if config.ArchiveDir != "" {
+		integrations = append(integrations, "archive of expired notes")
+	}
+	if config.VacuumInterval != 0 {
+		integrations = append(integrations, "vacuum every "+shortDuration(config.VacuumInterval))
+	}
+	if config.Hooks != nil && len(config.Hooks.writes)+len(config.Hooks.retention) > 0 {
+		integrations = append(integrations, plural(len(config.Hooks.writes), "write hook"), plural(len(config.Hooks.retention), "retention hook"))
+	}
+	add("integrations", "%s", strings.Join(integrations, ", "))
+	return lines
+}
+
+// when notes expire, like "7d after they're last viewed; 2 -retention rules"
+func describeExpiry(expiry ExpiryConfig) string {
+	parts := []string{}
+	if expiry.age == 0 {
+		parts = append(parts, "notes never expire")
+	} else if expiry.policy == EXPIRE_CREATED {
+		parts = append(parts, shortDuration(expiry.age)+" after they're created")
+	} else {
+		parts = append(parts, shortDuration(expiry.age)+" after they're last viewed")
+	}
+	if expiry.unviewedAge != 0 {
+		parts = append(parts, "unviewed notes after "+shortDuration(expiry.unviewedAge))
+	}
+	if len(expiry.retention) > 0 {
+		parts = append(parts, plural(len(expiry.retention), "-retention rule"))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// the number of distinct users with credentials, some of whom may have several passwords
+func countUsers(credentials map[string]bool) int {
+	users := map[string]bool{}
+	for credential := range credentials {
+		users[strings.SplitN(credential, ":", 2)[0]] = true
+	}
+	return len(users)
+}
+
+// only the scheme and host of a url, since the rest, like a Slack webhook's path, may be a secret
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return "(unparseable url)"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// like "1 user" or "3 users"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// a duration in the largest whole unit, like "7d" or "36h", rather than "168h0m0s"
+func shortDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d >= day && d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// a number of bytes in the largest whole binary unit, like "32MiB"
+func byteSize(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for i < len(units)-1 && n >= 1024 && n%1024 == 0 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%d%s", n, units[i])
+}
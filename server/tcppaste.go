@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// a tcp paste must finish within this long, however often it sends data
+const tcpPasteMaxDuration = time.Minute
+
+// TCPPasteConfig stores the settings for the netcat-style paste listener
+type TCPPasteConfig struct {
+	Port        int
+	IdleTimeout time.Duration
+	MaxSize     int
+	MaxConns    int
+	Token       string
+	Allowed     []*net.IPNet
+	Unlisted    bool
+}
+
+// parses a comma-separated list of IP addresses and CIDR ranges
+func parseAllowList(list string) ([]*net.IPNet, error) {
+	allowed := []*net.IPNet{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			allowed = append(allowed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q", entry)
+		}
+		allowed = append(allowed, ipNet)
+	}
+	return allowed, nil
+}
+
+// accepts pastes over plain tcp, like termbin
+// each connection is read until EOF, the idle timeout, or the size cap,
+// stored as a note with a generated name, and answered with the note's url
+func serveTCPPaste(listener net.Listener, datastore Datastore, names NameGenerator, config TCPPasteConfig, baseURL string, listeners Listeners, settings *Settings) error {
+	// limits the number of connections handled at once
+	slots := make(chan struct{}, config.MaxConns)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		select {
+		case slots <- struct{}{}:
+			go func() {
+				defer func() { <-slots }()
+				handleTCPPaste(conn, datastore, names, config, baseURL, listeners, settings)
+			}()
+		default:
+			conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+			fmt.Fprintln(conn, "error: too many connections, try again later")
+			conn.Close()
+		}
+	}
+}
+
+// handles a single tcp paste connection
+func handleTCPPaste(conn net.Conn, datastore Datastore, names NameGenerator, config TCPPasteConfig, baseURL string, listeners Listeners, settings *Settings) {
+	defer conn.Close()
+	remote := conn.RemoteAddr().String()
+
+	if len(config.Allowed) > 0 && !addrAllowed(conn.RemoteAddr(), config.Allowed) {
+		log.Printf("rejected tcp paste from %s: address not allowed", remote)
+		return
+	}
+
+	body, err := readTCPPaste(conn, config)
+	if err != nil {
+		log.Printf("reading tcp paste from %s: %v", remote, err)
+		conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+
+	if config.Token != "" {
+		// the first line of the paste must be the token, and is not stored
+		line := body
+		rest := []byte{}
+		if i := bytes.IndexByte(body, '\n'); i >= 0 {
+			line, rest = body[:i], body[i+1:]
+		}
+		line = bytes.TrimRight(line, "\r")
+		if subtle.ConstantTimeCompare(line, []byte(config.Token)) != 1 {
+			log.Printf("rejected tcp paste from %s: bad token", remote)
+			conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+			fmt.Fprintln(conn, "error: unauthorized")
+			return
+		}
+		body = rest
+	}
+
+	if settings.readOnly() {
+		log.Printf("rejected tcp paste from %s: corkboard is read-only", remote)
+		conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+		fmt.Fprintln(conn, "error: "+errorMessages[ERR_READ_ONLY])
+		return
+	}
+
+	if emptyBody(body) {
+		log.Printf("rejected tcp paste from %s: paste is empty", remote)
+		conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+		fmt.Fprintln(conn, "error: paste is empty")
+		return
+	}
+
+	noteName, err := createNoteWithRandomName(datastore, names, body, NoteOptions{Unlisted: config.Unlisted, ContentType: sniffContentType("", body)})
+	if message, ok := rejectionMessage(err); ok {
+		log.Printf("rejected tcp paste from %s: %s", remote, message)
+		conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+		fmt.Fprintln(conn, "error: "+message)
+		return
+	}
+	if err != nil {
+		log.Printf("error writing tcp paste from %s: %v", remote, err)
+		conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+		fmt.Fprintln(conn, "error: unable to save paste")
+		return
+	}
+	listeners.publish(NoteEvent{Action: NOTE_CREATED, Name: noteName, Size: len(body)})
+	log.Printf("New note %s", noteName)
+	conn.SetWriteDeadline(time.Now().Add(config.IdleTimeout))
+	fmt.Fprintln(conn, noteURL(baseURL, noteName))
+}
+
+// reads a paste until EOF or until the connection has been idle for too long
+// clients like netcat often don't close their end, so idling counts as the end of the paste
+func readTCPPaste(conn net.Conn, config TCPPasteConfig) ([]byte, error) {
+	body := bytes.NewBuffer(nil)
+	buf := make([]byte, 4096)
+	hardDeadline := time.Now().Add(tcpPasteMaxDuration)
+	for {
+		deadline := time.Now().Add(config.IdleTimeout)
+		if deadline.After(hardDeadline) {
+			deadline = hardDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		body.Write(buf[:n])
+		if body.Len() > config.MaxSize {
+			return nil, fmt.Errorf("paste is larger than %d bytes", config.MaxSize)
+		}
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) || err == io.EOF {
+				return body.Bytes(), nil
+			}
+			return nil, err
+		}
+	}
+}
+
+// checks whether a remote address is in any of the allowed ranges
+func addrAllowed(addr net.Addr, allowed []*net.IPNet) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range allowed {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	texttemplate "text/template"
+)
+
+// parses the embedded templates, then overlays any of them which are also in dir,
+// so that dir needs just the templates being customised
+// an override which is missing, won't parse or fails validateTemplates is skipped with a warning, and the embedded
+// template used instead; if strict, for -dev, it's an error instead
+// either way, the templates it returns have passed validateTemplates
+func loadTemplates(dir string, strict bool) (*template.Template, error) {
+	templates, err := template.New("").Funcs(templateFuncs).ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateTemplates(templates); err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return templates, nil
+	}
+
+	// a problem with an override; see overrideProblem
+	problem := func(instead string, format string, args ...interface{}) error {
+		return overrideProblem(strict, instead, format, args...)
+	}
+
+	embedded, err := fs.Glob(templateFS, "templates/*")
+	if err != nil {
+		return nil, err
+	}
+	builtIn := make(map[string]bool, len(embedded))
+	for _, name := range embedded {
+		builtIn[path.Base(name)] = true
+	}
+
+	overrides, err := os.ReadDir(dir)
+	if err != nil {
+		return templates, problem("using the built-in templates", "reading -templates-dir: %v", err)
+	}
+	for _, entry := range overrides {
+		name := entry.Name()
+		// the digest template isn't a page; see loadDigestTemplate
+		if entry.IsDir() || name == digestTemplateName {
+			continue
+		}
+		if !builtIn[name] {
+			if err := problem("ignoring it", "%s in -templates-dir isn't one of corkboard's templates", name); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			if err := problem("using the built-in one", "reading template %s: %v", name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// parsed into a copy, so an override which doesn't parse leaves the templates as they were
+		overlaid, err := templates.Clone()
+		if err != nil {
+			return nil, err
+		}
+		_, err = overlaid.New(name).Parse(string(contents))
+		if err != nil {
+			if err := problem("using the built-in one", "parsing template %s: %v", name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := validateTemplates(overlaid); err != nil {
+			if err := problem("using the built-in one", "checking template %s: %v", name, err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		templates = overlaid
+		log.Printf("using template %s from -templates-dir", name)
+	}
+	return templates, nil
+}
+
+// a problem with a -templates-dir template, which strict makes fatal; otherwise it's logged along with what's done instead
+func overrideProblem(strict bool, instead string, format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if strict {
+		return err
+	}
+	log.Printf("warning: %v; %s", err, instead)
+	return nil
+}
+
+// checks that every template in pageTemplates is there, and runs against the zero value of the data it's given,
+// so that a missing template or a field which doesn't exist fails at startup rather than with a 500
+// a copy of templates is executed, since a template can't be parsed into once it has been
+func validateTemplates(templates *template.Template) error {
+	templates, err := templates.Clone()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(pageTemplates))
+	for name := range pageTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		page := templates.Lookup(name)
+		if page == nil {
+			return fmt.Errorf("there's no template %s", name)
+		}
+		err := page.Execute(io.Discard, pageTemplates[name])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the template digests are written with
+// it's markdown rather than html, so it's a text template, parsed apart from the pages
+const digestTemplateName = "digest.md"
+
+// functions available to the digest template
+var digestTemplateFuncs = texttemplate.FuncMap{
+	"escape": escapeMarkdown,
+}
+
+// parses the embedded digest template, or the one in dir if it has one, like loadTemplates
+func loadDigestTemplate(dir string, strict bool) (*texttemplate.Template, error) {
+	contents, err := fs.ReadFile(templateFS, "templates/"+digestTemplateName)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := parseDigestTemplate(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return digest, nil
+	}
+	contents, err = os.ReadFile(filepath.Join(dir, digestTemplateName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return digest, nil
+	}
+	if err != nil {
+		return digest, overrideProblem(strict, "using the built-in one", "reading template %s: %v", digestTemplateName, err)
+	}
+	overridden, err := parseDigestTemplate(string(contents))
+	if err != nil {
+		return digest, overrideProblem(strict, "using the built-in one", "checking template %s: %v", digestTemplateName, err)
+	}
+	log.Printf("using template %s from -templates-dir", digestTemplateName)
+	return overridden, nil
+}
+
+// parses a digest template, and runs it against the zero value of its data, like validateTemplates
+func parseDigestTemplate(text string) (*texttemplate.Template, error) {
+	digest, err := texttemplate.New(digestTemplateName).Funcs(digestTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	err = digest.Execute(io.Discard, DigestData{})
+	if err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the embedded template name, with marker just before its closing body tag
+func markedTemplate(t *testing.T, name string, marker string) string {
+	t.Helper()
+	contents, err := fs.ReadFile(templateFS, "templates/"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(string(contents), "</body>", marker+"</body>", 1)
+}
+
+// writes files into a new -templates-dir
+func templatesDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// a -templates-dir with only some templates in it has those from it, and the rest built in
+func TestPartialTemplatesDir(t *testing.T) {
+	config := testConfig(t)
+	config.TemplatesDir = templatesDir(t, map[string]string{"index.html": markedTemplate(t, "index.html", "custom index")})
+	handler := testServer(t, config).Config.Handler
+	if resp := serveRequest(t, handler, http.MethodPut, "/api/note/todo", "hello"); resp.Code != http.StatusCreated {
+		t.Fatalf("creating todo: got %d", resp.Code)
+	}
+	if resp := serveRequest(t, handler, http.MethodGet, "/", ""); resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "custom index") {
+		t.Errorf("the index: got %d, want it from -templates-dir", resp.Code)
+	}
+	resp := serveRequest(t, handler, http.MethodGet, "/note/todo", "")
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), "hello") {
+		t.Errorf("the note's page: got %d %q, want the built-in one", resp.Code, resp.Body)
+	}
+}
+
+// a broken override is skipped for the built-in template, but with -dev it's an error
+func TestBrokenTemplateOverrides(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"doesn't parse", map[string]string{"note.html": "{{ .Note"}},
+		{"has a field which doesn't exist", map[string]string{"note.html": "{{ .NoSuchField }}"}},
+		{"isn't one of corkboard's", map[string]string{"extra.html": "hello"}},
+		{"alongside a good one", map[string]string{"note.html": "{{ .Note", "index.html": markedTemplate(t, "index.html", "custom index")}},
+	}
+	for _, c := range cases {
+		dir := templatesDir(t, c.files)
+		if _, err := loadTemplates(dir, true); err == nil {
+			t.Errorf("%s: loaded with -dev", c.name)
+		}
+		templates, err := loadTemplates(dir, false)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		var note, index strings.Builder
+		if err := templates.ExecuteTemplate(&note, "note.html", pageTemplates["note.html"]); err != nil {
+			t.Errorf("%s: rendering the note: %v", c.name, err)
+		}
+		if !strings.Contains(note.String(), "<html>") {
+			t.Errorf("%s: the note's page is %q, want the built-in one", c.name, note.String())
+		}
+		if err := templates.ExecuteTemplate(&index, "index.html", pageTemplates["index.html"]); err != nil {
+			t.Errorf("%s: rendering the index: %v", c.name, err)
+		}
+		_, overridden := c.files["index.html"]
+		if strings.Contains(index.String(), "custom index") != overridden {
+			t.Errorf("%s: the index is from -templates-dir: %v, want %v", c.name, !overridden, overridden)
+		}
+	}
+}
+
+// a -templates-dir which can't be read leaves the built-in templates, but with -dev it's an error
+func TestMissingTemplatesDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if _, err := loadTemplates(dir, true); err == nil {
+		t.Error("loaded a missing -templates-dir with -dev")
+	}
+	templates, err := loadTemplates(dir, false)
+	if err != nil || templates.Lookup("note.html") == nil {
+		t.Errorf("got %v, want the built-in templates", err)
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// how often views of notes waiting to be written to their last_viewed are written
+const viewFlushInterval = 30 * time.Second
+
+// most notes whose views can wait to be written; a view of one more writes them all at once
+const maxPendingViews = 1024
+
+// pendingViews batches the last_viewed updates of reads, so a note read over and over
+// costs one write per flush rather than one per read
+// it's shared by every copy of a Datastore, and keyed by the names notes are stored under
+type pendingViews struct {
+	lock  sync.Mutex
+	views map[string]time.Time
+}
+
+func newPendingViews() *pendingViews {
+	return &pendingViews{views: make(map[string]time.Time)}
+}
+
+// records a view of the note stored under key, returning how many notes are waiting
+func (p *pendingViews) add(key string, viewed time.Time) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if viewed.After(p.views[key]) {
+		p.views[key] = viewed
+	}
+	return len(p.views)
+}
+
+// takes every waiting view, leaving none
+func (p *pendingViews) take() map[string]time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	views := p.views
+	p.views = make(map[string]time.Time)
+	return views
+}
+
+// puts back views which couldn't be written, unless the notes have been viewed again since
+func (p *pendingViews) restore(views map[string]time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for key, viewed := range views {
+		if viewed.After(p.views[key]) {
+			p.views[key] = viewed
+		}
+	}
+}
+
+// writes the views waiting in the batch to the notes' last_viewed, in one transaction, returning how many there were
+// a note's last_viewed is never moved back, and notes deleted since they were viewed are skipped
+// if the write fails, the views are kept for the next flush
+func (ds *Datastore) flushViews() (_ int, err error) {
+	if ds.viewed == nil {
+		return 0, nil
+	}
+	defer ds.metrics.observe("flushViews", time.Now(), &err)
+	views := ds.viewed.take()
+	if len(views) == 0 {
+		return 0, nil
+	}
+	err = ds.withTx(context.Background(), func(tx Datastore) error {
+		for key, viewed := range views {
+			_, err := tx.writer().Exec(`update "note" set last_viewed = ?1 where name = ?2 and last_viewed < ?1`, formatTime(viewed), key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ds.viewed.restore(views)
+		return 0, err
+	}
+	return len(views), nil
+}
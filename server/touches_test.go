@@ -0,0 +1,129 @@
+package server
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// the last time a note was viewed, as the database has it
+func lastViewed(t *testing.T, datastore Datastore, name string) time.Time {
+	t.Helper()
+	_, viewed, ok, err := datastore.getNoteTimes(name)
+	if err != nil || !ok {
+		t.Fatalf("reading %s's times: %v, %v", name, ok, err)
+	}
+	return viewed
+}
+
+func TestViewsAreBatched(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	for _, name := range []string{"read", "deleted"} {
+		if _, err := datastore.setNote(name, []byte("body"), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Hour)
+	for _, name := range []string{"read", "read", "deleted"} {
+		if _, _, err := datastore.getNote(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := datastore.deleteNote("deleted"); err != nil {
+		t.Fatal(err)
+	}
+	if viewed := lastViewed(t, datastore, "read"); !viewed.Equal(testEpoch) {
+		t.Errorf("before flushing, read was last viewed at %v, want %v", viewed, testEpoch)
+	}
+	flushed, err := datastore.flushViews()
+	if err != nil || flushed != 2 {
+		t.Fatalf("flushing: got %d, %v, want 2 notes", flushed, err)
+	}
+	if viewed, want := lastViewed(t, datastore, "read"), testEpoch.Add(time.Hour); !viewed.Equal(want) {
+		t.Errorf("after flushing, read was last viewed at %v, want %v", viewed, want)
+	}
+	if exists, err := datastore.noteExists("deleted"); err != nil || exists {
+		t.Errorf("flushing a view brought back a deleted note: %v, %v", exists, err)
+	}
+}
+
+// a view from before a note's last_viewed doesn't move it back
+func TestFlushedViewsDontGoBack(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	if _, err := datastore.setNote("note", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := datastore.getNote("note"); err != nil {
+		t.Fatal(err)
+	}
+	// imported with a later view, while the earlier one waited
+	created := testEpoch.Add(-time.Hour)
+	later := testEpoch.Add(time.Hour)
+	if _, err := datastore.setNoteWithTimes("note", []byte("body"), true, NoteOptions{}, created, later, later); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := datastore.flushViews(); err != nil {
+		t.Fatal(err)
+	}
+	if viewed := lastViewed(t, datastore, "note"); !viewed.Equal(later) {
+		t.Errorf("last viewed at %v, want %v", viewed, later)
+	}
+}
+
+// a note viewed just before it would have expired isn't expired by the next cleanup, though the view is still waiting
+func TestExpiryFlushesViews(t *testing.T) {
+	datastore, clock := testDatastore(t, testConfig(t))
+	if _, err := datastore.setNote("note", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(23 * time.Hour)
+	if _, _, err := datastore.getNote("note"); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Hour)
+	expiry := ExpiryConfig{age: 24 * time.Hour, policy: EXPIRE_VIEWED}
+	maintenance := NewMaintenance(datastore)
+	expiring, err := maintenance.expiring(expiry, 10)
+	if err != nil || len(expiring) != 0 {
+		t.Errorf("expiring: got %v, %v, want none", expiring, err)
+	}
+	deleted, err := maintenance.prune(expiry, nil)
+	if err != nil || deleted != 0 {
+		t.Errorf("pruning: deleted %d, %v, want none", deleted, err)
+	}
+}
+
+// views still waiting when the datastore is closed are written first
+func TestCloseFlushesViews(t *testing.T) {
+	config := testConfig(t)
+	config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+	config.CreateDB = true
+	datastore, err := openDatastore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := newFakeClock(testEpoch)
+	datastore.setClock(clock)
+	if err := datastore.RunMigrations(testMigrations(t, "")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := datastore.setNote("note", []byte("body"), false); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	if _, _, err := datastore.getNote("note"); err != nil {
+		t.Fatal(err)
+	}
+	if err := datastore.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openDatastore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if viewed, want := lastViewed(t, reopened, "note"), testEpoch.Add(time.Hour); !viewed.Equal(want) {
+		t.Errorf("last viewed at %v, want %v", viewed, want)
+	}
+}
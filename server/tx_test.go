@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// the number of rows in each of the database's tables, to tell that nothing at all was left behind
+func tableCounts(t *testing.T, datastore Datastore) map[string]int {
+	t.Helper()
+	rows, err := datastore.reader.Query(`select name from sqlite_master where type = 'table' and name not like 'sqlite_%'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	counts := make(map[string]int, len(tables))
+	for _, table := range tables {
+		var count int
+		if err := datastore.reader.QueryRow(fmt.Sprintf(`select count(*) from "%s"`, table)).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		counts[table] = count
+	}
+	return counts
+}
+
+// checks every table has as many rows as it had, and the notes say what they did
+func checkUnchanged(t *testing.T, datastore Datastore, before map[string]int) {
+	t.Helper()
+	for table, count := range tableCounts(t, datastore) {
+		if count != before[table] {
+			t.Errorf("%s has %d rows, where it had %d", table, count, before[table])
+		}
+	}
+	if body, ok, err := datastore.peekNote("existing"); err != nil || !ok || string(body) != "before" {
+		t.Errorf("existing says %q, %v, %v, want before", body, ok, err)
+	}
+	if ok, err := datastore.noteExists("doomed"); err != nil || !ok {
+		t.Errorf("doomed: exists %v, %v", ok, err)
+	}
+	if ok, err := datastore.noteExists("new"); err != nil || ok {
+		t.Errorf("new: exists %v, %v", ok, err)
+	}
+	if changes, _, err := datastore.listChanges(0, 100); err != nil || len(changes) != 2 {
+		t.Errorf("the change log has %d changes, %v, want the 2 from before", len(changes), err)
+	}
+}
+
+// several changes, of the kinds a handler makes together
+func changeNotes(tx Datastore) error {
+	if _, err := tx.setNote("new", []byte("new"), false); err != nil {
+		return err
+	}
+	if _, err := tx.setNote("existing", []byte("after"), true); err != nil {
+		return err
+	}
+	if _, err := tx.swapNote("existing", hashBody([]byte("after")), []byte("swapped")); err != nil {
+		return err
+	}
+	return tx.deleteNote("doomed")
+}
+
+// a transaction which fails, however it fails, leaves nothing of what it did, in the notes or the change log
+func TestWithTxRollsBack(t *testing.T) {
+	cases := []struct {
+		name string
+		run  func(datastore Datastore) error
+	}{
+		{"an error", func(datastore Datastore) error {
+			return datastore.withTx(context.Background(), func(tx Datastore) error {
+				if err := changeNotes(tx); err != nil {
+					return err
+				}
+				return errRollback
+			})
+		}},
+		{"a panic", func(datastore Datastore) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("panicked: %v", r)
+				}
+			}()
+			return datastore.withTx(context.Background(), func(tx Datastore) error {
+				if err := changeNotes(tx); err != nil {
+					return err
+				}
+				panic("partway")
+			})
+		}},
+		{"a cancelled context", func(datastore Datastore) error {
+			ctx, cancel := context.WithCancel(context.Background())
+			return datastore.withTx(ctx, func(tx Datastore) error {
+				err := changeNotes(tx)
+				cancel()
+				return err
+			})
+		}},
+		{"an error from a nested transaction, returned", func(datastore Datastore) error {
+			return datastore.withTx(context.Background(), func(tx Datastore) error {
+				if _, err := tx.setNote("new", []byte("new"), false); err != nil {
+					return err
+				}
+				return tx.withTx(context.Background(), func(inner Datastore) error {
+					if err := inner.deleteNote("doomed"); err != nil {
+						return err
+					}
+					return errRollback
+				})
+			})
+		}},
+		{"an error after a nested transaction", func(datastore Datastore) error {
+			return datastore.withTx(context.Background(), func(tx Datastore) error {
+				if err := tx.withTx(context.Background(), changeNotes); err != nil {
+					return err
+				}
+				return errRollback
+			})
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			datastore, _ := testDatastore(t, testConfig(t))
+			for _, name := range []string{"existing", "doomed"} {
+				if _, err := datastore.setNote(name, []byte("before"), false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			before := tableCounts(t, datastore)
+			if err := c.run(datastore); err == nil {
+				t.Fatal("the transaction succeeded")
+			}
+			checkUnchanged(t, datastore, before)
+			// the writer's connection was given back
+			if _, err := datastore.setNote("afterwards", []byte("hello"), false); err != nil {
+				t.Errorf("writing after the transaction: %v", err)
+			}
+		})
+	}
+}
+
+// an error in a nested transaction only undoes its own changes, if the outer one carries on
+func TestNestedWithTx(t *testing.T) {
+	datastore, _ := testDatastore(t, testConfig(t))
+	err := datastore.withTx(context.Background(), func(tx Datastore) error {
+		if _, err := tx.setNote("outer", []byte("outer"), false); err != nil {
+			return err
+		}
+		err := tx.withTx(context.Background(), func(inner Datastore) error {
+			if _, err := inner.setNote("inner", []byte("inner"), false); err != nil {
+				return err
+			}
+			// the inner transaction sees the outer one's changes
+			if ok, err := inner.noteExists("outer"); err != nil || !ok {
+				return fmt.Errorf("outer: exists %v, %v", ok, err)
+			}
+			return errRollback
+		})
+		if err != errRollback {
+			return fmt.Errorf("the inner transaction: %v", err)
+		}
+		return tx.withTx(context.Background(), func(inner Datastore) error {
+			_, err := inner.setNote("second", []byte("second"), false)
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"outer": true, "inner": false, "second": true} {
+		if ok, err := datastore.noteExists(name); err != nil || ok != want {
+			t.Errorf("%s: exists %v, %v, want %v", name, ok, err, want)
+		}
+	}
+	if changes, _, err := datastore.listChanges(0, 100); err != nil || len(changes) != 2 {
+		t.Errorf("the change log has %d changes, %v, want one for each note kept", len(changes), err)
+	}
+}
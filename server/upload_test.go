@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// a request body which gives the first half of body, then fails with err, after calling cut if it isn't nil
+type cutShortBody struct {
+	body []byte
+	err  error
+	cut  func()
+	read int
+}
+
+func (r *cutShortBody) Read(p []byte) (int, error) {
+	if half := len(r.body) / 2; r.read < half {
+		n := copy(p, r.body[r.read:half])
+		r.read += n
+		return n, nil
+	}
+	if r.cut != nil {
+		r.cut()
+	}
+	return 0, r.err
+}
+
+// an upload which fails partway never leaves a truncated note: a new note isn't created, and an existing one keeps its contents
+func TestCutShortUploads(t *testing.T) {
+	server := testServer(t, testConfig(t))
+	handler := server.Config.Handler
+	full := strings.Repeat("the whole new note\n", 100)
+	cases := []struct {
+		name string
+		err  error
+		// whether the client goes away when the body is cut short
+		gone bool
+	}{
+		{"unexpected eof", io.ErrUnexpectedEOF, false},
+		{"read error", errors.New("connection reset"), false},
+		{"client went away", errors.New("context canceled"), true},
+	}
+	for _, c := range cases {
+		for _, method := range []string{http.MethodPut, http.MethodPost} {
+			name := fmt.Sprintf("%s-%s", strings.ReplaceAll(c.name, " ", "-"), strings.ToLower(method))
+			if method == http.MethodPut {
+				if resp := serveRequest(t, handler, http.MethodPut, "/api/note/"+name, "original"); resp.Code != http.StatusCreated {
+					t.Fatalf("creating %s: got %d", name, resp.Code)
+				}
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			body := &cutShortBody{body: []byte(full), err: c.err}
+			if c.gone {
+				body.cut = cancel
+			}
+			req := httptest.NewRequest(method, "/api/note/"+name, body).WithContext(ctx)
+			req.ContentLength = int64(len(full))
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			cancel()
+			if resp.Code < 400 {
+				t.Errorf("%s %s: got %d, want an error", method, name, resp.Code)
+			}
+
+			got := serveRequest(t, handler, http.MethodGet, "/api/note/"+name, "")
+			switch {
+			case method == http.MethodPost && got.Code != http.StatusNotFound:
+				t.Errorf("%s %s: the note was created, with %q", method, name, got.Body)
+			case method == http.MethodPut && got.Body.String() != "original":
+				t.Errorf("%s %s: the note says %q, want original", method, name, got.Body)
+			}
+		}
+	}
+}
+
+// a client which hangs up partway through its body, over a real connection
+func TestHungUpUpload(t *testing.T) {
+	server := testServer(t, testConfig(t))
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "PUT /api/note/partial HTTP/1.1\r\nHost: corkboard\r\nContent-Length: 1000\r\n\r\n%s", strings.Repeat("x", 500))
+	conn.Close()
+
+	// the server handles the hang-up in its own time, so the note mustn't appear for a while after
+	for end := time.Now().Add(200 * time.Millisecond); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		resp := serveRequest(t, server.Config.Handler, http.MethodGet, "/api/note/partial", "")
+		if resp.Code != http.StatusNotFound {
+			t.Fatalf("the note was created, with %d bytes", resp.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Visibility is when a note is shown; a nil bound means the note isn't bounded that way
+// outside it, the note is left out of listings and only admins can read it
+type Visibility struct {
+	PublishAt *time.Time `json:"publish_at,omitempty"`
+	HideAfter *time.Time `json:"hide_after,omitempty"`
+}
+
+// whether a note with this visibility is shown at now
+func (v Visibility) visibleAt(now time.Time) bool {
+	return (v.PublishAt == nil || !v.PublishAt.After(now)) && (v.HideAfter == nil || v.HideAfter.After(now))
+}
+
+// checks that a note with this visibility would ever be shown
+func (v Visibility) validate() error {
+	if v.PublishAt != nil && v.HideAfter != nil && !v.PublishAt.Before(*v.HideAfter) {
+		return errors.New("publish_at must be before hide_after")
+	}
+	return nil
+}
+
+// describes why a note with this visibility isn't shown at now, for its page; "" if it is
+func (v Visibility) describe(now time.Time) string {
+	switch {
+	case v.PublishAt != nil && v.PublishAt.After(now):
+		return "Scheduled: hidden until " + v.PublishAt.UTC().Format(expiryFormat)
+	case v.HideAfter != nil && !v.HideAfter.After(now):
+		return "Hidden since " + v.HideAfter.UTC().Format(expiryFormat)
+	}
+	return ""
+}
+
+// parses a bound of a note's visibility, which is "" for none, or a time like 2026-10-17T09:30:00Z
+// name is the query parameter or field it was given as, for the error
+func parseVisibilityTime(name string, value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	bound, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, noteFormError{name + " must be a time like 2026-10-17T09:30:00Z"}
+	}
+	bound = bound.UTC()
+	return &bound, nil
+}
+
+// reads the visibility a request gives a new note, from the publish-at and hide-after query parameters
+func requestVisibility(req *http.Request) (Visibility, error) {
+	var visibility Visibility
+	var err error
+	visibility.PublishAt, err = parseVisibilityTime("publish-at", req.URL.Query().Get("publish-at"))
+	if err != nil {
+		return visibility, err
+	}
+	visibility.HideAfter, err = parseVisibilityTime("hide-after", req.URL.Query().Get("hide-after"))
+	if err != nil {
+		return visibility, err
+	}
+	return visibility, visibility.validate()
+}
+
+// middleware for reading a note, which responds 404 as if it didn't exist unless it's visible now
+// or the user is an admin; see AdminOnly
+func VisibleOnly(h httprouter.Handle, datastore Datastore, admins map[string]bool) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		if isAdmin(req, admins) {
+			h(resp, req, params)
+			return
+		}
+		datastore := datastore.scoped(req)
+		visibility, ok, err := datastore.getNoteVisibility(params.ByName("note"))
+		if err != nil {
+			errorResponse(resp, req, http.StatusInternalServerError)
+			log.Printf("accessing visibility of %s: %v", params.ByName("note"), err)
+			return
+		}
+		if ok && !visibility.visibleAt(datastore.now()) {
+			errorResponse(resp, req, http.StatusNotFound)
+			return
+		}
+		h(resp, req, params)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// header carrying the signature of a delivery to a watch with a secret:
+// "sha256=" followed by the HMAC-SHA256 of the body keyed with the secret, as hex
+const watchSignatureHeader = "X-Corkboard-Signature"
+
+// check for deliveries at least this often
+const watchPollInterval = 30 * time.Second
+
+// a delivery is attempted this many times, waiting twice as long after each failure,
+// before its watch is disabled
+const (
+	watchMaxAttempts = 5
+	watchMinBackoff  = 10 * time.Second
+)
+
+// WatchPayload is the json posted to a watch's url when its note changes
+type WatchPayload struct {
+	// "updated" or "deleted"
+	Event string `json:"event"`
+	Note  string `json:"note"`
+	// the hash of the note's new body, and its page if -base-url is set; left out if it was deleted
+	Hash string `json:"hash,omitempty"`
+	URL  string `json:"url,omitempty"`
+	// when the note changed
+	Time  time.Time `json:"time"`
+	Watch int64     `json:"watch"`
+}
+
+// Watcher posts changes to watched notes to the urls watching them
+// the deliveries are queued by triggers in the database, so it's only told about changes to wake it up
+type Watcher struct {
+	datastore Datastore
+	baseURL   string
+	client    *http.Client
+	wake      chan struct{}
+	// stop the delivery loop, and are closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+// creates a watcher for the notes in datastore, whose pages are under baseURL
+// unless allowInternal is set, it refuses to deliver to addresses which aren't on the public internet, like fetches
+func NewWatcher(datastore Datastore, baseURL string, allowInternal bool) *Watcher {
+	return &Watcher{
+		datastore: datastore,
+		baseURL:   baseURL,
+		client:    NewFetcher(allowInternal).client,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// wakes the delivery loop for a change which may be to a watched note
+// a new note can't be watched yet
+func (w *Watcher) noteChanged(event NoteEvent) {
+	if event.Action == NOTE_CREATED {
+		return
+	}
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// the deliveries waiting to be made, for diagnostics
+func (w *Watcher) diagnostics() interface{} {
+	queued, err := w.datastore.countWatchDeliveries()
+	if err != nil {
+		return map[string]string{"error": err.Error()}
+	}
+	return map[string]int64{"queued": queued}
+}
+
+// makes deliveries until stopped
+func (w *Watcher) run() {
+	defer close(w.done)
+	for {
+		for {
+			delivery, ok, err := w.datastore.nextWatchDelivery()
+			if err != nil {
+				log.Printf("reading watch deliveries: %v", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			w.attempt(delivery)
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-time.After(watchPollInterval):
+		}
+	}
+}
+
+// stops delivering, waiting for any delivery being made to finish
+// deliveries still queued are made when corkboard next starts
+func (w *Watcher) shutdown() {
+	close(w.stop)
+	<-w.done
+}
+
+// tries to make a delivery, rescheduling it with exponential backoff if it fails,
+// or disabling its watch once it has failed watchMaxAttempts times
+func (w *Watcher) attempt(delivery watchDelivery) {
+	err := w.deliver(delivery)
+	if err == nil {
+		err = w.datastore.finishWatchDelivery(delivery.id)
+		if err != nil {
+			log.Printf("removing delivery to watch %d from queue: %v", delivery.watch, err)
+		}
+		return
+	}
+	if delivery.attempts+1 >= watchMaxAttempts {
+		log.Printf("disabling watch %d on %s: delivering to %s failed %d times, lastly: %v",
+			delivery.watch, delivery.note, delivery.url, watchMaxAttempts, err)
+		err = w.datastore.disableWatch(delivery.watch)
+		if err != nil {
+			log.Printf("disabling watch %d: %v", delivery.watch, err)
+		}
+		return
+	}
+	delay := watchMinBackoff << delivery.attempts
+	log.Printf("delivering change to %s to watch %d (attempt %d): %v; retrying in %s",
+		delivery.note, delivery.watch, delivery.attempts+1, err, delay)
+	err = w.datastore.retryWatchDelivery(delivery.id, delay)
+	if err != nil {
+		log.Printf("rescheduling delivery to watch %d: %v", delivery.watch, err)
+	}
+}
+
+// posts a delivery to its watch's url, signed with the watch's secret if it has one
+func (w *Watcher) deliver(delivery watchDelivery) error {
+	name := delivery.note
+	if w.datastore.private {
+		// a user's private notes are stored as "user/note"
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	payload := WatchPayload{Event: eventVerbs[delivery.action], Note: name, Time: delivery.time, Watch: delivery.watch}
+	if delivery.action != NOTE_DELETED {
+		payload.Hash = delivery.hash.String
+		if w.baseURL != "" {
+			payload.URL = noteURL(w.baseURL, name)
+		}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, delivery.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "corkboard/"+corkboardVersion)
+	if delivery.secret != "" {
+		req.Header.Set(watchSignatureHeader, signWatchPayload(delivery.secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("responded %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// the value of watchSignatureHeader for a delivery's body
+func signWatchPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// which watches on a note a request may see and unregister: nil for all of them, if the user is an admin
+// or the note is one of their private notes, or else a pointer to the user, for only the ones they registered
+func watchCreator(req *http.Request, datastore Datastore, admins map[string]bool) *string {
+	if isAdmin(req, admins) || datastore.owner != "" {
+		return nil
+	}
+	user := requestUser(req)
+	return &user
+}
+
+// registers the url parameter to be posted a WatchPayload whenever the note is updated or deleted
+// if the secret parameter is given, deliveries are signed with it; see watchSignatureHeader
+// the parameters may be in the query or a form body
+// responds 201 with the watch as json
+func WatchNote(datastore Datastore) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		err := req.ParseForm()
+		if err != nil {
+			APIError(resp, http.StatusBadRequest, ERR_BAD_REQUEST)
+			return
+		}
+		watchURL := req.Form.Get("url")
+		target, err := url.Parse(watchURL)
+		if err == nil {
+			err = checkFetchURL(target)
+		}
+		if watchURL == "" || err != nil {
+			APIErrorMessage(resp, http.StatusBadRequest, ERR_BAD_REQUEST, "url must be an http or https url")
+			return
+		}
+		watch, status, err := datastore.addWatch(noteName, watchURL, req.Form.Get("secret"), requestUser(req))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error watching note %s: %v", noteName, err)
+			return
+		}
+		if status == NO_NOTE {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		log.Printf("Added watch %d on note %s", watch.ID, noteName)
+		resp.Header().Set("Content-Type", "application/json")
+		resp.WriteHeader(http.StatusCreated)
+		err = json.NewEncoder(resp).Encode(watch)
+		if err != nil {
+			log.Printf("responding with watch: %v", err)
+		}
+	}
+}
+
+// lists the watches on a note as json; see watchCreator for whose
+func ListWatches(datastore Datastore, admins map[string]bool) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		exists, err := datastore.noteExists(noteName)
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("accessing %s: %v", noteName, err)
+			return
+		}
+		if !exists {
+			noteNotFound(resp, req, datastore, noteName)
+			return
+		}
+		watches, err := datastore.listWatches(noteName, watchCreator(req, datastore, admins))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("listing watches on %s: %v", noteName, err)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(resp).Encode(watches)
+		if err != nil {
+			log.Printf("responding with watch list: %v", err)
+		}
+	}
+}
+
+// unregisters a watch; see watchCreator for whose
+func UnwatchNote(datastore Datastore, admins map[string]bool) httprouter.Handle {
+	return func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		datastore := datastore.scoped(req)
+		noteName := params.ByName("note")
+		id, err := strconv.ParseInt(params.ByName("watch"), 10, 64)
+		if err != nil {
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		}
+		status, err := datastore.deleteWatch(noteName, id, watchCreator(req, datastore, admins))
+		if err != nil {
+			APIError(resp, http.StatusInternalServerError, ERR_INTERNAL)
+			log.Printf("error deleting watch %d on %s: %v", id, noteName, err)
+			return
+		}
+		switch status {
+		case NO_NOTE:
+			APIError(resp, http.StatusNotFound, ERR_NOT_FOUND)
+			return
+		case MISMATCH:
+			APIError(resp, http.StatusForbidden, ERR_FORBIDDEN)
+			return
+		}
+		log.Printf("Deleted watch %d on note %s", id, noteName)
+	}
+}
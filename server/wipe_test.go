@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// whether any of the database's files hold text
+func databaseHolds(t *testing.T, path string, text string) bool {
+	t.Helper()
+	for _, file := range []string{path, path + "-wal", path + "-journal"} {
+		contents, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(contents, []byte(text)) {
+			return true
+		}
+	}
+	return false
+}
+
+// after a note is wiped, its contents are nowhere in the database's files, nor are those of its snapshots,
+// unless another note has the same contents, which the response says
+func TestWipeLeavesNoPlaintext(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		// another note with the same contents, which keeps them
+		shared     bool
+		wantSecure bool
+	}{
+		{"alone", nil, false, true},
+		{"deduplicated", []string{"-dedupe"}, false, true},
+		{"shared", []string{"-dedupe"}, true, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			config := testConfig(t, c.args...)
+			config.DatabasePath = filepath.Join(t.TempDir(), "notes.db")
+			config.CreateDB = true
+			app, err := NewApp(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer app.Close()
+			handler := app.Router()
+			secret := strings.Repeat("AKIA-PLAINTEXT-4f1c9e ", 20)
+			earlier := strings.Repeat("AKIA-EARLIER-8b2d07 ", 20)
+			steps := []struct {
+				method, path, body string
+			}{
+				{http.MethodPut, "/api/note/secret", earlier},
+				{http.MethodPost, "/api/note/secret/snapshot", ""},
+				{http.MethodPut, "/api/note/secret", secret},
+				{http.MethodPut, "/api/note/unrelated", "nothing to hide"},
+			}
+			for _, step := range steps {
+				if resp := serveRequest(t, handler, step.method, step.path, step.body); resp.Code >= 300 {
+					t.Fatalf("%s %s: got %d %q", step.method, step.path, resp.Code, resp.Body)
+				}
+			}
+			if c.shared {
+				if resp := serveRequest(t, handler, http.MethodPut, "/api/note/copy", secret); resp.Code != http.StatusCreated {
+					t.Fatalf("creating copy: got %d", resp.Code)
+				}
+			}
+			// otherwise this test couldn't tell whether the wipe worked
+			if !databaseHolds(t, config.DatabasePath, secret) || !databaseHolds(t, config.DatabasePath, earlier) {
+				t.Fatal("the note's contents aren't in the database's files before it's wiped")
+			}
+
+			resp := serveRequest(t, handler, http.MethodDelete, "/api/note/secret?wipe=true", "")
+			var result WipeResult
+			if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+				t.Fatalf("got %d %q", resp.Code, resp.Body)
+			}
+			if !result.Deleted || result.SecureDelete != c.wantSecure || (result.Reason == "") != c.wantSecure {
+				t.Errorf("got %+v, want it deleted, and securely %v", result, c.wantSecure)
+			}
+			if databaseHolds(t, config.DatabasePath, earlier) {
+				t.Error("the snapshot's contents are still in the database's files")
+			}
+			if holds := databaseHolds(t, config.DatabasePath, secret); holds != c.shared {
+				t.Errorf("the contents are in the database's files: %v, want %v", holds, c.shared)
+			}
+			if resp := serveRequest(t, handler, http.MethodGet, "/api/note/unrelated", ""); resp.Body.String() != "nothing to hide" {
+				t.Errorf("another note says %q after the wipe", resp.Body)
+			}
+			if c.shared {
+				if resp := serveRequest(t, handler, http.MethodGet, "/api/note/copy", ""); resp.Body.String() != secret {
+					t.Error("the note sharing the contents lost them")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package server_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/FBemf/corkboard/server/servertest"
+)
+
+// markup which would run script if a page carried it as it was written
+var xssPayloads = []string{
+	`<script>alert(1)</script>`,
+	`<img src=x onerror=alert(1)>`,
+	`"><svg onload=alert(1)>`,
+	`javascript:alert(1)`,
+	`JaVaScRiPt:alert(1)`,
+	`data:text/html,<script>alert(1)</script>`,
+	`[click](javascript:alert(1))`,
+	`[click](data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==)`,
+}
+
+// what a page which let a payload through would contain
+var xssMarkers = []string{
+	"<script>alert",
+	"<img src=x",
+	"<svg onload",
+	`href="javascript:`,
+	`href="JaVaScRiPt:`,
+	`href="data:`,
+}
+
+// every page which renders a note's body, name or title escapes the markup in it, and links to nothing but http
+func TestRenderedPagesEscapeMarkup(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	for i, payload := range xssPayloads {
+		// in the body, the title and the name
+		name := "xss-" + strings.Repeat("x", i) + payload
+		path := "/api/note/" + url.PathEscape(name) + "?title=" + url.QueryEscape(payload)
+		if status, body := s.Do(t, http.MethodPut, path, payload); status != http.StatusCreated {
+			t.Fatalf("creating a note for %q: got %d %q", payload, status, body)
+		}
+		if status, body := s.Do(t, http.MethodPut, "/api/note/"+url.PathEscape(name)+"/meta", `{"language": "markdown"}`); status != http.StatusOK {
+			t.Fatalf("making %q markdown: got %d %q", payload, status, body)
+		}
+		escaped := strings.ReplaceAll(url.PathEscape(name), "%2F", "/")
+		for _, path := range []string{"/note/" + escaped, "/note/" + escaped + "/print"} {
+			status, body := s.Do(t, http.MethodGet, path, "")
+			if status != http.StatusOK {
+				t.Errorf("GET %s: got %d", path, status)
+				continue
+			}
+			for _, marker := range xssMarkers {
+				if strings.Contains(body, marker) {
+					t.Errorf("GET %s: the page for %q contains %q", path, payload, marker)
+				}
+			}
+		}
+	}
+	for _, path := range []string{"/", "/notes?view=tree", "/notes?view=tree&folder=", "/feed.json"} {
+		status, body := s.Do(t, http.MethodGet, path, "")
+		if status != http.StatusOK {
+			t.Errorf("GET %s: got %d", path, status)
+			continue
+		}
+		for _, marker := range xssMarkers {
+			if strings.Contains(body, marker) {
+				t.Errorf("GET %s contains %q", path, marker)
+			}
+		}
+	}
+}
+
+// a language is a class name on a note's page, so it can't be markup
+func TestLanguageIsAToken(t *testing.T) {
+	s := servertest.New(t, servertest.Config(t))
+	if status, body := s.Do(t, http.MethodPut, "/api/note/code", "x"); status != http.StatusCreated {
+		t.Fatalf("creating code: got %d %q", status, body)
+	}
+	for _, language := range []string{`go\" onmouseover=\"alert(1)`, `x><script>alert(1)</script>`} {
+		if status, body := s.Do(t, http.MethodPut, "/api/note/code/meta", `{"language": "`+language+`"}`); status != http.StatusBadRequest {
+			t.Errorf("language %s: got %d %q, want 400", language, status, body)
+		}
+	}
+}
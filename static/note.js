@@ -1,38 +0,0 @@
-function copyToClipboard(str) {
-    const el = document.createElement('textarea');
-    el.value = str;
-    document.body.appendChild(el);
-    el.select();
-    document.execCommand('copy');
-    document.body.removeChild(el);
-};
-
-document.addEventListener("DOMContentLoaded", () => {
-    let deleteButton = document.getElementById("delete");
-    let copyButton = document.getElementById("copy");
-    let noteArea = document.getElementById("note");
-
-    deleteButton.addEventListener("click", event => {
-        event.preventDefault();
-        let noteName = document.getElementById("noteName").textContent;
-        if (window.confirm("Are you sure you want to delete this note?")) {
-            fetch(`/api/note/${noteName}`, {
-                method: "DELETE",
-                cache: "no-cache",
-                redirect: "follow",
-            }).then(resp => {
-                if (resp.ok) {
-                    window.location = "/"
-                }
-            });
-        }
-    });
-
-    copyButton.addEventListener("click", event => {
-        event.preventDefault();
-        copyToClipboard(noteArea.textContent);
-        let previousContent = copyButton.textContent;
-        copyButton.textContent = "Copied!";
-        setTimeout(() => copyButton.textContent = previousContent, 1500);
-    });
-});